@@ -8,10 +8,15 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/mstgnz/self-hosted-serverless/internal/alert"
 	"github.com/mstgnz/self-hosted-serverless/internal/cli"
+	"github.com/mstgnz/self-hosted-serverless/internal/event"
 	"github.com/mstgnz/self-hosted-serverless/internal/function"
 	"github.com/mstgnz/self-hosted-serverless/internal/grpc"
+	"github.com/mstgnz/self-hosted-serverless/internal/history"
+	"github.com/mstgnz/self-hosted-serverless/internal/logging"
 	"github.com/mstgnz/self-hosted-serverless/internal/server"
+	"github.com/mstgnz/self-hosted-serverless/internal/tracing"
 )
 
 func main() {
@@ -54,12 +59,36 @@ func main() {
 		} else {
 			cli.GetMetrics()
 		}
+	case "keys":
+		runKeysCommand(args[1:])
 	case "":
 		// Start the server if no command is provided
+		logging.Setup()
+		auditLog := logging.GetGlobalAuditLogger()
+		tracer := tracing.GetGlobalTracer()
 		registry := function.NewRegistry()
 
+		// Record invocations and restore the metrics they add up to
+		store, err := history.NewStoreFromEnv()
+		if err != nil {
+			log.Printf("Warning: Invocation history disabled: %v", err)
+		}
+		if store != nil {
+			registry.SetHistory(store)
+			if err := registry.RebuildMetrics(); err != nil {
+				log.Printf("Warning: Failed to rebuild metrics from invocation history: %v", err)
+			}
+		}
+
+		// Evaluate alert rules against the function metrics
+		alerts, err := alert.NewManagerFromEnv(registry, event.GetGlobalBus())
+		if err != nil {
+			log.Printf("Warning: Alerting disabled: %v", err)
+		}
+
 		// Start HTTP server
 		srv := server.NewServer(port, registry)
+		srv.SetAlerts(alerts)
 		go func() {
 			log.Printf("Starting HTTP server on port %d...\n", port)
 			if err := srv.Start(); err != nil {
@@ -85,9 +114,58 @@ func main() {
 		log.Println("Shutting down servers...")
 		srv.Stop()
 		grpcSrv.Stop()
+		if alerts != nil {
+			alerts.Close()
+		}
+		if store != nil {
+			store.Close()
+		}
+		tracer.Close()
+		auditLog.Close()
 	default:
 		fmt.Printf("Unknown command: %s\n", command)
-		fmt.Println("Available commands: create, run, list, metrics")
+		fmt.Println("Available commands: create, run, list, metrics, keys")
+		os.Exit(1)
+	}
+}
+
+// runKeysCommand handles the "keys" subcommands
+func runKeysCommand(args []string) {
+	usage := func() {
+		fmt.Println("Usage:")
+		fmt.Println("  go-serverless keys list")
+		fmt.Println("  go-serverless keys create <name> <scope,...> [function,...]")
+		fmt.Println("  go-serverless keys rotate <id>")
+		fmt.Println("  go-serverless keys revoke <id>")
 		os.Exit(1)
 	}
+	if len(args) == 0 {
+		usage()
+	}
+
+	switch args[0] {
+	case "list":
+		cli.ListKeys()
+	case "create":
+		if len(args) < 3 {
+			usage()
+		}
+		var functions []string
+		if len(args) > 3 {
+			functions = cli.SplitList(args[3])
+		}
+		cli.CreateKey(args[1], cli.SplitList(args[2]), functions)
+	case "rotate":
+		if len(args) < 2 {
+			usage()
+		}
+		cli.RotateKey(args[1])
+	case "revoke":
+		if len(args) < 2 {
+			usage()
+		}
+		cli.RevokeKey(args[1])
+	default:
+		usage()
+	}
 }
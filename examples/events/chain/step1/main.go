@@ -7,6 +7,7 @@ import (
 
 	"github.com/mstgnz/self-hosted-serverless/internal/common"
 	"github.com/mstgnz/self-hosted-serverless/internal/event"
+	"github.com/mstgnz/self-hosted-serverless/internal/tracing"
 )
 
 // Handler is the function handler
@@ -42,9 +43,10 @@ func (h *FunctionHandler) Execute(input map[string]interface{}) (interface{}, er
 	data["step1_result"] = "Step 1 processing completed"
 	data["next_step"] = "step2"
 
-	// Publish event for next step
+	// Publish event for next step, as part of the trace of the request
+	ctx := tracing.ExtractInput(context.Background(), input)
 	eventBus := event.GetGlobalBus()
-	eventBus.Publish(context.Background(), event.Event{
+	eventBus.Publish(ctx, event.Event{
 		Type:    "process.step1.completed",
 		Payload: data,
 	})
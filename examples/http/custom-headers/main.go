@@ -1,6 +1,8 @@
 package main
 
 import (
+	"encoding/json"
+
 	"github.com/mstgnz/self-hosted-serverless/internal/common"
 )
 
@@ -12,6 +14,7 @@ var Info = common.FunctionInfo{
 	Name:        "custom-headers",
 	Description: "A function that returns custom HTTP headers",
 	Runtime:     "go",
+	HTTPMode:    common.HTTPModeEvent,
 }
 
 // FunctionHandler implements the serverless function
@@ -19,18 +22,31 @@ type FunctionHandler struct{}
 
 // Execute executes the function with the given input
 func (h *FunctionHandler) Execute(input map[string]interface{}) (interface{}, error) {
-	// Return both data and headers
-	// The server will extract headers from the response and set them in the HTTP response
-	return map[string]interface{}{
-		"data": map[string]interface{}{
-			"message":   "Hello, World!",
-			"timestamp": input["timestamp"],
-		},
-		"headers": map[string]string{
-			"X-Custom-Header": "Custom Value",
-			"X-Powered-By":    "Self-Hosted Serverless",
-			"X-Function-Name": "custom-headers",
-			"Cache-Control":   "no-cache, no-store, must-revalidate",
+	// In HTTP event mode the input is the full HTTP request
+	req, err := common.ParseHTTPRequest(input)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"message":   "Hello, World!",
+		"method":    req.Method,
+		"timestamp": req.Query["timestamp"],
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The server writes the status code, headers and body as-is
+	return common.HTTPResponse{
+		StatusCode: 200,
+		Headers: map[string]common.HeaderValues{
+			"Content-Type":    {"application/json"},
+			"X-Custom-Header": {"Custom Value"},
+			"X-Powered-By":    {"Self-Hosted Serverless"},
+			"X-Function-Name": {"custom-headers"},
+			"Cache-Control":   {"no-cache, no-store, must-revalidate"},
 		},
+		Body: string(body),
 	}, nil
 }
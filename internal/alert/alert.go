@@ -0,0 +1,451 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/common"
+	"github.com/mstgnz/self-hosted-serverless/internal/event"
+	"github.com/mstgnz/self-hosted-serverless/internal/function"
+	"github.com/mstgnz/self-hosted-serverless/internal/metrics"
+)
+
+// Alert states
+const (
+	StatePending  = "pending"
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// EventPrefix starts the type of the events published when alerts change
+// state, such as "alert.firing"
+const EventPrefix = "alert."
+
+// resolvedRetention is how long resolved alerts are still listed
+const resolvedRetention = time.Hour
+
+// Notification delivery
+const (
+	notifyQueueSize = 100
+	notifyAttempts  = 3
+)
+
+var (
+	transitionsTotal = metrics.GetGlobalRegistry().NewCounterVec("serverless_alert_transitions_total",
+		"Alerts entering a state.", "rule", "state")
+	notificationsTotal = metrics.GetGlobalRegistry().NewCounterVec("serverless_alert_notifications_total",
+		"Alert notifications by outcome.", "outcome")
+)
+
+// Source provides the functions and metrics rules are evaluated against.
+// *function.Registry is a Source.
+type Source interface {
+	ListFunctions() []common.FunctionInfo
+	GetWindowMetrics(window time.Duration) map[string]function.WindowMetrics
+}
+
+// Alert is a rule's condition holding for a function
+type Alert struct {
+	Rule        string    `json:"rule"`
+	Function    string    `json:"function"`
+	State       string    `json:"state"`
+	Severity    string    `json:"severity"`
+	Description string    `json:"description,omitempty"`
+	Metric      string    `json:"metric"`
+	Op          string    `json:"op"`
+	Threshold   float64   `json:"threshold"`
+	Value       float64   `json:"value"`
+	Summary     string    `json:"summary"`
+	ActiveAt    time.Time `json:"active_at"`
+	FiredAt     time.Time `json:"fired_at,omitzero"`
+	ResolvedAt  time.Time `json:"resolved_at,omitzero"`
+}
+
+// summarize describes the alert in a line, for chat notifications
+func (a *Alert) summarize(rule Rule) {
+	over := formatSecs(rule.WindowSecs)
+	if rule.BaselineSecs > 0 {
+		over += " vs " + formatSecs(rule.BaselineSecs)
+	}
+	a.Summary = fmt.Sprintf("[%s] %s on %s: %s %s %s %s over %s (%s)",
+		strings.ToUpper(a.State), a.Rule, a.Function, a.Metric,
+		strconv.FormatFloat(a.Value, 'g', 4, 64), a.Op, strconv.FormatFloat(a.Threshold, 'g', 4, 64),
+		over, a.Severity)
+}
+
+// formatSecs formats a window in its largest whole unit, such as "5m"
+func formatSecs(secs int) string {
+	switch {
+	case secs%3600 == 0:
+		return strconv.Itoa(secs/3600) + "h"
+	case secs%60 == 0:
+		return strconv.Itoa(secs/60) + "m"
+	}
+	return strconv.Itoa(secs) + "s"
+}
+
+// payload is the event payload of the alert
+func (a Alert) payload() map[string]any {
+	p := map[string]any{
+		"rule":      a.Rule,
+		"function":  a.Function,
+		"state":     a.State,
+		"severity":  a.Severity,
+		"metric":    a.Metric,
+		"op":        a.Op,
+		"threshold": a.Threshold,
+		"value":     a.Value,
+		"summary":   a.Summary,
+		"active_at": a.ActiveAt,
+	}
+	if a.Description != "" {
+		p["description"] = a.Description
+	}
+	if !a.FiredAt.IsZero() {
+		p["fired_at"] = a.FiredAt
+	}
+	if !a.ResolvedAt.IsZero() {
+		p["resolved_at"] = a.ResolvedAt
+	}
+	return p
+}
+
+type alertKey struct {
+	rule, function string
+}
+
+// Manager evaluates alert rules periodically. Alerts become pending when
+// their condition holds, firing once it has held for the rule's duration,
+// and resolved when it stops holding. Every change of state is published on
+// the event bus, and alerts that fire or resolve are sent to the notifiers.
+type Manager struct {
+	source    Source
+	bus       *event.Bus
+	rules     []Rule
+	notifiers []Notifier
+	interval  time.Duration
+	started   time.Time
+
+	mu     sync.Mutex
+	alerts map[alertKey]*Alert
+
+	notify     chan Alert
+	retryDelay time.Duration
+	stop       chan struct{}
+	done       chan struct{}
+	sent       chan struct{}
+	closeOnce  sync.Once
+}
+
+// NewManagerFromEnv creates the manager for the rules in ALERT_RULES_FILE,
+// or returns nil if it is unset
+func NewManagerFromEnv(source Source, bus *event.Bus) (*Manager, error) {
+	file := os.Getenv("ALERT_RULES_FILE")
+	if file == "" {
+		return nil, nil
+	}
+	cfg, err := LoadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return NewManager(source, bus, cfg)
+}
+
+// NewManager validates cfg and starts evaluating its rules in the
+// background. Close must be called to stop it.
+func NewManager(source Source, bus *event.Bus, cfg Config) (*Manager, error) {
+	names := make(map[string]bool, len(cfg.Rules))
+	for i := range cfg.Rules {
+		if err := cfg.Rules[i].Validate(); err != nil {
+			return nil, err
+		}
+		if names[cfg.Rules[i].Name] {
+			return nil, fmt.Errorf("duplicate rule %s", cfg.Rules[i].Name)
+		}
+		names[cfg.Rules[i].Name] = true
+	}
+
+	notifiers := make([]Notifier, 0, len(cfg.Notifiers))
+	for i, nc := range cfg.Notifiers {
+		n, err := NewWebhookNotifier(nc)
+		if err != nil {
+			return nil, fmt.Errorf("notifier %d: %w", i, err)
+		}
+		notifiers = append(notifiers, n)
+	}
+
+	interval := time.Duration(cfg.IntervalSecs) * time.Second
+	if cfg.IntervalSecs <= 0 {
+		interval = DefaultIntervalSecs * time.Second
+	}
+
+	m := &Manager{
+		source:     source,
+		bus:        bus,
+		rules:      cfg.Rules,
+		notifiers:  notifiers,
+		interval:   interval,
+		started:    time.Now(),
+		alerts:     make(map[alertKey]*Alert),
+		notify:     make(chan Alert, notifyQueueSize),
+		retryDelay: time.Second,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		sent:       make(chan struct{}),
+	}
+	go m.run()
+	go m.send()
+	return m, nil
+}
+
+// Rules returns the rules being evaluated
+func (m *Manager) Rules() []Rule {
+	return append([]Rule(nil), m.rules...)
+}
+
+// Alerts returns the pending and firing alerts, and those resolved in the
+// last hour, ordered by rule and function. A non-empty state selects the
+// alerts in that state.
+func (m *Manager) Alerts(state string) []Alert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	alerts := make([]Alert, 0, len(m.alerts))
+	for _, a := range m.alerts {
+		if state == "" || a.State == state {
+			alerts = append(alerts, *a)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Rule != alerts[j].Rule {
+			return alerts[i].Rule < alerts[j].Rule
+		}
+		return alerts[i].Function < alerts[j].Function
+	})
+	return alerts
+}
+
+// Close stops evaluating rules and waits for queued notifications to be sent
+func (m *Manager) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.stop)
+		<-m.done
+		close(m.notify)
+		<-m.sent
+	})
+	return nil
+}
+
+// run evaluates the rules every interval
+func (m *Manager) run() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case now := <-ticker.C:
+			m.Evaluate(now)
+		}
+	}
+}
+
+// Evaluate checks every rule against the metrics at now and moves alerts
+// between states
+func (m *Manager) Evaluate(now time.Time) {
+	functions := m.source.ListFunctions()
+	windows := make(map[time.Duration]map[string]function.WindowMetrics)
+	windowMetrics := func(window time.Duration) map[string]function.WindowMetrics {
+		if _, ok := windows[window]; !ok {
+			windows[window] = m.source.GetWindowMetrics(window)
+		}
+		return windows[window]
+	}
+
+	var changed []Alert
+	m.mu.Lock()
+	seen := make(map[alertKey]bool)
+	for _, rule := range m.rules {
+		// A server that just started has no traffic to show yet
+		if rule.watchesLow() && now.Sub(m.started) < rule.window() {
+			for key := range m.alerts {
+				if key.rule == rule.Name {
+					seen[key] = true
+				}
+			}
+			continue
+		}
+
+		for _, fn := range functions {
+			if rule.Function != "" && rule.Function != fn.Name {
+				continue
+			}
+			key := alertKey{rule.Name, fn.Name}
+			seen[key] = true
+
+			value, ok := evaluate(rule, fn.Name, windowMetrics)
+			active := ok && compare(rule.Op, value, rule.Threshold)
+			if a, changedState := m.transition(key, rule, active, value, now); changedState {
+				changed = append(changed, a)
+			}
+		}
+	}
+
+	// Alerts of removed functions or rules resolve, and resolved alerts are
+	// dropped after a while
+	for key, a := range m.alerts {
+		switch {
+		case a.State == StateResolved:
+			if now.Sub(a.ResolvedAt) >= resolvedRetention {
+				delete(m.alerts, key)
+			}
+		case !seen[key]:
+			if a, changedState := m.transition(key, m.rule(key.rule), false, a.Value, now); changedState {
+				changed = append(changed, a)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	for _, a := range changed {
+		m.publish(a)
+	}
+}
+
+// rule returns the rule with the given name
+func (m *Manager) rule(name string) Rule {
+	for _, r := range m.rules {
+		if r.Name == name {
+			return r
+		}
+	}
+	return Rule{Name: name}
+}
+
+// evaluate returns the value a rule watches for a function, and whether the
+// rule applies at all
+func evaluate(rule Rule, name string, windowMetrics func(time.Duration) map[string]function.WindowMetrics) (float64, bool) {
+	metric := ruleMetrics[rule.Metric]
+	current := windowMetrics(rule.window())[name]
+	if current.Invocations < rule.MinInvocations {
+		return 0, false
+	}
+	value := metric.value(current)
+	if rule.BaselineSecs == 0 {
+		return value, true
+	}
+
+	baseline := metric.value(windowMetrics(rule.baseline())[name])
+	if baseline == 0 {
+		// The baseline includes the window, so it is only zero when the
+		// window is too
+		return 0, true
+	}
+	return value / baseline, true
+}
+
+// transition moves the alert of key to the state its condition calls for,
+// returning the alert and whether its state changed. m.mu must be held.
+func (m *Manager) transition(key alertKey, rule Rule, active bool, value float64, now time.Time) (Alert, bool) {
+	a, exists := m.alerts[key]
+	if exists && a.State != StateResolved {
+		a.Value = value
+	}
+
+	switch {
+	case active && (!exists || a.State == StateResolved):
+		a = &Alert{
+			Rule:        rule.Name,
+			Function:    key.function,
+			State:       StatePending,
+			Severity:    rule.Severity,
+			Description: rule.Description,
+			Metric:      rule.Metric,
+			Op:          rule.Op,
+			Threshold:   rule.Threshold,
+			Value:       value,
+			ActiveAt:    now,
+		}
+		m.alerts[key] = a
+		if rule.ForSecs > 0 {
+			break
+		}
+		fallthrough
+	case active && a.State == StatePending && now.Sub(a.ActiveAt) >= time.Duration(rule.ForSecs)*time.Second:
+		a.State = StateFiring
+		a.FiredAt = now
+	case !active && exists && a.State == StatePending:
+		// The condition didn't hold long enough to fire
+		delete(m.alerts, key)
+		return Alert{}, false
+	case !active && exists && a.State == StateFiring:
+		a.State = StateResolved
+		a.ResolvedAt = now
+	default:
+		if exists && a.State != StateResolved {
+			a.summarize(rule)
+		}
+		return Alert{}, false
+	}
+
+	a.summarize(rule)
+	return *a, true
+}
+
+// publish announces an alert's new state on the event bus and queues its
+// notification
+func (m *Manager) publish(a Alert) {
+	transitionsTotal.Inc(a.Rule, a.State)
+	log.Printf("Alert %s", a.Summary)
+
+	if m.bus != nil {
+		m.bus.Publish(context.Background(), event.Event{Type: EventPrefix + a.State, Payload: a.payload()})
+	}
+
+	if a.State == StatePending || len(m.notifiers) == 0 {
+		return
+	}
+	select {
+	case m.notify <- a:
+	default:
+		notificationsTotal.Inc("dropped")
+		log.Printf("Warning: Alert notification queue full, dropped %s", a.Summary)
+	}
+}
+
+// send delivers queued notifications to every notifier, retrying failures
+func (m *Manager) send() {
+	defer close(m.sent)
+
+	for a := range m.notify {
+		for _, n := range m.notifiers {
+			var err error
+			for attempt := 0; attempt < notifyAttempts; attempt++ {
+				if attempt > 0 {
+					time.Sleep(m.retryDelay << (attempt - 1))
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+				err = n.Notify(ctx, a)
+				cancel()
+				if err == nil {
+					break
+				}
+			}
+			if err != nil {
+				notificationsTotal.Inc("error")
+				log.Printf("Warning: Failed to send alert notification for %s on %s: %v", a.Rule, a.Function, err)
+				continue
+			}
+			notificationsTotal.Inc("success")
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/common"
+	"github.com/mstgnz/self-hosted-serverless/internal/event"
+	"github.com/mstgnz/self-hosted-serverless/internal/function"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSource serves fixed metrics for each window
+type fakeSource struct {
+	mu        sync.Mutex
+	functions []string
+	windows   map[time.Duration]map[string]function.WindowMetrics
+}
+
+func newFakeSource(functions ...string) *fakeSource {
+	return &fakeSource{functions: functions, windows: make(map[time.Duration]map[string]function.WindowMetrics)}
+}
+
+func (s *fakeSource) set(window time.Duration, m function.WindowMetrics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.windows[window] == nil {
+		s.windows[window] = make(map[string]function.WindowMetrics)
+	}
+	s.windows[window][m.Name] = m
+}
+
+func (s *fakeSource) ListFunctions() []common.FunctionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infos := make([]common.FunctionInfo, len(s.functions))
+	for i, name := range s.functions {
+		infos[i] = common.FunctionInfo{Name: name}
+	}
+	return infos
+}
+
+func (s *fakeSource) GetWindowMetrics(window time.Duration) map[string]function.WindowMetrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	metrics := make(map[string]function.WindowMetrics)
+	for name, m := range s.windows[window] {
+		metrics[name] = m
+	}
+	return metrics
+}
+
+// recordEvents collects the alert events published on bus
+func recordEvents(bus *event.Bus) func() []event.Event {
+	var mu sync.Mutex
+	var events []event.Event
+	for _, state := range []string{StatePending, StateFiring, StateResolved} {
+		bus.Subscribe(EventPrefix+state, func(ctx context.Context, evt event.Event) error {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, evt)
+			return nil
+		})
+	}
+	return func() []event.Event {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]event.Event(nil), events...)
+	}
+}
+
+func newTestManager(t *testing.T, source Source, bus *event.Bus, cfg Config) *Manager {
+	m, err := NewManager(source, bus, cfg)
+	require.NoError(t, err)
+	m.retryDelay = time.Millisecond
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestAlertLifecycle(t *testing.T) {
+	var mu sync.Mutex
+	var received []map[string]any
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		mu.Lock()
+		received = append(received, body)
+		mu.Unlock()
+	}))
+	defer endpoint.Close()
+
+	source := newFakeSource("resize-image", "thumbnail")
+	bus := event.NewBus()
+	events := recordEvents(bus)
+	m := newTestManager(t, source, bus, Config{
+		Rules: []Rule{{
+			Name:           "high-error-rate",
+			Metric:         "error_rate",
+			Op:             ">",
+			Threshold:      0.05,
+			WindowSecs:     300,
+			ForSecs:        60,
+			MinInvocations: 10,
+			Severity:       SeverityCritical,
+		}},
+		Notifiers: []NotifierConfig{
+			{URL: endpoint.URL, Headers: map[string]string{"Authorization": "Bearer token"}},
+			{URL: endpoint.URL, Format: FormatSlack, Headers: map[string]string{"Authorization": "Bearer token"}},
+		},
+	})
+
+	window := 5 * time.Minute
+	source.set(window, function.WindowMetrics{Name: "resize-image", Invocations: 100, Errors: 12, ErrorRate: 0.12})
+	source.set(window, function.WindowMetrics{Name: "thumbnail", Invocations: 2, Errors: 2, ErrorRate: 1})
+	start := time.Now()
+
+	// The condition holding makes the alert pending. Too few invocations
+	// keep the other function out of it.
+	m.Evaluate(start)
+	alerts := m.Alerts("")
+	require.Len(t, alerts, 1)
+	assert.Equal(t, StatePending, alerts[0].State)
+	assert.Equal(t, "resize-image", alerts[0].Function)
+	assert.Equal(t, 0.12, alerts[0].Value)
+
+	// It fires once it has held for the rule's duration
+	m.Evaluate(start.Add(30 * time.Second))
+	assert.Equal(t, StatePending, m.Alerts("")[0].State)
+	m.Evaluate(start.Add(time.Minute))
+	firing := m.Alerts(StateFiring)
+	require.Len(t, firing, 1)
+	assert.Equal(t, start.Add(time.Minute), firing[0].FiredAt)
+	assert.Equal(t, "[FIRING] high-error-rate on resize-image: error_rate 0.12 > 0.05 over 5m (critical)", firing[0].Summary)
+
+	// And resolves when it stops holding
+	source.set(window, function.WindowMetrics{Name: "resize-image", Invocations: 100, Errors: 1, ErrorRate: 0.01})
+	m.Evaluate(start.Add(2 * time.Minute))
+	assert.Empty(t, m.Alerts(StateFiring))
+	resolved := m.Alerts(StateResolved)
+	require.Len(t, resolved, 1)
+	assert.Equal(t, 0.01, resolved[0].Value)
+
+	// Resolved alerts are listed for an hour
+	m.Evaluate(start.Add(2*time.Minute + resolvedRetention))
+	assert.Empty(t, m.Alerts(""))
+
+	var types []string
+	for _, evt := range events() {
+		types = append(types, evt.Type)
+		assert.Equal(t, "resize-image", evt.Payload["function"])
+	}
+	assert.Equal(t, []string{"alert.pending", "alert.firing", "alert.resolved"}, types)
+
+	// Firing and resolving are sent to both notifiers
+	require.NoError(t, m.Close())
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, received, 4)
+	assert.Equal(t, "firing", received[0]["state"])
+	assert.Equal(t, firing[0].Summary, received[1]["text"])
+	assert.Equal(t, "resolved", received[2]["state"])
+	assert.Contains(t, received[3]["text"], "[RESOLVED]")
+}
+
+func TestAlertPendingClears(t *testing.T) {
+	source := newFakeSource("resize-image")
+	bus := event.NewBus()
+	events := recordEvents(bus)
+	m := newTestManager(t, source, bus, Config{Rules: []Rule{{
+		Name: "slow", Metric: "p95_ms", Op: ">", Threshold: 2000, WindowSecs: 300, ForSecs: 300,
+	}}})
+
+	window := 5 * time.Minute
+	source.set(window, function.WindowMetrics{Name: "resize-image", Invocations: 5, Latency: function.LatencyStats{P95: 3 * time.Second}})
+	m.Evaluate(time.Now())
+	require.Len(t, m.Alerts(StatePending), 1)
+
+	// A condition that doesn't hold long enough never fires
+	source.set(window, function.WindowMetrics{Name: "resize-image", Invocations: 5, Latency: function.LatencyStats{P95: time.Second}})
+	m.Evaluate(time.Now())
+	assert.Empty(t, m.Alerts(""))
+	assert.Len(t, events(), 1)
+}
+
+func TestAlertNoInvocations(t *testing.T) {
+	source := newFakeSource("nightly-report", "resize-image")
+	m := newTestManager(t, source, nil, Config{Rules: []Rule{{
+		Name: "idle", Function: "nightly-report", Metric: "invocations", Op: "==", Threshold: 0, WindowSecs: 3600,
+	}}})
+	source.set(time.Hour, function.WindowMetrics{Name: "resize-image", Invocations: 40})
+
+	// Right after starting, no traffic is expected yet
+	now := time.Now()
+	m.Evaluate(now)
+	assert.Empty(t, m.Alerts(""))
+
+	// A function without metrics has no invocations
+	m.Evaluate(now.Add(time.Hour))
+	alerts := m.Alerts("")
+	require.Len(t, alerts, 1)
+	assert.Equal(t, StateFiring, alerts[0].State)
+	assert.Equal(t, "nightly-report", alerts[0].Function)
+
+	// Alerts of removed functions resolve
+	source.mu.Lock()
+	source.functions = []string{"resize-image"}
+	source.mu.Unlock()
+	m.Evaluate(now.Add(time.Hour + time.Minute))
+	assert.Len(t, m.Alerts(StateResolved), 1)
+}
+
+func TestAlertBaseline(t *testing.T) {
+	source := newFakeSource("checkout")
+	m := newTestManager(t, source, nil, Config{Rules: []Rule{{
+		Name: "timeout-spike", Metric: "timeout_rate", Op: ">", Threshold: 3, WindowSecs: 300, BaselineSecs: 3600,
+	}}})
+
+	// Timeouts over the last 5 minutes at twice the hourly rate
+	source.set(5*time.Minute, function.WindowMetrics{Name: "checkout", Invocations: 100, Timeouts: 4})
+	source.set(time.Hour, function.WindowMetrics{Name: "checkout", Invocations: 1000, Timeouts: 20})
+	m.Evaluate(time.Now())
+	assert.Empty(t, m.Alerts(""))
+
+	// And at five times
+	source.set(5*time.Minute, function.WindowMetrics{Name: "checkout", Invocations: 100, Timeouts: 10})
+	m.Evaluate(time.Now())
+	alerts := m.Alerts(StateFiring)
+	require.Len(t, alerts, 1)
+	assert.InDelta(t, 5, alerts[0].Value, 0.001)
+	assert.Contains(t, alerts[0].Summary, "over 5m vs 1h")
+
+	// No timeouts at all is no spike
+	source.set(5*time.Minute, function.WindowMetrics{Name: "checkout", Invocations: 100})
+	source.set(time.Hour, function.WindowMetrics{Name: "checkout", Invocations: 1000})
+	m.Evaluate(time.Now())
+	assert.Len(t, m.Alerts(StateResolved), 1)
+}
+
+func TestNotificationRetries(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer endpoint.Close()
+
+	source := newFakeSource("resize-image")
+	m := newTestManager(t, source, nil, Config{
+		Rules:     []Rule{{Name: "errors", Metric: "errors", Op: ">=", Threshold: 1, WindowSecs: 60}},
+		Notifiers: []NotifierConfig{{URL: endpoint.URL}},
+	})
+	source.set(time.Minute, function.WindowMetrics{Name: "resize-image", Invocations: 1, Errors: 1})
+	m.Evaluate(time.Now())
+	require.NoError(t, m.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 3, attempts)
+}
+
+func TestRuleValidation(t *testing.T) {
+	valid := Rule{Name: "r", Metric: "error_rate", Op: ">", Threshold: 0.1, WindowSecs: 300}
+	rule := valid
+	require.NoError(t, rule.Validate())
+	assert.Equal(t, SeverityWarning, rule.Severity)
+
+	tests := []struct {
+		name   string
+		modify func(r *Rule)
+	}{
+		{"no name", func(r *Rule) { r.Name = "" }},
+		{"unknown metric", func(r *Rule) { r.Metric = "cpu" }},
+		{"unknown op", func(r *Rule) { r.Op = "!=" }},
+		{"no window", func(r *Rule) { r.WindowSecs = 0 }},
+		{"window too long", func(r *Rule) { r.WindowSecs = 2 * 86400 }},
+		{"negative for", func(r *Rule) { r.ForSecs = -1 }},
+		{"baseline too short", func(r *Rule) { r.BaselineSecs = 300 }},
+		{"baseline of a count", func(r *Rule) { r.Metric = "errors"; r.BaselineSecs = 3600 }},
+		{"unknown severity", func(r *Rule) { r.Severity = "page" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := valid
+			tt.modify(&rule)
+			assert.Error(t, rule.Validate())
+		})
+	}
+
+	_, err := NewManager(newFakeSource(), nil, Config{Rules: []Rule{valid, valid}})
+	assert.ErrorContains(t, err, "duplicate rule")
+	_, err = NewManager(newFakeSource(), nil, Config{Notifiers: []NotifierConfig{{URLEnv: "ALERT_TEST_UNSET_URL"}}})
+	assert.ErrorContains(t, err, "notifier URL is empty")
+	_, err = NewManager(newFakeSource(), nil, Config{Notifiers: []NotifierConfig{{URL: "http://example.com", Format: "xml"}}})
+	assert.ErrorContains(t, err, "unknown notifier format")
+}
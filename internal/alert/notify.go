@@ -0,0 +1,108 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Notification formats
+const (
+	// FormatJSON posts the alert as JSON
+	FormatJSON = "json"
+	// FormatSlack posts {"text": summary}, which Slack incoming webhooks and
+	// compatible chat services such as Mattermost accept
+	FormatSlack = "slack"
+)
+
+// notifyTimeout caps how long one notification may take
+const notifyTimeout = 10 * time.Second
+
+// Notifier delivers alerts that fire or resolve
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// NotifierConfig describes an HTTP endpoint alerts are posted to
+type NotifierConfig struct {
+	// URL is the endpoint. URLEnv names an environment variable holding it
+	// instead, which keeps secret webhook URLs out of the config file.
+	URL     string            `json:"url,omitempty"`
+	URLEnv  string            `json:"url_env,omitempty"`
+	Format  string            `json:"format,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// WebhookNotifier posts alerts to an HTTP endpoint
+type WebhookNotifier struct {
+	url     string
+	format  string
+	headers map[string]string
+	client  *http.Client
+}
+
+// NewWebhookNotifier validates cfg and resolves its URL
+func NewWebhookNotifier(cfg NotifierConfig) (*WebhookNotifier, error) {
+	url := cfg.URL
+	if cfg.URLEnv != "" {
+		url = os.Getenv(cfg.URLEnv)
+	}
+	if url == "" {
+		return nil, errors.New("notifier URL is empty")
+	}
+
+	format := cfg.Format
+	switch format {
+	case "":
+		format = FormatJSON
+	case FormatJSON, FormatSlack:
+	default:
+		return nil, fmt.Errorf("unknown notifier format %q", cfg.Format)
+	}
+
+	return &WebhookNotifier{
+		url:     url,
+		format:  format,
+		headers: cfg.Headers,
+		client:  &http.Client{Timeout: notifyTimeout},
+	}, nil
+}
+
+// Notify posts an alert, failing unless the endpoint answers with a 2xx
+// status
+func (n *WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	var body any = alert
+	if n.format == FormatSlack {
+		body = map[string]string{"text": alert.Summary}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create notification: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("notification rejected with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/function"
+)
+
+// Severities
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Rule describes a condition on a function's windowed metrics that raises an
+// alert once it has held for ForSecs. When BaselineSecs is set, the metric
+// over the window is compared to the same metric over the longer baseline
+// window, which includes it, and Threshold is the ratio between the two, so
+// a threshold of 3 catches a metric tripling.
+type Rule struct {
+	Name string `json:"name"`
+	// Function is the function the rule watches. Empty watches every
+	// function, each raising its own alert.
+	Function     string  `json:"function,omitempty"`
+	Metric       string  `json:"metric"`
+	Op           string  `json:"op"`
+	Threshold    float64 `json:"threshold"`
+	WindowSecs   int     `json:"window_secs"`
+	ForSecs      int     `json:"for_secs,omitempty"`
+	BaselineSecs int     `json:"baseline_secs,omitempty"`
+	// MinInvocations skips the rule while the window holds fewer
+	// invocations, so one failed call isn't an error rate of 100%
+	MinInvocations int64  `json:"min_invocations,omitempty"`
+	Severity       string `json:"severity,omitempty"`
+	Description    string `json:"description,omitempty"`
+}
+
+// metric reads a value from windowed metrics
+type metric struct {
+	value func(m function.WindowMetrics) float64
+	// count is set for metrics that grow with the window, which can't be
+	// compared to a baseline
+	count bool
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// ruleMetrics are the metrics rules can watch
+var ruleMetrics = map[string]metric{
+	"invocations":     {value: func(m function.WindowMetrics) float64 { return float64(m.Invocations) }, count: true},
+	"errors":          {value: func(m function.WindowMetrics) float64 { return float64(m.Errors) }, count: true},
+	"timeouts":        {value: func(m function.WindowMetrics) float64 { return float64(m.Timeouts) }, count: true},
+	"invocation_rate": {value: func(m function.WindowMetrics) float64 { return m.InvocationRate }},
+	"error_rate":      {value: func(m function.WindowMetrics) float64 { return m.ErrorRate }},
+	"timeout_rate": {value: func(m function.WindowMetrics) float64 {
+		if m.Invocations == 0 {
+			return 0
+		}
+		return float64(m.Timeouts) / float64(m.Invocations)
+	}},
+	"avg_duration_ms": {value: func(m function.WindowMetrics) float64 { return ms(m.AverageDuration) }},
+	"p50_ms":          {value: func(m function.WindowMetrics) float64 { return ms(m.Latency.P50) }},
+	"p90_ms":          {value: func(m function.WindowMetrics) float64 { return ms(m.Latency.P90) }},
+	"p95_ms":          {value: func(m function.WindowMetrics) float64 { return ms(m.Latency.P95) }},
+	"p99_ms":          {value: func(m function.WindowMetrics) float64 { return ms(m.Latency.P99) }},
+	"max_ms":          {value: func(m function.WindowMetrics) float64 { return ms(m.Latency.Max) }},
+}
+
+// compare applies a comparison operator
+func compare(op string, value, threshold float64) bool {
+	switch op {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case "==":
+		return value == threshold
+	}
+	return false
+}
+
+// watchesLow reports whether the rule alerts on low values, such as traffic
+// stopping, which a server that just started would show too
+func (r Rule) watchesLow() bool {
+	return r.Op == "<" || r.Op == "<=" || r.Op == "=="
+}
+
+func (r Rule) window() time.Duration {
+	return time.Duration(r.WindowSecs) * time.Second
+}
+
+func (r Rule) baseline() time.Duration {
+	return time.Duration(r.BaselineSecs) * time.Second
+}
+
+// Validate checks a rule, filling in its default severity
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return errors.New("rule name is empty")
+	}
+	m, ok := ruleMetrics[r.Metric]
+	if !ok {
+		return fmt.Errorf("rule %s: unknown metric %q", r.Name, r.Metric)
+	}
+	switch r.Op {
+	case ">", ">=", "<", "<=", "==":
+	default:
+		return fmt.Errorf("rule %s: unknown op %q", r.Name, r.Op)
+	}
+	if r.WindowSecs <= 0 || r.window() > function.MaxMetricsWindow {
+		return fmt.Errorf("rule %s: window_secs must be between 1 and %d", r.Name, int(function.MaxMetricsWindow/time.Second))
+	}
+	if r.ForSecs < 0 {
+		return fmt.Errorf("rule %s: for_secs is negative", r.Name)
+	}
+	if r.BaselineSecs != 0 {
+		if m.count {
+			return fmt.Errorf("rule %s: %s can't be compared to a baseline, use a rate instead", r.Name, r.Metric)
+		}
+		if r.BaselineSecs <= r.WindowSecs || r.baseline() > function.MaxMetricsWindow {
+			return fmt.Errorf("rule %s: baseline_secs must be longer than window_secs and at most %d", r.Name, int(function.MaxMetricsWindow/time.Second))
+		}
+	}
+	switch r.Severity {
+	case "":
+		r.Severity = SeverityWarning
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return fmt.Errorf("rule %s: unknown severity %q", r.Name, r.Severity)
+	}
+	return nil
+}
+
+// DefaultIntervalSecs is how often rules are evaluated when the config
+// doesn't say
+const DefaultIntervalSecs = 30
+
+// Config is the content of an alert rules file
+type Config struct {
+	IntervalSecs int              `json:"interval_secs,omitempty"`
+	Rules        []Rule           `json:"rules"`
+	Notifiers    []NotifierConfig `json:"notifiers,omitempty"`
+}
+
+// LoadFile reads the alert rules file at path
+func LoadFile(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read alert rules file: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse alert rules file: %w", err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/db"
+)
+
+// ErrUnauthorized is returned when a client presents a missing or invalid key
+var ErrUnauthorized = errors.New("unauthorized")
+
+// defaultSubject is the identity subject of clients using API_KEY
+const defaultSubject = "default"
+
+// Authenticator validates the credentials presented by HTTP and gRPC clients.
+// Clients may use the single API_KEY, which grants every scope, one of the
+// named keys held in the key store, a JWT when a JWT verifier is set, or a
+// client certificate with a mapping.
+type Authenticator struct {
+	apiKey string
+	store  KeyStore
+	jwt    *JWTVerifier
+	certs  []CertIdentity
+}
+
+// NewAuthenticator creates an authenticator for the given key and key store.
+// Authentication is disabled when the key is empty and the store is nil.
+func NewAuthenticator(apiKey string, store KeyStore) *Authenticator {
+	return &Authenticator{apiKey: apiKey, store: store}
+}
+
+// WithJWT enables JWT bearer token authentication alongside API keys
+func (a *Authenticator) WithJWT(verifier *JWTVerifier) *Authenticator {
+	a.jwt = verifier
+	return a
+}
+
+// WithClientCerts grants the scopes of the mappings to the client
+// certificates they match
+func (a *Authenticator) WithClientCerts(certs []CertIdentity) *Authenticator {
+	a.certs = certs
+	return a
+}
+
+// NewAuthenticatorFromEnv creates an authenticator from API_KEY, the key
+// store selected by API_KEYS_FILE or API_KEYS_DB ("postgres" or "sqlite"),
+// the JWT settings read by JWTConfigFromEnv, and the client certificate
+// mappings in TLS_CLIENT_IDENTITIES_FILE
+func NewAuthenticatorFromEnv() (*Authenticator, error) {
+	var store KeyStore
+	switch {
+	case os.Getenv("API_KEYS_FILE") != "":
+		fileStore, err := NewFileKeyStore(os.Getenv("API_KEYS_FILE"))
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	case os.Getenv("API_KEYS_DB") != "":
+		conn, err := db.NewService(db.DatabaseType(os.Getenv("API_KEYS_DB")))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open key store: %w", err)
+		}
+		sqlStore, err := NewSQLKeyStore(conn.GetDB())
+		if err != nil {
+			return nil, err
+		}
+		store = sqlStore
+	}
+	authenticator := NewAuthenticator(os.Getenv("API_KEY"), store)
+
+	if cfg := JWTConfigFromEnv(); cfg.Enabled() {
+		verifier, err := NewJWTVerifier(cfg)
+		if err != nil {
+			return nil, err
+		}
+		authenticator.WithJWT(verifier)
+	}
+
+	if file := os.Getenv("TLS_CLIENT_IDENTITIES_FILE"); file != "" {
+		certs, err := LoadCertIdentities(file)
+		if err != nil {
+			return nil, err
+		}
+		authenticator.WithClientCerts(certs)
+	}
+	return authenticator, nil
+}
+
+// Enabled reports whether clients must present a key
+func (a *Authenticator) Enabled() bool {
+	return a.apiKey != "" || a.store != nil || a.jwt != nil || len(a.certs) > 0
+}
+
+// Authenticate checks the key presented by a client and returns its identity
+func (a *Authenticator) Authenticate(key string) (Identity, error) {
+	if !a.Enabled() {
+		return Identity{}, nil
+	}
+	if key == "" {
+		return Identity{}, ErrUnauthorized
+	}
+
+	if a.apiKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(a.apiKey)) == 1 {
+		return Identity{Subject: defaultSubject, Method: MethodAPIKey, Scopes: []string{ScopeAdmin}}, nil
+	}
+
+	if a.jwt != nil && looksLikeJWT(key) {
+		id, err := a.jwt.Verify(key)
+		if err != nil {
+			return Identity{}, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+		}
+		return id, nil
+	}
+
+	if a.store == nil || !strings.HasPrefix(key, keyPrefix) {
+		return Identity{}, ErrUnauthorized
+	}
+	// Keys are random, so looking them up by hash leaks nothing useful
+	// through timing
+	stored, err := a.store.FindByHash(HashKey(key))
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		log.Printf("Warning: Failed to look up API key: %v", err)
+	}
+	if err != nil || stored.Expired(time.Now()) {
+		return Identity{}, ErrUnauthorized
+	}
+	return stored.Identity(), nil
+}
+
+// ListKeys returns every key in the store
+func (a *Authenticator) ListKeys() ([]Key, error) {
+	if a.store == nil {
+		return nil, ErrNoKeyStore
+	}
+	return a.store.List()
+}
+
+// CreateKey adds a key to the store and returns it with its secret. The
+// secret is not stored and cannot be retrieved later.
+func (a *Authenticator) CreateKey(key Key) (Key, string, error) {
+	if a.store == nil {
+		return Key{}, "", ErrNoKeyStore
+	}
+	if err := key.validate(); err != nil {
+		return Key{}, "", err
+	}
+
+	id, err := newKeyID()
+	if err != nil {
+		return Key{}, "", err
+	}
+	secret, err := newSecret(id)
+	if err != nil {
+		return Key{}, "", err
+	}
+
+	key.ID = id
+	key.Hash = HashKey(secret)
+	key.CreatedAt = time.Now().UTC()
+	if err := a.store.Save(key); err != nil {
+		return Key{}, "", err
+	}
+	return key, secret, nil
+}
+
+// RotateKey replaces the secret of a key, keeping its name, scopes and
+// functions. The old secret stops working immediately.
+func (a *Authenticator) RotateKey(id string) (Key, string, error) {
+	if a.store == nil {
+		return Key{}, "", ErrNoKeyStore
+	}
+
+	key, err := a.store.Get(id)
+	if err != nil {
+		return Key{}, "", err
+	}
+	secret, err := newSecret(id)
+	if err != nil {
+		return Key{}, "", err
+	}
+
+	key.Hash = HashKey(secret)
+	if err := a.store.Save(key); err != nil {
+		return Key{}, "", err
+	}
+	return key, secret, nil
+}
+
+// RevokeKey deletes a key from the store
+func (a *Authenticator) RevokeKey(id string) error {
+	if a.store == nil {
+		return ErrNoKeyStore
+	}
+	return a.store.Delete(id)
+}
+
+// KeyFromHeaders extracts the key from the X-API-Key header value or, if that
+// is empty, from a Bearer token in the Authorization header value. gRPC
+// clients send the same values as x-api-key and authorization metadata.
+func KeyFromHeaders(apiKey, authorization string) string {
+	if apiKey != "" {
+		return apiKey
+	}
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimPrefix(authorization, "Bearer ")
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate(t *testing.T) {
+	// Authentication is disabled without a key
+	authenticator := NewAuthenticator("", nil)
+	assert.False(t, authenticator.Enabled())
+	_, err := authenticator.Authenticate("")
+	assert.NoError(t, err)
+
+	authenticator = NewAuthenticator("secret", nil)
+	assert.True(t, authenticator.Enabled())
+	id, err := authenticator.Authenticate("secret")
+	assert.NoError(t, err)
+	assert.True(t, id.HasScope(ScopeAdmin))
+	_, err = authenticator.Authenticate("wrong")
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	_, err = authenticator.Authenticate("")
+	assert.ErrorIs(t, err, ErrUnauthorized)
+
+	// Keys can't be managed without a store
+	_, _, err = authenticator.CreateKey(Key{Name: "ci", Scopes: []string{ScopeInvoke}})
+	assert.ErrorIs(t, err, ErrNoKeyStore)
+}
+
+func TestKeyLifecycle(t *testing.T) {
+	store, err := NewFileKeyStore(filepath.Join(t.TempDir(), "keys.json"))
+	assert.NoError(t, err)
+	authenticator := NewAuthenticator("", store)
+	assert.True(t, authenticator.Enabled())
+
+	// Invalid settings are rejected
+	_, _, err = authenticator.CreateKey(Key{Name: "ci"})
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	_, _, err = authenticator.CreateKey(Key{Name: "ci", Scopes: []string{"root"}})
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	key, secret, err := authenticator.CreateKey(Key{
+		Name:      "ci",
+		Scopes:    []string{ScopeInvoke},
+		Functions: []string{"deploy-hook"},
+	})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, key.ID)
+	assert.Equal(t, HashKey(secret), key.Hash)
+
+	id, err := authenticator.Authenticate(secret)
+	assert.NoError(t, err)
+	assert.Equal(t, Identity{
+		Subject:   "ci",
+		Method:    MethodAPIKey,
+		KeyID:     key.ID,
+		Scopes:    []string{ScopeInvoke},
+		Functions: []string{"deploy-hook"},
+	}, id)
+
+	// Rotating replaces the secret
+	rotated, newSecret, err := authenticator.RotateKey(key.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID, rotated.ID)
+	_, err = authenticator.Authenticate(secret)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	_, err = authenticator.Authenticate(newSecret)
+	assert.NoError(t, err)
+
+	// Revoking removes the key
+	assert.NoError(t, authenticator.RevokeKey(key.ID))
+	_, err = authenticator.Authenticate(newSecret)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.ErrorIs(t, authenticator.RevokeKey(key.ID), ErrKeyNotFound)
+	_, _, err = authenticator.RotateKey(key.ID)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestAuthenticateExpiredKey(t *testing.T) {
+	store, err := NewFileKeyStore(filepath.Join(t.TempDir(), "keys.json"))
+	assert.NoError(t, err)
+	authenticator := NewAuthenticator("", store)
+
+	expired := time.Now().Add(-time.Minute)
+	_, secret, err := authenticator.CreateKey(Key{Name: "old", Scopes: []string{ScopeInvoke}, ExpiresAt: &expired})
+	assert.NoError(t, err)
+
+	_, err = authenticator.Authenticate(secret)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestKeyFromHeaders(t *testing.T) {
+	assert.Equal(t, "key", KeyFromHeaders("key", ""))
+	assert.Equal(t, "key", KeyFromHeaders("key", "Bearer token"))
+	assert.Equal(t, "token", KeyFromHeaders("", "Bearer token"))
+	assert.Equal(t, "", KeyFromHeaders("", "Basic abc"))
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+)
+
+// CertIdentity grants scopes to the client certificates it matches. Each set
+// match field must match the certificate; at least one must be set.
+type CertIdentity struct {
+	// CommonName matches the common name of the certificate's subject
+	CommonName string `json:"common_name,omitempty"`
+	// DNSName, URI and Email match one of the certificate's subject
+	// alternative names
+	DNSName string `json:"dns_name,omitempty"`
+	URI     string `json:"uri,omitempty"`
+	Email   string `json:"email,omitempty"`
+
+	Scopes    []string `json:"scopes"`
+	Functions []string `json:"functions,omitempty"`
+}
+
+// matches reports whether the certificate has every name the mapping sets
+func (c CertIdentity) matches(cert *x509.Certificate) bool {
+	if c.CommonName != "" && c.CommonName != cert.Subject.CommonName {
+		return false
+	}
+	if c.DNSName != "" && !slices.Contains(cert.DNSNames, c.DNSName) {
+		return false
+	}
+	if c.URI != "" && !slices.ContainsFunc(cert.URIs, func(u *url.URL) bool { return u.String() == c.URI }) {
+		return false
+	}
+	if c.Email != "" && !slices.Contains(cert.EmailAddresses, c.Email) {
+		return false
+	}
+	return true
+}
+
+func (c CertIdentity) validate() error {
+	if c.CommonName == "" && c.DNSName == "" && c.URI == "" && c.Email == "" {
+		return errors.New("one of common_name, dns_name, uri or email is required")
+	}
+	if len(c.Scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range c.Scopes {
+		if !slices.Contains(Scopes, scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// LoadCertIdentities reads the client certificate mappings in the JSON file
+// at path
+func LoadCertIdentities(path string) ([]CertIdentity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client identities file: %w", err)
+	}
+	var certs []CertIdentity
+	if err := json.Unmarshal(data, &certs); err != nil {
+		return nil, fmt.Errorf("failed to parse client identities file: %w", err)
+	}
+	for i, c := range certs {
+		if err := c.validate(); err != nil {
+			return nil, fmt.Errorf("client identity %d: %w", i, err)
+		}
+	}
+	return certs, nil
+}
+
+// certSubject returns the certificate's common name, or its full subject if
+// the common name is empty
+func certSubject(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	return cert.Subject.String()
+}
+
+// AuthenticateCert returns the identity of the client certificate presented
+// on a TLS connection. The listeners only accept certificates signed by the
+// configured client CA, so a presented certificate is a verified one. It is
+// granted the scopes of the first mapping that matches it; a certificate
+// without a mapping doesn't authenticate the client, which must then present
+// a key.
+func (a *Authenticator) AuthenticateCert(state *tls.ConnectionState) (Identity, bool) {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return Identity{}, false
+	}
+
+	cert := state.PeerCertificates[0]
+	for _, c := range a.certs {
+		if c.matches(cert) {
+			return Identity{
+				Subject:   certSubject(cert),
+				Method:    MethodClientCert,
+				Scopes:    c.Scopes,
+				Functions: c.Functions,
+			}, true
+		}
+	}
+	return Identity{}, false
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticateCert(t *testing.T) {
+	a := NewAuthenticator("", nil).WithClientCerts([]CertIdentity{
+		{CommonName: "billing-service", Scopes: []string{ScopeInvoke}, Functions: []string{"charge"}},
+		{DNSName: "ci.internal", Scopes: []string{ScopeAdmin}},
+		{URI: "spiffe://acme/metrics", Email: "ops@acme.io", Scopes: []string{ScopeMetricsRead}},
+	})
+	assert.True(t, a.Enabled())
+
+	_, ok := a.AuthenticateCert(nil)
+	assert.False(t, ok)
+	_, ok = a.AuthenticateCert(&tls.ConnectionState{})
+	assert.False(t, ok)
+
+	authenticate := func(cert *x509.Certificate) (Identity, bool) {
+		return a.AuthenticateCert(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+	}
+
+	id, ok := authenticate(&x509.Certificate{Subject: pkix.Name{CommonName: "billing-service"}})
+	assert.True(t, ok)
+	assert.Equal(t, Identity{Subject: "billing-service", Method: MethodClientCert, Scopes: []string{ScopeInvoke}, Functions: []string{"charge"}}, id)
+
+	// Without a common name the full subject is used
+	id, ok = authenticate(&x509.Certificate{Subject: pkix.Name{Organization: []string{"Acme"}}, DNSNames: []string{"ci.internal"}})
+	assert.True(t, ok)
+	assert.Equal(t, "O=Acme", id.Subject)
+	assert.Equal(t, []string{ScopeAdmin}, id.Scopes)
+
+	// Every name a mapping sets must match
+	uri, _ := url.Parse("spiffe://acme/metrics")
+	_, ok = authenticate(&x509.Certificate{URIs: []*url.URL{uri}})
+	assert.False(t, ok)
+	id, ok = authenticate(&x509.Certificate{URIs: []*url.URL{uri}, EmailAddresses: []string{"ops@acme.io"}})
+	assert.True(t, ok)
+	assert.Equal(t, []string{ScopeMetricsRead}, id.Scopes)
+
+	// Certificates without a mapping get nothing
+	_, ok = authenticate(&x509.Certificate{Subject: pkix.Name{CommonName: "someone-else"}})
+	assert.False(t, ok)
+	_, ok = NewAuthenticator("secret", nil).AuthenticateCert(&tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "billing-service"}}},
+	})
+	assert.False(t, ok)
+}
+
+func TestLoadCertIdentities(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identities.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"common_name": "billing-service", "scopes": ["invoke"], "functions": ["charge"]}]`), 0600))
+	certs, err := LoadCertIdentities(path)
+	require.NoError(t, err)
+	assert.Equal(t, []CertIdentity{{CommonName: "billing-service", Scopes: []string{ScopeInvoke}, Functions: []string{"charge"}}}, certs)
+
+	for _, content := range []string{
+		`[{"scopes": ["invoke"]}]`,
+		`[{"common_name": "billing-service"}]`,
+		`[{"common_name": "billing-service", "scopes": ["root"]}]`,
+		`{}`,
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		_, err := LoadCertIdentities(path)
+		assert.Error(t, err, content)
+	}
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/ratelimit"
+)
+
+// Authentication methods recorded in an Identity
+const (
+	MethodAPIKey     = "api_key"
+	MethodClientCert = "client_cert"
+	MethodJWT        = "jwt"
+)
+
+// Identity describes an authenticated client and what it may access
+type Identity struct {
+	Subject string   `json:"subject"`
+	Method  string   `json:"method"`
+	KeyID   string   `json:"key_id,omitempty"`
+	Scopes  []string `json:"scopes,omitempty"`
+	// Functions limits which functions the client may invoke. An empty list
+	// allows every function.
+	Functions []string `json:"functions,omitempty"`
+	// Claims holds the verified claims of a JWT
+	Claims map[string]any `json:"claims,omitempty"`
+	// Limits caps the request rate and concurrency of the client. It is not
+	// passed to functions.
+	Limits *ratelimit.Limits `json:"-"`
+}
+
+// HasScope reports whether the identity was granted scope. The admin scope
+// grants every scope.
+func (id Identity) HasScope(scope string) bool {
+	return scope == "" || slices.Contains(id.Scopes, scope) || slices.Contains(id.Scopes, ScopeAdmin)
+}
+
+// CanInvoke reports whether the identity may invoke the named function
+func (id Identity) CanInvoke(name string) bool {
+	if !id.HasScope(ScopeInvoke) {
+		return false
+	}
+	return len(id.Functions) == 0 || slices.Contains(id.Functions, name)
+}
+
+// Map returns the identity as JSON-shaped data, the form in which it is
+// passed to functions
+func (id Identity) Map() map[string]any {
+	data, _ := json.Marshal(id)
+	var m map[string]any
+	json.Unmarshal(data, &m)
+	return m
+}
+
+type identityKey struct{}
+
+// NewContext returns a copy of ctx carrying the client identity
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the client identity stored in ctx, if any
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
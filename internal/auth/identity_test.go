@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentityContext(t *testing.T) {
+	_, ok := FromContext(context.Background())
+	assert.False(t, ok)
+
+	ctx := NewContext(context.Background(), Identity{Subject: "billing-service", Method: MethodClientCert})
+	id, ok := FromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "billing-service", id.Subject)
+}
+
+func TestIdentityScopes(t *testing.T) {
+	id := Identity{Scopes: []string{ScopeInvoke}, Functions: []string{"hello"}}
+	assert.True(t, id.HasScope(ScopeInvoke))
+	assert.False(t, id.HasScope(ScopeDBRead))
+	assert.True(t, id.CanInvoke("hello"))
+	assert.False(t, id.CanInvoke("other"))
+
+	// No function list allows every function; admin grants every scope
+	admin := Identity{Scopes: []string{ScopeAdmin}}
+	assert.True(t, admin.HasScope(ScopeDBRead))
+	assert.True(t, admin.CanInvoke("other"))
+
+	// Without the invoke scope no function can be invoked
+	reader := Identity{Scopes: []string{ScopeMetricsRead}}
+	assert.False(t, reader.CanInvoke("hello"))
+
+	assert.Equal(t, map[string]any{
+		"subject": "ci",
+		"method":  MethodAPIKey,
+		"scopes":  []any{ScopeInvoke},
+	}, Identity{Subject: "ci", Method: MethodAPIKey, Scopes: []string{ScopeInvoke}}.Map())
+}
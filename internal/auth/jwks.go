@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksRefreshInterval is how long keys fetched from a JWKS URL are used
+	// before they are fetched again
+	jwksRefreshInterval = time.Hour
+	// jwksMinRefreshInterval limits how often an unknown key ID triggers a
+	// fetch, so tokens with made-up key IDs can't flood the key server
+	jwksMinRefreshInterval = time.Minute
+)
+
+// jwk is a single JSON Web Key
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// Symmetric keys
+	K string `json:"k"`
+	// RSA keys
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC keys
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// verificationKey is a parsed JWK that can verify signatures
+type verificationKey struct {
+	kid string
+	alg string
+	key any // []byte, *rsa.PublicKey or *ecdsa.PublicKey
+}
+
+// keySet loads the keys used to verify tokens from a JWKS file or URL
+type keySet struct {
+	file   string
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      []verificationKey
+	modTime   time.Time
+	fetchedAt time.Time
+}
+
+func newKeySet(file, url string) (*keySet, error) {
+	ks := &keySet{
+		file:      file,
+		url:       url,
+		client:    &http.Client{Timeout: 5 * time.Second},
+		fetchedAt: time.Now(),
+	}
+	if err := ks.load(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// find returns the keys that may have signed a token with the given key ID
+// and algorithm. A token without a key ID may have been signed by any key.
+func (ks *keySet) find(kid, alg string) []verificationKey {
+	ks.refresh(false)
+	matches := ks.match(kid, alg)
+	if len(matches) == 0 && kid != "" {
+		// The issuer may have rotated its keys
+		ks.refresh(true)
+		matches = ks.match(kid, alg)
+	}
+	return matches
+}
+
+func (ks *keySet) match(kid, alg string) []verificationKey {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	var matches []verificationKey
+	for _, k := range ks.keys {
+		if (kid == "" || k.kid == kid) && k.alg == alg {
+			matches = append(matches, k)
+		}
+	}
+	return matches
+}
+
+// refresh reloads the keys if they are stale, or if force is set and they
+// were not fetched recently. The keys are read without holding the lock, so
+// a slow key server doesn't block verification with the current keys.
+func (ks *keySet) refresh(force bool) {
+	if !ks.stale(force) {
+		return
+	}
+	if err := ks.load(); err != nil {
+		log.Printf("Warning: Failed to refresh JWKS, keeping the previous keys: %v", err)
+	}
+}
+
+// stale reports whether the keys should be reloaded. For a JWKS URL it
+// records the attempt, even if the fetch later fails, so concurrent callers
+// and a broken key server don't cause a fetch on every request.
+func (ks *keySet) stale(force bool) bool {
+	if ks.file != "" {
+		info, err := os.Stat(ks.file)
+		ks.mu.Lock()
+		defer ks.mu.Unlock()
+		return err != nil || !info.ModTime().Equal(ks.modTime)
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	age := time.Since(ks.fetchedAt)
+	if age < jwksRefreshInterval && (!force || age < jwksMinRefreshInterval) {
+		return false
+	}
+	ks.fetchedAt = time.Now()
+	return true
+}
+
+// load reads and parses the keys, then swaps them in
+func (ks *keySet) load() error {
+	var (
+		data    []byte
+		modTime time.Time
+		err     error
+	)
+	if ks.file != "" {
+		info, statErr := os.Stat(ks.file)
+		if statErr != nil {
+			return fmt.Errorf("failed to read JWKS file: %w", statErr)
+		}
+		modTime = info.ModTime()
+		data, err = os.ReadFile(ks.file)
+		if err != nil {
+			return fmt.Errorf("failed to read JWKS file: %w", err)
+		}
+	} else {
+		data, err = ks.fetch()
+		if err != nil {
+			return err
+		}
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+	ks.modTime = modTime
+	return nil
+}
+
+func (ks *keySet) fetch() ([]byte, error) {
+	resp, err := ks.client.Get(ks.url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	return data, nil
+}
+
+// parseJWKS parses a JSON Web Key Set, skipping keys that are not usable for
+// signature verification
+func parseJWKS(data []byte) ([]verificationKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	var keys []verificationKey
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.parse()
+		if err != nil {
+			log.Printf("Warning: Skipping JWKS key %q: %v", k.Kid, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no usable keys")
+	}
+	return keys, nil
+}
+
+// parse converts a JWK to a verification key. The algorithm is derived from
+// the key type, so a token can never pick an algorithm its key wasn't made for.
+func (k jwk) parse() (verificationKey, error) {
+	var key verificationKey
+	switch k.Kty {
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil || len(secret) == 0 {
+			return key, errors.New("invalid symmetric key")
+		}
+		key = verificationKey{alg: "HS256", key: secret}
+	case "RSA":
+		n, errN := decodeBigInt(k.N)
+		e, errE := decodeBigInt(k.E)
+		if errN != nil || errE != nil || !e.IsInt64() {
+			return key, errors.New("invalid RSA key")
+		}
+		key = verificationKey{alg: "RS256", key: &rsa.PublicKey{N: n, E: int(e.Int64())}}
+	case "EC":
+		if k.Crv != "P-256" {
+			return key, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, errX := decodeBigInt(k.X)
+		y, errY := decodeBigInt(k.Y)
+		if errX != nil || errY != nil || !elliptic.P256().IsOnCurve(x, y) {
+			return key, errors.New("invalid EC key")
+		}
+		key = verificationKey{alg: "ES256", key: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}}
+	default:
+		return key, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+
+	if k.Alg != "" && k.Alg != key.alg {
+		return key, fmt.Errorf("unsupported algorithm %q", k.Alg)
+	}
+	key.kid = k.Kid
+	return key, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
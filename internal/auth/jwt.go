@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// jwtLeeway is the clock skew tolerated when checking exp and nbf
+const jwtLeeway = time.Minute
+
+// JWTConfig configures JWT bearer token authentication
+type JWTConfig struct {
+	// JWKSFile or JWKSURL is the source of the verification keys
+	JWKSFile string
+	JWKSURL  string
+	// Issuer and Audience, when set, must match the iss and aud claims
+	Issuer   string
+	Audience string
+	// ScopesClaim holds the granted scopes, as a space-separated string or
+	// a list. FunctionsClaim holds the functions the token may invoke.
+	ScopesClaim    string
+	FunctionsClaim string
+}
+
+// JWTConfigFromEnv reads JWT_JWKS_FILE, JWT_JWKS_URL, JWT_ISSUER,
+// JWT_AUDIENCE, JWT_SCOPES_CLAIM and JWT_FUNCTIONS_CLAIM
+func JWTConfigFromEnv() JWTConfig {
+	cfg := JWTConfig{
+		JWKSFile:       os.Getenv("JWT_JWKS_FILE"),
+		JWKSURL:        os.Getenv("JWT_JWKS_URL"),
+		Issuer:         os.Getenv("JWT_ISSUER"),
+		Audience:       os.Getenv("JWT_AUDIENCE"),
+		ScopesClaim:    os.Getenv("JWT_SCOPES_CLAIM"),
+		FunctionsClaim: os.Getenv("JWT_FUNCTIONS_CLAIM"),
+	}
+	if cfg.ScopesClaim == "" {
+		cfg.ScopesClaim = "scope"
+	}
+	if cfg.FunctionsClaim == "" {
+		cfg.FunctionsClaim = "functions"
+	}
+	return cfg
+}
+
+// Enabled reports whether JWT authentication is configured
+func (c JWTConfig) Enabled() bool {
+	return c.JWKSFile != "" || c.JWKSURL != ""
+}
+
+// JWTVerifier validates JWT bearer tokens signed with HS256, RS256 or ES256
+type JWTVerifier struct {
+	cfg  JWTConfig
+	keys *keySet
+}
+
+// NewJWTVerifier creates a verifier and loads its keys
+func NewJWTVerifier(cfg JWTConfig) (*JWTVerifier, error) {
+	if cfg.JWKSFile != "" && cfg.JWKSURL != "" {
+		return nil, errors.New("only one of JWT_JWKS_FILE and JWT_JWKS_URL may be set")
+	}
+	if !cfg.Enabled() {
+		return nil, errors.New("JWT authentication is not configured")
+	}
+
+	keys, err := newKeySet(cfg.JWKSFile, cfg.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+	return &JWTVerifier{cfg: cfg, keys: keys}, nil
+}
+
+// Verify checks a token's signature and claims and returns the identity it
+// describes. Scopes in the token that the server doesn't know, such as
+// "openid", are ignored.
+func (v *JWTVerifier) Verify(token string) (Identity, error) {
+	claims, err := v.verify(token, time.Now())
+	if err != nil {
+		return Identity{}, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	var scopes []string
+	for _, scope := range claimList(claims[v.cfg.ScopesClaim]) {
+		if slices.Contains(Scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return Identity{
+		Subject:   subject,
+		Method:    MethodJWT,
+		Scopes:    scopes,
+		Functions: claimList(claims[v.cfg.FunctionsClaim]),
+		Claims:    claims,
+	}, nil
+}
+
+func (v *JWTVerifier) verify(token string, now time.Time) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("invalid token signature")
+	}
+
+	keys := v.keys.find(header.Kid, header.Alg)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no key for algorithm %q and key ID %q", header.Alg, header.Kid)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	if !slices.ContainsFunc(keys, func(k verificationKey) bool { return verifySignature(k, signed, signature) }) {
+		return nil, errors.New("invalid token signature")
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid token claims: %w", err)
+	}
+	if err := v.checkClaims(claims, now); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *JWTVerifier) checkClaims(claims map[string]any, now time.Time) error {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token not yet valid")
+	}
+
+	if v.cfg.Issuer != "" && claims["iss"] != v.cfg.Issuer {
+		return errors.New("token issuer mismatch")
+	}
+	if v.cfg.Audience != "" && !slices.Contains(claimList(claims["aud"]), v.cfg.Audience) {
+		return errors.New("token audience mismatch")
+	}
+	return nil
+}
+
+func verifySignature(k verificationKey, signed, signature []byte) bool {
+	digest := sha256.Sum256(signed)
+
+	switch key := k.key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(signed)
+		return hmac.Equal(signature, mac.Sum(nil))
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		// ES256 signatures are the raw 32-byte r and s values
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	}
+	return false
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// claimList reads a claim holding either a space-separated string or a list
+// of strings
+func claimList(claim any) []string {
+	switch c := claim.(type) {
+	case string:
+		return strings.Fields(c)
+	case []any:
+		var list []string
+		for _, item := range c {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// looksLikeJWT reports whether a bearer credential is a JWT rather than an API key
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2 && !strings.HasPrefix(token, keyPrefix)
+}
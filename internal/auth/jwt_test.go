@@ -0,0 +1,311 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var b64 = base64.RawURLEncoding
+
+// testSigner signs tokens with one key and publishes it as a JWK
+type testSigner struct {
+	kid  string
+	alg  string
+	key  any
+	jwk  map[string]string
+	sign func(signed []byte) []byte
+}
+
+func newHMACSigner(t *testing.T, kid string) testSigner {
+	secret := []byte("a-shared-secret-of-reasonable-len")
+	return testSigner{
+		kid: kid, alg: "HS256",
+		jwk: map[string]string{"kty": "oct", "kid": kid, "k": b64.EncodeToString(secret)},
+		sign: func(signed []byte) []byte {
+			mac := hmac.New(sha256.New, secret)
+			mac.Write(signed)
+			return mac.Sum(nil)
+		},
+	}
+}
+
+func newRSASigner(t *testing.T, kid string) testSigner {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return testSigner{
+		kid: kid, alg: "RS256",
+		jwk: map[string]string{
+			"kty": "RSA", "kid": kid, "use": "sig",
+			"n": b64.EncodeToString(key.N.Bytes()),
+			"e": b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		},
+		sign: func(signed []byte) []byte {
+			digest := sha256.Sum256(signed)
+			sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+			require.NoError(t, err)
+			return sig
+		},
+	}
+}
+
+func newECSigner(t *testing.T, kid string) testSigner {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return testSigner{
+		kid: kid, alg: "ES256",
+		jwk: map[string]string{
+			"kty": "EC", "kid": kid, "crv": "P-256",
+			"x": b64.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y": b64.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		},
+		sign: func(signed []byte) []byte {
+			digest := sha256.Sum256(signed)
+			r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+			require.NoError(t, err)
+			return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		},
+	}
+}
+
+func (s testSigner) token(t *testing.T, claims map[string]any) string {
+	header, err := json.Marshal(map[string]string{"alg": s.alg, "typ": "JWT", "kid": s.kid})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	return signed + "." + b64.EncodeToString(s.sign([]byte(signed)))
+}
+
+func writeJWKS(t *testing.T, file string, signers ...testSigner) {
+	var keys []map[string]string
+	for _, s := range signers {
+		keys = append(keys, s.jwk)
+	}
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(file, data, 0600))
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"sub":       "alice",
+		"iss":       "https://issuer.example",
+		"aud":       []string{"serverless", "other"},
+		"exp":       time.Now().Add(time.Hour).Unix(),
+		"scope":     "openid invoke metrics:read",
+		"functions": []string{"hello"},
+		"tenant":    "acme",
+	}
+}
+
+func TestJWTVerify(t *testing.T) {
+	signers := []testSigner{newHMACSigner(t, "hs"), newRSASigner(t, "rs"), newECSigner(t, "es")}
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, file, signers...)
+
+	verifier, err := NewJWTVerifier(JWTConfig{
+		JWKSFile:       file,
+		Issuer:         "https://issuer.example",
+		Audience:       "serverless",
+		ScopesClaim:    "scope",
+		FunctionsClaim: "functions",
+	})
+	require.NoError(t, err)
+
+	for _, signer := range signers {
+		t.Run(signer.alg, func(t *testing.T) {
+			id, err := verifier.Verify(signer.token(t, validClaims()))
+			require.NoError(t, err)
+			assert.Equal(t, "alice", id.Subject)
+			assert.Equal(t, MethodJWT, id.Method)
+			// Unknown scopes such as openid are dropped
+			assert.Equal(t, []string{ScopeInvoke, ScopeMetricsRead}, id.Scopes)
+			assert.True(t, id.CanInvoke("hello"))
+			assert.False(t, id.CanInvoke("other"))
+			assert.Equal(t, "acme", id.Claims["tenant"])
+		})
+	}
+
+	hs := signers[0]
+	invalid := map[string]func(claims map[string]any){
+		"expired":          func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"no expiry":        func(c map[string]any) { delete(c, "exp") },
+		"not yet valid":    func(c map[string]any) { c["nbf"] = time.Now().Add(time.Hour).Unix() },
+		"wrong issuer":     func(c map[string]any) { c["iss"] = "https://evil.example" },
+		"wrong audience":   func(c map[string]any) { c["aud"] = "other" },
+		"missing audience": func(c map[string]any) { delete(c, "aud") },
+	}
+	for name, mutate := range invalid {
+		t.Run(name, func(t *testing.T) {
+			claims := validClaims()
+			mutate(claims)
+			_, err := verifier.Verify(hs.token(t, claims))
+			assert.Error(t, err)
+		})
+	}
+
+	// Expiry within the leeway is still accepted
+	claims := validClaims()
+	claims["exp"] = time.Now().Add(-30 * time.Second).Unix()
+	_, err = verifier.Verify(hs.token(t, claims))
+	assert.NoError(t, err)
+
+	// A tampered payload fails signature verification
+	token := hs.token(t, validClaims())
+	parts := strings.Split(token, ".")
+	forged := validClaims()
+	forged["sub"] = "mallory"
+	payload, _ := json.Marshal(forged)
+	_, err = verifier.Verify(parts[0] + "." + b64.EncodeToString(payload) + "." + parts[2])
+	assert.Error(t, err)
+
+	// A token can't choose an algorithm its key wasn't made for
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "kid": "rs"})
+	signed := b64.EncodeToString(header) + "." + parts[1]
+	_, err = verifier.Verify(signed + "." + b64.EncodeToString(hs.sign([]byte(signed))))
+	assert.Error(t, err)
+
+	header, _ = json.Marshal(map[string]string{"alg": "none"})
+	_, err = verifier.Verify(b64.EncodeToString(header) + "." + parts[1] + ".")
+	assert.Error(t, err)
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	first := newECSigner(t, "first")
+	second := newECSigner(t, "second")
+
+	fetches := 0
+	current := first
+	keyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{current.jwk}})
+	}))
+	defer keyServer.Close()
+
+	cfg := JWTConfig{JWKSURL: keyServer.URL, ScopesClaim: "scope", FunctionsClaim: "functions"}
+	verifier, err := NewJWTVerifier(cfg)
+	require.NoError(t, err)
+
+	_, err = verifier.Verify(first.token(t, validClaims()))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fetches)
+
+	// A token signed with an unknown key ID refetches the key set, once the
+	// last fetch is old enough
+	current = second
+	_, err = verifier.Verify(second.token(t, validClaims()))
+	assert.Error(t, err)
+	assert.Equal(t, 1, fetches)
+
+	verifier.keys.fetchedAt = time.Now().Add(-2 * jwksMinRefreshInterval)
+	_, err = verifier.Verify(second.token(t, validClaims()))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fetches)
+
+	// Unknown key IDs don't trigger another fetch straight away
+	_, err = verifier.Verify(newECSigner(t, "unknown").token(t, validClaims()))
+	assert.Error(t, err)
+	assert.Equal(t, 2, fetches)
+}
+
+func TestJWTVerifyDuringFetch(t *testing.T) {
+	signer := newECSigner(t, "current")
+
+	fetching := make(chan struct{})
+	release := make(chan struct{})
+	first := true
+	keyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !first {
+			close(fetching)
+			<-release
+		}
+		first = false
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{signer.jwk}})
+	}))
+	defer keyServer.Close()
+	defer close(release)
+
+	verifier, err := NewJWTVerifier(JWTConfig{JWKSURL: keyServer.URL, ScopesClaim: "scope"})
+	require.NoError(t, err)
+
+	// An unknown key ID starts a fetch that hangs on the key server
+	verifier.keys.fetchedAt = time.Now().Add(-2 * jwksMinRefreshInterval)
+	go verifier.Verify(newECSigner(t, "unknown").token(t, validClaims()))
+	<-fetching
+
+	// Tokens signed with a known key still verify in the meantime
+	done := make(chan error, 1)
+	go func() {
+		_, err := verifier.Verify(signer.token(t, validClaims()))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("verification blocked on the key fetch")
+	}
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	signer := newRSASigner(t, "rs")
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, file, signer)
+
+	verifier, err := NewJWTVerifier(JWTConfig{JWKSFile: file, ScopesClaim: "scp", FunctionsClaim: "functions"})
+	require.NoError(t, err)
+	authenticator := NewAuthenticator("secret", nil).WithJWT(verifier)
+
+	claims := validClaims()
+	claims["scp"] = []string{ScopeEventsPublish}
+	id, err := authenticator.Authenticate(signer.token(t, claims))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ScopeEventsPublish}, id.Scopes)
+
+	// API keys keep working alongside JWTs
+	id, err = authenticator.Authenticate("secret")
+	assert.NoError(t, err)
+	assert.Equal(t, MethodAPIKey, id.Method)
+
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	_, err = authenticator.Authenticate(signer.token(t, claims))
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestParseJWKS(t *testing.T) {
+	_, err := parseJWKS([]byte(`{"keys": []}`))
+	assert.Error(t, err)
+
+	// Encryption keys and unsupported curves are skipped
+	keys, err := parseJWKS([]byte(`{"keys": [
+		{"kty": "oct", "kid": "enc", "use": "enc", "k": "c2VjcmV0"},
+		{"kty": "EC", "kid": "p384", "crv": "P-384", "x": "AA", "y": "AA"},
+		{"kty": "oct", "kid": "sig", "k": "c2VjcmV0"}
+	]}`))
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+	assert.Equal(t, "sig", keys[0].kid)
+	assert.Equal(t, "HS256", keys[0].alg)
+
+	// A key can't claim an algorithm that doesn't match its type
+	_, err = parseJWKS([]byte(`{"keys": [{"kty": "oct", "alg": "RS256", "k": "c2VjcmV0"}]}`))
+	assert.Error(t, err)
+}
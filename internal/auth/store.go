@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// KeyStore persists API keys
+type KeyStore interface {
+	// List returns every key
+	List() ([]Key, error)
+	// Get returns the key with the given ID
+	Get(id string) (Key, error)
+	// FindByHash returns the key whose secret has the given hash
+	FindByHash(hash string) (Key, error)
+	// Save inserts a key or replaces the key with the same ID
+	Save(key Key) error
+	// Delete removes the key with the given ID
+	Delete(id string) error
+}
+
+// keyManifest is the on-disk format of a FileKeyStore
+type keyManifest struct {
+	Keys []Key `json:"keys"`
+}
+
+// FileKeyStore keeps keys in a JSON file. The file is re-read whenever it is
+// replaced or modified, so every server sharing the file sees keys created
+// or revoked by the others.
+type FileKeyStore struct {
+	file string
+	mu   sync.Mutex
+	keys []Key
+	info os.FileInfo
+}
+
+// NewFileKeyStore creates a key store backed by file. The file is created on
+// the first change if it does not exist.
+func NewFileKeyStore(file string) (*FileKeyStore, error) {
+	s := &FileKeyStore{file: file}
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// List returns every key
+func (s *FileKeyStore) List() ([]Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	return append([]Key(nil), s.keys...), nil
+}
+
+// Get returns the key with the given ID
+func (s *FileKeyStore) Get(id string) (Key, error) {
+	return s.find(func(k Key) bool { return k.ID == id })
+}
+
+// FindByHash returns the key whose secret has the given hash
+func (s *FileKeyStore) FindByHash(hash string) (Key, error) {
+	return s.find(func(k Key) bool { return k.Hash == hash })
+}
+
+// Save inserts a key or replaces the key with the same ID
+func (s *FileKeyStore) Save(key Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.refresh(); err != nil {
+		return err
+	}
+
+	keys := append([]Key(nil), s.keys...)
+	replaced := false
+	for i, k := range keys {
+		if k.ID == key.ID {
+			keys[i] = key
+			replaced = true
+		}
+	}
+	if !replaced {
+		keys = append(keys, key)
+	}
+	return s.save(keys)
+}
+
+// Delete removes the key with the given ID
+func (s *FileKeyStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.refresh(); err != nil {
+		return err
+	}
+
+	for i, k := range s.keys {
+		if k.ID == id {
+			keys := append(append([]Key(nil), s.keys[:i]...), s.keys[i+1:]...)
+			return s.save(keys)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+}
+
+func (s *FileKeyStore) find(match func(Key) bool) (Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.refresh(); err != nil {
+		return Key{}, err
+	}
+	for _, k := range s.keys {
+		if match(k) {
+			return k, nil
+		}
+	}
+	return Key{}, ErrKeyNotFound
+}
+
+// refresh reloads the file if it changed. The caller must hold the lock.
+func (s *FileKeyStore) refresh() error {
+	info, err := os.Stat(s.file)
+	if os.IsNotExist(err) {
+		s.keys = nil
+		s.info = nil
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read keys file: %w", err)
+	}
+	// Changes made through a store replace the file, so comparing the file
+	// identity catches them even within the file system's timestamp resolution.
+	if s.info != nil && os.SameFile(s.info, info) && info.ModTime().Equal(s.info.ModTime()) && info.Size() == s.info.Size() {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		return fmt.Errorf("failed to read keys file: %w", err)
+	}
+	var manifest keyManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("failed to parse keys file: %w", err)
+	}
+
+	s.keys = manifest.Keys
+	s.info = info
+	return nil
+}
+
+// save writes keys to the file, replacing it atomically. The caller must
+// hold the lock.
+func (s *FileKeyStore) save(keys []Key) error {
+	data, err := json.MarshalIndent(keyManifest{Keys: keys}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode keys: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.file), ".keys-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to write keys file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write keys file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write keys file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.file); err != nil {
+		return fmt.Errorf("failed to write keys file: %w", err)
+	}
+
+	// Force a reload on the next read so the stored file info matches the
+	// new file
+	s.keys = keys
+	s.info = nil
+	return nil
+}
+
+// SQLKeyStore keeps keys in the api_keys table of a PostgreSQL or SQLite database
+type SQLKeyStore struct {
+	db *sql.DB
+}
+
+// NewSQLKeyStore creates a key store backed by db, creating the api_keys
+// table if it does not exist
+func NewSQLKeyStore(db *sql.DB) (*SQLKeyStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		functions TEXT NOT NULL,
+		limits TEXT NULL,
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create api_keys table: %w", err)
+	}
+	return &SQLKeyStore{db: db}, nil
+}
+
+const selectKeys = `SELECT id, name, hash, scopes, functions, limits, created_at, expires_at FROM api_keys`
+
+// List returns every key
+func (s *SQLKeyStore) List() ([]Key, error) {
+	rows, err := s.db.Query(selectKeys + ` ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []Key
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Get returns the key with the given ID
+func (s *SQLKeyStore) Get(id string) (Key, error) {
+	return scanKey(s.db.QueryRow(selectKeys+` WHERE id = $1`, id))
+}
+
+// FindByHash returns the key whose secret has the given hash
+func (s *SQLKeyStore) FindByHash(hash string) (Key, error) {
+	return scanKey(s.db.QueryRow(selectKeys+` WHERE hash = $1`, hash))
+}
+
+// Save inserts a key or replaces the key with the same ID
+func (s *SQLKeyStore) Save(key Key) error {
+	scopes, err := json.Marshal(key.Scopes)
+	if err != nil {
+		return err
+	}
+	functions, err := json.Marshal(key.Functions)
+	if err != nil {
+		return err
+	}
+	var limits sql.NullString
+	if key.Limits != nil {
+		data, err := json.Marshal(key.Limits)
+		if err != nil {
+			return err
+		}
+		limits = sql.NullString{String: string(data), Valid: true}
+	}
+
+	_, err = s.db.Exec(`INSERT INTO api_keys (id, name, hash, scopes, functions, limits, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name, hash = excluded.hash, scopes = excluded.scopes,
+			functions = excluded.functions, limits = excluded.limits, expires_at = excluded.expires_at`,
+		key.ID, key.Name, key.Hash, string(scopes), string(functions), limits, key.CreatedAt.UTC(), nullTime(key.ExpiresAt))
+	if err != nil {
+		return fmt.Errorf("failed to save key: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the key with the given ID
+func (s *SQLKeyStore) Delete(id string) error {
+	result, err := s.db.Exec(`DELETE FROM api_keys WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete key: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanKey(row rowScanner) (Key, error) {
+	var (
+		key       Key
+		scopes    string
+		functions string
+		limits    sql.NullString
+		expiresAt sql.NullTime
+	)
+	err := row.Scan(&key.ID, &key.Name, &key.Hash, &scopes, &functions, &limits, &key.CreatedAt, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Key{}, ErrKeyNotFound
+	}
+	if err != nil {
+		return Key{}, fmt.Errorf("failed to read key: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(scopes), &key.Scopes); err != nil {
+		return Key{}, fmt.Errorf("failed to read key scopes: %w", err)
+	}
+	if err := json.Unmarshal([]byte(functions), &key.Functions); err != nil {
+		return Key{}, fmt.Errorf("failed to read key functions: %w", err)
+	}
+	if limits.Valid {
+		if err := json.Unmarshal([]byte(limits.String), &key.Limits); err != nil {
+			return Key{}, fmt.Errorf("failed to read key limits: %w", err)
+		}
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	return key, nil
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/mstgnz/self-hosted-serverless/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+// testKeyStore exercises the behaviour shared by every KeyStore
+func testKeyStore(t *testing.T, store KeyStore) {
+	keys, err := store.List()
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	key := Key{
+		ID:        "k1",
+		Name:      "ci",
+		Hash:      HashKey("sk_k1_secret"),
+		Scopes:    []string{ScopeInvoke, ScopeMetricsRead},
+		Functions: []string{"hello"},
+		Limits:    &ratelimit.Limits{Requests: 10, Concurrency: 2},
+		CreatedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		ExpiresAt: &expires,
+	}
+	assert.NoError(t, store.Save(key))
+
+	found, err := store.FindByHash(key.Hash)
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID, found.ID)
+	assert.Equal(t, key.Scopes, found.Scopes)
+	assert.Equal(t, key.Functions, found.Functions)
+	assert.Equal(t, key.Limits, found.Limits)
+	assert.True(t, found.ExpiresAt.Equal(expires))
+
+	// Saving a key with the same ID replaces it
+	key.Hash = HashKey("sk_k1_rotated")
+	assert.NoError(t, store.Save(key))
+	_, err = store.FindByHash(HashKey("sk_k1_secret"))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	found, err = store.Get("k1")
+	assert.NoError(t, err)
+	assert.Equal(t, key.Hash, found.Hash)
+
+	// Keys without limits read back without them
+	key.Limits = nil
+	assert.NoError(t, store.Save(key))
+	found, err = store.Get("k1")
+	assert.NoError(t, err)
+	assert.Nil(t, found.Limits)
+
+	keys, err = store.List()
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+
+	assert.NoError(t, store.Delete("k1"))
+	assert.ErrorIs(t, store.Delete("k1"), ErrKeyNotFound)
+	_, err = store.Get("k1")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestFileKeyStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.json")
+	store, err := NewFileKeyStore(file)
+	assert.NoError(t, err)
+	testKeyStore(t, store)
+
+	// A second store on the same file sees changes made by the first
+	other, err := NewFileKeyStore(file)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Save(Key{ID: "k2", Name: "ops", Hash: "h2", Scopes: []string{ScopeAdmin}}))
+	found, err := other.FindByHash("h2")
+	assert.NoError(t, err)
+	assert.Equal(t, "ops", found.Name)
+}
+
+func TestSQLKeyStore(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	store, err := NewSQLKeyStore(db)
+	assert.NoError(t, err)
+	testKeyStore(t, store)
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"text/template"
 	"time"
 )
@@ -222,6 +223,16 @@ func GetMetrics() {
 			fmt.Printf("  Average Cold Start Latency: %v\n", time.Duration(avgColdStart))
 		}
 
+		// Format init and handler time
+		if avgInit, ok := metric["avg_init_duration"].(float64); ok {
+			fmt.Printf("  Average Init Duration: %v\n", time.Duration(avgInit))
+		}
+		if avgHandler, ok := metric["avg_handler_duration"].(float64); ok {
+			fmt.Printf("  Average Handler Duration: %v\n", time.Duration(avgHandler))
+		}
+
+		printLatency("  ", "Latency", metric["latency"])
+
 		fmt.Println()
 	}
 }
@@ -274,4 +285,34 @@ func GetFunctionMetrics(functionName string) {
 	if avgColdStart, ok := metric["avg_cold_start_latency"].(float64); ok {
 		fmt.Printf("Average Cold Start Latency: %v\n", time.Duration(avgColdStart))
 	}
+
+	// Format init and handler time
+	fmt.Printf("Compilations: %v (cache hits: %v)\n", metric["compilations"], metric["cache_hits"])
+	fmt.Printf("Instances Created: %v (reused: %v)\n", metric["instances_created"], metric["instances_reused"])
+	if avgInit, ok := metric["avg_init_duration"].(float64); ok {
+		fmt.Printf("Average Init Duration: %v\n", time.Duration(avgInit))
+	}
+	if avgHandler, ok := metric["avg_handler_duration"].(float64); ok {
+		fmt.Printf("Average Handler Duration: %v\n", time.Duration(avgHandler))
+	}
+
+	printLatency("", "Latency", metric["latency"])
+	printLatency("", "Cold Latency", metric["cold_latency"])
+	printLatency("", "Warm Latency", metric["warm_latency"])
+}
+
+// printLatency prints the percentiles of a latency distribution on one line
+func printLatency(indent, label string, data interface{}) {
+	latency, ok := data.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	parts := make([]string, 0, 5)
+	for _, p := range []string{"p50", "p90", "p95", "p99", "max"} {
+		if v, ok := latency[p].(float64); ok {
+			parts = append(parts, fmt.Sprintf("%s %v", p, time.Duration(v)))
+		}
+	}
+	fmt.Printf("%s%s: %s\n", indent, label, strings.Join(parts, ", "))
 }
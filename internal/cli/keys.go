@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// keyResponse is a key as returned by the /keys endpoints
+type keyResponse struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	Functions []string `json:"functions"`
+	CreatedAt string   `json:"created_at"`
+	ExpiresAt string   `json:"expires_at"`
+}
+
+// adminRequest sends a request to the local server's key API, authenticated
+// with the admin key in the API_KEY environment variable
+func adminRequest(method, path string, body any) []byte {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			log.Fatalf("Failed to marshal request body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, "http://localhost:8080"+path, reader)
+	if err != nil {
+		log.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key := os.Getenv("API_KEY"); key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatalf("Failed to read response: %v", err)
+	}
+	if resp.StatusCode >= 300 {
+		log.Fatalf("Request failed: %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data
+}
+
+// ListKeys lists the API keys in the server's key store
+func ListKeys() {
+	var result struct {
+		Keys []keyResponse `json:"keys"`
+	}
+	if err := json.Unmarshal(adminRequest(http.MethodGet, "/keys", nil), &result); err != nil {
+		log.Fatalf("Failed to parse response: %v", err)
+	}
+
+	if len(result.Keys) == 0 {
+		fmt.Println("No keys")
+		return
+	}
+	fmt.Println("API keys:")
+	for _, k := range result.Keys {
+		functions := "all functions"
+		if len(k.Functions) > 0 {
+			functions = strings.Join(k.Functions, ",")
+		}
+		fmt.Printf("  %s  %s  scopes=%s  functions=%s\n", k.ID, k.Name, strings.Join(k.Scopes, ","), functions)
+	}
+}
+
+// CreateKey creates an API key and prints its secret. An empty functions
+// list allows every function.
+func CreateKey(name string, scopes, functions []string) {
+	printKeySecret(adminRequest(http.MethodPost, "/keys", map[string]any{
+		"name":      name,
+		"scopes":    scopes,
+		"functions": functions,
+	}))
+}
+
+// RotateKey replaces the secret of an API key and prints the new secret
+func RotateKey(id string) {
+	printKeySecret(adminRequest(http.MethodPost, "/keys/"+id+"/rotate", nil))
+}
+
+// RevokeKey deletes an API key
+func RevokeKey(id string) {
+	adminRequest(http.MethodDelete, "/keys/"+id, nil)
+	fmt.Printf("Key %s revoked\n", id)
+}
+
+func printKeySecret(data []byte) {
+	var result struct {
+		Key    keyResponse `json:"key"`
+		Secret string      `json:"secret"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		log.Fatalf("Failed to parse response: %v", err)
+	}
+
+	fmt.Printf("Key %s (%s)\n", result.Key.ID, result.Key.Name)
+	fmt.Printf("  Secret: %s\n", result.Secret)
+	fmt.Println("Store the secret now; it cannot be shown again.")
+}
+
+// SplitList splits a comma-separated command line argument
+func SplitList(arg string) []string {
+	var items []string
+	for _, item := range strings.Split(arg, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,12 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitList(t *testing.T) {
+	assert.Equal(t, []string{"invoke", "metrics:read"}, SplitList("invoke, metrics:read,"))
+	assert.Nil(t, SplitList(""))
+}
@@ -0,0 +1,165 @@
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Forwarding headers a trusted proxy can set
+const (
+	HeaderForwarded    = "Forwarded"
+	HeaderForwardedFor = "X-Forwarded-For"
+	HeaderRealIP       = "X-Real-IP"
+)
+
+var headers = []string{HeaderForwarded, HeaderForwardedFor, HeaderRealIP}
+
+// Resolver determines the IP address of the client behind a request. The
+// forwarding header set by proxies is only honoured when the request comes
+// from a trusted proxy, since anyone else can send it with any value. Only
+// the one header the proxies set is read: a proxy passes the others through
+// as the client sent them.
+type Resolver struct {
+	trusted []netip.Prefix
+	header  string
+}
+
+// NewResolver creates a resolver trusting proxies in the given CIDR ranges to
+// set the named forwarding header, X-Forwarded-For if it is empty. Single IP
+// addresses are accepted as well as ranges.
+func NewResolver(proxies []string, header string) (*Resolver, error) {
+	r := &Resolver{header: HeaderForwardedFor}
+	if header != "" {
+		i := slices.IndexFunc(headers, func(h string) bool {
+			return strings.EqualFold(h, header)
+		})
+		if i < 0 {
+			return nil, fmt.Errorf("invalid trusted proxy header %q: must be %s, %s or %s", header, HeaderForwarded, HeaderForwardedFor, HeaderRealIP)
+		}
+		r.header = headers[i]
+	}
+
+	for _, p := range proxies {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(p)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", p, err)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		r.trusted = append(r.trusted, prefix.Masked())
+	}
+	return r, nil
+}
+
+// ResolverFromEnv creates a resolver trusting the comma-separated CIDR ranges
+// in TRUSTED_PROXIES to set the header named by TRUSTED_PROXY_HEADER. Without
+// TRUSTED_PROXIES, forwarding headers are ignored.
+func ResolverFromEnv() (*Resolver, error) {
+	return NewResolver(strings.Split(os.Getenv("TRUSTED_PROXIES"), ","), os.Getenv("TRUSTED_PROXY_HEADER"))
+}
+
+// Header returns the name of the forwarding header the resolver reads
+func (r *Resolver) Header() string {
+	return r.header
+}
+
+// FromRequest returns the IP address of the client behind an HTTP request
+func (r *Resolver) FromRequest(req *http.Request) string {
+	return r.Resolve(req.RemoteAddr, req.Header.Values(r.header))
+}
+
+// Resolve returns the IP address of the client given the address the
+// connection came from and the values of the resolver's forwarding header.
+//
+// The hops recorded by the header are walked from the connection backwards,
+// as long as each hop is a trusted proxy. The first untrusted hop is the
+// client; anything further left may have been made up by it.
+func (r *Resolver) Resolve(remoteAddr string, values []string) string {
+	remote, ok := parseHost(remoteAddr)
+	if !ok {
+		return remoteAddr
+	}
+	if !r.isTrusted(remote) {
+		return remote.String()
+	}
+
+	var hops []string
+	switch r.header {
+	case HeaderForwarded:
+		hops = parseForwarded(values)
+	case HeaderForwardedFor:
+		for _, v := range values {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+	default:
+		hops = values
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, ok := parseHost(hops[i])
+		if !ok {
+			// An unknown or obfuscated hop hides everything behind it
+			break
+		}
+		client = ip
+		if !r.isTrusted(ip) {
+			break
+		}
+	}
+	return client.String()
+}
+
+func (r *Resolver) isTrusted(ip netip.Addr) bool {
+	for _, prefix := range r.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwarded returns the for= parameters of Forwarded header values, in
+// hop order
+func parseForwarded(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hops = append(hops, strings.Trim(val, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// parseHost parses an IP address that may carry a port, and IPv6 addresses
+// that may be in brackets
+func parseHost(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
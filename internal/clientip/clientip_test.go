@@ -0,0 +1,92 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewResolver(t *testing.T) {
+	r, err := NewResolver([]string{"10.0.0.0/8", " 192.168.1.1 ", "", "::1"}, "")
+	assert.NoError(t, err)
+	assert.Len(t, r.trusted, 3)
+	assert.Equal(t, HeaderForwardedFor, r.Header())
+
+	r, err = NewResolver(nil, "x-real-ip")
+	assert.NoError(t, err)
+	assert.Equal(t, HeaderRealIP, r.Header())
+
+	_, err = NewResolver([]string{"not-an-ip"}, "")
+	assert.Error(t, err)
+	_, err = NewResolver(nil, "X-Client-IP")
+	assert.Error(t, err)
+}
+
+func TestResolve(t *testing.T) {
+	proxies := []string{"10.0.0.0/8", "2001:db8::/32"}
+
+	tests := []struct {
+		name   string
+		header string
+		remote string
+		values []string
+		want   string
+	}{
+		{name: "direct", remote: "203.0.113.7:4000", want: "203.0.113.7"},
+		{name: "untrusted remote ignores headers", remote: "203.0.113.7:4000", values: []string{"1.2.3.4"}, want: "203.0.113.7"},
+		{name: "trusted proxy", remote: "10.0.0.1:4000", values: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "spoofed leftmost hop", remote: "10.0.0.1:4000", values: []string{"1.2.3.4, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "chain of trusted proxies", remote: "10.0.0.1:4000", values: []string{"198.51.100.1, 10.0.0.2", "10.0.0.3"}, want: "198.51.100.1"},
+		{name: "only trusted hops", remote: "10.0.0.1:4000", values: []string{"10.0.0.2"}, want: "10.0.0.2"},
+		{name: "invalid hop", remote: "10.0.0.1:4000", values: []string{"198.51.100.1, garbage"}, want: "10.0.0.1"},
+		{name: "no header", remote: "10.0.0.1:4000", want: "10.0.0.1"},
+		{name: "real ip", header: HeaderRealIP, remote: "10.0.0.1:4000", values: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "forwarded", header: HeaderForwarded, remote: "10.0.0.1:4000", values: []string{`for=198.51.100.1;proto=https, For="[2001:db8:cafe::17]:4711"`}, want: "198.51.100.1"},
+		{name: "forwarded ipv6", header: HeaderForwarded, remote: "[2001:db8::1]:4000", values: []string{`for="[2001:db9::17]:4711"`}, want: "2001:db9::17"},
+		{name: "obfuscated forwarded", header: HeaderForwarded, remote: "10.0.0.1:4000", values: []string{"for=_hidden, for=10.0.0.2"}, want: "10.0.0.2"},
+		{name: "ipv4 mapped", remote: "[::ffff:10.0.0.1]:4000", values: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "no port", remote: "203.0.113.7", want: "203.0.113.7"},
+		{name: "unparsable remote", remote: "pipe", want: "pipe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewResolver(proxies, tt.header)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, r.Resolve(tt.remote, tt.values))
+		})
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	r, err := NewResolver([]string{"192.0.2.0/24"}, "")
+	assert.NoError(t, err)
+
+	// httptest requests come from 192.0.2.1
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Add("X-Forwarded-For", "1.2.3.4")
+	req.Header.Add("X-Forwarded-For", "198.51.100.1")
+	assert.Equal(t, "198.51.100.1", r.FromRequest(req))
+
+	t.Setenv("TRUSTED_PROXIES", "")
+	untrusting, err := ResolverFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1", untrusting.FromRequest(req))
+}
+
+func TestFromRequestSpoofedHeader(t *testing.T) {
+	// A trusted proxy that only sets X-Real-IP passes the client's own
+	// forwarding headers through
+	r, err := NewResolver([]string{"192.0.2.0/24"}, HeaderRealIP)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Real-IP", "198.51.100.1")
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	req.Header.Set("Forwarded", "for=1.2.3.4")
+	assert.Equal(t, "198.51.100.1", r.FromRequest(req))
+
+	// Without X-Real-IP the client is the proxy, not what the client claims
+	req.Header.Del("X-Real-IP")
+	assert.Equal(t, "192.0.2.1", r.FromRequest(req))
+}
@@ -0,0 +1,126 @@
+package common
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// HTTP modes control how the HTTP server passes requests to a function
+const (
+	// HTTPModeJSON passes the JSON request body as input and writes the result as JSON (default)
+	HTTPModeJSON = "json"
+	// HTTPModeEvent passes an HTTPRequest envelope as input and expects an HTTPResponse envelope back
+	HTTPModeEvent = "event"
+)
+
+// HTTPRequest is the envelope a function in HTTP event mode receives as input
+type HTTPRequest struct {
+	Method          string              `json:"method"`
+	Path            string              `json:"path"`
+	PathParams      map[string]string   `json:"path_params,omitempty"`
+	Query           map[string][]string `json:"query"`
+	Headers         map[string][]string `json:"headers"`
+	Body            string              `json:"body"`
+	IsBase64Encoded bool                `json:"is_base64_encoded"`
+	// Identity describes the authenticated client, if any
+	Identity map[string]any `json:"identity,omitempty"`
+	// ClientIP is the IP address of the client, behind any trusted proxies
+	ClientIP string `json:"client_ip,omitempty"`
+}
+
+// HTTPResponse is the envelope a function in HTTP event mode returns
+type HTTPResponse struct {
+	StatusCode      int                     `json:"status_code"`
+	Headers         map[string]HeaderValues `json:"headers,omitempty"`
+	Body            string                  `json:"body"`
+	IsBase64Encoded bool                    `json:"is_base64_encoded"`
+}
+
+// HeaderValues holds the values of a single HTTP header. In JSON it accepts
+// either a single string or an array of strings.
+type HeaderValues []string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (v *HeaderValues) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*v = HeaderValues{single}
+		return nil
+	}
+
+	var multi []string
+	if err := json.Unmarshal(data, &multi); err != nil {
+		return fmt.Errorf("header value must be a string or an array of strings")
+	}
+	*v = multi
+	return nil
+}
+
+// ToMap converts the request envelope into a function input map
+func (r HTTPRequest) ToMap() (map[string]any, error) {
+	var m map[string]any
+	if err := convert(r, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DecodedBody returns the request body, decoding it from base64 if needed
+func (r HTTPRequest) DecodedBody() ([]byte, error) {
+	return decodeBody(r.Body, r.IsBase64Encoded)
+}
+
+// DecodedBody returns the response body, decoding it from base64 if needed
+func (r HTTPResponse) DecodedBody() ([]byte, error) {
+	return decodeBody(r.Body, r.IsBase64Encoded)
+}
+
+// ParseHTTPRequest converts function input back into a request envelope.
+// Go functions running in HTTP event mode can use it to read their input.
+func ParseHTTPRequest(input map[string]any) (HTTPRequest, error) {
+	var req HTTPRequest
+	if err := convert(input, &req); err != nil {
+		return HTTPRequest{}, fmt.Errorf("invalid HTTP request envelope: %w", err)
+	}
+	return req, nil
+}
+
+// ParseHTTPResponse converts a function result into a response envelope.
+// The result may be an HTTPResponse, a pointer to one, or any JSON-compatible
+// value with the same shape (as returned by WebAssembly functions).
+func ParseHTTPResponse(result any) (HTTPResponse, error) {
+	switch r := result.(type) {
+	case HTTPResponse:
+		return r, nil
+	case *HTTPResponse:
+		if r == nil {
+			return HTTPResponse{}, fmt.Errorf("invalid HTTP response envelope: nil response")
+		}
+		return *r, nil
+	case map[string]any:
+		var resp HTTPResponse
+		if err := convert(r, &resp); err != nil {
+			return HTTPResponse{}, fmt.Errorf("invalid HTTP response envelope: %w", err)
+		}
+		return resp, nil
+	default:
+		return HTTPResponse{}, fmt.Errorf("invalid HTTP response envelope: unexpected type %T", result)
+	}
+}
+
+func decodeBody(body string, isBase64 bool) ([]byte, error) {
+	if !isBase64 {
+		return []byte(body), nil
+	}
+	return base64.StdEncoding.DecodeString(body)
+}
+
+// convert copies src into dst by round-tripping through JSON
+func convert(src any, dst any) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
@@ -1,13 +1,86 @@
 package common
 
+import (
+	"context"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/cors"
+)
+
 // FunctionHandler is the interface that all serverless functions must implement
 type FunctionHandler interface {
 	Execute(input map[string]any) (any, error)
 }
 
+// ContextFunctionHandler is implemented by functions that take the context of
+// their execution. It carries the execution's trace and deadline, and passing
+// it to db.Service's QueryContext and ExecContext counts the statements
+// toward the execution's usage.
+type ContextFunctionHandler interface {
+	FunctionHandler
+	ExecuteContext(ctx context.Context, input map[string]any) (any, error)
+}
+
+// StreamingFunctionHandler is implemented by functions that emit their result
+// incrementally, such as progress updates, partial results or token streams.
+// Each call to emit sends one chunk to the caller.
+type StreamingFunctionHandler interface {
+	FunctionHandler
+	ExecuteStream(input map[string]any, emit func(chunk any) error) error
+}
+
+// BidiStreamingFunctionHandler is implemented by functions that consume a
+// stream of input records and emit a stream of results. The inputs channel is
+// closed when the caller has sent its last record.
+type BidiStreamingFunctionHandler interface {
+	FunctionHandler
+	ExecuteBidi(inputs <-chan map[string]any, emit func(chunk any) error) error
+}
+
+// StartReport describes what a runtime did to get a function ready to run
+type StartReport struct {
+	// Compiled is set when the function's code had to be compiled or loaded
+	// rather than taken from a cache. Such an execution is a cold start.
+	Compiled bool
+	// InstanceCreated is set when a new instance of the function was created
+	// rather than an existing one reused
+	InstanceCreated bool
+	// InitDuration is the time spent compiling, loading and instantiating the
+	// function before its handler ran
+	InitDuration time.Duration
+}
+
+// Cold reports whether the execution had to wait for the function's code to
+// be compiled or loaded
+func (s StartReport) Cold() bool {
+	return s.Compiled
+}
+
+// Add merges the report of another start into s, as when a function runs
+// once per record of a stream
+func (s *StartReport) Add(other StartReport) {
+	s.Compiled = s.Compiled || other.Compiled
+	s.InstanceCreated = s.InstanceCreated || other.InstanceCreated
+	s.InitDuration += other.InitDuration
+}
+
+// ReportingFunctionHandler is implemented by handlers whose runtime reports
+// how each execution was started. Handlers that don't implement it are
+// assumed to be loaded and reused. ctx is the context of the execution.
+type ReportingFunctionHandler interface {
+	FunctionHandler
+	ExecuteReporting(ctx context.Context, input map[string]any) (any, StartReport, error)
+}
+
 // FunctionInfo represents metadata about a registered function
 type FunctionInfo struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Runtime     string `json:"runtime"`
+	HTTPMode    string `json:"http_mode,omitempty"`
+	// Version identifies the deployed code. WebAssembly functions default to
+	// a hash of their module.
+	Version string `json:"version,omitempty"`
+	// CORS overrides the server-wide CORS policy for the function
+	CORS *cors.Policy `json:"cors,omitempty"`
 }
@@ -0,0 +1,176 @@
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Defaults used when a policy doesn't list its methods or headers
+var (
+	DefaultMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	DefaultHeaders = []string{"Content-Type", "X-API-Key", "Authorization"}
+)
+
+// Policy configures which cross-origin requests browsers may make. Origins
+// are matched exactly, as "*" for any origin, or with a wildcard subdomain
+// such as "https://*.example.com".
+type Policy struct {
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowedMethods   []string `json:"allowed_methods,omitempty"`
+	AllowedHeaders   []string `json:"allowed_headers,omitempty"`
+	ExposedHeaders   []string `json:"exposed_headers,omitempty"`
+	AllowCredentials bool     `json:"allow_credentials,omitempty"`
+	MaxAgeSecs       int      `json:"max_age_secs,omitempty"`
+}
+
+// PolicyFromEnv reads the server-wide policy from CORS_ALLOWED_ORIGINS,
+// CORS_ALLOWED_METHODS, CORS_ALLOWED_HEADERS, CORS_EXPOSED_HEADERS,
+// CORS_ALLOW_CREDENTIALS and CORS_MAX_AGE_SECS. Any origin is allowed unless
+// CORS_ALLOWED_ORIGINS is set; set it to "none" to disable CORS.
+func PolicyFromEnv() (*Policy, error) {
+	p := &Policy{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: splitList(os.Getenv("CORS_ALLOWED_METHODS")),
+		AllowedHeaders: splitList(os.Getenv("CORS_ALLOWED_HEADERS")),
+		ExposedHeaders: splitList(os.Getenv("CORS_EXPOSED_HEADERS")),
+	}
+
+	switch origins := os.Getenv("CORS_ALLOWED_ORIGINS"); origins {
+	case "":
+	case "none":
+		p.AllowedOrigins = nil
+	default:
+		p.AllowedOrigins = splitList(origins)
+	}
+	if v := os.Getenv("CORS_ALLOW_CREDENTIALS"); v != "" {
+		credentials, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CORS_ALLOW_CREDENTIALS: %w", err)
+		}
+		p.AllowCredentials = credentials
+	}
+	if v := os.Getenv("CORS_MAX_AGE_SECS"); v != "" {
+		maxAge, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CORS_MAX_AGE_SECS: %w", err)
+		}
+		p.MaxAgeSecs = maxAge
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks that every origin pattern is well formed, and that
+// credentials are only allowed for listed origins
+func (p *Policy) Validate() error {
+	if p.AllowCredentials && slices.Contains(p.AllowedOrigins, "*") {
+		return errors.New(`CORS credentials can't be allowed for any origin ("*"): list the allowed origins instead`)
+	}
+	for _, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			return fmt.Errorf("invalid CORS origin %q: must be a scheme and host such as https://app.example.com", origin)
+		}
+	}
+	if p.MaxAgeSecs < 0 {
+		return errors.New("CORS max age must not be negative")
+	}
+	return nil
+}
+
+// Handle adds the CORS response headers for a request and reports whether it
+// was a preflight request, which the caller should answer without running
+// the handler. Requests from origins the policy doesn't allow get no CORS
+// headers, so browsers block them.
+func (p *Policy) Handle(w http.ResponseWriter, r *http.Request) (preflight bool) {
+	preflight = r.Method == http.MethodOptions
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return preflight
+	}
+
+	header := w.Header()
+	header.Add("Vary", "Origin")
+	if !p.allows(origin) {
+		return preflight
+	}
+
+	// Credentials are never allowed for any origin, even by a policy that
+	// skipped validation, since any site could then act as the user
+	if slices.Contains(p.AllowedOrigins, "*") {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+		if p.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	if !preflight {
+		if len(p.ExposedHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+		}
+		return false
+	}
+
+	methods := p.AllowedMethods
+	if len(methods) == 0 {
+		methods = DefaultMethods
+	}
+	headers := p.AllowedHeaders
+	if len(headers) == 0 {
+		headers = DefaultHeaders
+	}
+	header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	header.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	if p.MaxAgeSecs > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(p.MaxAgeSecs))
+	}
+	return true
+}
+
+// allows reports whether the policy allows requests from origin
+func (p *Policy) allows(origin string) bool {
+	for _, pattern := range p.AllowedOrigins {
+		if pattern == "*" || strings.EqualFold(pattern, origin) {
+			return true
+		}
+
+		// https://*.example.com matches any subdomain, but not example.com itself
+		scheme, domain, ok := strings.Cut(pattern, "://*.")
+		if !ok {
+			continue
+		}
+		prefix := scheme + "://"
+		if len(origin) > len(prefix) && strings.EqualFold(origin[:len(prefix)], prefix) {
+			host := origin[len(prefix):]
+			suffix := "." + domain
+			if len(host) > len(suffix) && strings.EqualFold(host[len(host)-len(suffix):], suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,140 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func request(method, origin string) *http.Request {
+	r := httptest.NewRequest(method, "/run/hello", nil)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	return r
+}
+
+func TestHandleAnyOrigin(t *testing.T) {
+	policy := &Policy{AllowedOrigins: []string{"*"}}
+
+	w := httptest.NewRecorder()
+	assert.False(t, policy.Handle(w, request(http.MethodPost, "https://app.example.com")))
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Methods"))
+
+	w = httptest.NewRecorder()
+	assert.True(t, policy.Handle(w, request(http.MethodOptions, "https://app.example.com")))
+	assert.Equal(t, "GET, POST, PUT, PATCH, DELETE, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type, X-API-Key, Authorization", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Empty(t, w.Header().Get("Access-Control-Max-Age"))
+
+	// Requests without an Origin are not cross-origin
+	w = httptest.NewRecorder()
+	assert.False(t, policy.Handle(w, request(http.MethodGet, "")))
+	assert.Empty(t, w.Header())
+}
+
+func TestHandleOriginList(t *testing.T) {
+	policy := &Policy{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Content-Type"},
+		ExposedHeaders:   []string{"X-Request-ID"},
+		AllowCredentials: true,
+		MaxAgeSecs:       600,
+	}
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.com", true},
+		{"HTTPS://APP.EXAMPLE.COM", true},
+		{"https://evil.example.com", false},
+		{"https://a.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"http://a.example.org", false},
+		{"https://a.example.org.evil.com", false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		policy.Handle(w, request(http.MethodPost, tt.origin))
+		assert.Contains(t, w.Header().Values("Vary"), "Origin", tt.origin)
+		if tt.allowed {
+			assert.Equal(t, tt.origin, w.Header().Get("Access-Control-Allow-Origin"), tt.origin)
+			assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+			assert.Equal(t, "X-Request-ID", w.Header().Get("Access-Control-Expose-Headers"))
+		} else {
+			assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), tt.origin)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	assert.True(t, policy.Handle(w, request(http.MethodOptions, "https://app.example.com")))
+	assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+	assert.Empty(t, w.Header().Get("Access-Control-Expose-Headers"))
+}
+
+func TestHandleCredentials(t *testing.T) {
+	policy := &Policy{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true}
+	require.NoError(t, policy.Validate())
+
+	w := httptest.NewRecorder()
+	policy.Handle(w, request(http.MethodGet, "https://app.example.com"))
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+
+	// Credentials are never allowed for any origin
+	policy = &Policy{AllowedOrigins: []string{"https://app.example.com", "*"}, AllowCredentials: true}
+	assert.Error(t, policy.Validate())
+	w = httptest.NewRecorder()
+	policy.Handle(w, request(http.MethodGet, "https://evil.example.net"))
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func TestPolicyFromEnv(t *testing.T) {
+	policy, err := PolicyFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"*"}, policy.AllowedOrigins)
+	assert.False(t, policy.AllowCredentials)
+
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com, https://*.example.org")
+	t.Setenv("CORS_ALLOWED_METHODS", "GET,POST")
+	t.Setenv("CORS_EXPOSED_HEADERS", "X-Request-ID")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	t.Setenv("CORS_MAX_AGE_SECS", "300")
+	policy, err = PolicyFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://app.example.com", "https://*.example.org"}, policy.AllowedOrigins)
+	assert.Equal(t, []string{"GET", "POST"}, policy.AllowedMethods)
+	assert.Equal(t, []string{"X-Request-ID"}, policy.ExposedHeaders)
+	assert.True(t, policy.AllowCredentials)
+	assert.Equal(t, 300, policy.MaxAgeSecs)
+
+	t.Setenv("CORS_ALLOWED_ORIGINS", "none")
+	policy, err = PolicyFromEnv()
+	require.NoError(t, err)
+	assert.Empty(t, policy.AllowedOrigins)
+
+	t.Setenv("CORS_ALLOWED_ORIGINS", "app.example.com")
+	_, err = PolicyFromEnv()
+	assert.Error(t, err)
+
+	// Credentials need a list of origins, not the default of any origin
+	t.Setenv("CORS_ALLOWED_ORIGINS", "")
+	_, err = PolicyFromEnv()
+	assert.Error(t, err)
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "false")
+
+	t.Setenv("CORS_ALLOWED_ORIGINS", "*")
+	t.Setenv("CORS_MAX_AGE_SECS", "soon")
+	_, err = PolicyFromEnv()
+	assert.Error(t, err)
+}
@@ -1,10 +1,13 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 
+	"github.com/mstgnz/self-hosted-serverless/internal/tracing"
+	"github.com/mstgnz/self-hosted-serverless/internal/usage"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -66,18 +69,51 @@ func (s *Service) Close() error {
 
 // Query executes a SQL query and returns the rows (SQL databases only)
 func (s *Service) Query(query string, args ...any) (*sql.Rows, error) {
+	return s.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext executes a SQL query as part of the trace of ctx, counting it
+// toward the usage of the execution of ctx, if any
+func (s *Service) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
 	if s.sqlDB == nil {
 		return nil, errors.New("SQL database not initialized or using non-SQL database")
 	}
-	return s.sqlDB.Query(query, args...)
+	usage.FromContext(ctx).CountDBQuery()
+	ctx, span := s.startSpan(ctx, "db.query", query)
+	defer span.End()
+
+	rows, err := s.sqlDB.QueryContext(ctx, query, args...)
+	span.SetError(err)
+	return rows, err
 }
 
 // Exec executes a SQL statement (SQL databases only)
 func (s *Service) Exec(query string, args ...any) (sql.Result, error) {
+	return s.ExecContext(context.Background(), query, args...)
+}
+
+// ExecContext executes a SQL statement as part of the trace of ctx, counting
+// it toward the usage of the execution of ctx, if any
+func (s *Service) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
 	if s.sqlDB == nil {
 		return nil, errors.New("SQL database not initialized or using non-SQL database")
 	}
-	return s.sqlDB.Exec(query, args...)
+	usage.FromContext(ctx).CountDBQuery()
+	ctx, span := s.startSpan(ctx, "db.exec", query)
+	defer span.End()
+
+	result, err := s.sqlDB.ExecContext(ctx, query, args...)
+	span.SetError(err)
+	return result, err
+}
+
+// startSpan starts a span for a statement. Only the statement is recorded,
+// never its arguments, which may hold personal data.
+func (s *Service) startSpan(ctx context.Context, name, query string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, name, tracing.SpanKindClient)
+	span.SetAttribute("db.system", string(s.dbType))
+	span.SetAttribute("db.statement", query)
+	return ctx, span
 }
 
 // GetDB returns the underlying SQL database connection
@@ -89,3 +125,14 @@ func (s *Service) GetDB() *sql.DB {
 func (s *Service) GetRedis() *redis.Client {
 	return s.redisClient
 }
+
+// Ping checks that the database connection is alive
+func (s *Service) Ping(ctx context.Context) error {
+	if s.sqlDB != nil {
+		return s.sqlDB.PingContext(ctx)
+	}
+	if s.redisClient != nil {
+		return s.redisClient.Ping(ctx).Err()
+	}
+	return errors.New("database not initialized")
+}
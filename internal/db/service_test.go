@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -134,3 +135,25 @@ func TestClose(t *testing.T) {
 	err = service.Close()
 	assert.NoError(t, err)
 }
+
+// TestPing tests checking the database connection
+func TestPing(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("Failed to create mock database: %v", err)
+	}
+	defer db.Close()
+
+	service := &Service{
+		dbType: PostgreSQL,
+		sqlDB:  db,
+	}
+
+	mock.ExpectPing()
+	assert.NoError(t, service.Ping(context.Background()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// A service without a connection is never healthy
+	service = &Service{dbType: PostgreSQL}
+	assert.Error(t, service.Ping(context.Background()))
+}
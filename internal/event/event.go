@@ -5,12 +5,38 @@ import (
 	"fmt"
 	"sync"
 	"sync/atomic"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/metrics"
+	"github.com/mstgnz/self-hosted-serverless/internal/tracing"
 )
 
+var (
+	publishesTotal = metrics.GetGlobalRegistry().NewCounterVec("serverless_event_publishes_total",
+		"Events published to the bus.", "type")
+	deliveriesTotal = metrics.GetGlobalRegistry().NewCounterVec("serverless_event_deliveries_total",
+		"Events delivered to subscribers by outcome.", "type", "outcome")
+)
+
+// OtherType is the label that event types past the caps are counted under
+const OtherType = "other"
+
+// maxTypes caps how many event types without subscribers are counted under
+// their own name. Clients choose the types they publish, so each new one
+// can't be allowed to add metric series forever.
+const maxTypes = 100
+
+// maxSubscribedTypes is how many more types may be counted under their own
+// name once they have subscribers. Subscriptions come and go, so they are
+// capped too.
+const maxSubscribedTypes = 100
+
 // Event represents a serverless event
 type Event struct {
 	Type    string         `json:"type"`
 	Payload map[string]any `json:"payload"`
+	// Attributes carry metadata about the event, such as the traceparent of
+	// the trace it belongs to
+	Attributes map[string]string `json:"attributes,omitempty"`
 }
 
 // Handler is a function that handles an event
@@ -25,6 +51,18 @@ type handlerEntry struct {
 type Bus struct {
 	handlers map[string][]handlerEntry
 	mutex    sync.RWMutex
+	stats    map[string]*TypeStats
+	statsMu  sync.Mutex
+	// types holds the event types counted under their own name
+	types map[string]struct{}
+}
+
+// TypeStats counts the events of a type that went through a bus
+type TypeStats struct {
+	Published   int64 `json:"published"`
+	Delivered   int64 `json:"delivered"`
+	Failed      int64 `json:"failed"`
+	Subscribers int   `json:"subscribers"`
 }
 
 var idCounter atomic.Int64
@@ -37,6 +75,8 @@ func nextID() string {
 func NewBus() *Bus {
 	return &Bus{
 		handlers: make(map[string][]handlerEntry),
+		stats:    make(map[string]*TypeStats),
+		types:    make(map[string]struct{}),
 	}
 }
 
@@ -59,32 +99,139 @@ func (b *Bus) Subscribe(eventType string, handler Handler) func() {
 		for i, e := range entries {
 			if e.id == id {
 				b.handlers[eventType] = append(entries[:i], entries[i+1:]...)
+				if len(b.handlers[eventType]) == 0 {
+					delete(b.handlers, eventType)
+				}
 				return
 			}
 		}
 	}
 }
 
-// Publish publishes an event to all registered handlers
+// Publish publishes an event to all registered handlers. The event joins the
+// trace of ctx, or the trace in its attributes if ctx has none, and each
+// handler is called with a context that continues it.
 func (b *Bus) Publish(ctx context.Context, event Event) []error {
+	if !tracing.SpanContextFromContext(ctx).IsValid() {
+		ctx = tracing.Extract(ctx, func(key string) string { return event.Attributes[key] })
+	}
+	ctx, span := tracing.Start(ctx, "publish "+event.Type, tracing.SpanKindProducer)
+	defer span.End()
+	span.SetAttribute("event.type", event.Type)
+
+	attributes := make(map[string]string, len(event.Attributes)+2)
+	for key, value := range event.Attributes {
+		attributes[key] = value
+	}
+	tracing.Inject(ctx, func(key, value string) { attributes[key] = value })
+	if len(attributes) > 0 {
+		event.Attributes = attributes
+	}
+
 	b.mutex.RLock()
 	entries, exists := b.handlers[event.Type]
 	b.mutex.RUnlock()
 
+	label := b.label(event.Type, len(entries) > 0)
+	publishesTotal.Inc(label)
 	if !exists {
+		b.count(label, 0, 0)
 		return nil
 	}
 
 	var errors []error
 	for _, entry := range entries {
-		if err := entry.handler(ctx, event); err != nil {
+		if err := b.deliver(ctx, entry, event); err != nil {
 			errors = append(errors, err)
+			deliveriesTotal.Inc(label, "error")
+			continue
 		}
+		deliveriesTotal.Inc(label, "success")
 	}
+	span.SetAttribute("event.handlers", len(entries))
+	b.count(label, len(entries)-len(errors), len(errors))
 
 	return errors
 }
 
+// label returns the name an event type is counted under: its own if it is one
+// of the first maxTypes seen, or of the first maxTypes+maxSubscribedTypes if
+// it has subscribers, OtherType otherwise
+func (b *Bus) label(eventType string, subscribed bool) string {
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+
+	if _, ok := b.types[eventType]; ok {
+		return eventType
+	}
+	limit := maxTypes
+	if subscribed {
+		limit += maxSubscribedTypes
+	}
+	if len(b.types) < limit {
+		b.types[eventType] = struct{}{}
+		return eventType
+	}
+	return OtherType
+}
+
+// count records a published event and the outcome of its deliveries under
+// the label of its type
+func (b *Bus) count(eventType string, delivered, failed int) {
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+
+	stats, ok := b.stats[eventType]
+	if !ok {
+		stats = &TypeStats{}
+		b.stats[eventType] = stats
+	}
+	stats.Published++
+	stats.Delivered += int64(delivered)
+	stats.Failed += int64(failed)
+}
+
+// Stats returns the counts of the event types published since the bus was
+// created, with the number of handlers subscribed to each. Types that have
+// subscribers but no events yet are included. Like the metrics, types past
+// the caps are counted together under OtherType, so clients can't grow the
+// stats without bound.
+func (b *Bus) Stats() map[string]TypeStats {
+	b.mutex.RLock()
+	subscribers := make(map[string]int, len(b.handlers))
+	for eventType, entries := range b.handlers {
+		subscribers[eventType] = len(entries)
+	}
+	b.mutex.RUnlock()
+
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+
+	stats := make(map[string]TypeStats, len(b.stats))
+	for eventType, s := range b.stats {
+		stats[eventType] = *s
+	}
+	for eventType, n := range subscribers {
+		s := stats[eventType]
+		s.Subscribers = n
+		if n > 0 || s.Published > 0 {
+			stats[eventType] = s
+		}
+	}
+	return stats
+}
+
+// deliver calls a handler in a span of its own
+func (b *Bus) deliver(ctx context.Context, entry handlerEntry, event Event) error {
+	ctx, span := tracing.Start(ctx, "process "+event.Type, tracing.SpanKindConsumer)
+	defer span.End()
+	span.SetAttribute("event.type", event.Type)
+
+	err := entry.handler(ctx, event)
+	span.SetError(err)
+	return err
+}
+
 var (
 	globalBus  *Bus
 	globalOnce sync.Once
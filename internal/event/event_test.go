@@ -1,11 +1,17 @@
 package event
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"sync"
 	"testing"
 
+	"github.com/mstgnz/self-hosted-serverless/internal/metrics"
+	"github.com/mstgnz/self-hosted-serverless/internal/tracing"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewBus(t *testing.T) {
@@ -90,3 +96,116 @@ func TestGetGlobalBus(t *testing.T) {
 	// Verify that we get the same instance
 	assert.Equal(t, bus1, bus2)
 }
+
+func TestPublishPropagatesTrace(t *testing.T) {
+	bus := NewBus()
+
+	var received []Event
+	var contexts []tracing.SpanContext
+	bus.Subscribe("step", func(ctx context.Context, event Event) error {
+		received = append(received, event)
+		contexts = append(contexts, tracing.SpanContextFromContext(ctx))
+		return nil
+	})
+
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := tracing.ParseTraceparent(traceparent)
+	assert.NoError(t, err)
+
+	// The trace of the publisher's context is added to the attributes
+	ctx := tracing.ContextWithSpanContext(context.Background(), sc)
+	bus.Publish(ctx, Event{Type: "step", Attributes: map[string]string{"source": "test"}})
+
+	// An event that already carries a trace continues it
+	bus.Publish(context.Background(), Event{Type: "step", Attributes: map[string]string{"traceparent": traceparent}})
+
+	// Events without a trace are left alone
+	bus.Publish(context.Background(), Event{Type: "step"})
+
+	assert.Len(t, received, 3)
+	assert.Equal(t, map[string]string{"source": "test", "traceparent": traceparent}, received[0].Attributes)
+	assert.Equal(t, sc.TraceID, contexts[0].TraceID)
+	assert.Equal(t, sc.TraceID, contexts[1].TraceID)
+	assert.Nil(t, received[2].Attributes)
+	assert.False(t, contexts[2].IsValid())
+}
+
+func TestStats(t *testing.T) {
+	bus := NewBus()
+	ctx := context.Background()
+
+	bus.Subscribe("ok", func(ctx context.Context, event Event) error { return nil })
+	bus.Subscribe("ok", func(ctx context.Context, event Event) error { return errors.New("boom") })
+	cancel := bus.Subscribe("idle", func(ctx context.Context, event Event) error { return nil })
+
+	bus.Publish(ctx, Event{Type: "ok"})
+	bus.Publish(ctx, Event{Type: "ok"})
+	bus.Publish(ctx, Event{Type: "unheard"})
+
+	stats := bus.Stats()
+	assert.Equal(t, TypeStats{Published: 2, Delivered: 2, Failed: 2, Subscribers: 2}, stats["ok"])
+	assert.Equal(t, TypeStats{Published: 1}, stats["unheard"])
+	assert.Equal(t, TypeStats{Subscribers: 1}, stats["idle"])
+
+	// Types without events or subscribers are left out
+	cancel()
+	assert.NotContains(t, bus.Stats(), "idle")
+}
+
+func TestMetricLabelsBounded(t *testing.T) {
+	bus := NewBus()
+	ctx := context.Background()
+
+	bus.Subscribe("order.created", func(ctx context.Context, event Event) error { return nil })
+	for i := range maxTypes + 10 {
+		bus.Publish(ctx, Event{Type: fmt.Sprintf("flood.%d", i)})
+	}
+	bus.Publish(ctx, Event{Type: "order.created"})
+
+	var buf bytes.Buffer
+	require.NoError(t, metrics.GetGlobalRegistry().WriteText(&buf))
+	text := buf.String()
+	assert.Contains(t, text, `serverless_event_publishes_total{type="flood.0"}`)
+	assert.NotContains(t, text, fmt.Sprintf(`serverless_event_publishes_total{type="flood.%d"}`, maxTypes))
+	assert.Contains(t, text, `serverless_event_publishes_total{type="other"}`)
+
+	// Types with subscribers keep their own series
+	assert.Contains(t, text, `serverless_event_deliveries_total{type="order.created",outcome="success"}`)
+}
+
+func TestStatsBounded(t *testing.T) {
+	bus := NewBus()
+	ctx := context.Background()
+
+	bus.Subscribe("order.created", func(ctx context.Context, event Event) error { return nil })
+	for i := range maxTypes + 10 {
+		bus.Publish(ctx, Event{Type: fmt.Sprintf("flood.%d", i)})
+	}
+	bus.Publish(ctx, Event{Type: "order.created"})
+	bus.Publish(ctx, Event{Type: "flood.0"})
+
+	stats := bus.Stats()
+	assert.Len(t, stats, maxTypes+2)
+	assert.Equal(t, TypeStats{Published: 2}, stats["flood.0"])
+	assert.Equal(t, TypeStats{Published: 10}, stats[OtherType])
+	assert.Equal(t, TypeStats{Published: 1, Delivered: 1, Subscribers: 1}, stats["order.created"])
+}
+
+func TestStatsBoundedSubscriptions(t *testing.T) {
+	bus := NewBus()
+	ctx := context.Background()
+
+	// Subscribing to a type, publishing one event and unsubscribing leaves
+	// no handlers behind, and past the caps the types are counted together
+	for i := range maxTypes + maxSubscribedTypes + 10 {
+		eventType := fmt.Sprintf("churn.%d", i)
+		cancel := bus.Subscribe(eventType, func(ctx context.Context, event Event) error { return nil })
+		bus.Publish(ctx, Event{Type: eventType})
+		cancel()
+	}
+
+	assert.Empty(t, bus.handlers)
+	stats := bus.Stats()
+	assert.Len(t, stats, maxTypes+maxSubscribedTypes+1)
+	assert.Equal(t, TypeStats{Published: 10, Delivered: 10}, stats[OtherType])
+}
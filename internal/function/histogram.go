@@ -0,0 +1,110 @@
+package function
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// subBuckets is the number of linear buckets each power of two of
+// microseconds is split into, which bounds the error of a recorded latency to
+// 1/subBuckets (6.25%) of its value
+const (
+	subBucketBits = 4
+	subBuckets    = 1 << subBucketBits
+)
+
+// latencyHistogram records a latency distribution in log-linear buckets, as
+// an HDR histogram does, so percentiles stay accurate from microseconds to
+// hours in a few kilobytes per function
+type latencyHistogram struct {
+	counts []int64
+	count  int64
+	max    time.Duration
+}
+
+// LatencyStats summarizes a latency distribution
+type LatencyStats struct {
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P95 time.Duration `json:"p95"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+}
+
+func (h *latencyHistogram) record(d time.Duration) {
+	i := bucketIndex(d)
+	if i >= len(h.counts) {
+		counts := make([]int64, i+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[i]++
+	h.count++
+	h.max = max(h.max, d)
+}
+
+// merge adds the latencies recorded in o
+func (h *latencyHistogram) merge(o *latencyHistogram) {
+	if len(o.counts) > len(h.counts) {
+		counts := make([]int64, len(o.counts))
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	h.count += o.count
+	h.max = max(h.max, o.max)
+}
+
+// quantile returns the latency below which a fraction q of the recorded
+// latencies fall. It is the upper bound of the bucket holding that rank,
+// capped at the largest latency recorded.
+func (h *latencyHistogram) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := max(int64(math.Ceil(q*float64(h.count))), 1)
+	var cumulative int64
+	for i, c := range h.counts {
+		cumulative += c
+		if cumulative >= rank {
+			return min(bucketUpperBound(i), h.max)
+		}
+	}
+	return h.max
+}
+
+func (h *latencyHistogram) stats() LatencyStats {
+	return LatencyStats{
+		P50: h.quantile(0.50),
+		P90: h.quantile(0.90),
+		P95: h.quantile(0.95),
+		P99: h.quantile(0.99),
+		Max: h.max,
+	}
+}
+
+// bucketIndex maps a latency to its bucket. Latencies under subBuckets
+// microseconds get a bucket each; above that, every power of two is split
+// into subBuckets equal buckets.
+func bucketIndex(d time.Duration) int {
+	us := uint64(max(d.Microseconds(), 0))
+	if us < subBuckets {
+		return int(us)
+	}
+	shift := bits.Len64(us) - subBucketBits - 1
+	return (shift+1)*subBuckets + int(us>>shift) - subBuckets
+}
+
+// bucketUpperBound returns the largest latency that falls in bucket i
+func bucketUpperBound(i int) time.Duration {
+	if i < subBuckets {
+		return time.Duration(i+1)*time.Microsecond - 1
+	}
+	shift := i/subBuckets - 1
+	base := uint64(i%subBuckets+subBuckets) << shift
+	return time.Duration(base+1<<shift)*time.Microsecond - 1
+}
@@ -0,0 +1,47 @@
+package function
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucketBounds(t *testing.T) {
+	// Every latency falls in a bucket whose upper bound is at most 6.25% above it
+	for _, d := range []time.Duration{
+		0, time.Microsecond, 15 * time.Microsecond, 16 * time.Microsecond, 33 * time.Microsecond,
+		time.Millisecond, 1234567 * time.Microsecond, 30 * time.Second, 5 * time.Hour,
+	} {
+		i := bucketIndex(d)
+		upper := bucketUpperBound(i)
+		assert.GreaterOrEqual(t, upper, d, d)
+		if i > 0 {
+			assert.Less(t, bucketUpperBound(i-1), d, d)
+		}
+		assert.LessOrEqual(t, float64(upper-d), float64(d)/subBuckets+float64(time.Microsecond), d)
+	}
+
+	// Bucket indexes grow with the latency without gaps
+	for us := int64(1); us < 1<<12; us++ {
+		assert.LessOrEqual(t, bucketIndex(time.Duration(us)*time.Microsecond)-bucketIndex(time.Duration(us-1)*time.Microsecond), 1)
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	var h latencyHistogram
+	assert.Equal(t, LatencyStats{}, h.stats())
+
+	h.record(3 * time.Millisecond)
+	stats := h.stats()
+	// A single value is capped at the maximum rather than its bucket's bound
+	assert.Equal(t, 3*time.Millisecond, stats.P50)
+	assert.Equal(t, 3*time.Millisecond, stats.P99)
+	assert.Equal(t, 3*time.Millisecond, stats.Max)
+
+	for range 99 {
+		h.record(time.Millisecond)
+	}
+	assert.InEpsilon(t, float64(time.Millisecond), float64(h.quantile(0.99)), 1.0/subBuckets)
+	assert.Equal(t, 3*time.Millisecond, h.quantile(1))
+}
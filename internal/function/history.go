@@ -0,0 +1,129 @@
+package function
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/history"
+	"github.com/mstgnz/self-hosted-serverless/internal/tracing"
+	"github.com/mstgnz/self-hosted-serverless/internal/usage"
+)
+
+// Triggers recorded in the invocation history
+const (
+	TriggerHTTP  = "http"
+	TriggerRoute = "route"
+	TriggerGRPC  = "grpc"
+)
+
+// ExecOption describes how an execution was triggered, for the invocation
+// history and tracing
+type ExecOption func(*invocation)
+
+// WithTrigger records what triggered the execution, such as TriggerHTTP
+func WithTrigger(trigger string) ExecOption {
+	return func(inv *invocation) { inv.trigger = trigger }
+}
+
+// WithContext makes the execution part of the trace of ctx
+func WithContext(ctx context.Context) ExecOption {
+	return func(inv *invocation) { inv.ctx = ctx }
+}
+
+// WithCaller records who triggered the execution, such as the name of the
+// API key used
+func WithCaller(caller string) ExecOption {
+	return func(inv *invocation) { inv.caller = caller }
+}
+
+// WithKeyID records the ID of the API key the execution was triggered with,
+// so its usage can be charged to the key
+func WithKeyID(id string) ExecOption {
+	return func(inv *invocation) { inv.keyID = id }
+}
+
+// invocation holds what is known about an execution before it runs
+type invocation struct {
+	ctx        context.Context
+	trigger    string
+	caller     string
+	keyID      string
+	inputBytes int64
+	span       *tracing.Span
+	meter      *usage.Meter
+}
+
+func (r *Registry) newInvocation(input any, opts []ExecOption) invocation {
+	inv := invocation{ctx: context.Background()}
+	for _, opt := range opts {
+		opt(&inv)
+	}
+	if r.history != nil && input != nil {
+		inv.inputBytes = jsonSize(input)
+	}
+	return inv
+}
+
+// SetHistory makes the registry record every execution in store
+func (r *Registry) SetHistory(store *history.Store) {
+	r.history = store
+}
+
+// History returns the invocation history store, or nil if there is none
+func (r *Registry) History() *history.Store {
+	return r.history
+}
+
+// RebuildMetrics replaces the function metrics with those of the executions
+// in the invocation history, so they survive restarts. Executions older than
+// the history's retention are gone and not counted. It is meant to be called
+// at startup, before any function runs.
+func (r *Registry) RebuildMetrics() error {
+	if r.history == nil {
+		return errors.New("invocation history is not enabled")
+	}
+
+	var since time.Time
+	if retention := r.history.Retention(); retention > 0 {
+		since = time.Now().Add(-retention)
+	}
+
+	r.metrics.ResetAll()
+	return r.history.Replay(since, func(rec history.Record) {
+		var err error
+		switch rec.Outcome {
+		case history.OutcomeTimeout:
+			err = context.DeadlineExceeded
+		case history.OutcomeError:
+			err = errors.New(rec.Error)
+		}
+		r.metrics.RecordExecutionAt(rec.Function, rec.EndedAt, rec.Duration, err, nil)
+	})
+}
+
+// injectTrace passes the trace context of ctx to a function in its input, so
+// the events it publishes join the trace
+func injectTrace(ctx context.Context, input map[string]any) {
+	if input != nil {
+		tracing.InjectInput(ctx, input)
+	}
+}
+
+// jsonSize returns the size of v encoded as JSON, or 0 if it can't be encoded
+func jsonSize(v any) int64 {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return int64(len(data))
+}
+
+// codeVersion identifies a version of a function's code by its hash
+func codeVersion(code []byte) string {
+	sum := sha256.Sum256(code)
+	return hex.EncodeToString(sum[:6])
+}
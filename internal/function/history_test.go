@@ -0,0 +1,151 @@
+package function
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/mstgnz/self-hosted-serverless/internal/common"
+	"github.com/mstgnz/self-hosted-serverless/internal/history"
+	"github.com/mstgnz/self-hosted-serverless/internal/usage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHistory(t *testing.T) *history.Store {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+
+	store, err := history.NewStore(db, history.DefaultConfig())
+	require.NoError(t, err)
+	return store
+}
+
+func TestExecuteRecordsHistory(t *testing.T) {
+	registry := NewRegistry()
+	store := newTestHistory(t)
+	registry.SetHistory(store)
+
+	registry.Register("echo", &MockFunctionHandler{
+		ExecuteFunc: func(input map[string]interface{}) (interface{}, error) {
+			if input["fail"] == true {
+				return nil, errors.New("boom")
+			}
+			return input, nil
+		},
+	}, common.FunctionInfo{Name: "echo", Runtime: "go", Version: "v1"})
+
+	_, err := registry.Execute("echo", map[string]interface{}{"a": "b"}, WithTrigger(TriggerHTTP), WithCaller("billing"))
+	require.NoError(t, err)
+	_, err = registry.Execute("echo", map[string]interface{}{"fail": true}, WithTrigger(TriggerGRPC))
+	require.Error(t, err)
+
+	var chunks int
+	err = registry.ExecuteStream("echo", map[string]interface{}{"x": 1}, func(chunk any) error {
+		chunks++
+		return nil
+	}, WithTrigger(TriggerRoute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, chunks)
+
+	require.NoError(t, store.Close())
+
+	records, err := store.Query(history.Filter{Function: "echo"})
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	byTrigger := make(map[string]history.Record)
+	for _, rec := range records {
+		byTrigger[rec.Trigger] = rec
+	}
+
+	rec := byTrigger[TriggerHTTP]
+	assert.Equal(t, "billing", rec.Caller)
+	assert.Equal(t, "v1", rec.Version)
+	assert.Equal(t, history.OutcomeSuccess, rec.Outcome)
+	assert.Equal(t, int64(len(`{"a":"b"}`)), rec.InputBytes)
+	assert.Equal(t, int64(len(`{"a":"b"}`)), rec.OutputBytes)
+
+	rec = byTrigger[TriggerGRPC]
+	assert.Equal(t, history.OutcomeError, rec.Outcome)
+	assert.Equal(t, "boom", rec.Error)
+	assert.Zero(t, rec.OutputBytes)
+
+	rec = byTrigger[TriggerRoute]
+	assert.Equal(t, history.OutcomeSuccess, rec.Outcome)
+	assert.Equal(t, int64(len(`{"x":1}`)), rec.OutputBytes)
+}
+
+func TestRebuildMetrics(t *testing.T) {
+	store := newTestHistory(t)
+
+	now := time.Now()
+	store.Record(history.Record{Function: "resize", StartedAt: now.Add(-2 * time.Minute), EndedAt: now.Add(-2 * time.Minute), Duration: 10 * time.Millisecond, Outcome: history.OutcomeSuccess})
+	store.Record(history.Record{Function: "resize", StartedAt: now.Add(-time.Minute), EndedAt: now.Add(-time.Minute), Duration: 30 * time.Millisecond, Outcome: history.OutcomeError, Error: "boom"})
+	store.Record(history.Record{Function: "resize", StartedAt: now, EndedAt: now, Duration: time.Second, Outcome: history.OutcomeTimeout})
+	require.NoError(t, store.Close())
+
+	registry := NewRegistry()
+	assert.Error(t, registry.RebuildMetrics())
+
+	registry.SetHistory(store)
+	require.NoError(t, registry.RebuildMetrics())
+
+	metrics, ok := registry.GetFunctionMetrics("resize")
+	require.True(t, ok)
+	assert.Equal(t, int64(3), metrics.ExecutionCount)
+	assert.Equal(t, int64(2), metrics.ErrorCount)
+
+	window, ok := registry.GetFunctionWindowMetrics("resize", time.Hour)
+	require.True(t, ok)
+	assert.Equal(t, int64(3), window.Invocations)
+	assert.Equal(t, int64(1), window.Timeouts)
+}
+
+func TestCodeVersion(t *testing.T) {
+	code := []byte("not really wasm")
+	assert.Equal(t, codeVersion(code), codeVersion(code))
+	assert.Len(t, codeVersion(code), 12)
+	assert.NotEqual(t, codeVersion(code), codeVersion([]byte("other")))
+}
+
+// ContextMockFunctionHandler is a mock handler that issues a database query
+// through the context it is given
+type ContextMockFunctionHandler struct {
+	MockFunctionHandler
+}
+
+// ExecuteContext counts a query on the meter of ctx and calls ExecuteFunc
+func (m *ContextMockFunctionHandler) ExecuteContext(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+	usage.FromContext(ctx).CountDBQuery()
+	return m.ExecuteFunc(input)
+}
+
+func TestExecuteRecordsUsage(t *testing.T) {
+	registry := NewRegistry()
+	store := newTestHistory(t)
+	registry.SetHistory(store)
+
+	registry.Register("query", &ContextMockFunctionHandler{MockFunctionHandler{
+		ExecuteFunc: func(input map[string]interface{}) (interface{}, error) {
+			return input, nil
+		},
+	}}, common.FunctionInfo{Name: "query", Runtime: "go"})
+
+	_, err := registry.Execute("query", map[string]interface{}{}, WithCaller("billing"), WithKeyID("k1"))
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	records, err := store.Query(history.Filter{KeyID: "k1"})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "billing", records[0].Caller)
+	assert.Equal(t, int64(1), records[0].DBQueries)
+	assert.Zero(t, records[0].MemoryPages)
+}
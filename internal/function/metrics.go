@@ -3,6 +3,29 @@ package function
 import (
 	"sync"
 	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/common"
+	"github.com/mstgnz/self-hosted-serverless/internal/metrics"
+)
+
+// Execution outcomes reported to Prometheus
+const (
+	outcomeSuccess = "success"
+	outcomeError   = "error"
+	outcomeTimeout = "timeout"
+)
+
+var (
+	invocationsTotal = metrics.GetGlobalRegistry().NewCounterVec("serverless_function_invocations_total",
+		"Function executions by outcome.", "function", "runtime", "outcome")
+	errorsTotal = metrics.GetGlobalRegistry().NewCounterVec("serverless_function_errors_total",
+		"Function executions that failed or timed out.", "function", "runtime")
+	coldStartsTotal = metrics.GetGlobalRegistry().NewCounterVec("serverless_function_cold_starts_total",
+		"Function executions that were cold starts.", "function", "runtime")
+	durationSeconds = metrics.GetGlobalRegistry().NewHistogramVec("serverless_function_duration_seconds",
+		"Function execution time in seconds.", nil, "function", "runtime", "outcome")
+	initDurationSeconds = metrics.GetGlobalRegistry().NewHistogramVec("serverless_function_init_duration_seconds",
+		"Time spent compiling and instantiating functions before their handler ran, in seconds.", nil, "function", "runtime")
 )
 
 // MetricsCollector collects metrics for function executions
@@ -14,6 +37,25 @@ type MetricsCollector struct {
 	lastExecutions   map[string]time.Time
 	coldStartCounts  map[string]int64
 	coldStartLatency map[string]time.Duration
+	starts           map[string]*startStats
+	latencies        map[string]*latencyDistributions
+	windows          map[string]*windowedMetrics
+}
+
+// startStats sums up how a function's executions were started, over the
+// executions whose start was reported
+type startStats struct {
+	reports          int64
+	compilations     int64
+	instancesCreated int64
+	initTime         time.Duration
+	handlerTime      time.Duration
+}
+
+// latencyDistributions holds a function's latencies overall and split by
+// cold and warm executions
+type latencyDistributions struct {
+	all, cold, warm latencyHistogram
 }
 
 // NewMetricsCollector creates a new metrics collector
@@ -25,11 +67,23 @@ func NewMetricsCollector() *MetricsCollector {
 		lastExecutions:   make(map[string]time.Time),
 		coldStartCounts:  make(map[string]int64),
 		coldStartLatency: make(map[string]time.Duration),
+		starts:           make(map[string]*startStats),
+		latencies:        make(map[string]*latencyDistributions),
+		windows:          make(map[string]*windowedMetrics),
 	}
 }
 
-// RecordExecution records a function execution
+// RecordExecution records a function execution whose start is unknown
 func (m *MetricsCollector) RecordExecution(functionName string, duration time.Duration, err error) {
+	m.RecordExecutionAt(functionName, time.Now(), duration, err, nil)
+}
+
+// RecordExecutionAt records a function execution that ended at the given
+// time and reports whether it was a cold start. start is how the runtime
+// started the function; it is nil when unknown, as when rebuilding the
+// metrics from the invocation history, and such executions are left out of
+// the cold start and init time figures.
+func (m *MetricsCollector) RecordExecutionAt(functionName string, now time.Time, duration time.Duration, err error, start *common.StartReport) (coldStart bool) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
@@ -44,48 +98,128 @@ func (m *MetricsCollector) RecordExecution(functionName string, duration time.Du
 		m.executionErrors[functionName]++
 	}
 
-	// Check if this is a cold start
-	now := time.Now()
-	lastExecution, exists := m.lastExecutions[functionName]
-	if !exists || now.Sub(lastExecution) > 5*time.Minute {
-		m.coldStartCounts[functionName]++
-		m.coldStartLatency[functionName] += duration
+	// Record how the function was started
+	if start != nil {
+		stats, ok := m.starts[functionName]
+		if !ok {
+			stats = &startStats{}
+			m.starts[functionName] = stats
+		}
+		stats.reports++
+		if start.Compiled {
+			stats.compilations++
+		}
+		if start.InstanceCreated {
+			stats.instancesCreated++
+		}
+		stats.initTime += start.InitDuration
+		stats.handlerTime += max(duration-start.InitDuration, 0)
+
+		if start.Cold() {
+			m.coldStartCounts[functionName]++
+			m.coldStartLatency[functionName] += start.InitDuration
+			coldStart = true
+		}
+	}
+
+	// Record the latency distributions
+	latencies, ok := m.latencies[functionName]
+	if !ok {
+		latencies = &latencyDistributions{}
+		m.latencies[functionName] = latencies
 	}
+	latencies.all.record(duration)
+	if coldStart {
+		latencies.cold.record(duration)
+	} else if start != nil {
+		latencies.warm.record(duration)
+	}
+
+	// Record the execution in the recent windows
+	windows, ok := m.windows[functionName]
+	if !ok {
+		windows = newWindowedMetrics()
+		m.windows[functionName] = windows
+	}
+	windows.record(now, duration, err)
 
 	// Update last execution time
 	m.lastExecutions[functionName] = now
+	return coldStart
 }
 
-// GetMetrics returns metrics for all functions
-func (m *MetricsCollector) GetMetrics() map[string]FunctionMetrics {
+// GetWindowMetrics returns metrics for all functions over the last window,
+// which may be up to MaxMetricsWindow
+func (m *MetricsCollector) GetWindowMetrics(window time.Duration) map[string]WindowMetrics {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	metrics := make(map[string]FunctionMetrics)
+	now := time.Now()
+	metrics := make(map[string]WindowMetrics, len(m.windows))
+	for name, windows := range m.windows {
+		metrics[name] = windows.summarize(name, now, window)
+	}
+	return metrics
+}
 
-	for name, count := range m.executionCounts {
-		avgDuration := time.Duration(0)
-		if count > 0 {
-			avgDuration = time.Duration(int64(m.executionTimes[name]) / count)
-		}
+// GetFunctionWindowMetrics returns metrics for a specific function over the
+// last window, which may be up to MaxMetricsWindow
+func (m *MetricsCollector) GetFunctionWindowMetrics(functionName string, window time.Duration) (WindowMetrics, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 
-		avgColdStartLatency := time.Duration(0)
-		coldStartCount := m.coldStartCounts[name]
-		if coldStartCount > 0 {
-			avgColdStartLatency = time.Duration(int64(m.coldStartLatency[name]) / coldStartCount)
-		}
+	windows, exists := m.windows[functionName]
+	if !exists {
+		return WindowMetrics{}, false
+	}
+	return windows.summarize(functionName, time.Now(), window), true
+}
 
-		metrics[name] = FunctionMetrics{
-			Name:                name,
-			ExecutionCount:      count,
-			AverageDuration:     avgDuration,
-			ErrorCount:          m.executionErrors[name],
-			LastExecutionTime:   m.lastExecutions[name],
-			ColdStartCount:      coldStartCount,
-			AvgColdStartLatency: avgColdStartLatency,
-		}
+// Reset clears the metrics of a function and reports whether it had any
+func (m *MetricsCollector) Reset(functionName string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.executionCounts[functionName]; !exists {
+		return false
 	}
+	delete(m.executionCounts, functionName)
+	delete(m.executionTimes, functionName)
+	delete(m.executionErrors, functionName)
+	delete(m.lastExecutions, functionName)
+	delete(m.coldStartCounts, functionName)
+	delete(m.coldStartLatency, functionName)
+	delete(m.starts, functionName)
+	delete(m.latencies, functionName)
+	delete(m.windows, functionName)
+	return true
+}
 
+// ResetAll clears the metrics of every function
+func (m *MetricsCollector) ResetAll() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	clear(m.executionCounts)
+	clear(m.executionTimes)
+	clear(m.executionErrors)
+	clear(m.lastExecutions)
+	clear(m.coldStartCounts)
+	clear(m.coldStartLatency)
+	clear(m.starts)
+	clear(m.latencies)
+	clear(m.windows)
+}
+
+// GetMetrics returns metrics for all functions
+func (m *MetricsCollector) GetMetrics() map[string]FunctionMetrics {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	metrics := make(map[string]FunctionMetrics)
+	for name := range m.executionCounts {
+		metrics[name] = m.functionMetrics(name)
+	}
 	return metrics
 }
 
@@ -94,36 +228,58 @@ func (m *MetricsCollector) GetFunctionMetrics(functionName string) (FunctionMetr
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	count, exists := m.executionCounts[functionName]
-	if !exists {
+	if _, exists := m.executionCounts[functionName]; !exists {
 		return FunctionMetrics{}, false
 	}
+	return m.functionMetrics(functionName), true
+}
 
+// functionMetrics builds the metrics of a function. The caller must hold the
+// lock.
+func (m *MetricsCollector) functionMetrics(name string) FunctionMetrics {
+	count := m.executionCounts[name]
 	avgDuration := time.Duration(0)
 	if count > 0 {
-		avgDuration = time.Duration(int64(m.executionTimes[functionName]) / count)
+		avgDuration = time.Duration(int64(m.executionTimes[name]) / count)
 	}
 
 	avgColdStartLatency := time.Duration(0)
-	coldStartCount := m.coldStartCounts[functionName]
+	coldStartCount := m.coldStartCounts[name]
 	if coldStartCount > 0 {
-		avgColdStartLatency = time.Duration(int64(m.coldStartLatency[functionName]) / coldStartCount)
+		avgColdStartLatency = time.Duration(int64(m.coldStartLatency[name]) / coldStartCount)
 	}
 
 	metrics := FunctionMetrics{
-		Name:                functionName,
+		Name:                name,
 		ExecutionCount:      count,
 		AverageDuration:     avgDuration,
-		ErrorCount:          m.executionErrors[functionName],
-		LastExecutionTime:   m.lastExecutions[functionName],
+		ErrorCount:          m.executionErrors[name],
+		LastExecutionTime:   m.lastExecutions[name],
 		ColdStartCount:      coldStartCount,
 		AvgColdStartLatency: avgColdStartLatency,
 	}
-
-	return metrics, true
+	if stats, ok := m.starts[name]; ok {
+		metrics.Compilations = stats.compilations
+		metrics.CacheHits = stats.reports - stats.compilations
+		metrics.InstancesCreated = stats.instancesCreated
+		metrics.InstancesReused = stats.reports - stats.instancesCreated
+		metrics.AvgInitDuration = time.Duration(int64(stats.initTime) / stats.reports)
+		metrics.AvgHandlerDuration = time.Duration(int64(stats.handlerTime) / stats.reports)
+	}
+	if latencies, ok := m.latencies[name]; ok {
+		metrics.Latency = latencies.all.stats()
+		metrics.ColdLatency = latencies.cold.stats()
+		metrics.WarmLatency = latencies.warm.stats()
+	}
+	return metrics
 }
 
-// FunctionMetrics represents metrics for a function
+// FunctionMetrics represents metrics for a function. Latency percentiles are
+// accurate to within 6.25%.
+//
+// A cold start is an execution that had to wait for the function's code to be
+// compiled or loaded, and its latency is the time spent doing so. The start
+// figures only cover executions whose start the runtime reported.
 type FunctionMetrics struct {
 	Name                string        `json:"name"`
 	ExecutionCount      int64         `json:"execution_count"`
@@ -132,4 +288,13 @@ type FunctionMetrics struct {
 	LastExecutionTime   time.Time     `json:"last_execution_time"`
 	ColdStartCount      int64         `json:"cold_start_count"`
 	AvgColdStartLatency time.Duration `json:"avg_cold_start_latency"`
+	Compilations        int64         `json:"compilations"`
+	CacheHits           int64         `json:"cache_hits"`
+	InstancesCreated    int64         `json:"instances_created"`
+	InstancesReused     int64         `json:"instances_reused"`
+	AvgInitDuration     time.Duration `json:"avg_init_duration"`
+	AvgHandlerDuration  time.Duration `json:"avg_handler_duration"`
+	Latency             LatencyStats  `json:"latency"`
+	ColdLatency         LatencyStats  `json:"cold_latency"`
+	WarmLatency         LatencyStats  `json:"warm_latency"`
 }
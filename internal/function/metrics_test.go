@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/mstgnz/self-hosted-serverless/internal/common"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -17,6 +18,8 @@ func TestNewMetricsCollector(t *testing.T) {
 	assert.NotNil(t, collector.lastExecutions)
 	assert.NotNil(t, collector.coldStartCounts)
 	assert.NotNil(t, collector.coldStartLatency)
+	assert.NotNil(t, collector.starts)
+	assert.NotNil(t, collector.latencies)
 }
 
 func TestRecordExecution(t *testing.T) {
@@ -34,8 +37,8 @@ func TestRecordExecution(t *testing.T) {
 	assert.Equal(t, int64(1), metrics.ExecutionCount)
 	assert.Equal(t, duration, metrics.AverageDuration)
 	assert.Equal(t, int64(0), metrics.ErrorCount)
-	assert.Equal(t, int64(1), metrics.ColdStartCount)
-	assert.Equal(t, duration, metrics.AvgColdStartLatency)
+	// The start of the execution is unknown
+	assert.Equal(t, int64(0), metrics.ColdStartCount)
 
 	// Record another execution
 	collector.RecordExecution(functionName, duration, nil)
@@ -46,7 +49,6 @@ func TestRecordExecution(t *testing.T) {
 	assert.Equal(t, int64(2), metrics.ExecutionCount)
 	assert.Equal(t, duration, metrics.AverageDuration)
 	assert.Equal(t, int64(0), metrics.ErrorCount)
-	assert.Equal(t, int64(1), metrics.ColdStartCount) // Still 1 because it's not a cold start
 
 	// Record an execution with an error
 	collector.RecordExecution(functionName, duration, errors.New("test error"))
@@ -111,31 +113,70 @@ func TestGetFunctionMetrics(t *testing.T) {
 
 func TestColdStart(t *testing.T) {
 	collector := NewMetricsCollector()
-
-	// Record an execution
 	functionName := "test-function"
-	duration := 100 * time.Millisecond
-	collector.RecordExecution(functionName, duration, nil)
+	now := time.Now()
+
+	// The module had to be compiled
+	cold := &common.StartReport{Compiled: true, InstanceCreated: true, InitDuration: 80 * time.Millisecond}
+	assert.True(t, collector.RecordExecutionAt(functionName, now, 100*time.Millisecond, nil, cold))
 
-	// Verify it's a cold start
 	metrics, _ := collector.GetFunctionMetrics(functionName)
 	assert.Equal(t, int64(1), metrics.ColdStartCount)
-	assert.Equal(t, duration, metrics.AvgColdStartLatency)
+	assert.Equal(t, 80*time.Millisecond, metrics.AvgColdStartLatency)
 
-	// Record another execution immediately (not a cold start)
-	collector.RecordExecution(functionName, duration, nil)
+	// The compiled module was cached, however long the function was idle
+	warm := &common.StartReport{InstanceCreated: true, InitDuration: 2 * time.Millisecond}
+	assert.False(t, collector.RecordExecutionAt(functionName, now.Add(time.Hour), 12*time.Millisecond, nil, warm))
+
+	// The start of this execution is unknown
+	assert.False(t, collector.RecordExecutionAt(functionName, now.Add(time.Hour), 10*time.Millisecond, nil, nil))
+
+	// A reused instance
+	assert.False(t, collector.RecordExecutionAt(functionName, now.Add(time.Hour), 10*time.Millisecond, nil, &common.StartReport{}))
 
-	// Verify cold start count hasn't changed
 	metrics, _ = collector.GetFunctionMetrics(functionName)
+	assert.Equal(t, int64(4), metrics.ExecutionCount)
 	assert.Equal(t, int64(1), metrics.ColdStartCount)
+	assert.Equal(t, 80*time.Millisecond, metrics.AvgColdStartLatency)
+	assert.Equal(t, int64(1), metrics.Compilations)
+	assert.Equal(t, int64(2), metrics.CacheHits)
+	assert.Equal(t, int64(2), metrics.InstancesCreated)
+	assert.Equal(t, int64(1), metrics.InstancesReused)
+	// Init and handler time average over the three reported executions
+	assert.Equal(t, 82*time.Millisecond/3, metrics.AvgInitDuration)
+	assert.Equal(t, 40*time.Millisecond/3, metrics.AvgHandlerDuration)
+
+	assert.True(t, collector.Reset(functionName))
+	assert.NotContains(t, collector.starts, functionName)
+}
 
-	// Simulate time passing (> 5 minutes)
-	collector.lastExecutions[functionName] = time.Now().Add(-6 * time.Minute)
-
-	// Record another execution (should be a cold start)
-	collector.RecordExecution(functionName, duration, nil)
+func TestLatencyPercentiles(t *testing.T) {
+	collector := NewMetricsCollector()
 
-	// Verify cold start count has increased
-	metrics, _ = collector.GetFunctionMetrics(functionName)
-	assert.Equal(t, int64(2), metrics.ColdStartCount)
+	// A 1ms cold start, 2ms to 100ms, then a 2s cold start after the module
+	// is recompiled
+	now := time.Now()
+	cold := &common.StartReport{Compiled: true, InstanceCreated: true}
+	collector.RecordExecutionAt("test-function", now, time.Millisecond, nil, cold)
+	for i := 2; i <= 100; i++ {
+		collector.RecordExecutionAt("test-function", now, time.Duration(i)*time.Millisecond, nil, &common.StartReport{})
+	}
+	collector.RecordExecutionAt("test-function", now, 2*time.Second, nil, cold)
+
+	metrics, _ := collector.GetFunctionMetrics("test-function")
+	within := func(want, got time.Duration) {
+		t.Helper()
+		assert.InEpsilon(t, float64(want), float64(got), 1.0/subBuckets)
+	}
+	within(51*time.Millisecond, metrics.Latency.P50)
+	within(91*time.Millisecond, metrics.Latency.P90)
+	within(96*time.Millisecond, metrics.Latency.P95)
+	within(100*time.Millisecond, metrics.Latency.P99)
+	assert.Equal(t, 2*time.Second, metrics.Latency.Max)
+
+	// The first and last executions were cold
+	within(time.Millisecond, metrics.ColdLatency.P50)
+	assert.Equal(t, 2*time.Second, metrics.ColdLatency.Max)
+	within(51*time.Millisecond, metrics.WarmLatency.P50)
+	assert.Equal(t, 100*time.Millisecond, metrics.WarmLatency.Max)
 }
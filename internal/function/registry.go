@@ -2,18 +2,26 @@ package function
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"plugin"
+	"regexp"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mstgnz/self-hosted-serverless/internal/common"
+	"github.com/mstgnz/self-hosted-serverless/internal/health"
+	"github.com/mstgnz/self-hosted-serverless/internal/history"
+	"github.com/mstgnz/self-hosted-serverless/internal/ratelimit"
 	"github.com/mstgnz/self-hosted-serverless/internal/runtime"
+	"github.com/mstgnz/self-hosted-serverless/internal/tracing"
+	"github.com/mstgnz/self-hosted-serverless/internal/usage"
 )
 
 // WasmFunctionHandler implements FunctionHandler for WebAssembly functions using WASI stdio.
@@ -23,12 +31,50 @@ type WasmFunctionHandler struct {
 }
 
 func (h *WasmFunctionHandler) Execute(input map[string]any) (any, error) {
-	return h.runtime.ExecuteWASI(h.wasmFile, input)
+	result, _, err := h.ExecuteReporting(tracing.ExtractInput(context.Background(), input), input)
+	return result, err
 }
 
+// ExecuteReporting executes the module and reports whether it had to be
+// compiled
+func (h *WasmFunctionHandler) ExecuteReporting(ctx context.Context, input map[string]any) (any, common.StartReport, error) {
+	return h.runtime.ExecuteWASIContext(ctx, h.wasmFile, input)
+}
+
+// execute calls the handler with the context of the execution if it takes
+// one, and adds how it was started to start. Handlers that don't report it,
+// such as Go plugins loaded on startup, are warm.
+func execute(ctx context.Context, handler common.FunctionHandler, input map[string]any, start *common.StartReport) (any, error) {
+	switch h := handler.(type) {
+	case common.ReportingFunctionHandler:
+		result, report, err := h.ExecuteReporting(ctx, input)
+		start.Add(report)
+		return result, err
+	case common.ContextFunctionHandler:
+		return h.ExecuteContext(ctx, input)
+	}
+	return handler.Execute(input)
+}
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// IsValidName reports whether name can be used as a function name
+func IsValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+var (
+	// ErrFunctionNotFound is returned when executing a function that is not registered
+	ErrFunctionNotFound = errors.New("function not found")
+	// ErrStreamClosed is returned when a function emits a chunk after its stream
+	// has ended, for example because the execution timed out
+	ErrStreamClosed = errors.New("stream closed")
+)
+
 type execResult struct {
 	value any
 	err   error
+	start common.StartReport
 }
 
 // Registry manages the serverless functions
@@ -39,6 +85,12 @@ type Registry struct {
 	metrics         *MetricsCollector
 	mutex           sync.RWMutex
 	functionTimeout time.Duration
+	functionsDir    string
+	wasmErr         error
+	loadErrors      []error
+	limits          *ratelimit.Manager
+	functionLimits  map[string]ratelimit.Limits
+	history         *history.Store
 }
 
 // NewRegistry creates a new function registry
@@ -61,9 +113,23 @@ func NewRegistry() *Registry {
 		wasmRuntime:     wasmRuntime,
 		metrics:         NewMetricsCollector(),
 		functionTimeout: timeout,
+		functionsDir:    "functions",
+		wasmErr:         err,
+		limits:          ratelimit.GetGlobalManager(),
+		functionLimits:  make(map[string]ratelimit.Limits),
 	}
 
-	registry.loadFunctions()
+	if err := registry.loadFunctions(); err != nil {
+		registry.loadErrors = append(registry.loadErrors, err)
+	}
+	if err := registry.loadLimits(os.Getenv("FUNCTION_LIMITS_FILE")); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		registry.loadErrors = append(registry.loadErrors, err)
+	}
+
+	checker := health.GetGlobalChecker()
+	checker.Register("wasm_runtime", registry.CheckRuntime, true)
+	checker.Register("functions", registry.CheckFunctions, false)
 
 	return registry
 }
@@ -92,42 +158,349 @@ func (r *Registry) RegisterWasmFunction(name string, wasmFile string, info commo
 	return nil
 }
 
+// Deploy writes a function's code to the functions directory and registers it,
+// replacing any existing function with the same name. Only WebAssembly
+// functions can be deployed this way; Go plugins must be built for the exact
+// server binary and are loaded from the functions directory on startup.
+func (r *Registry) Deploy(info common.FunctionInfo, code []byte) error {
+	if !IsValidName(info.Name) {
+		return fmt.Errorf("invalid function name %q", info.Name)
+	}
+	if info.Runtime != "wasm" {
+		return fmt.Errorf("unsupported runtime %q: only wasm functions can be deployed", info.Runtime)
+	}
+	if info.CORS != nil {
+		if err := info.CORS.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.wasmRuntime == nil {
+		return errors.New("WebAssembly runtime not initialized")
+	}
+
+	if err := os.MkdirAll(r.functionsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create functions directory: %w", err)
+	}
+
+	// Validate before writing so a bad module never replaces a good one.
+	if err := r.wasmRuntime.Validate(code); err != nil {
+		return err
+	}
+
+	if info.Version == "" {
+		info.Version = codeVersion(code)
+	}
+
+	path := filepath.Join(r.functionsDir, info.Name+".wasm")
+	if err := os.WriteFile(path, code, 0644); err != nil {
+		return fmt.Errorf("failed to write function: %w", err)
+	}
+	if err := r.wasmRuntime.Compile(path); err != nil {
+		return err
+	}
+
+	if info.Description == "" {
+		info.Description = fmt.Sprintf("WebAssembly function: %s", info.Name)
+	}
+	return r.RegisterWasmFunction(info.Name, path, info)
+}
+
+// Delete unregisters a function. The module file of a WebAssembly function is
+// removed as well; Go plugins stay on disk since they cannot be unloaded.
+func (r *Registry) Delete(name string) error {
+	r.mutex.Lock()
+	handler, exists := r.functions[name]
+	delete(r.functions, name)
+	delete(r.metadata, name)
+	r.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrFunctionNotFound, name)
+	}
+
+	if wh, ok := handler.(*WasmFunctionHandler); ok {
+		wh.runtime.Evict(wh.wasmFile)
+		if err := os.Remove(wh.wasmFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove function file: %w", err)
+		}
+	}
+	return nil
+}
+
 // Execute executes a function by name with a configurable timeout and panic recovery.
-func (r *Registry) Execute(name string, input map[string]any) (any, error) {
+func (r *Registry) Execute(name string, input map[string]any, opts ...ExecOption) (any, error) {
+	return r.run(name, r.newInvocation(input, opts), nil, func(ctx context.Context, handler common.FunctionHandler, start *common.StartReport) (any, error) {
+		injectTrace(ctx, input)
+		return execute(ctx, handler, input, start)
+	})
+}
+
+// ExecuteStream executes a function and passes each chunk it produces to emit.
+// Functions that don't implement common.StreamingFunctionHandler produce a
+// single chunk holding their result.
+func (r *Registry) ExecuteStream(name string, input map[string]any, emit func(chunk any) error, opts ...ExecOption) error {
+	e := &emitter{emit: emit, count: r.history != nil}
+	defer e.close()
+
+	_, err := r.run(name, r.newInvocation(input, opts), e, func(ctx context.Context, handler common.FunctionHandler, start *common.StartReport) (any, error) {
+		injectTrace(ctx, input)
+		if sh, ok := handler.(common.StreamingFunctionHandler); ok {
+			return nil, sh.ExecuteStream(input, e.send)
+		}
+		result, err := execute(ctx, handler, input, start)
+		if err != nil {
+			return nil, err
+		}
+		return nil, e.send(result)
+	})
+	return err
+}
+
+// ExecuteBidi executes a function over a stream of input records and passes
+// each chunk it produces to emit. Functions that don't implement
+// common.BidiStreamingFunctionHandler are executed once per input record.
+func (r *Registry) ExecuteBidi(name string, inputs <-chan map[string]any, emit func(chunk any) error, opts ...ExecOption) error {
+	e := &emitter{emit: emit, count: r.history != nil}
+	defer e.close()
+
+	_, err := r.run(name, r.newInvocation(nil, opts), e, func(ctx context.Context, handler common.FunctionHandler, start *common.StartReport) (any, error) {
+		if bh, ok := handler.(common.BidiStreamingFunctionHandler); ok {
+			return nil, bh.ExecuteBidi(inputs, e.send)
+		}
+		for input := range inputs {
+			injectTrace(ctx, input)
+			result, err := execute(ctx, handler, input, start)
+			if err != nil {
+				return nil, err
+			}
+			if err := e.send(result); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// run calls fn with the named function's handler, enforcing the function's
+// limits and timeout, recovering panics and recording metrics. A request over
+// the limits gets a *ratelimit.LimitError. The output of streams is counted
+// by their emitter, e. fn is passed the context of the execution, which
+// carries its span, deadline and usage meter, and adds how the handler was
+// started to start.
+func (r *Registry) run(name string, inv invocation, e *emitter, fn func(ctx context.Context, handler common.FunctionHandler, start *common.StartReport) (any, error)) (any, error) {
+	traceCtx, span := tracing.Start(inv.ctx, "execute "+name, tracing.SpanKindInternal)
+	defer span.End()
+	span.SetAttribute("function.name", name)
+	if inv.trigger != "" {
+		span.SetAttribute("function.trigger", inv.trigger)
+	}
+	inv.span = span
+
 	r.mutex.RLock()
 	handler, exists := r.functions[name]
 	r.mutex.RUnlock()
 
 	if !exists {
-		return nil, fmt.Errorf("function %s not found", name)
+		err := fmt.Errorf("%w: %s", ErrFunctionNotFound, name)
+		span.SetError(err)
+		return nil, err
+	}
+
+	// A caller that gives up while queued for capacity leaves the queue
+	release, err := r.limits.Acquire(inv.ctx, "function:"+name, r.Limits(name))
+	if err != nil {
+		span.SetError(err)
+		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), r.functionTimeout)
+	// The execution outlives a caller that gives up on it, but not the timeout
+	inv.meter = &usage.Meter{}
+	ctx, cancel := context.WithTimeout(usage.NewContext(context.WithoutCancel(traceCtx), inv.meter), r.functionTimeout)
 	defer cancel()
 
 	ch := make(chan execResult, 1)
 	go func() {
 		var res execResult
+		// The concurrency slot is held until the function really returns,
+		// even if the caller has given up waiting for it
+		defer release()
 		defer func() {
 			if rec := recover(); rec != nil {
 				res.err = fmt.Errorf("function panicked: %v", rec)
 			}
 			ch <- res
 		}()
-		res.value, res.err = handler.Execute(input)
+		res.value, res.err = fn(ctx, handler, &res.start)
 	}()
 
 	startTime := time.Now()
 	select {
 	case <-ctx.Done():
-		r.metrics.RecordExecution(name, time.Since(startTime), ctx.Err())
-		return nil, fmt.Errorf("function %s: execution timed out after %v", name, r.functionTimeout)
+		err := fmt.Errorf("function %s: execution timed out after %v: %w", name, r.functionTimeout, ctx.Err())
+		// How the handler was started is unknown until it returns
+		r.recordExecution(name, inv, startTime, nil, err, outcomeTimeout, 0)
+		return nil, err
 	case res := <-ch:
-		r.metrics.RecordExecution(name, time.Since(startTime), res.err)
+		outcome := outcomeSuccess
+		if res.err != nil {
+			outcome = outcomeError
+		}
+		var outputBytes int64
+		if e != nil {
+			outputBytes = e.bytes.Load()
+		} else if r.history != nil && res.err == nil {
+			outputBytes = jsonSize(res.value)
+		}
+		r.recordExecution(name, inv, startTime, &res.start, res.err, outcome, outputBytes)
 		return res.value, res.err
 	}
 }
 
+// recordExecution records an execution in the function metrics, in the
+// Prometheus metrics, which are labeled by runtime and outcome, and in the
+// invocation history. report is how the handler was started, if known.
+func (r *Registry) recordExecution(name string, inv invocation, start time.Time, report *common.StartReport, err error, outcome string, outputBytes int64) {
+	end := time.Now()
+	duration := end.Sub(start)
+
+	r.mutex.RLock()
+	info := r.metadata[name]
+	r.mutex.RUnlock()
+
+	coldStart := r.metrics.RecordExecutionAt(name, end, duration, err, report)
+
+	inv.span.SetAttribute("function.runtime", info.Runtime)
+	if info.Version != "" {
+		inv.span.SetAttribute("function.version", info.Version)
+	}
+	inv.span.SetAttribute("function.outcome", outcome)
+	if report != nil {
+		inv.span.SetAttribute("function.cold_start", coldStart)
+		inv.span.SetAttribute("function.init_duration_ms", report.InitDuration.Milliseconds())
+	}
+	inv.span.SetError(err)
+
+	invocationsTotal.Inc(name, info.Runtime, outcome)
+	durationSeconds.Observe(duration.Seconds(), name, info.Runtime, outcome)
+	if err != nil {
+		errorsTotal.Inc(name, info.Runtime)
+	}
+	if coldStart {
+		coldStartsTotal.Inc(name, info.Runtime)
+	}
+	if report != nil {
+		initDurationSeconds.Observe(report.InitDuration.Seconds(), name, info.Runtime)
+	}
+
+	if r.history != nil {
+		used := inv.meter.Usage()
+		rec := history.Record{
+			Function:    name,
+			Version:     info.Version,
+			Trigger:     inv.trigger,
+			Caller:      inv.caller,
+			KeyID:       inv.keyID,
+			StartedAt:   start,
+			EndedAt:     end,
+			Duration:    duration,
+			Outcome:     outcome,
+			InputBytes:  inv.inputBytes,
+			OutputBytes: outputBytes,
+			MemoryPages: used.MemoryPages,
+			DBQueries:   used.DBQueries,
+		}
+		if err != nil {
+			rec.Error = err.Error()
+		}
+		r.history.Record(rec)
+	}
+}
+
+// emitter guards a stream's emit callback so that it is never called after
+// the execution has returned to the caller.
+type emitter struct {
+	mu     sync.Mutex
+	closed bool
+	emit   func(chunk any) error
+	// count makes the emitter count the JSON size of the chunks it sends
+	count bool
+	bytes atomic.Int64
+}
+
+func (e *emitter) send(chunk any) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return ErrStreamClosed
+	}
+	if e.count {
+		e.bytes.Add(jsonSize(chunk))
+	}
+	return e.emit(chunk)
+}
+
+func (e *emitter) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.closed = true
+}
+
+// SetLimits sets the rate and concurrency limits of a function. The name "*"
+// sets the default for functions without limits of their own.
+func (r *Registry) SetLimits(name string, limits ratelimit.Limits) error {
+	if err := limits.Validate(); err != nil {
+		return fmt.Errorf("function %s: %w", name, err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if limits.IsZero() {
+		delete(r.functionLimits, name)
+	} else {
+		r.functionLimits[name] = limits
+	}
+	return nil
+}
+
+// Limits returns the rate and concurrency limits that apply to a function
+func (r *Registry) Limits(name string) ratelimit.Limits {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if limits, ok := r.functionLimits[name]; ok {
+		return limits
+	}
+	return r.functionLimits["*"]
+}
+
+// loadLimits reads function limits from a JSON file mapping function names,
+// or "*" for the default, to their limits
+func (r *Registry) loadLimits(file string) error {
+	if file == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read function limits file: %w", err)
+	}
+	var limits map[string]ratelimit.Limits
+	if err := json.Unmarshal(data, &limits); err != nil {
+		return fmt.Errorf("failed to parse function limits file: %w", err)
+	}
+
+	for name, l := range limits {
+		if err := r.SetLimits(name, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ListFunctions returns a list of all registered functions
 func (r *Registry) ListFunctions() []common.FunctionInfo {
 	r.mutex.RLock()
@@ -141,6 +514,15 @@ func (r *Registry) ListFunctions() []common.FunctionInfo {
 	return functions
 }
 
+// GetFunctionInfo returns the metadata of a registered function
+func (r *Registry) GetFunctionInfo(name string) (common.FunctionInfo, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	info, exists := r.metadata[name]
+	return info, exists
+}
+
 // GetMetrics returns metrics for all functions
 func (r *Registry) GetMetrics() map[string]FunctionMetrics {
 	return r.metrics.GetMetrics()
@@ -151,9 +533,30 @@ func (r *Registry) GetFunctionMetrics(name string) (FunctionMetrics, bool) {
 	return r.metrics.GetFunctionMetrics(name)
 }
 
+// GetWindowMetrics returns metrics for all functions over the last window
+func (r *Registry) GetWindowMetrics(window time.Duration) map[string]WindowMetrics {
+	return r.metrics.GetWindowMetrics(window)
+}
+
+// GetFunctionWindowMetrics returns metrics for a specific function over the
+// last window
+func (r *Registry) GetFunctionWindowMetrics(name string, window time.Duration) (WindowMetrics, bool) {
+	return r.metrics.GetFunctionWindowMetrics(name, window)
+}
+
+// ResetMetrics clears the metrics of a function, or of every function when
+// name is empty. It reports whether there were metrics to clear.
+func (r *Registry) ResetMetrics(name string) bool {
+	if name == "" {
+		r.metrics.ResetAll()
+		return true
+	}
+	return r.metrics.Reset(name)
+}
+
 // loadFunctions loads all functions from the functions directory
 func (r *Registry) loadFunctions() error {
-	functionsDir := "functions"
+	functionsDir := r.functionsDir
 
 	if _, err := os.Stat(functionsDir); os.IsNotExist(err) {
 		if err := os.MkdirAll(functionsDir, 0755); err != nil {
@@ -162,6 +565,8 @@ func (r *Registry) loadFunctions() error {
 		return nil
 	}
 
+	// A function that fails to load is recorded and skipped so the others
+	// are still served; the errors are reported by CheckFunctions.
 	return filepath.WalkDir(functionsDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -174,7 +579,10 @@ func (r *Registry) loadFunctions() error {
 		ext := filepath.Ext(path)
 		switch ext {
 		case ".so":
-			return r.loadGoPlugin(path)
+			if err := r.loadGoPlugin(path); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+				r.loadErrors = append(r.loadErrors, err)
+			}
 		case ".wasm":
 			if r.wasmRuntime != nil {
 				name := filepath.Base(path)
@@ -184,14 +592,34 @@ func (r *Registry) loadFunctions() error {
 					Description: fmt.Sprintf("WebAssembly function: %s", name),
 					Runtime:     "wasm",
 				}
+				if code, err := os.ReadFile(path); err == nil {
+					info.Version = codeVersion(code)
+				}
 				return r.RegisterWasmFunction(name, path, info)
 			}
+			r.loadErrors = append(r.loadErrors, fmt.Errorf("cannot load %s: WebAssembly runtime not initialized", path))
 		}
 
 		return nil
 	})
 }
 
+// CheckRuntime reports whether the WebAssembly runtime was initialized
+func (r *Registry) CheckRuntime(ctx context.Context) error {
+	if r.wasmRuntime == nil {
+		if r.wasmErr != nil {
+			return fmt.Errorf("WebAssembly runtime not initialized: %w", r.wasmErr)
+		}
+		return errors.New("WebAssembly runtime not initialized")
+	}
+	return nil
+}
+
+// CheckFunctions reports the errors of functions that failed to load on startup
+func (r *Registry) CheckFunctions(ctx context.Context) error {
+	return errors.Join(r.loadErrors...)
+}
+
 // loadGoPlugin loads a Go plugin
 func (r *Registry) loadGoPlugin(path string) error {
 	p, err := plugin.Open(path)
@@ -218,6 +646,11 @@ func (r *Registry) loadGoPlugin(path string) error {
 	if !ok {
 		return errors.New("plugin Info is not a FunctionInfo")
 	}
+	if info.CORS != nil {
+		if err := info.CORS.Validate(); err != nil {
+			return fmt.Errorf("plugin %s: %w", path, err)
+		}
+	}
 
 	r.Register(info.Name, handler, info)
 
@@ -1,10 +1,19 @@
 package function
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mstgnz/self-hosted-serverless/internal/common"
+	"github.com/mstgnz/self-hosted-serverless/internal/cors"
+	"github.com/mstgnz/self-hosted-serverless/internal/metrics"
+	"github.com/mstgnz/self-hosted-serverless/internal/ratelimit"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -18,6 +27,19 @@ func (m *MockFunctionHandler) Execute(input map[string]interface{}) (interface{}
 	return m.ExecuteFunc(input)
 }
 
+// ReportingMockFunctionHandler is a mock handler that reports how it was
+// started
+type ReportingMockFunctionHandler struct {
+	MockFunctionHandler
+	Start common.StartReport
+}
+
+// ExecuteReporting calls the mock ExecuteFunc and reports Start
+func (m *ReportingMockFunctionHandler) ExecuteReporting(ctx context.Context, input map[string]interface{}) (interface{}, common.StartReport, error) {
+	result, err := m.ExecuteFunc(input)
+	return result, m.Start, err
+}
+
 func TestNewRegistry(t *testing.T) {
 	registry := NewRegistry()
 	assert.NotNil(t, registry)
@@ -125,3 +147,267 @@ func TestListFunctions(t *testing.T) {
 	assert.True(t, functionNames["function-2"])
 	assert.True(t, functionNames["function-3"])
 }
+
+// MockStreamingHandler is a mock implementation of StreamingFunctionHandler and
+// BidiStreamingFunctionHandler for testing
+type MockStreamingHandler struct {
+	MockFunctionHandler
+}
+
+// ExecuteStream emits one chunk per item in the "items" input
+func (m *MockStreamingHandler) ExecuteStream(input map[string]interface{}, emit func(chunk interface{}) error) error {
+	items, _ := input["items"].([]interface{})
+	for _, item := range items {
+		if err := emit(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExecuteBidi emits the "value" field of every input record
+func (m *MockStreamingHandler) ExecuteBidi(inputs <-chan map[string]interface{}, emit func(chunk interface{}) error) error {
+	for input := range inputs {
+		if err := emit(input["value"]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestExecuteStream(t *testing.T) {
+	registry := NewRegistry()
+
+	registry.Register("streaming", &MockStreamingHandler{}, common.FunctionInfo{Name: "streaming", Runtime: "go"})
+	registry.Register("unary", &MockFunctionHandler{
+		ExecuteFunc: func(input map[string]interface{}) (interface{}, error) {
+			return "single", nil
+		},
+	}, common.FunctionInfo{Name: "unary", Runtime: "go"})
+
+	// A streaming function emits each chunk
+	var chunks []interface{}
+	err := registry.ExecuteStream("streaming", map[string]interface{}{"items": []interface{}{"a", "b", "c"}}, func(chunk interface{}) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"a", "b", "c"}, chunks)
+
+	// A regular function produces a single chunk
+	chunks = nil
+	err = registry.ExecuteStream("unary", nil, func(chunk interface{}) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"single"}, chunks)
+
+	// Emit errors stop the function
+	err = registry.ExecuteStream("streaming", map[string]interface{}{"items": []interface{}{"a", "b"}}, func(chunk interface{}) error {
+		return errors.New("client gone")
+	})
+	assert.EqualError(t, err, "client gone")
+
+	// Metrics are recorded for streamed executions
+	metrics, exists := registry.GetFunctionMetrics("streaming")
+	assert.True(t, exists)
+	assert.Equal(t, int64(2), metrics.ExecutionCount)
+	assert.Equal(t, int64(1), metrics.ErrorCount)
+}
+
+func TestExecuteBidi(t *testing.T) {
+	registry := NewRegistry()
+
+	registry.Register("bidi", &MockStreamingHandler{}, common.FunctionInfo{Name: "bidi", Runtime: "go"})
+	registry.Register("unary", &MockFunctionHandler{
+		ExecuteFunc: func(input map[string]interface{}) (interface{}, error) {
+			return input["value"].(float64) * 2, nil
+		},
+	}, common.FunctionInfo{Name: "unary", Runtime: "go"})
+
+	for name, expected := range map[string][]interface{}{
+		"bidi":  {1.0, 2.0, 3.0},
+		"unary": {2.0, 4.0, 6.0},
+	} {
+		inputs := make(chan map[string]interface{})
+		go func() {
+			defer close(inputs)
+			for i := 1; i <= 3; i++ {
+				inputs <- map[string]interface{}{"value": float64(i)}
+			}
+		}()
+
+		var chunks []interface{}
+		err := registry.ExecuteBidi(name, inputs, func(chunk interface{}) error {
+			chunks = append(chunks, chunk)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, chunks, name)
+	}
+}
+
+func TestDeployAndDelete(t *testing.T) {
+	registry := NewRegistry()
+	registry.functionsDir = t.TempDir()
+
+	// The smallest valid WebAssembly module: magic number and version
+	code := []byte("\x00asm\x01\x00\x00\x00")
+
+	err := registry.Deploy(common.FunctionInfo{Name: "hello", Runtime: "wasm"}, code)
+	assert.NoError(t, err)
+
+	info, exists := registry.GetFunctionInfo("hello")
+	assert.True(t, exists)
+	assert.Equal(t, "wasm", info.Runtime)
+	assert.Equal(t, "WebAssembly function: hello", info.Description)
+
+	path := filepath.Join(registry.functionsDir, "hello.wasm")
+	assert.FileExists(t, path)
+
+	// Invalid modules, names and runtimes are rejected
+	assert.Error(t, registry.Deploy(common.FunctionInfo{Name: "bad", Runtime: "wasm"}, []byte("not wasm")))
+	assert.NoFileExists(t, filepath.Join(registry.functionsDir, "bad.wasm"))
+	assert.Error(t, registry.Deploy(common.FunctionInfo{Name: "../hello", Runtime: "wasm"}, code))
+	assert.Error(t, registry.Deploy(common.FunctionInfo{Name: "hello", Runtime: "go"}, code))
+	// So are CORS policies that allow credentials for any origin
+	anyOrigin := &cors.Policy{AllowedOrigins: []string{"*"}, AllowCredentials: true}
+	assert.Error(t, registry.Deploy(common.FunctionInfo{Name: "open", Runtime: "wasm", CORS: anyOrigin}, code))
+	assert.NoFileExists(t, filepath.Join(registry.functionsDir, "open.wasm"))
+
+	// Deleting removes the function and its module file
+	assert.NoError(t, registry.Delete("hello"))
+	_, exists = registry.GetFunctionInfo("hello")
+	assert.False(t, exists)
+	assert.NoFileExists(t, path)
+
+	assert.ErrorIs(t, registry.Delete("hello"), ErrFunctionNotFound)
+}
+
+func TestExecuteLimits(t *testing.T) {
+	registry := NewRegistry()
+	registry.limits = ratelimit.NewManager()
+
+	release := make(chan struct{})
+	registry.Register("slow", &MockFunctionHandler{
+		ExecuteFunc: func(input map[string]interface{}) (interface{}, error) {
+			<-release
+			return "done", nil
+		},
+	}, common.FunctionInfo{Name: "slow", Runtime: "go"})
+	registry.Register("fast", &MockFunctionHandler{
+		ExecuteFunc: func(input map[string]interface{}) (interface{}, error) {
+			return "done", nil
+		},
+	}, common.FunctionInfo{Name: "fast", Runtime: "go"})
+
+	assert.Error(t, registry.SetLimits("slow", ratelimit.Limits{Concurrency: -1}))
+	assert.NoError(t, registry.SetLimits("slow", ratelimit.Limits{Concurrency: 1}))
+	assert.NoError(t, registry.SetLimits("*", ratelimit.Limits{Requests: 2}))
+
+	// A second concurrent execution is rejected while the first runs
+	done := make(chan error)
+	go func() {
+		_, err := registry.Execute("slow", nil)
+		done <- err
+	}()
+	assert.Eventually(t, func() bool {
+		return registry.limits.Stats()["function:slow"].InFlight == 1
+	}, time.Second, time.Millisecond)
+
+	_, err := registry.Execute("slow", nil)
+	var limitErr *ratelimit.LimitError
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, "function:slow", limitErr.Name)
+
+	close(release)
+	assert.NoError(t, <-done)
+
+	// Functions without limits of their own get the default
+	_, err = registry.Execute("fast", nil)
+	assert.NoError(t, err)
+	_, err = registry.Execute("fast", nil)
+	assert.NoError(t, err)
+	_, err = registry.Execute("fast", nil)
+	assert.ErrorIs(t, err, ratelimit.ErrLimited)
+}
+
+func TestExecuteQueueCancelled(t *testing.T) {
+	registry := NewRegistry()
+	registry.limits = ratelimit.NewManager()
+
+	release := make(chan struct{})
+	registry.Register("slow", &MockFunctionHandler{
+		ExecuteFunc: func(input map[string]interface{}) (interface{}, error) {
+			<-release
+			return "done", nil
+		},
+	}, common.FunctionInfo{Name: "slow", Runtime: "go"})
+	assert.NoError(t, registry.SetLimits("slow", ratelimit.Limits{Concurrency: 1, QueueTimeoutMs: 10000}))
+
+	done := make(chan error)
+	go func() {
+		_, err := registry.Execute("slow", nil)
+		done <- err
+	}()
+	assert.Eventually(t, func() bool {
+		return registry.limits.Stats()["function:slow"].InFlight == 1
+	}, time.Second, time.Millisecond)
+
+	// A caller that goes away stops waiting for a slot
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := registry.Execute("slow", nil, WithContext(ctx))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Zero(t, registry.limits.Stats()["function:slow"].Queued)
+
+	close(release)
+	assert.NoError(t, <-done)
+}
+
+func TestLoadLimits(t *testing.T) {
+	registry := NewRegistry()
+
+	file := filepath.Join(t.TempDir(), "limits.json")
+	assert.NoError(t, os.WriteFile(file, []byte(`{"*": {"concurrency": 4}, "resize": {"requests": 10, "interval_secs": 1}}`), 0644))
+	assert.NoError(t, registry.loadLimits(file))
+	assert.Equal(t, ratelimit.Limits{Requests: 10, IntervalSecs: 1}, registry.Limits("resize"))
+	assert.Equal(t, ratelimit.Limits{Concurrency: 4}, registry.Limits("other"))
+
+	assert.NoError(t, os.WriteFile(file, []byte(`{"resize": {"requests": -1}}`), 0644))
+	assert.Error(t, registry.loadLimits(file))
+}
+
+func TestExecutePrometheusMetrics(t *testing.T) {
+	registry := NewRegistry()
+	handler := &ReportingMockFunctionHandler{
+		MockFunctionHandler: MockFunctionHandler{
+			ExecuteFunc: func(input map[string]interface{}) (interface{}, error) {
+				return nil, errors.New("boom")
+			},
+		},
+		Start: common.StartReport{Compiled: true, InstanceCreated: true, InitDuration: time.Millisecond},
+	}
+	registry.Register("prom-fail", handler, common.FunctionInfo{Name: "prom-fail", Runtime: "wasm"})
+
+	registry.Execute("prom-fail", nil)
+	handler.Start = common.StartReport{InstanceCreated: true}
+	registry.Execute("prom-fail", nil)
+
+	var b strings.Builder
+	assert.NoError(t, metrics.GetGlobalRegistry().WriteText(&b))
+	assert.Contains(t, b.String(), `serverless_function_invocations_total{function="prom-fail",runtime="wasm",outcome="error"} 2`)
+	assert.Contains(t, b.String(), `serverless_function_errors_total{function="prom-fail",runtime="wasm"} 2`)
+	// Only the first execution had to compile the module
+	assert.Contains(t, b.String(), `serverless_function_cold_starts_total{function="prom-fail",runtime="wasm"} 1`)
+	assert.Contains(t, b.String(), `serverless_function_init_duration_seconds_count{function="prom-fail",runtime="wasm"} 2`)
+
+	m, _ := registry.GetFunctionMetrics("prom-fail")
+	assert.Equal(t, int64(1), m.Compilations)
+	assert.Equal(t, int64(1), m.CacheHits)
+	assert.Equal(t, int64(2), m.InstancesCreated)
+	assert.Equal(t, time.Millisecond, m.AvgColdStartLatency)
+}
@@ -0,0 +1,134 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// MaxMetricsWindow is the longest window windowed metrics are kept for
+const MaxMetricsWindow = 24 * time.Hour
+
+// ringSizes lays out the rings of time slots windowed metrics are kept in.
+// Short windows are read from fine slots and long ones from coarse slots, so
+// memory per function is fixed no matter how long the server runs.
+var ringSizes = []struct {
+	slotLen time.Duration
+	slots   int
+}{
+	{10 * time.Second, 30}, // up to 5m
+	{time.Minute, 60},      // up to 1h
+	{time.Hour, 24},        // up to 24h
+}
+
+// WindowMetrics represents a function's metrics over a recent window of time.
+// The window is rounded up to whole slots of 10 seconds (up to 5m), a minute
+// (up to 1h) or an hour (up to 24h), the latest of which is still filling.
+type WindowMetrics struct {
+	Name            string        `json:"name"`
+	WindowSecs      int64         `json:"window_secs"`
+	Invocations     int64         `json:"invocations"`
+	Errors          int64         `json:"errors"`
+	Timeouts        int64         `json:"timeouts"`
+	InvocationRate  float64       `json:"invocation_rate"`
+	ErrorRate       float64       `json:"error_rate"`
+	AverageDuration time.Duration `json:"average_duration"`
+	Latency         LatencyStats  `json:"latency"`
+}
+
+// windowSlot holds the executions that ended in one slot of time
+type windowSlot struct {
+	index         int64
+	invocations   int64
+	errors        int64
+	timeouts      int64
+	totalDuration time.Duration
+	latency       latencyHistogram
+}
+
+type windowRing struct {
+	slotLen time.Duration
+	slots   []windowSlot
+}
+
+// windowedMetrics keeps a function's recent executions in rings of slots
+type windowedMetrics struct {
+	rings []windowRing
+}
+
+func newWindowedMetrics() *windowedMetrics {
+	w := &windowedMetrics{rings: make([]windowRing, len(ringSizes))}
+	for i, size := range ringSizes {
+		w.rings[i] = windowRing{slotLen: size.slotLen, slots: make([]windowSlot, size.slots)}
+	}
+	return w
+}
+
+func (w *windowedMetrics) record(now time.Time, duration time.Duration, err error) {
+	for i := range w.rings {
+		slot := w.rings[i].slot(now)
+		slot.invocations++
+		slot.totalDuration += duration
+		slot.latency.record(duration)
+		if err != nil {
+			slot.errors++
+			if errors.Is(err, context.DeadlineExceeded) {
+				slot.timeouts++
+			}
+		}
+	}
+}
+
+// slot returns the slot for now, clearing it if it last held an older slot
+func (r *windowRing) slot(now time.Time) *windowSlot {
+	index := now.UnixNano() / int64(r.slotLen)
+	slot := &r.slots[index%int64(len(r.slots))]
+	if slot.index != index {
+		*slot = windowSlot{index: index}
+	}
+	return slot
+}
+
+// summarize adds up the slots covering window from the finest ring that
+// reaches back far enough
+func (w *windowedMetrics) summarize(name string, now time.Time, window time.Duration) WindowMetrics {
+	ring := &w.rings[len(w.rings)-1]
+	for i := range w.rings {
+		if w.rings[i].slotLen*time.Duration(len(w.rings[i].slots)) >= window {
+			ring = &w.rings[i]
+			break
+		}
+	}
+
+	n := int64((window + ring.slotLen - 1) / ring.slotLen)
+	current := now.UnixNano() / int64(ring.slotLen)
+
+	var total windowSlot
+	for index := current - n + 1; index <= current; index++ {
+		slot := &ring.slots[index%int64(len(ring.slots))]
+		if slot.index != index {
+			continue
+		}
+		total.invocations += slot.invocations
+		total.errors += slot.errors
+		total.timeouts += slot.timeouts
+		total.totalDuration += slot.totalDuration
+		total.latency.merge(&slot.latency)
+	}
+
+	span := time.Duration(n) * ring.slotLen
+	m := WindowMetrics{
+		Name:           name,
+		WindowSecs:     int64(span / time.Second),
+		Invocations:    total.invocations,
+		Errors:         total.errors,
+		Timeouts:       total.timeouts,
+		InvocationRate: float64(total.invocations) / span.Seconds(),
+		Latency:        total.latency.stats(),
+	}
+	if total.invocations > 0 {
+		m.ErrorRate = float64(total.errors) / float64(total.invocations)
+		m.AverageDuration = total.totalDuration / time.Duration(total.invocations)
+	}
+	return m
+}
@@ -0,0 +1,93 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindowedMetrics(t *testing.T) {
+	w := newWindowedMetrics()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// An hour ago: an error; 3 minutes ago: two timeouts; now: 10 successes
+	w.record(now.Add(-59*time.Minute), time.Second, errors.New("boom"))
+	for range 2 {
+		w.record(now.Add(-3*time.Minute), 30*time.Second, fmt.Errorf("timed out: %w", context.DeadlineExceeded))
+	}
+	for range 10 {
+		w.record(now, 10*time.Millisecond, nil)
+	}
+
+	m := w.summarize("f", now, time.Minute)
+	assert.Equal(t, int64(60), m.WindowSecs)
+	assert.Equal(t, int64(10), m.Invocations)
+	assert.Zero(t, m.Errors)
+	assert.InDelta(t, 10.0/60, m.InvocationRate, 1e-9)
+	assert.Equal(t, 10*time.Millisecond, m.AverageDuration)
+	assert.Equal(t, 10*time.Millisecond, m.Latency.Max)
+
+	m = w.summarize("f", now, 5*time.Minute)
+	assert.Equal(t, int64(12), m.Invocations)
+	assert.Equal(t, int64(2), m.Errors)
+	assert.Equal(t, int64(2), m.Timeouts)
+	assert.InDelta(t, 2.0/12, m.ErrorRate, 1e-9)
+	assert.Equal(t, 30*time.Second, m.Latency.Max)
+
+	m = w.summarize("f", now, time.Hour)
+	assert.Equal(t, int64(13), m.Invocations)
+	assert.Equal(t, int64(3), m.Errors)
+
+	// A day later, only the newest hour slot remains
+	later := now.Add(24 * time.Hour)
+	w.record(later, time.Millisecond, nil)
+	m = w.summarize("f", later, 24*time.Hour)
+	assert.Equal(t, int64(86400), m.WindowSecs)
+	assert.Equal(t, int64(1), m.Invocations)
+	assert.Equal(t, int64(0), m.Errors)
+}
+
+func TestWindowedMetricsBounded(t *testing.T) {
+	w := newWindowedMetrics()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// A week of executions every 10 seconds reuses the same slots
+	for now := start; now.Before(start.Add(7 * 24 * time.Hour)); now = now.Add(10 * time.Second) {
+		w.record(now, time.Millisecond, nil)
+	}
+	slots := 0
+	for _, ring := range w.rings {
+		slots += len(ring.slots)
+	}
+	assert.Equal(t, 30+60+24, slots)
+
+	now := start.Add(7*24*time.Hour - 10*time.Second)
+	assert.Equal(t, int64(6), w.summarize("f", now, time.Minute).Invocations)
+	assert.Equal(t, int64(360), w.summarize("f", now, time.Hour).Invocations)
+}
+
+func TestMetricsCollectorReset(t *testing.T) {
+	collector := NewMetricsCollector()
+	collector.RecordExecution("a", time.Millisecond, nil)
+	collector.RecordExecution("b", time.Millisecond, nil)
+
+	m, ok := collector.GetFunctionWindowMetrics("a", 5*time.Minute)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), m.Invocations)
+	assert.Len(t, collector.GetWindowMetrics(time.Hour), 2)
+
+	assert.True(t, collector.Reset("a"))
+	assert.False(t, collector.Reset("a"))
+	_, ok = collector.GetFunctionMetrics("a")
+	assert.False(t, ok)
+	_, ok = collector.GetFunctionWindowMetrics("a", time.Minute)
+	assert.False(t, ok)
+
+	collector.ResetAll()
+	assert.Empty(t, collector.GetMetrics())
+	assert.Empty(t, collector.GetWindowMetrics(time.Hour))
+}
@@ -0,0 +1,53 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/health"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthCheckInterval is how often component health is re-evaluated for the
+// gRPC health service
+const healthCheckInterval = 10 * time.Second
+
+// healthMethodPrefix is the method prefix of the grpc.health.v1 service, which
+// is exempt from authentication and rate limiting like HTTP /health
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+// watchHealth keeps the serving status of the health server in sync with the
+// health checker until stop is closed. The overall status ("") and every
+// registered service share the same status.
+func watchHealth(checker *health.Checker, server *grpchealth.Server, services []string, stop <-chan struct{}) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		updateHealth(checker, server, services)
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func updateHealth(checker *health.Checker, server *grpchealth.Server, services []string) {
+	status := healthpb.HealthCheckResponse_SERVING
+	if !checker.Check(context.Background()).Ready() {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+
+	server.SetServingStatus("", status)
+	for _, service := range services {
+		server.SetServingStatus(service, status)
+	}
+}
+
+func isHealthMethod(method string) bool {
+	return strings.HasPrefix(method, healthMethodPrefix)
+}
@@ -0,0 +1,35 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/health"
+	"github.com/stretchr/testify/assert"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestUpdateHealth(t *testing.T) {
+	checker := health.NewChecker()
+	server := grpchealth.NewServer()
+	services := []string{"function.FunctionService"}
+
+	servingStatus := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		assert.NoError(t, err)
+		return resp.Status
+	}
+
+	// Non-critical failures keep the server serving
+	checker.Register("database", func(ctx context.Context) error { return errors.New("down") }, false)
+	updateHealth(checker, server, services)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus(""))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus("function.FunctionService"))
+
+	checker.Register("wasm_runtime", func(ctx context.Context) error { return errors.New("down") }, true)
+	updateHealth(checker, server, services)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus(""))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus("function.FunctionService"))
+}
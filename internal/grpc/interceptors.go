@@ -0,0 +1,405 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/mstgnz/self-hosted-serverless/internal/function"
+	"github.com/mstgnz/self-hosted-serverless/internal/logging"
+	"github.com/mstgnz/self-hosted-serverless/internal/metrics"
+	"github.com/mstgnz/self-hosted-serverless/internal/ratelimit"
+	"github.com/mstgnz/self-hosted-serverless/internal/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// serverOptions returns the interceptors applied to every RPC: panic recovery,
+// access logging, rate limiting and authentication, in that order.
+func (s *Service) serverOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(recoveryUnaryInterceptor, s.loggingUnaryInterceptor, s.admitUnaryInterceptor),
+		grpc.ChainStreamInterceptor(recoveryStreamInterceptor, s.loggingStreamInterceptor, s.admitStreamInterceptor),
+	}
+}
+
+// methodScopes maps RPC method names, in both API versions, to the scope they
+// require. Methods not listed, such as ListFunctions, only require a valid key.
+var methodScopes = map[string]string{
+	"ExecuteFunction":    auth.ScopeInvoke,
+	"StreamExecute":      auth.ScopeInvoke,
+	"BidiExecute":        auth.ScopeInvoke,
+	"DeployFunction":     auth.ScopeAdmin,
+	"DeleteFunction":     auth.ScopeAdmin,
+	"DescribeFunction":   auth.ScopeMetricsRead,
+	"PublishEvent":       auth.ScopeEventsPublish,
+	"SubscribeEvents":    auth.ScopeAdmin,
+	"GetMetrics":         auth.ScopeMetricsRead,
+	"GetFunctionMetrics": auth.ScopeMetricsRead,
+}
+
+// admit applies the same per-IP rate limit, API key, scope and key limit
+// checks as the HTTP server. Clients send the key as x-api-key or
+// "authorization: Bearer <key>" metadata, or connect over mutual TLS with a
+// client certificate. Health checks are always admitted so probes don't need a
+// key. The returned context carries the client identity when one is known,
+// and release must be called once the call has finished.
+func (s *Service) admit(ctx context.Context, method string) (_ context.Context, release func(), _ error) {
+	noop := func() {}
+	if isHealthMethod(method) {
+		return ctx, noop, nil
+	}
+	if ok, retryAfter := s.limiter.Check(peerIP(ctx)); !ok {
+		return nil, nil, limitStatus(ctx, &ratelimit.LimitError{Name: "ip", RetryAfter: retryAfter})
+	}
+
+	id, ok := s.peerCertIdentity(ctx)
+	if !ok {
+		if !s.auth.Enabled() {
+			return ctx, noop, nil
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		key := auth.KeyFromHeaders(firstValue(md, "x-api-key"), firstValue(md, "authorization"))
+		var err error
+		if id, err = s.auth.Authenticate(key); err != nil {
+			auditDenied(ctx, method, err.Error())
+			return nil, nil, status.Error(codes.Unauthenticated, "unauthorized")
+		}
+	}
+
+	setAccessIdentity(ctx, id)
+	ctx = auth.NewContext(ctx, id)
+	if scope := methodScopes[path.Base(method)]; !id.HasScope(scope) {
+		auditDenied(ctx, method, "missing scope "+scope)
+		return nil, nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	release = noop
+	if id.Limits != nil {
+		var err error
+		if release, err = s.limits.Acquire(ctx, "key:"+id.KeyID, *id.Limits); err != nil {
+			return nil, nil, limitStatus(ctx, err)
+		}
+	}
+	return ctx, release, nil
+}
+
+// limitStatus converts an error from a limit into a ResourceExhausted status,
+// sending the retry delay in seconds as retry-after header metadata. Other
+// errors are returned as they are.
+func limitStatus(ctx context.Context, err error) error {
+	var limitErr *ratelimit.LimitError
+	if !errors.As(err, &limitErr) {
+		return err
+	}
+	// Setting the header fails outside of a real call, which is harmless
+	grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(limitErr.RetryAfterSecs())))
+	return status.Error(codes.ResourceExhausted, "too many requests")
+}
+
+// authorizeFunction checks that the client may invoke the named function
+func authorizeFunction(ctx context.Context, name string) error {
+	if id, ok := auth.FromContext(ctx); ok && !id.CanInvoke(name) {
+		method, _ := grpc.Method(ctx)
+		auditDenied(ctx, method, "not allowed to invoke function "+name)
+		return status.Errorf(codes.PermissionDenied, "not allowed to invoke function %s", name)
+	}
+	return nil
+}
+
+// withCaller adds the client identity and IP, if known, to a function's input.
+// Values the client sent under those keys are dropped so they can't be forged.
+func withCaller(ctx context.Context, input map[string]any) map[string]any {
+	delete(input, "identity")
+	delete(input, "client_ip")
+	if id, ok := auth.FromContext(ctx); ok {
+		input["identity"] = id.Map()
+	}
+	if ip := peerIP(ctx); ip != "" {
+		input["client_ip"] = ip
+	}
+	return input
+}
+
+// execOptions describes a call's execution for the invocation history and
+// tracing
+func execOptions(ctx context.Context) []function.ExecOption {
+	caller := peerIP(ctx)
+	id, ok := auth.FromContext(ctx)
+	if ok && id.Subject != "" {
+		caller = id.Subject
+	}
+	opts := []function.ExecOption{
+		function.WithContext(ctx),
+		function.WithTrigger(function.TriggerGRPC),
+		function.WithCaller(caller),
+	}
+	if ok && id.KeyID != "" {
+		opts = append(opts, function.WithKeyID(id.KeyID))
+	}
+	return opts
+}
+
+// peerCertIdentity returns the identity of the client certificate presented
+// on the connection, if it has a mapping
+func (s *Service) peerCertIdentity(ctx context.Context) (auth.Identity, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return auth.Identity{}, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return auth.Identity{}, false
+	}
+	return s.auth.AuthenticateCert(&info.State)
+}
+
+func (s *Service) admitUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, release, err := s.admit(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return handler(ctx, req)
+}
+
+func (s *Service) admitStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, release, err := s.admit(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// contextStream overrides the context of a server stream
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// recoveryUnaryInterceptor converts a panic in a handler into an Internal error
+func recoveryUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("Panic in gRPC method %s: %v", info.FullMethod, rec)
+			err = status.Error(codes.Internal, "internal error")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// recoveryStreamInterceptor converts a panic in a handler into an Internal error
+func recoveryStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("Panic in gRPC method %s: %v", info.FullMethod, rec)
+			err = status.Error(codes.Internal, "internal error")
+		}
+	}()
+	return handler(srv, ss)
+}
+
+// loggingUnaryInterceptor resolves the client IP and request ID and writes an
+// access log line for every unary call
+func (s *Service) loggingUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	ctx, entry := withRequestID(s.withClientIP(ctx))
+	// Setting the header fails outside of a real call, which is harmless
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, logging.RequestIDFromContext(ctx)))
+	ctx, span := startSpan(ctx, info.FullMethod)
+	defer span.End()
+	resp, err := handler(ctx, req)
+	traceCall(ctx, span, err)
+	logAccess(ctx, entry, info.FullMethod, err, time.Since(start))
+	return resp, err
+}
+
+// loggingStreamInterceptor resolves the client IP and request ID and writes an
+// access log line for every stream once it ends
+func (s *Service) loggingStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, entry := withRequestID(s.withClientIP(ss.Context()))
+	ss.SetHeader(metadata.Pairs(requestIDKey, logging.RequestIDFromContext(ctx)))
+	ctx, span := startSpan(ctx, info.FullMethod)
+	defer span.End()
+	err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	traceCall(ctx, span, err)
+	logAccess(ctx, entry, info.FullMethod, err, time.Since(start))
+	return err
+}
+
+// requestIDKey is the metadata key of the request ID, sent back to clients
+// as header metadata
+var requestIDKey = strings.ToLower(logging.RequestIDHeader)
+
+type accessEntryKey struct{}
+
+// accessEntry collects what the access log line of a call reports but only
+// inner interceptors learn, such as who the client is
+type accessEntry struct {
+	identity      auth.Identity
+	authenticated bool
+}
+
+// withRequestID adds the call's request ID to ctx, taken from its
+// x-request-id metadata if the client sent a valid one, along with the entry
+// of its access log line
+func withRequestID(ctx context.Context) (context.Context, *accessEntry) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = logging.ContextWithRequestID(ctx, logging.RequestID(firstValue(md, requestIDKey)))
+	entry := &accessEntry{}
+	return context.WithValue(ctx, accessEntryKey{}, entry), entry
+}
+
+// setAccessIdentity records the client identity for the access log
+func setAccessIdentity(ctx context.Context, id auth.Identity) {
+	if entry, ok := ctx.Value(accessEntryKey{}).(*accessEntry); ok {
+		entry.identity = id
+		entry.authenticated = true
+	}
+}
+
+// audit records an event in the audit log, attributed to the client of the
+// call
+func audit(ctx context.Context, event logging.AuditEvent) {
+	if id, ok := auth.FromContext(ctx); ok {
+		event.Actor = id.Subject
+		event.KeyID = id.KeyID
+	}
+	event.ClientIP = peerIP(ctx)
+	event.Protocol = "grpc"
+	logging.Audit(ctx, event)
+}
+
+// auditDenied records a call rejected by authentication or authorization
+func auditDenied(ctx context.Context, method, reason string) {
+	audit(ctx, logging.AuditEvent{
+		Action:  logging.ActionAuthFailure,
+		Outcome: logging.OutcomeDenied,
+		Target:  method,
+		Reason:  reason,
+	})
+}
+
+// auditResult records an admin action that succeeded, or failed with err
+func auditResult(ctx context.Context, action, target string, err error) {
+	event := logging.AuditEvent{Action: action, Outcome: logging.OutcomeSuccess, Target: target}
+	if err != nil {
+		event.Outcome = logging.OutcomeFailure
+		event.Reason = err.Error()
+	}
+	audit(ctx, event)
+}
+
+// startSpan starts the span of a call as a continuation of the trace in its
+// traceparent metadata, if any
+func startSpan(ctx context.Context, method string) (context.Context, *tracing.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = tracing.Extract(ctx, func(key string) string { return firstValue(md, key) })
+	ctx, span := tracing.Start(ctx, strings.TrimPrefix(method, "/"), tracing.SpanKindServer)
+	span.SetAttribute("rpc.system", "grpc")
+	span.SetAttribute("rpc.service", path.Dir(strings.TrimPrefix(method, "/")))
+	span.SetAttribute("rpc.method", path.Base(method))
+	return ctx, span
+}
+
+// traceCall records the outcome of a call in its span
+func traceCall(ctx context.Context, span *tracing.Span, err error) {
+	code := status.Code(err)
+	span.SetAttribute("rpc.grpc.status_code", int(code))
+	span.SetAttribute("client.address", peerIP(ctx))
+	if code != codes.OK {
+		span.SetError(err)
+	}
+}
+
+var (
+	grpcRequestsTotal = metrics.GetGlobalRegistry().NewCounterVec("serverless_grpc_requests_total",
+		"gRPC calls by method and status code.", "method", "code")
+	grpcRequestDuration = metrics.GetGlobalRegistry().NewHistogramVec("serverless_grpc_request_duration_seconds",
+		"gRPC call latency in seconds, until the stream ends for streaming calls.", nil, "method")
+)
+
+// logAccess writes an access log line and records the call in the request
+// metrics
+func logAccess(ctx context.Context, entry *accessEntry, method string, err error, duration time.Duration) {
+	code := status.Code(err)
+	grpcRequestsTotal.Inc(method, code.String())
+	grpcRequestDuration.Observe(duration.Seconds(), method)
+
+	attrs := []slog.Attr{
+		slog.String("protocol", "grpc"),
+		slog.String("method", method),
+		slog.String("code", code.String()),
+		slog.Float64("duration_ms", float64(duration.Microseconds())/1000),
+		slog.String("client_ip", peerIP(ctx)),
+	}
+	if entry.authenticated {
+		attrs = append(attrs, slog.String("subject", entry.identity.Subject))
+		if entry.identity.KeyID != "" {
+			attrs = append(attrs, slog.String("key_id", entry.identity.KeyID))
+		}
+	}
+
+	level := slog.LevelInfo
+	switch code {
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable:
+		level = slog.LevelError
+	}
+	slog.LogAttrs(ctx, level, "request", attrs...)
+}
+
+type clientIPKey struct{}
+
+// withClientIP resolves the IP address of the client once per call, honouring
+// the forwarding metadata of trusted proxies, so rate limiting, logs and
+// functions all see the same address
+func (s *Service) withClientIP(ctx context.Context) context.Context {
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		addr = p.Addr.String()
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	ip := s.clientIP.Resolve(addr, md.Get(s.clientIP.Header()))
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// peerIP returns the IP address of the client that made the call
+func peerIP(ctx context.Context) string {
+	if ip, ok := ctx.Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
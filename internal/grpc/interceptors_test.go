@@ -0,0 +1,230 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/mstgnz/self-hosted-serverless/internal/clientip"
+	"github.com/mstgnz/self-hosted-serverless/internal/ratelimit"
+	"github.com/mstgnz/self-hosted-serverless/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func incomingContext(ip string, kv ...string) context.Context {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 12345},
+	})
+	return metadata.NewIncomingContext(ctx, metadata.Pairs(kv...))
+}
+
+func TestAdmitUnaryInterceptor(t *testing.T) {
+	service := setupTestService()
+	service.auth = auth.NewAuthenticator("secret", nil)
+	service.limiter = ratelimit.NewLimiter(2, time.Minute)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/function.FunctionService/ListFunctions"}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return "ok", nil
+	}
+
+	// Missing key
+	_, err := service.admitUnaryInterceptor(incomingContext("10.0.0.1"), nil, info, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Key sent as a Bearer token
+	resp, err := service.admitUnaryInterceptor(incomingContext("10.0.0.1", "authorization", "Bearer secret"), nil, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+
+	// Rate limit is per client IP
+	_, err = service.admitUnaryInterceptor(incomingContext("10.0.0.1", "x-api-key", "secret"), nil, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	_, err = service.admitUnaryInterceptor(incomingContext("10.0.0.2", "x-api-key", "secret"), nil, info, handler)
+	assert.NoError(t, err)
+
+	// Health checks need neither a key nor rate limit budget
+	healthInfo := &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}
+	_, err = service.admitUnaryInterceptor(incomingContext("10.0.0.1"), nil, healthInfo, handler)
+	assert.NoError(t, err)
+}
+
+func TestAdmitClientCert(t *testing.T) {
+	service := setupTestService()
+	service.auth = auth.NewAuthenticator("secret", nil).WithClientCerts([]auth.CertIdentity{
+		{DNSName: "billing.internal", Scopes: []string{auth.ScopeMetricsRead}},
+	})
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/function.FunctionService/ListFunctions"}
+	handler := func(ctx context.Context, req any) (any, error) {
+		id, _ := auth.FromContext(ctx)
+		return id, nil
+	}
+
+	// A verified client certificate with a mapping replaces the key and
+	// identifies the caller
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "billing-service"}, DNSNames: []string{"billing.internal"}}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 12345},
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{cert},
+		}},
+	})
+	resp, err := service.admitUnaryInterceptor(ctx, nil, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, auth.Identity{Subject: "billing-service", Method: auth.MethodClientCert, Scopes: []string{auth.ScopeMetricsRead}}, resp)
+
+	// The mapping's scopes apply
+	deployInfo := &grpc.UnaryServerInfo{FullMethod: "/function.v2.FunctionService/DeployFunction"}
+	_, err = service.admitUnaryInterceptor(ctx, nil, deployInfo, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// A certificate without a mapping doesn't authenticate the caller
+	cert.DNSNames = []string{"other.internal"}
+	_, err = service.admitUnaryInterceptor(ctx, nil, info, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestAdmitScopes(t *testing.T) {
+	service := setupTestService()
+	store, err := auth.NewFileKeyStore(filepath.Join(t.TempDir(), "keys.json"))
+	assert.NoError(t, err)
+	service.auth = auth.NewAuthenticator("", store)
+
+	_, secret, err := service.auth.CreateKey(auth.Key{
+		Name:      "ci",
+		Scopes:    []string{auth.ScopeInvoke},
+		Functions: []string{"test-function"},
+	})
+	assert.NoError(t, err)
+
+	// The handler checks the function allowlist like the execute RPCs do
+	handler := func(ctx context.Context, req any) (any, error) {
+		if name := req.(string); name != "" {
+			return nil, authorizeFunction(ctx, name)
+		}
+		return nil, nil
+	}
+	call := func(method, function string) error {
+		info := &grpc.UnaryServerInfo{FullMethod: method}
+		_, err := service.admitUnaryInterceptor(incomingContext("10.0.0.1", "x-api-key", secret), function, info, handler)
+		return err
+	}
+
+	assert.NoError(t, call("/function.v2.FunctionService/ExecuteFunction", "test-function"))
+	assert.NoError(t, call("/function.v2.FunctionService/ListFunctions", ""))
+
+	// Functions outside the key's list and methods needing other scopes are denied
+	assert.Equal(t, codes.PermissionDenied, status.Code(call("/function.v2.FunctionService/ExecuteFunction", "other")))
+	assert.Equal(t, codes.PermissionDenied, status.Code(call("/function.v2.FunctionService/GetMetrics", "")))
+	assert.Equal(t, codes.PermissionDenied, status.Code(call("/function.FunctionService/ExecuteFunction", "other")))
+}
+
+func TestRecoveryUnaryInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/function.FunctionService/ExecuteFunction"}
+	handler := func(ctx context.Context, req any) (any, error) {
+		panic("boom")
+	}
+
+	_, err := recoveryUnaryInterceptor(context.Background(), nil, info, handler)
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestAdmitKeyLimits(t *testing.T) {
+	service := setupTestService()
+	service.limits = ratelimit.NewManager()
+	store, err := auth.NewFileKeyStore(filepath.Join(t.TempDir(), "keys.json"))
+	assert.NoError(t, err)
+	service.auth = auth.NewAuthenticator("", store)
+
+	_, secret, err := service.auth.CreateKey(auth.Key{
+		Name:   "limited",
+		Scopes: []string{auth.ScopeInvoke},
+		Limits: &ratelimit.Limits{Concurrency: 1},
+	})
+	assert.NoError(t, err)
+
+	// A second call is rejected while the first is still running
+	info := &grpc.UnaryServerInfo{FullMethod: "/function.v2.FunctionService/ListFunctions"}
+	handler := func(ctx context.Context, req any) (any, error) {
+		_, err := service.admitUnaryInterceptor(incomingContext("10.0.0.1", "x-api-key", secret), nil, info,
+			func(ctx context.Context, req any) (any, error) { return nil, nil })
+		return nil, err
+	}
+	_, err = service.admitUnaryInterceptor(incomingContext("10.0.0.1", "x-api-key", secret), nil, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// The slot is released once the call returns
+	_, err = service.admitUnaryInterceptor(incomingContext("10.0.0.1", "x-api-key", secret), nil, info,
+		func(ctx context.Context, req any) (any, error) { return nil, nil })
+	assert.NoError(t, err)
+}
+
+func TestLoggingInterceptorClientIP(t *testing.T) {
+	service := setupTestService()
+	info := &grpc.UnaryServerInfo{FullMethod: "/function.v2.FunctionService/ExecuteFunction"}
+	var seen string
+	handler := func(ctx context.Context, req any) (any, error) {
+		seen = withCaller(ctx, map[string]any{})["client_ip"].(string)
+		return nil, nil
+	}
+
+	// Forwarding metadata is ignored unless the peer is a trusted proxy
+	ctx := incomingContext("10.0.0.1", "x-forwarded-for", "198.51.100.1")
+	_, err := service.loggingUnaryInterceptor(ctx, nil, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", seen)
+
+	service.clientIP, err = clientip.NewResolver([]string{"10.0.0.0/8"}, "")
+	assert.NoError(t, err)
+	_, err = service.loggingUnaryInterceptor(ctx, nil, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "198.51.100.1", seen)
+}
+
+func TestLoggingInterceptorTracing(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := tracing.NewTracer(tracing.NewWriterExporter(&buf), tracing.Config{SampleRatio: 1})
+	tracing.SetGlobalTracer(tracer)
+	defer tracing.SetGlobalTracer(nil)
+
+	service := setupTestService()
+	info := &grpc.UnaryServerInfo{FullMethod: "/function.v2.FunctionService/ExecuteFunction"}
+	var handlerTrace tracing.SpanContext
+	handler := func(ctx context.Context, req any) (any, error) {
+		handlerTrace = tracing.SpanContextFromContext(ctx)
+		return nil, status.Error(codes.NotFound, "function not found")
+	}
+
+	ctx := incomingContext("10.0.0.1", "traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, err := service.loggingUnaryInterceptor(ctx, nil, info, handler)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.NoError(t, tracer.Close())
+
+	var span map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &span))
+	assert.Equal(t, "function.v2.FunctionService/ExecuteFunction", span["name"])
+	assert.Equal(t, "server", span["kind"])
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span["trace_id"])
+	assert.Equal(t, "00f067aa0ba902b7", span["parent_span_id"])
+	assert.Equal(t, handlerTrace.SpanID.String(), span["span_id"])
+	assert.Contains(t, span["error"], "function not found")
+	attributes := span["attributes"].(map[string]any)
+	assert.Equal(t, "function.v2.FunctionService", attributes["rpc.service"])
+	assert.Equal(t, "ExecuteFunction", attributes["rpc.method"])
+	assert.Equal(t, float64(codes.NotFound), attributes["rpc.grpc.status_code"])
+}
@@ -0,0 +1,144 @@
+package grpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	pbv2 "github.com/mstgnz/self-hosted-serverless/internal/grpc/proto/v2"
+	"github.com/mstgnz/self-hosted-serverless/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMain(m *testing.M) {
+	// Keep the tests from writing an audit log next to the package
+	logging.SetGlobalAuditLogger(nil)
+	os.Exit(m.Run())
+}
+
+// captureAudit makes the global audit log write to the returned buffer until
+// the test ends
+func captureAudit(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	logging.SetGlobalAuditLogger(logging.NewAuditLogger(&buf))
+	t.Cleanup(func() { logging.SetGlobalAuditLogger(nil) })
+	return &buf
+}
+
+func decodeAudit(t *testing.T, buf *bytes.Buffer) []logging.AuditEvent {
+	var events []logging.AuditEvent
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var event logging.AuditEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestLoggingInterceptorAccessLog(t *testing.T) {
+	prev, prevWriter, prevFlags := slog.Default(), log.Writer(), log.Flags()
+	defer func() {
+		slog.SetDefault(prev)
+		log.SetOutput(prevWriter)
+		log.SetFlags(prevFlags)
+	}()
+	var buf bytes.Buffer
+	slog.SetDefault(logging.NewLogger(&buf, logging.DefaultConfig()))
+
+	service := setupTestService()
+	service.auth = auth.NewAuthenticator("secret", nil)
+	info := &grpc.UnaryServerInfo{FullMethod: "/function.FunctionService/ListFunctions"}
+	var requestID string
+	handler := func(ctx context.Context, req any) (any, error) {
+		return service.admitUnaryInterceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+			requestID = logging.RequestIDFromContext(ctx)
+			return "ok", nil
+		})
+	}
+
+	ctx := incomingContext("10.0.0.1", "x-api-key", "secret", "x-request-id", "client-id-1")
+	_, err := service.loggingUnaryInterceptor(ctx, nil, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "client-id-1", requestID)
+
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "request", line["msg"])
+	assert.Equal(t, "grpc", line["protocol"])
+	assert.Equal(t, "/function.FunctionService/ListFunctions", line["method"])
+	assert.Equal(t, "OK", line["code"])
+	assert.Equal(t, "10.0.0.1", line["client_ip"])
+	assert.Equal(t, "client-id-1", line["request_id"])
+	assert.Equal(t, "default", line["subject"])
+
+	// Calls without a valid request ID get a new one
+	buf.Reset()
+	ctx = incomingContext("10.0.0.1", "x-api-key", "secret", "x-request-id", "has space")
+	_, err = service.loggingUnaryInterceptor(ctx, nil, info, handler)
+	assert.NoError(t, err)
+	assert.Len(t, requestID, 32)
+}
+
+func TestAuditAuthFailure(t *testing.T) {
+	audit := captureAudit(t)
+	service := setupTestService()
+	store, err := auth.NewFileKeyStore(t.TempDir() + "/keys.json")
+	require.NoError(t, err)
+	service.auth = auth.NewAuthenticator("", store)
+	_, secret, err := service.auth.CreateKey(auth.Key{Name: "reader", Scopes: []string{auth.ScopeMetricsRead}})
+	require.NoError(t, err)
+
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+	info := &grpc.UnaryServerInfo{FullMethod: "/function.v2.FunctionService/DeployFunction"}
+
+	_, err = service.admitUnaryInterceptor(incomingContext("10.0.0.1", "x-api-key", "wrong"), nil, info, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = service.admitUnaryInterceptor(incomingContext("10.0.0.1", "x-api-key", secret), nil, info, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	events := decodeAudit(t, audit)
+	require.Len(t, events, 2)
+	for _, event := range events {
+		assert.Equal(t, logging.ActionAuthFailure, event.Action)
+		assert.Equal(t, logging.OutcomeDenied, event.Outcome)
+		assert.Equal(t, "/function.v2.FunctionService/DeployFunction", event.Target)
+		assert.Equal(t, "10.0.0.1", event.ClientIP)
+		assert.Equal(t, "grpc", event.Protocol)
+	}
+	assert.Empty(t, events[0].Actor)
+	assert.Equal(t, "reader", events[1].Actor)
+	assert.Equal(t, "missing scope admin", events[1].Reason)
+}
+
+func TestAuditDeploy(t *testing.T) {
+	audit := captureAudit(t)
+	service := setupTestServiceV2()
+	ctx := auth.NewContext(context.Background(), auth.Identity{Subject: "ci", KeyID: "key-1"})
+
+	_, err := service.DeployFunction(ctx, &pbv2.DeployFunctionRequest{Name: "hello", Runtime: "wasm", Code: []byte("not wasm")})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = service.DeleteFunction(ctx, &pbv2.DeleteFunctionRequest{Name: "test-function"})
+	assert.NoError(t, err)
+
+	events := decodeAudit(t, audit)
+	require.Len(t, events, 2)
+	assert.Equal(t, logging.ActionDeploy, events[0].Action)
+	assert.Equal(t, logging.OutcomeFailure, events[0].Outcome)
+	assert.Equal(t, "hello", events[0].Target)
+	assert.Equal(t, "ci", events[0].Actor)
+	assert.Equal(t, "key-1", events[0].KeyID)
+	assert.NotEmpty(t, events[0].Reason)
+	assert.Equal(t, logging.ActionDelete, events[1].Action)
+	assert.Equal(t, logging.OutcomeSuccess, events[1].Outcome)
+}
@@ -0,0 +1,195 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/common"
+	"github.com/mstgnz/self-hosted-serverless/internal/event"
+	"github.com/mstgnz/self-hosted-serverless/internal/function"
+	pbv2 "github.com/mstgnz/self-hosted-serverless/internal/grpc/proto/v2"
+	"github.com/mstgnz/self-hosted-serverless/internal/logging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// subscriptionBuffer is the number of events buffered per subscriber before
+// publishing to it fails
+const subscriptionBuffer = 64
+
+// DeployFunction deploys a function, replacing any existing function with the same name
+func (s *ServiceV2) DeployFunction(ctx context.Context, req *pbv2.DeployFunctionRequest) (*pbv2.FunctionInfo, error) {
+	info := common.FunctionInfo{
+		Name:        req.GetName(),
+		Description: req.GetDescription(),
+		Runtime:     req.GetRuntime(),
+	}
+	if len(req.GetCode()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "code is required")
+	}
+
+	err := s.registry.Deploy(info, req.GetCode())
+	auditResult(ctx, logging.ActionDeploy, info.Name, err)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to deploy function: %v", err)
+	}
+
+	deployed, _ := s.registry.GetFunctionInfo(info.Name)
+	return functionInfoToProto(deployed), nil
+}
+
+// DeleteFunction removes a function
+func (s *ServiceV2) DeleteFunction(ctx context.Context, req *pbv2.DeleteFunctionRequest) (*pbv2.DeleteFunctionResponse, error) {
+	err := s.registry.Delete(req.GetName())
+	auditResult(ctx, logging.ActionDelete, req.GetName(), err)
+	if errors.Is(err, function.ErrFunctionNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pbv2.DeleteFunctionResponse{}, nil
+}
+
+// DescribeFunction returns a function's metadata and metrics
+func (s *ServiceV2) DescribeFunction(ctx context.Context, req *pbv2.DescribeFunctionRequest) (*pbv2.DescribeFunctionResponse, error) {
+	info, exists := s.registry.GetFunctionInfo(req.GetName())
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "function %s not found", req.GetName())
+	}
+
+	response := &pbv2.DescribeFunctionResponse{
+		Info: functionInfoToProto(info),
+	}
+	if metrics, ok := s.registry.GetFunctionMetrics(info.Name); ok {
+		response.Metrics = metricsToProto(metrics)
+	}
+	return response, nil
+}
+
+// PublishEvent publishes an event on the event bus
+func (s *ServiceV2) PublishEvent(ctx context.Context, req *pbv2.PublishEventRequest) (*pbv2.PublishEventResponse, error) {
+	evt := req.GetEvent()
+	if evt.GetType() == "" {
+		return nil, status.Error(codes.InvalidArgument, "event type is required")
+	}
+
+	var payload map[string]any
+	if evt.GetPayload() != nil {
+		payload = evt.GetPayload().AsMap()
+	}
+
+	errs := s.eventBus.Publish(ctx, event.Event{
+		Type:    evt.GetType(),
+		Payload: payload,
+	})
+
+	response := &pbv2.PublishEventResponse{}
+	for _, err := range errs {
+		response.Errors = append(response.Errors, err.Error())
+	}
+	return response, nil
+}
+
+// SubscribeEvents streams events of the requested types until the client
+// cancels the call
+func (s *ServiceV2) SubscribeEvents(req *pbv2.SubscribeEventsRequest, stream pbv2.FunctionService_SubscribeEventsServer) error {
+	if len(req.GetTypes()) == 0 {
+		return status.Error(codes.InvalidArgument, "at least one event type is required")
+	}
+
+	events := make(chan event.Event, subscriptionBuffer)
+	for _, eventType := range req.GetTypes() {
+		cancel := s.eventBus.Subscribe(eventType, func(ctx context.Context, evt event.Event) error {
+			select {
+			case events <- evt:
+				return nil
+			default:
+				return errors.New("gRPC event subscriber is not keeping up")
+			}
+		})
+		defer cancel()
+	}
+
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case evt := <-events:
+			msg, err := eventToProto(evt)
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// GetMetrics returns metrics for all functions
+func (s *ServiceV2) GetMetrics(ctx context.Context, req *pbv2.GetMetricsRequest) (*pbv2.GetMetricsResponse, error) {
+	metrics := s.registry.GetMetrics()
+
+	response := &pbv2.GetMetricsResponse{
+		Metrics: make(map[string]*pbv2.FunctionMetrics, len(metrics)),
+	}
+	for name, m := range metrics {
+		response.Metrics[name] = metricsToProto(m)
+	}
+	return response, nil
+}
+
+// GetFunctionMetrics returns metrics for a single function
+func (s *ServiceV2) GetFunctionMetrics(ctx context.Context, req *pbv2.GetFunctionMetricsRequest) (*pbv2.FunctionMetrics, error) {
+	metrics, exists := s.registry.GetFunctionMetrics(req.GetName())
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "function %s not found", req.GetName())
+	}
+	return metricsToProto(metrics), nil
+}
+
+func functionInfoToProto(info common.FunctionInfo) *pbv2.FunctionInfo {
+	return &pbv2.FunctionInfo{
+		Name:        info.Name,
+		Description: info.Description,
+		Runtime:     info.Runtime,
+		HttpMode:    info.HTTPMode,
+	}
+}
+
+func metricsToProto(m function.FunctionMetrics) *pbv2.FunctionMetrics {
+	return &pbv2.FunctionMetrics{
+		Name:                m.Name,
+		ExecutionCount:      m.ExecutionCount,
+		AverageDuration:     durationpb.New(m.AverageDuration),
+		ErrorCount:          m.ErrorCount,
+		LastExecutionTime:   timestamppb.New(m.LastExecutionTime),
+		ColdStartCount:      m.ColdStartCount,
+		AvgColdStartLatency: durationpb.New(m.AvgColdStartLatency),
+	}
+}
+
+func eventToProto(evt event.Event) (*pbv2.Event, error) {
+	msg := &pbv2.Event{Type: evt.Type}
+	if evt.Payload == nil {
+		return msg, nil
+	}
+
+	// Payloads may hold arbitrary Go values, so normalize them through JSON first.
+	value, _, err := encodeResultV2(evt.Payload, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert event payload: %w", err)
+	}
+	payload, ok := value.GetKind().(*structpb.Value_StructValue)
+	if !ok {
+		return nil, errors.New("failed to convert event payload: not an object")
+	}
+	msg.Payload = payload.StructValue
+	return msg, nil
+}
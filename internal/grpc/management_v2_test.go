@@ -0,0 +1,127 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/event"
+	pbv2 "github.com/mstgnz/self-hosted-serverless/internal/grpc/proto/v2"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// mockEventStream is a minimal server stream for testing SubscribeEvents
+type mockEventStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	events chan *pbv2.Event
+}
+
+func (m *mockEventStream) Context() context.Context {
+	return m.ctx
+}
+
+func (m *mockEventStream) Send(evt *pbv2.Event) error {
+	select {
+	case m.events <- evt:
+		return nil
+	case <-m.ctx.Done():
+		return m.ctx.Err()
+	}
+}
+
+func TestDeployAndDeleteFunctionV2(t *testing.T) {
+	service := setupTestServiceV2()
+	ctx := context.Background()
+
+	_, err := service.DeployFunction(ctx, &pbv2.DeployFunctionRequest{Name: "hello", Runtime: "wasm"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = service.DeployFunction(ctx, &pbv2.DeployFunctionRequest{
+		Name:    "hello",
+		Runtime: "wasm",
+		Code:    []byte("not wasm"),
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = service.DeleteFunction(ctx, &pbv2.DeleteFunctionRequest{Name: "non-existent"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = service.DeleteFunction(ctx, &pbv2.DeleteFunctionRequest{Name: "test-function"})
+	assert.NoError(t, err)
+	_, exists := service.registry.GetFunctionInfo("test-function")
+	assert.False(t, exists)
+}
+
+func TestDescribeFunctionAndMetricsV2(t *testing.T) {
+	service := setupTestServiceV2()
+	ctx := context.Background()
+
+	_, err := service.ExecuteFunction(ctx, &pbv2.ExecuteFunctionRequest{Name: "test-function"})
+	assert.NoError(t, err)
+
+	resp, err := service.DescribeFunction(ctx, &pbv2.DescribeFunctionRequest{Name: "test-function"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Test function", resp.Info.Description)
+	assert.Equal(t, int64(1), resp.Metrics.ExecutionCount)
+
+	_, err = service.DescribeFunction(ctx, &pbv2.DescribeFunctionRequest{Name: "non-existent"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	all, err := service.GetMetrics(ctx, &pbv2.GetMetricsRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), all.Metrics["test-function"].ExecutionCount)
+
+	single, err := service.GetFunctionMetrics(ctx, &pbv2.GetFunctionMetricsRequest{Name: "test-function"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), single.ErrorCount)
+
+	_, err = service.GetFunctionMetrics(ctx, &pbv2.GetFunctionMetricsRequest{Name: "non-existent"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestPublishAndSubscribeEventsV2(t *testing.T) {
+	service := setupTestServiceV2()
+	service.eventBus = event.NewBus()
+
+	// At least one event type is required
+	err := service.SubscribeEvents(&pbv2.SubscribeEventsRequest{}, &mockEventStream{ctx: context.Background()})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &mockEventStream{ctx: ctx, events: make(chan *pbv2.Event, 1)}
+	done := make(chan error, 1)
+	go func() {
+		done <- service.SubscribeEvents(&pbv2.SubscribeEventsRequest{Types: []string{"user.created"}}, stream)
+	}()
+
+	payload, err := structpb.NewStruct(map[string]interface{}{"id": "42"})
+	assert.NoError(t, err)
+
+	// Publish until the subscription is registered and the event arrives
+	var received *pbv2.Event
+	for received == nil {
+		resp, err := service.PublishEvent(context.Background(), &pbv2.PublishEventRequest{
+			Event: &pbv2.Event{Type: "user.created", Payload: payload},
+		})
+		assert.NoError(t, err)
+		assert.Empty(t, resp.Errors)
+
+		select {
+		case received = <-stream.events:
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	assert.Equal(t, "user.created", received.Type)
+	assert.Equal(t, "42", received.Payload.AsMap()["id"])
+
+	cancel()
+	<-done
+
+	_, err = service.PublishEvent(context.Background(), &pbv2.PublishEventRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -2,13 +2,24 @@ package grpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"os"
 
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/mstgnz/self-hosted-serverless/internal/clientip"
 	"github.com/mstgnz/self-hosted-serverless/internal/function"
 	pb "github.com/mstgnz/self-hosted-serverless/internal/grpc/proto"
+	pbv2 "github.com/mstgnz/self-hosted-serverless/internal/grpc/proto/v2"
+	"github.com/mstgnz/self-hosted-serverless/internal/health"
+	"github.com/mstgnz/self-hosted-serverless/internal/ratelimit"
+	"github.com/mstgnz/self-hosted-serverless/internal/tlsconfig"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
@@ -17,32 +28,86 @@ type Service struct {
 	pb.UnimplementedFunctionServiceServer
 	server   *grpc.Server
 	registry *function.Registry
+	auth     *auth.Authenticator
+	limiter  ratelimit.RateLimiter
+	limits   *ratelimit.Manager
+	clientIP *clientip.Resolver
+	health   *grpchealth.Server
+	stop     chan struct{}
 }
 
-// NewService creates a new gRPC service
+// NewService creates a new gRPC service. It enforces the same API_KEY and
+// RATE_LIMIT_PER_MIN settings as the HTTP server and serves TLS with the same
+// certificates.
 func NewService(registry *function.Registry) *Service {
+	authenticator, err := auth.NewAuthenticatorFromEnv()
+	if err != nil {
+		log.Printf("Warning: Failed to load API keys: %v\n", err)
+		authenticator = auth.NewAuthenticator(os.Getenv("API_KEY"), nil)
+	}
+
+	resolver, err := clientip.ResolverFromEnv()
+	if err != nil {
+		log.Printf("Warning: Failed to parse TRUSTED_PROXIES or TRUSTED_PROXY_HEADER, ignoring forwarding metadata: %v\n", err)
+		resolver, _ = clientip.NewResolver(nil, "")
+	}
+
 	return &Service{
 		registry: registry,
+		auth:     authenticator,
+		limiter:  ratelimit.NewRateLimiterFromEnv("grpc"),
+		limits:   ratelimit.GetGlobalManager(),
+		clientIP: resolver,
 	}
 }
 
 // Start starts the gRPC server
 func (s *Service) Start(port int) error {
+	opts := s.serverOptions()
+
+	tlsCfg, err := tlsconfig.ConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	if tlsCfg.Enabled() {
+		reloader, err := tlsconfig.NewReloader(tlsCfg)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(reloader.TLSConfig())))
+	}
+
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return fmt.Errorf("failed to listen: %w", err)
 	}
 
-	s.server = grpc.NewServer()
+	s.server = grpc.NewServer(opts...)
 	pb.RegisterFunctionServiceServer(s.server, s)
+	pbv2.RegisterFunctionServiceServer(s.server, NewServiceV2(s.registry))
+
+	var services []string
+	for name := range s.server.GetServiceInfo() {
+		services = append(services, name)
+	}
+	s.health = grpchealth.NewServer()
+	s.stop = make(chan struct{})
+	healthpb.RegisterHealthServer(s.server, s.health)
+	go watchHealth(health.GetGlobalChecker(), s.health, services, s.stop)
+
 	reflection.Register(s.server)
 
 	log.Printf("Starting gRPC server on port %d...\n", port)
 	return s.server.Serve(lis)
 }
 
-// Stop stops the gRPC server
+// Stop stops the gRPC server. Health checks report NOT_SERVING while
+// in-flight calls drain.
 func (s *Service) Stop() {
+	if s.health != nil {
+		close(s.stop)
+		s.health.Shutdown()
+	}
 	if s.server != nil {
 		s.server.GracefulStop()
 	}
@@ -50,14 +115,22 @@ func (s *Service) Stop() {
 
 // ExecuteFunction executes a function
 func (s *Service) ExecuteFunction(ctx context.Context, req *pb.ExecuteFunctionRequest) (*pb.ExecuteFunctionResponse, error) {
+	if err := authorizeFunction(ctx, req.Name); err != nil {
+		return nil, err
+	}
+
 	// Convert request to map
 	input := make(map[string]any)
 	for k, v := range req.Input {
 		input[k] = v
 	}
+	withCaller(ctx, input)
 
 	// Execute the function
-	result, err := s.registry.Execute(req.Name, input)
+	result, err := s.registry.Execute(req.Name, input, execOptions(ctx)...)
+	if errors.Is(err, ratelimit.ErrLimited) {
+		return nil, limitStatus(ctx, err)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute function: %w", err)
 	}
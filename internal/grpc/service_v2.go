@@ -0,0 +1,233 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/event"
+	"github.com/mstgnz/self-hosted-serverless/internal/function"
+	pbv2 "github.com/mstgnz/self-hosted-serverless/internal/grpc/proto/v2"
+	"github.com/mstgnz/self-hosted-serverless/internal/ratelimit"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ServiceV2 implements the typed v2 FunctionService. Inputs and results are
+// converted through JSON so gRPC callers see exactly what HTTP callers see.
+type ServiceV2 struct {
+	pbv2.UnimplementedFunctionServiceServer
+	registry *function.Registry
+	eventBus *event.Bus
+}
+
+// NewServiceV2 creates a new v2 gRPC service. It shares the registry, metrics
+// and global event bus with the HTTP server.
+func NewServiceV2(registry *function.Registry) *ServiceV2 {
+	return &ServiceV2{
+		registry: registry,
+		eventBus: event.GetGlobalBus(),
+	}
+}
+
+// ExecuteFunction executes a function
+func (s *ServiceV2) ExecuteFunction(ctx context.Context, req *pbv2.ExecuteFunctionRequest) (*pbv2.ExecuteFunctionResponse, error) {
+	if err := authorizeFunction(ctx, req.GetName()); err != nil {
+		return nil, err
+	}
+	input, err := decodeInputV2(req.GetInput(), req.GetRawInput())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid input: %v", err)
+	}
+	withCaller(ctx, input)
+
+	result, err := s.registry.Execute(req.GetName(), input, execOptions(ctx)...)
+	if errors.Is(err, function.ErrFunctionNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, ratelimit.ErrLimited) {
+		return nil, limitStatus(ctx, err)
+	}
+	if err != nil {
+		return &pbv2.ExecuteFunctionResponse{
+			Success: false,
+			Error:   err.Error(),
+		}, nil
+	}
+
+	value, raw, err := encodeResultV2(result, req.GetRawOutput())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pbv2.ExecuteFunctionResponse{
+		Success:   true,
+		Result:    value,
+		RawResult: raw,
+	}, nil
+}
+
+// StreamExecute executes a function and streams back each chunk it emits
+func (s *ServiceV2) StreamExecute(req *pbv2.ExecuteFunctionRequest, stream pbv2.FunctionService_StreamExecuteServer) error {
+	if err := authorizeFunction(stream.Context(), req.GetName()); err != nil {
+		return err
+	}
+	input, err := decodeInputV2(req.GetInput(), req.GetRawInput())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid input: %v", err)
+	}
+	withCaller(stream.Context(), input)
+
+	err = s.registry.ExecuteStream(req.GetName(), input, func(chunk any) error {
+		return sendChunkV2(stream, chunk, req.GetRawOutput())
+	}, execOptions(stream.Context())...)
+	return streamErrorV2(stream.Context(), err)
+}
+
+// BidiExecute streams input records from the client to a function and streams
+// back each chunk it emits. The first message must name the function.
+func (s *ServiceV2) BidiExecute(stream pbv2.FunctionService_BidiExecuteServer) error {
+	first, err := stream.Recv()
+	if errors.Is(err, io.EOF) {
+		return status.Error(codes.InvalidArgument, "function name is required")
+	}
+	if err != nil {
+		return err
+	}
+	if first.GetName() == "" {
+		return status.Error(codes.InvalidArgument, "function name is required")
+	}
+	if err := authorizeFunction(stream.Context(), first.GetName()); err != nil {
+		return err
+	}
+
+	inputs := make(chan map[string]any)
+	recvErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	// Receive input records until the client closes its side of the stream
+	go func() {
+		defer close(inputs)
+		req := first
+		for {
+			if req.GetInput() != nil || len(req.GetRawInput()) > 0 {
+				input, err := decodeInputV2(req.GetInput(), req.GetRawInput())
+				if err != nil {
+					recvErr <- status.Errorf(codes.InvalidArgument, "invalid input: %v", err)
+					return
+				}
+				select {
+				case inputs <- input:
+				case <-done:
+					return
+				}
+			}
+
+			var err error
+			req, err = stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				recvErr <- err
+				return
+			}
+		}
+	}()
+
+	err = s.registry.ExecuteBidi(first.GetName(), inputs, func(chunk any) error {
+		return sendChunkV2(stream, chunk, first.GetRawOutput())
+	}, execOptions(stream.Context())...)
+	if err != nil {
+		return streamErrorV2(stream.Context(), err)
+	}
+
+	select {
+	case err := <-recvErr:
+		return err
+	default:
+		return nil
+	}
+}
+
+// ListFunctions lists all available functions
+func (s *ServiceV2) ListFunctions(ctx context.Context, req *pbv2.ListFunctionsRequest) (*pbv2.ListFunctionsResponse, error) {
+	functions := s.registry.ListFunctions()
+
+	response := &pbv2.ListFunctionsResponse{
+		Functions: make([]*pbv2.FunctionInfo, 0, len(functions)),
+	}
+
+	for _, f := range functions {
+		response.Functions = append(response.Functions, functionInfoToProto(f))
+	}
+
+	return response, nil
+}
+
+// decodeInputV2 returns the function input from a request, preferring the
+// raw JSON input when it is set
+func decodeInputV2(structInput *structpb.Struct, rawInput []byte) (map[string]any, error) {
+	if len(rawInput) > 0 {
+		var input map[string]any
+		if err := json.Unmarshal(rawInput, &input); err != nil {
+			return nil, err
+		}
+		if input == nil {
+			input = make(map[string]any)
+		}
+		return input, nil
+	}
+
+	if structInput == nil {
+		return make(map[string]any), nil
+	}
+	return structInput.AsMap(), nil
+}
+
+// encodeResultV2 encodes a function result the same way the HTTP API does,
+// returning either a protobuf Value or, if raw is set, the JSON bytes
+func encodeResultV2(result any, raw bool) (*structpb.Value, []byte, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode result: %w", err)
+	}
+	if raw {
+		return nil, data, nil
+	}
+
+	value := &structpb.Value{}
+	if err := protojson.Unmarshal(data, value); err != nil {
+		return nil, nil, fmt.Errorf("failed to convert result: %w", err)
+	}
+	return value, nil, nil
+}
+
+// chunkSender is implemented by the server side of both streaming RPCs
+type chunkSender interface {
+	Send(*pbv2.ExecuteFunctionChunk) error
+}
+
+func sendChunkV2(stream chunkSender, chunk any, raw bool) error {
+	value, data, err := encodeResultV2(chunk, raw)
+	if err != nil {
+		return err
+	}
+	return stream.Send(&pbv2.ExecuteFunctionChunk{
+		Data:    value,
+		RawData: data,
+	})
+}
+
+// streamErrorV2 converts an execution error into a gRPC status error
+func streamErrorV2(ctx context.Context, err error) error {
+	if errors.Is(err, function.ErrFunctionNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return limitStatus(ctx, err)
+}
@@ -0,0 +1,206 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/mstgnz/self-hosted-serverless/internal/common"
+	"github.com/mstgnz/self-hosted-serverless/internal/function"
+	pbv2 "github.com/mstgnz/self-hosted-serverless/internal/grpc/proto/v2"
+	"github.com/mstgnz/self-hosted-serverless/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func setupTestServiceV2() *ServiceV2 {
+	registry := function.NewRegistry()
+
+	// Register a function that echoes its input in a nested result
+	mockHandler := &MockFunctionHandler{
+		ExecuteFunc: func(input map[string]interface{}) (interface{}, error) {
+			return map[string]interface{}{
+				"result": "success",
+				"input":  input,
+			}, nil
+		},
+	}
+
+	info := common.FunctionInfo{
+		Name:        "test-function",
+		Description: "Test function",
+		Runtime:     "go",
+	}
+
+	registry.Register(info.Name, mockHandler, info)
+
+	return NewServiceV2(registry)
+}
+
+func TestExecuteFunctionV2(t *testing.T) {
+	service := setupTestServiceV2()
+	ctx := context.Background()
+
+	input, err := structpb.NewStruct(map[string]interface{}{
+		"count": 3,
+		"tags":  []interface{}{"a", "b"},
+	})
+	assert.NoError(t, err)
+
+	resp, err := service.ExecuteFunction(ctx, &pbv2.ExecuteFunctionRequest{
+		Name:  "test-function",
+		Input: input,
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.Success)
+
+	// Nested values and numbers round-trip with their JSON types
+	result := resp.GetResult().AsInterface().(map[string]interface{})
+	assert.Equal(t, "success", result["result"])
+	echoed := result["input"].(map[string]interface{})
+	assert.Equal(t, float64(3), echoed["count"])
+	assert.Equal(t, []interface{}{"a", "b"}, echoed["tags"])
+}
+
+func TestExecuteFunctionV2CallerFields(t *testing.T) {
+	service := setupTestServiceV2()
+	forged := []byte(`{"identity": {"subject": "admin", "scopes": ["admin"]}, "client_ip": "10.0.0.1"}`)
+
+	echo := func(ctx context.Context) map[string]interface{} {
+		resp, err := service.ExecuteFunction(ctx, &pbv2.ExecuteFunctionRequest{Name: "test-function", RawInput: forged})
+		assert.NoError(t, err)
+		return resp.GetResult().AsInterface().(map[string]interface{})["input"].(map[string]interface{})
+	}
+
+	// Without a verified identity or peer the client's values are dropped
+	input := echo(context.Background())
+	assert.NotContains(t, input, "identity")
+	assert.NotContains(t, input, "client_ip")
+
+	// A verified identity and peer replace them
+	ctx := auth.NewContext(context.Background(), auth.Identity{Subject: "web", Scopes: []string{auth.ScopeInvoke}})
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.7"), Port: 5000}})
+	input = echo(ctx)
+	assert.Equal(t, "web", input["identity"].(map[string]interface{})["subject"])
+	assert.Equal(t, "192.0.2.7", input["client_ip"])
+}
+
+func TestExecuteFunctionV2Limited(t *testing.T) {
+	service := setupTestServiceV2()
+	ctx := context.Background()
+	service.registry.Register("limited", &MockFunctionHandler{
+		ExecuteFunc: func(input map[string]interface{}) (interface{}, error) {
+			return "ok", nil
+		},
+	}, common.FunctionInfo{Name: "limited", Runtime: "go"})
+	assert.NoError(t, service.registry.SetLimits("limited", ratelimit.Limits{Requests: 1}))
+
+	_, err := service.ExecuteFunction(ctx, &pbv2.ExecuteFunctionRequest{Name: "limited"})
+	assert.NoError(t, err)
+
+	// Calls over the function's limits are rejected rather than failed
+	_, err = service.ExecuteFunction(ctx, &pbv2.ExecuteFunctionRequest{Name: "limited"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestExecuteFunctionV2Raw(t *testing.T) {
+	service := setupTestServiceV2()
+	ctx := context.Background()
+
+	resp, err := service.ExecuteFunction(ctx, &pbv2.ExecuteFunctionRequest{
+		Name:      "test-function",
+		RawInput:  []byte(`{"user": {"id": 123}}`),
+		RawOutput: true,
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.Success)
+	assert.Nil(t, resp.Result)
+
+	var result map[string]interface{}
+	assert.NoError(t, json.Unmarshal(resp.RawResult, &result))
+	assert.Equal(t, map[string]interface{}{"user": map[string]interface{}{"id": float64(123)}}, result["input"])
+
+	// Invalid raw input is rejected
+	_, err = service.ExecuteFunction(ctx, &pbv2.ExecuteFunctionRequest{
+		Name:     "test-function",
+		RawInput: []byte(`not json`),
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Unknown functions are reported as not found
+	_, err = service.ExecuteFunction(ctx, &pbv2.ExecuteFunctionRequest{Name: "non-existent"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// mockChunkStream is a minimal server stream for testing the streaming RPCs
+type mockChunkStream struct {
+	grpc.ServerStream
+	requests []*pbv2.BidiExecuteRequest
+	chunks   []*pbv2.ExecuteFunctionChunk
+}
+
+func (m *mockChunkStream) Context() context.Context {
+	return context.Background()
+}
+
+func (m *mockChunkStream) Send(chunk *pbv2.ExecuteFunctionChunk) error {
+	m.chunks = append(m.chunks, chunk)
+	return nil
+}
+
+func (m *mockChunkStream) Recv() (*pbv2.BidiExecuteRequest, error) {
+	if len(m.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := m.requests[0]
+	m.requests = m.requests[1:]
+	return req, nil
+}
+
+func TestStreamExecuteV2(t *testing.T) {
+	service := setupTestServiceV2()
+
+	stream := &mockChunkStream{}
+	err := service.StreamExecute(&pbv2.ExecuteFunctionRequest{
+		Name:     "test-function",
+		RawInput: []byte(`{"key": "value"}`),
+	}, stream)
+	assert.NoError(t, err)
+
+	// A non-streaming function produces a single chunk with its result
+	assert.Equal(t, 1, len(stream.chunks))
+	result := stream.chunks[0].GetData().AsInterface().(map[string]interface{})
+	assert.Equal(t, "success", result["result"])
+
+	err = service.StreamExecute(&pbv2.ExecuteFunctionRequest{Name: "non-existent"}, &mockChunkStream{})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestBidiExecuteV2(t *testing.T) {
+	service := setupTestServiceV2()
+
+	// The first message names the function; every message may carry an input record
+	stream := &mockChunkStream{requests: []*pbv2.BidiExecuteRequest{
+		{Name: "test-function", RawOutput: true},
+		{RawInput: []byte(`{"n": 1}`)},
+		{RawInput: []byte(`{"n": 2}`)},
+	}}
+	err := service.BidiExecute(stream)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(stream.chunks))
+
+	var result map[string]interface{}
+	assert.NoError(t, json.Unmarshal(stream.chunks[1].GetRawData(), &result))
+	assert.Equal(t, map[string]interface{}{"n": float64(2)}, result["input"])
+
+	// A stream without a function name is rejected
+	err = service.BidiExecute(&mockChunkStream{requests: []*pbv2.BidiExecuteRequest{{}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
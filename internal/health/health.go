@@ -0,0 +1,169 @@
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status is the state of a component or of the server as a whole
+type Status string
+
+const (
+	// StatusUp means every component is healthy
+	StatusUp Status = "up"
+	// StatusDegraded means a non-critical component is unhealthy. The server
+	// is still ready to receive traffic.
+	StatusDegraded Status = "degraded"
+	// StatusDown means a critical component is unhealthy
+	StatusDown Status = "down"
+)
+
+// checkTimeout bounds how long a single component check may take
+const checkTimeout = 2 * time.Second
+
+// Check reports whether a component is healthy by returning nil
+type Check func(ctx context.Context) error
+
+type component struct {
+	check    Check
+	critical bool
+}
+
+// ComponentStatus is the result of checking a single component
+type ComponentStatus struct {
+	Status   Status `json:"status"`
+	Critical bool   `json:"critical"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Report is the result of checking every registered component
+type Report struct {
+	Status     Status                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+// Ready reports whether every critical component is healthy
+func (r Report) Ready() bool {
+	return r.Status != StatusDown
+}
+
+// Redacted returns a copy of the report without the component errors, which
+// may describe internal hosts or credentials
+func (r Report) Redacted() Report {
+	components := make(map[string]ComponentStatus, len(r.Components))
+	for name, result := range r.Components {
+		result.Error = ""
+		components[name] = result
+	}
+	return Report{Status: r.Status, Components: components}
+}
+
+// Checker keeps the set of components that make up the server's health
+type Checker struct {
+	components map[string]component
+	mutex      sync.RWMutex
+}
+
+// NewChecker creates a new health checker
+func NewChecker() *Checker {
+	return &Checker{
+		components: make(map[string]component),
+	}
+}
+
+// Register adds a component check, replacing any check with the same name.
+// When a critical component is unhealthy the server is reported as not ready.
+func (c *Checker) Register(name string, check Check, critical bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.components[name] = component{check: check, critical: critical}
+}
+
+// Unregister removes a component check
+func (c *Checker) Unregister(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.components, name)
+}
+
+// Check runs every component check concurrently and returns the combined report
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mutex.RLock()
+	names := make([]string, 0, len(c.components))
+	components := make(map[string]component, len(c.components))
+	for name, comp := range c.components {
+		names = append(names, name)
+		components[name] = comp
+	}
+	c.mutex.RUnlock()
+	sort.Strings(names)
+
+	results := make([]ComponentStatus, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, comp component) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, comp)
+		}(i, components[name])
+	}
+	wg.Wait()
+
+	report := Report{
+		Status:     StatusUp,
+		Components: make(map[string]ComponentStatus, len(names)),
+	}
+	for i, name := range names {
+		result := results[i]
+		report.Components[name] = result
+		if result.Status == StatusUp {
+			continue
+		}
+		if result.Critical {
+			report.Status = StatusDown
+		} else if report.Status == StatusUp {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+func runCheck(ctx context.Context, comp component) ComponentStatus {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- comp.check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		return ComponentStatus{Status: StatusDown, Critical: comp.critical, Error: err.Error()}
+	}
+	return ComponentStatus{Status: StatusUp, Critical: comp.critical}
+}
+
+var (
+	globalChecker *Checker
+	globalOnce    sync.Once
+)
+
+// GetGlobalChecker returns the global health checker shared by the HTTP and
+// gRPC servers
+func GetGlobalChecker() *Checker {
+	globalOnce.Do(func() {
+		globalChecker = NewChecker()
+	})
+	return globalChecker
+}
@@ -0,0 +1,60 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	checker := NewChecker()
+
+	// No components means healthy
+	report := checker.Check(context.Background())
+	assert.Equal(t, StatusUp, report.Status)
+	assert.True(t, report.Ready())
+
+	checker.Register("runtime", func(ctx context.Context) error { return nil }, true)
+	checker.Register("database", func(ctx context.Context) error { return errors.New("connection refused") }, false)
+
+	// A failing non-critical component degrades the server but keeps it ready
+	report = checker.Check(context.Background())
+	assert.Equal(t, StatusDegraded, report.Status)
+	assert.True(t, report.Ready())
+	assert.Equal(t, ComponentStatus{Status: StatusUp, Critical: true}, report.Components["runtime"])
+	assert.Equal(t, "connection refused", report.Components["database"].Error)
+	assert.Equal(t, ComponentStatus{Status: StatusDown}, report.Redacted().Components["database"])
+	assert.Equal(t, "connection refused", report.Components["database"].Error)
+
+	// A failing critical component makes the server not ready
+	checker.Register("runtime", func(ctx context.Context) error { return errors.New("not initialized") }, true)
+	report = checker.Check(context.Background())
+	assert.Equal(t, StatusDown, report.Status)
+	assert.False(t, report.Ready())
+
+	checker.Unregister("runtime")
+	checker.Unregister("database")
+	assert.Equal(t, StatusUp, checker.Check(context.Background()).Status)
+}
+
+func TestCheckTimeout(t *testing.T) {
+	checker := NewChecker()
+	checker.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	report := checker.Check(ctx)
+	assert.Equal(t, StatusDown, report.Status)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Components["slow"].Error)
+}
+
+func TestGetGlobalChecker(t *testing.T) {
+	assert.Same(t, GetGlobalChecker(), GetGlobalChecker())
+}
@@ -0,0 +1,466 @@
+package history
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/db"
+)
+
+// Execution outcomes
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+	OutcomeTimeout = "timeout"
+)
+
+// Query limits
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+)
+
+// Record is a single function execution
+type Record struct {
+	ID          string        `json:"id"`
+	Function    string        `json:"function"`
+	Version     string        `json:"version,omitempty"`
+	Trigger     string        `json:"trigger"`
+	Caller      string        `json:"caller,omitempty"`
+	KeyID       string        `json:"key_id,omitempty"`
+	StartedAt   time.Time     `json:"started_at"`
+	EndedAt     time.Time     `json:"ended_at"`
+	Duration    time.Duration `json:"duration"`
+	Outcome     string        `json:"outcome"`
+	Error       string        `json:"error,omitempty"`
+	InputBytes  int64         `json:"input_bytes"`
+	OutputBytes int64         `json:"output_bytes"`
+	// MemoryPages is the most 64 KiB pages of memory a WebAssembly instance
+	// of the execution had
+	MemoryPages int64 `json:"memory_pages"`
+	// DBQueries is the number of SQL statements the execution issued through
+	// its context
+	DBQueries int64 `json:"db_queries"`
+}
+
+// Filter selects records. Zero fields match every record. An Outcome of
+// "error" matches timeouts as well.
+type Filter struct {
+	Function string
+	Outcome  string
+	Trigger  string
+	Caller   string
+	KeyID    string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+// Config controls how records are written and kept
+type Config struct {
+	// BatchSize is the number of records written in one transaction
+	BatchSize int
+	// FlushInterval is the longest a record waits before being written
+	FlushInterval time.Duration
+	// QueueSize is the number of records buffered for writing. Records are
+	// dropped when the queue is full rather than slowing executions down.
+	QueueSize int
+	// Retention is how long records are kept. Zero keeps them forever.
+	Retention time.Duration
+}
+
+// DefaultConfig returns the configuration used when nothing is set
+func DefaultConfig() Config {
+	return Config{
+		BatchSize:     100,
+		FlushInterval: time.Second,
+		QueueSize:     10000,
+		Retention:     7 * 24 * time.Hour,
+	}
+}
+
+// pruneInterval is how often records older than the retention are deleted
+const pruneInterval = time.Hour
+
+// Store keeps the invocation history in the invocations table of a
+// PostgreSQL or SQLite database. Records are written asynchronously in
+// batches, so recording never blocks an execution on the database.
+type Store struct {
+	db  *sql.DB
+	cfg Config
+
+	mu      sync.RWMutex
+	closed  bool
+	queue   chan Record
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+// NewStoreFromEnv opens the history store selected by HISTORY_DB: "sqlite"
+// (the default), "postgres" or "none" to disable it, in which case it returns
+// nil. HISTORY_RETENTION, HISTORY_BATCH_SIZE and HISTORY_FLUSH_MS override the
+// defaults.
+func NewStoreFromEnv() (*Store, error) {
+	dbType := os.Getenv("HISTORY_DB")
+	switch dbType {
+	case "none":
+		return nil, nil
+	case "":
+		dbType = string(db.SQLite)
+	}
+
+	cfg := DefaultConfig()
+	if v := os.Getenv("HISTORY_RETENTION"); v != "" {
+		retention, err := time.ParseDuration(v)
+		if err != nil || retention < 0 {
+			return nil, fmt.Errorf("invalid HISTORY_RETENTION %q", v)
+		}
+		cfg.Retention = retention
+	}
+	if v := os.Getenv("HISTORY_BATCH_SIZE"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid HISTORY_BATCH_SIZE %q", v)
+		}
+		cfg.BatchSize = size
+	}
+	if v := os.Getenv("HISTORY_FLUSH_MS"); v != "" {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms <= 0 {
+			return nil, fmt.Errorf("invalid HISTORY_FLUSH_MS %q", v)
+		}
+		cfg.FlushInterval = time.Duration(ms) * time.Millisecond
+	}
+
+	conn, err := db.NewService(db.DatabaseType(dbType))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open invocation history: %w", err)
+	}
+	return NewStore(conn.GetDB(), cfg)
+}
+
+// NewStore creates a history store backed by db, creating the invocations
+// table if it does not exist, and starts writing records in the background.
+// Close must be called to write the records still queued.
+func NewStore(db *sql.DB, cfg Config) (*Store, error) {
+	defaults := DefaultConfig()
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaults.BatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaults.FlushInterval
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaults.QueueSize
+	}
+
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS invocations (
+			id TEXT PRIMARY KEY,
+			function TEXT NOT NULL,
+			version TEXT NOT NULL,
+			trigger TEXT NOT NULL,
+			caller TEXT NOT NULL,
+			started_at TIMESTAMP NOT NULL,
+			ended_at TIMESTAMP NOT NULL,
+			duration_ns BIGINT NOT NULL,
+			outcome TEXT NOT NULL,
+			error TEXT NOT NULL,
+			input_bytes BIGINT NOT NULL,
+			output_bytes BIGINT NOT NULL,
+			key_id TEXT NOT NULL DEFAULT '',
+			memory_pages BIGINT NOT NULL DEFAULT 0,
+			db_queries BIGINT NOT NULL DEFAULT 0
+		)`,
+		`CREATE INDEX IF NOT EXISTS invocations_started_at ON invocations (started_at)`,
+		`CREATE INDEX IF NOT EXISTS invocations_function_started_at ON invocations (function, started_at)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, fmt.Errorf("failed to create invocations table: %w", err)
+		}
+	}
+	if err := addColumns(db); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		db:    db,
+		cfg:   cfg,
+		queue: make(chan Record, cfg.QueueSize),
+		done:  make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// addedColumns are the columns added to the invocations table since it was
+// first created, which older tables lack
+var addedColumns = []struct{ name, definition string }{
+	{"key_id", "TEXT NOT NULL DEFAULT ''"},
+	{"memory_pages", "BIGINT NOT NULL DEFAULT 0"},
+	{"db_queries", "BIGINT NOT NULL DEFAULT 0"},
+}
+
+// addColumns adds the columns an invocations table created by an older
+// version lacks
+func addColumns(db *sql.DB) error {
+	rows, err := db.Query(`SELECT * FROM invocations LIMIT 0`)
+	if err != nil {
+		return fmt.Errorf("failed to read invocations table: %w", err)
+	}
+	columns, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read invocations table: %w", err)
+	}
+
+	for _, c := range addedColumns {
+		if slices.Contains(columns, c.name) {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE invocations ADD COLUMN ` + c.name + ` ` + c.definition); err != nil {
+			return fmt.Errorf("failed to add column %s to invocations table: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+// Record queues a record for writing. It never blocks; when the queue is
+// full the record is dropped and counted.
+func (s *Store) Record(rec Record) {
+	if rec.ID == "" {
+		rec.ID = newID()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- rec:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Close writes the queued records and stops the background writer
+func (s *Store) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+
+	<-s.done
+	return nil
+}
+
+// run writes queued records in batches and deletes expired ones
+func (s *Store) run() {
+	defer close(s.done)
+
+	flushTicker := time.NewTicker(s.cfg.FlushInterval)
+	defer flushTicker.Stop()
+	pruneTicker := time.NewTicker(pruneInterval)
+	defer pruneTicker.Stop()
+
+	s.prune()
+	batch := make([]Record, 0, s.cfg.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			if err := s.write(batch); err != nil {
+				log.Printf("Warning: Failed to write %d invocation records: %v", len(batch), err)
+			}
+			batch = batch[:0]
+		}
+		if dropped := s.dropped.Swap(0); dropped > 0 {
+			log.Printf("Warning: Invocation history queue full, dropped %d records", dropped)
+		}
+	}
+
+	for {
+		select {
+		case rec, ok := <-s.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, rec)
+			if len(batch) >= s.cfg.BatchSize {
+				flush()
+			}
+		case <-flushTicker.C:
+			flush()
+		case <-pruneTicker.C:
+			s.prune()
+		}
+	}
+}
+
+// write inserts a batch of records in one transaction
+func (s *Store) write(batch []Record) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO invocations (id, function, version, trigger, caller, started_at, ended_at,
+		duration_ns, outcome, error, input_bytes, output_bytes, key_id, memory_pages, db_queries)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, rec := range batch {
+		_, err := stmt.Exec(rec.ID, rec.Function, rec.Version, rec.Trigger, rec.Caller, rec.StartedAt.UTC(),
+			rec.EndedAt.UTC(), int64(rec.Duration), rec.Outcome, rec.Error, rec.InputBytes, rec.OutputBytes,
+			rec.KeyID, rec.MemoryPages, rec.DBQueries)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// prune deletes the records older than the retention
+func (s *Store) prune() {
+	if s.cfg.Retention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-s.cfg.Retention).UTC()
+	if _, err := s.db.Exec(`DELETE FROM invocations WHERE started_at < $1`, cutoff); err != nil {
+		log.Printf("Warning: Failed to delete expired invocation records: %v", err)
+	}
+}
+
+const selectRecords = `SELECT id, function, version, trigger, caller, started_at, ended_at, duration_ns,
+	outcome, error, input_bytes, output_bytes, key_id, memory_pages, db_queries FROM invocations`
+
+// Query returns the newest records matching f
+func (s *Store) Query(f Filter) ([]Record, error) {
+	var (
+		conds []string
+		args  []any
+	)
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Function != "" {
+		add("function = $%d", f.Function)
+	}
+	switch f.Outcome {
+	case "":
+	case OutcomeError:
+		conds = append(conds, "outcome IN ('error', 'timeout')")
+	default:
+		add("outcome = $%d", f.Outcome)
+	}
+	if f.Trigger != "" {
+		add("trigger = $%d", f.Trigger)
+	}
+	if f.Caller != "" {
+		add("caller = $%d", f.Caller)
+	}
+	if f.KeyID != "" {
+		add("key_id = $%d", f.KeyID)
+	}
+	if !f.Since.IsZero() {
+		add("started_at >= $%d", f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		add("started_at < $%d", f.Until.UTC())
+	}
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	limit = min(limit, MaxQueryLimit)
+
+	query := selectRecords
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY started_at DESC LIMIT %d", limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query invocations: %w", err)
+	}
+	defer rows.Close()
+
+	records := []Record{}
+	for rows.Next() {
+		rec, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// Replay calls fn with every record started at or after since, oldest
+// first, so aggregate metrics can be rebuilt from the history
+func (s *Store) Replay(since time.Time, fn func(Record)) error {
+	rows, err := s.db.Query(selectRecords+` WHERE started_at >= $1 ORDER BY started_at`, since.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to read invocations: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rec, err := scanRecord(rows)
+		if err != nil {
+			return err
+		}
+		fn(rec)
+	}
+	return rows.Err()
+}
+
+// Retention returns how long records are kept, zero meaning forever
+func (s *Store) Retention() time.Duration {
+	return s.cfg.Retention
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRecord(row rowScanner) (Record, error) {
+	var (
+		rec      Record
+		duration int64
+	)
+	err := row.Scan(&rec.ID, &rec.Function, &rec.Version, &rec.Trigger, &rec.Caller, &rec.StartedAt, &rec.EndedAt,
+		&duration, &rec.Outcome, &rec.Error, &rec.InputBytes, &rec.OutputBytes, &rec.KeyID, &rec.MemoryPages, &rec.DBQueries)
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to read invocation: %w", err)
+	}
+	rec.Duration = time.Duration(duration)
+	return rec, nil
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
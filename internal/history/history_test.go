@@ -0,0 +1,131 @@
+package history
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStore(t *testing.T, cfg Config) (*Store, *sql.DB) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+
+	store, err := NewStore(db, cfg)
+	require.NoError(t, err)
+	return store, db
+}
+
+func TestStoreQuery(t *testing.T) {
+	store, _ := newTestStore(t, Config{Retention: 0})
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	records := []Record{
+		{Function: "resize", Trigger: "http", Caller: "billing", Outcome: OutcomeSuccess, InputBytes: 12, OutputBytes: 34},
+		{Function: "resize", Trigger: "grpc", Caller: "billing", Outcome: OutcomeError, Error: "boom"},
+		{Function: "resize", Trigger: "http", Caller: "web", Outcome: OutcomeTimeout, Error: "timed out"},
+		{Function: "thumb", Version: "abc123", Trigger: "route", Outcome: OutcomeSuccess},
+	}
+	for i, rec := range records {
+		rec.StartedAt = start.Add(time.Duration(i) * time.Minute)
+		rec.Duration = time.Duration(i+1) * time.Millisecond
+		rec.EndedAt = rec.StartedAt.Add(rec.Duration)
+		store.Record(rec)
+	}
+	require.NoError(t, store.Close())
+
+	all, err := store.Query(Filter{})
+	require.NoError(t, err)
+	require.Len(t, all, 4)
+	// Newest first, with every field round-tripped
+	assert.Equal(t, "thumb", all[0].Function)
+	assert.Equal(t, "abc123", all[0].Version)
+	assert.Len(t, all[0].ID, 32)
+	assert.True(t, all[3].StartedAt.Equal(start))
+	assert.Equal(t, time.Millisecond, all[3].Duration)
+	assert.Equal(t, int64(12), all[3].InputBytes)
+	assert.Equal(t, int64(34), all[3].OutputBytes)
+
+	tests := []struct {
+		filter Filter
+		want   int
+	}{
+		{Filter{Function: "resize"}, 3},
+		{Filter{Outcome: OutcomeError}, 2},
+		{Filter{Outcome: OutcomeTimeout}, 1},
+		{Filter{Function: "resize", Trigger: "http"}, 2},
+		{Filter{Caller: "billing"}, 2},
+		{Filter{Since: start.Add(90 * time.Second)}, 2},
+		{Filter{Until: start.Add(90 * time.Second)}, 2},
+		{Filter{Limit: 1}, 1},
+	}
+	for _, tt := range tests {
+		got, err := store.Query(tt.filter)
+		require.NoError(t, err)
+		assert.Len(t, got, tt.want, "%+v", tt.filter)
+	}
+}
+
+func TestStoreBatchesAndRetention(t *testing.T) {
+	store, db := newTestStore(t, Config{BatchSize: 2, FlushInterval: time.Hour, Retention: 24 * time.Hour})
+
+	// A full batch is written without waiting for the flush interval
+	now := time.Now()
+	store.Record(Record{Function: "f", StartedAt: now, EndedAt: now, Outcome: OutcomeSuccess})
+	store.Record(Record{Function: "f", StartedAt: now.Add(-48 * time.Hour), EndedAt: now, Outcome: OutcomeSuccess})
+	assert.Eventually(t, func() bool {
+		var n int
+		db.QueryRow(`SELECT COUNT(*) FROM invocations`).Scan(&n)
+		return n == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Records past the retention are deleted
+	store.prune()
+	var n int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM invocations`).Scan(&n))
+	assert.Equal(t, 1, n)
+
+	require.NoError(t, store.Close())
+	// Records after Close are ignored rather than panicking
+	store.Record(Record{Function: "f"})
+}
+
+func TestStoreReplay(t *testing.T) {
+	store, _ := newTestStore(t, Config{})
+	start := time.Now().Add(-time.Hour)
+	for i := range 3 {
+		at := start.Add(time.Duration(i) * time.Minute)
+		store.Record(Record{Function: "f", StartedAt: at, EndedAt: at, Outcome: OutcomeSuccess})
+	}
+	require.NoError(t, store.Close())
+
+	var seen []time.Time
+	require.NoError(t, store.Replay(start.Add(30*time.Second), func(rec Record) {
+		seen = append(seen, rec.StartedAt)
+	}))
+	require.Len(t, seen, 2)
+	assert.True(t, seen[0].Before(seen[1]))
+}
+
+func TestNewStoreFromEnv(t *testing.T) {
+	t.Setenv("HISTORY_DB", "none")
+	store, err := NewStoreFromEnv()
+	assert.NoError(t, err)
+	assert.Nil(t, store)
+
+	t.Setenv("HISTORY_DB", "")
+	t.Setenv("HISTORY_RETENTION", "forever")
+	_, err = NewStoreFromEnv()
+	assert.Error(t, err)
+
+	t.Setenv("HISTORY_RETENTION", "")
+	t.Setenv("HISTORY_BATCH_SIZE", "0")
+	_, err = NewStoreFromEnv()
+	assert.Error(t, err)
+}
@@ -0,0 +1,104 @@
+package history
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Usage groupings
+const (
+	GroupByFunction = "function"
+	GroupByKey      = "key"
+)
+
+// Usage adds up what a group of executions consumed, for chargeback
+type Usage struct {
+	Function string `json:"function,omitempty"`
+	// KeyID and Caller identify the client when grouping by key. Clients
+	// without a key of the key store, such as JWT bearers or anonymous
+	// callers, have an empty KeyID and are told apart by Caller.
+	KeyID        string  `json:"key_id,omitempty"`
+	Caller       string  `json:"caller,omitempty"`
+	Invocations  int64   `json:"invocations"`
+	Errors       int64   `json:"errors"`
+	WallTimeSecs float64 `json:"wall_time_secs"`
+	InputBytes   int64   `json:"input_bytes"`
+	OutputBytes  int64   `json:"output_bytes"`
+	DBQueries    int64   `json:"db_queries"`
+	// PeakMemoryPages is the most pages any one WebAssembly instance had,
+	// and MemoryPageSecs the pages of each execution times its wall time
+	PeakMemoryPages int64   `json:"peak_memory_pages"`
+	MemoryPageSecs  float64 `json:"memory_page_secs"`
+}
+
+// UsageFilter selects the executions to report on and how to group them.
+// Zero times leave the range open.
+type UsageFilter struct {
+	Since   time.Time
+	Until   time.Time
+	GroupBy string
+}
+
+// Usage adds up the executions started in the filter's range, grouped by
+// function or by API key. Errors include timeouts.
+func (s *Store) Usage(f UsageFilter) ([]Usage, error) {
+	var group []string
+	switch f.GroupBy {
+	case "", GroupByFunction:
+		group = []string{"function"}
+	case GroupByKey:
+		group = []string{"key_id", "caller"}
+	default:
+		return nil, fmt.Errorf("invalid grouping %q", f.GroupBy)
+	}
+
+	var (
+		conds []string
+		args  []any
+	)
+	if !f.Since.IsZero() {
+		args = append(args, f.Since.UTC())
+		conds = append(conds, fmt.Sprintf("started_at >= $%d", len(args)))
+	}
+	if !f.Until.IsZero() {
+		args = append(args, f.Until.UTC())
+		conds = append(conds, fmt.Sprintf("started_at < $%d", len(args)))
+	}
+
+	columns := strings.Join(group, ", ")
+	query := `SELECT ` + columns + `, COUNT(*), SUM(CASE WHEN outcome = 'success' THEN 0 ELSE 1 END),
+		SUM(duration_ns), SUM(input_bytes), SUM(output_bytes), SUM(db_queries), MAX(memory_pages),
+		SUM(memory_pages * duration_ns / 1000000) FROM invocations`
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " GROUP BY " + columns + " ORDER BY " + columns
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usage: %w", err)
+	}
+	defer rows.Close()
+
+	usage := []Usage{}
+	for rows.Next() {
+		var (
+			u              Usage
+			wallNs, pageMs int64
+		)
+		dest := []any{&u.Function}
+		if f.GroupBy == GroupByKey {
+			dest = []any{&u.KeyID, &u.Caller}
+		}
+		dest = append(dest, &u.Invocations, &u.Errors, &wallNs, &u.InputBytes, &u.OutputBytes, &u.DBQueries,
+			&u.PeakMemoryPages, &pageMs)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to read usage: %w", err)
+		}
+		u.WallTimeSecs = time.Duration(wallNs).Seconds()
+		u.MemoryPageSecs = float64(pageMs) / 1000
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
@@ -0,0 +1,100 @@
+package history
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreUsage(t *testing.T) {
+	store, _ := newTestStore(t, Config{Retention: 0})
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	records := []Record{
+		{Function: "resize", Caller: "billing", KeyID: "k1", Outcome: OutcomeSuccess, InputBytes: 10, OutputBytes: 20, MemoryPages: 2, DBQueries: 3},
+		{Function: "resize", Caller: "billing", KeyID: "k1", Outcome: OutcomeTimeout, InputBytes: 5, MemoryPages: 4},
+		{Function: "thumb", Caller: "web", Outcome: OutcomeError, DBQueries: 1},
+		{Function: "thumb", Caller: "billing", KeyID: "k1", Outcome: OutcomeSuccess},
+	}
+	for i, rec := range records {
+		rec.Trigger = "http"
+		rec.StartedAt = start.Add(time.Duration(i) * time.Minute)
+		rec.Duration = time.Second
+		rec.EndedAt = rec.StartedAt.Add(rec.Duration)
+		store.Record(rec)
+	}
+	require.NoError(t, store.Close())
+
+	byFunction, err := store.Usage(UsageFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, []Usage{
+		{Function: "resize", Invocations: 2, Errors: 1, WallTimeSecs: 2, InputBytes: 15, OutputBytes: 20,
+			DBQueries: 3, PeakMemoryPages: 4, MemoryPageSecs: 6},
+		{Function: "thumb", Invocations: 2, Errors: 1, WallTimeSecs: 2, DBQueries: 1},
+	}, byFunction)
+
+	byKey, err := store.Usage(UsageFilter{GroupBy: GroupByKey})
+	require.NoError(t, err)
+	assert.Equal(t, []Usage{
+		{Caller: "web", Invocations: 1, Errors: 1, WallTimeSecs: 1, DBQueries: 1},
+		{KeyID: "k1", Caller: "billing", Invocations: 3, Errors: 1, WallTimeSecs: 3, InputBytes: 15, OutputBytes: 20,
+			DBQueries: 3, PeakMemoryPages: 4, MemoryPageSecs: 6},
+	}, byKey)
+
+	// The range covers the first two records
+	ranged, err := store.Usage(UsageFilter{Since: start, Until: start.Add(90 * time.Second)})
+	require.NoError(t, err)
+	require.Len(t, ranged, 1)
+	assert.Equal(t, "resize", ranged[0].Function)
+	assert.Equal(t, int64(2), ranged[0].Invocations)
+
+	_, err = store.Usage(UsageFilter{GroupBy: "region"})
+	assert.Error(t, err)
+}
+
+func TestStoreAddsColumns(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+
+	// A table as created before resource accounting
+	_, err = db.Exec(`CREATE TABLE invocations (
+		id TEXT PRIMARY KEY,
+		function TEXT NOT NULL,
+		version TEXT NOT NULL,
+		trigger TEXT NOT NULL,
+		caller TEXT NOT NULL,
+		started_at TIMESTAMP NOT NULL,
+		ended_at TIMESTAMP NOT NULL,
+		duration_ns BIGINT NOT NULL,
+		outcome TEXT NOT NULL,
+		error TEXT NOT NULL,
+		input_bytes BIGINT NOT NULL,
+		output_bytes BIGINT NOT NULL
+	)`)
+	require.NoError(t, err)
+	now := time.Now().UTC()
+	_, err = db.Exec(`INSERT INTO invocations VALUES ('old', 'resize', '', 'http', '', $1, $1, 0, 'success', '', 0, 0)`, now)
+	require.NoError(t, err)
+
+	store, err := NewStore(db, Config{Retention: 0})
+	require.NoError(t, err)
+	store.Record(Record{Function: "resize", Trigger: "http", KeyID: "k1", Outcome: OutcomeSuccess,
+		StartedAt: now, EndedAt: now, MemoryPages: 2, DBQueries: 1})
+	require.NoError(t, store.Close())
+
+	records, err := store.Query(Filter{Function: "resize"})
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+
+	records, err = store.Query(Filter{KeyID: "k1"})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, int64(2), records[0].MemoryPages)
+	assert.Equal(t, int64(1), records[0].DBQueries)
+}
@@ -0,0 +1,170 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/tracing"
+)
+
+// DefaultAuditFile is where the audit log is written unless AUDIT_LOG_FILE
+// says otherwise
+const DefaultAuditFile = "data/audit.log"
+
+// Audited actions
+const (
+	ActionAuthFailure  = "auth.failure"
+	ActionDBQuery      = "db.query"
+	ActionDeploy       = "function.deploy"
+	ActionDelete       = "function.delete"
+	ActionKeyCreate    = "key.create"
+	ActionKeyRevoke    = "key.revoke"
+	ActionKeyRotate    = "key.rotate"
+	ActionRouteAdd     = "route.add"
+	ActionRouteRemove  = "route.remove"
+	ActionMetricsReset = "metrics.reset"
+)
+
+// Audit outcomes
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+)
+
+// AuditEvent is an entry of the audit log
+type AuditEvent struct {
+	Time      time.Time      `json:"time"`
+	Action    string         `json:"action"`
+	Outcome   string         `json:"outcome"`
+	Actor     string         `json:"actor,omitempty"`
+	KeyID     string         `json:"key_id,omitempty"`
+	ClientIP  string         `json:"client_ip,omitempty"`
+	Protocol  string         `json:"protocol,omitempty"`
+	Target    string         `json:"target,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+	TraceID   string         `json:"trace_id,omitempty"`
+	Reason    string         `json:"reason,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+// AuditLogger appends audit events to a log as lines of JSON. A nil
+// AuditLogger discards events.
+type AuditLogger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewAuditLogger creates an audit logger that writes to w
+func NewAuditLogger(w io.Writer) *AuditLogger {
+	return &AuditLogger{w: w}
+}
+
+// NewAuditFileLogger creates an audit logger that appends to the file at
+// path, creating it and its directory if needed. Only the owner can read the
+// file.
+func NewAuditFileLogger(path string) (*AuditLogger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &AuditLogger{w: file, closer: file}, nil
+}
+
+// NewAuditLoggerFromEnv creates the audit logger configured by AUDIT_LOG_FILE,
+// which is DefaultAuditFile if unset. "stdout" and "stderr" write to the
+// process's streams and "none" disables the audit log, returning nil.
+func NewAuditLoggerFromEnv() (*AuditLogger, error) {
+	switch path := os.Getenv("AUDIT_LOG_FILE"); path {
+	case "none":
+		return nil, nil
+	case "stdout":
+		return NewAuditLogger(os.Stdout), nil
+	case "stderr":
+		return NewAuditLogger(os.Stderr), nil
+	case "":
+		return NewAuditFileLogger(DefaultAuditFile)
+	default:
+		return NewAuditFileLogger(path)
+	}
+}
+
+// Record appends an event, filling in its time, and the request ID and trace
+// of ctx
+func (l *AuditLogger) Record(ctx context.Context, event AuditEvent) {
+	if l == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if event.RequestID == "" {
+		event.RequestID = RequestIDFromContext(ctx)
+	}
+	if sc := tracing.SpanContextFromContext(ctx); event.TraceID == "" && sc.IsValid() {
+		event.TraceID = sc.TraceID.String()
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Warning: Failed to encode audit event %s: %v", event.Action, err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(line); err != nil {
+		log.Printf("Warning: Failed to write audit event %s: %v", event.Action, err)
+	}
+}
+
+// Close closes the file written to, if the logger opened one
+func (l *AuditLogger) Close() error {
+	if l == nil || l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+var (
+	globalAudit     atomic.Pointer[AuditLogger]
+	globalAuditOnce sync.Once
+)
+
+// GetGlobalAuditLogger returns the audit logger configured by the environment,
+// or nil if the audit log is disabled
+func GetGlobalAuditLogger() *AuditLogger {
+	globalAuditOnce.Do(func() {
+		l, err := NewAuditLoggerFromEnv()
+		if err != nil {
+			log.Printf("Warning: Audit log disabled: %v", err)
+		}
+		globalAudit.Store(l)
+	})
+	return globalAudit.Load()
+}
+
+// SetGlobalAuditLogger replaces the global audit logger. It is meant for
+// tests.
+func SetGlobalAuditLogger(l *AuditLogger) {
+	globalAuditOnce.Do(func() {})
+	globalAudit.Store(l)
+}
+
+// Audit records an event in the global audit log
+func Audit(ctx context.Context, event AuditEvent) {
+	GetGlobalAuditLogger().Record(ctx, event)
+}
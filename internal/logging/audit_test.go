@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewAuditLogger(&buf)
+
+	sc, err := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	ctx := ContextWithRequestID(tracing.ContextWithSpanContext(context.Background(), sc), "req-1")
+	l.Record(ctx, AuditEvent{Action: ActionKeyCreate, Outcome: OutcomeSuccess, Actor: "admin", Target: "key-1"})
+	l.Record(context.Background(), AuditEvent{Action: ActionAuthFailure, Outcome: OutcomeDenied, Reason: "unauthorized"})
+
+	var events []AuditEvent
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var event AuditEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	require.Len(t, events, 2)
+	assert.Equal(t, ActionKeyCreate, events[0].Action)
+	assert.Equal(t, "admin", events[0].Actor)
+	assert.Equal(t, "req-1", events[0].RequestID)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", events[0].TraceID)
+	assert.False(t, events[0].Time.IsZero())
+	assert.Equal(t, ActionAuthFailure, events[1].Action)
+	assert.Empty(t, events[1].RequestID)
+
+	// A nil logger discards events
+	var disabled *AuditLogger
+	disabled.Record(ctx, AuditEvent{Action: ActionDeploy})
+	assert.NoError(t, disabled.Close())
+}
+
+func TestAuditFileLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "audit.log")
+	t.Setenv("AUDIT_LOG_FILE", path)
+
+	for range 2 {
+		l, err := NewAuditLoggerFromEnv()
+		require.NoError(t, err)
+		l.Record(context.Background(), AuditEvent{Action: ActionDeploy, Outcome: OutcomeSuccess, Target: "hello"})
+		require.NoError(t, l.Close())
+	}
+
+	// Reopening the log appends to it
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, bytes.Count(data, []byte("\n")))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	t.Setenv("AUDIT_LOG_FILE", "none")
+	l, err := NewAuditLoggerFromEnv()
+	assert.NoError(t, err)
+	assert.Nil(t, l)
+}
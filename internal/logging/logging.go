@@ -0,0 +1,122 @@
+// Package logging sets up structured logging with log/slog, carries request
+// IDs through contexts and writes the audit log.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/tracing"
+)
+
+// Log formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Config configures the logger
+type Config struct {
+	Level  slog.Level
+	Format string
+}
+
+// DefaultConfig returns the default logger configuration: JSON at info level
+func DefaultConfig() Config {
+	return Config{Level: slog.LevelInfo, Format: FormatJSON}
+}
+
+// ConfigFromEnv reads the logger configuration from LOG_LEVEL (debug, info,
+// warn or error) and LOG_FORMAT (json or text)
+func ConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := cfg.Level.UnmarshalText([]byte(v)); err != nil {
+			return cfg, fmt.Errorf("invalid LOG_LEVEL %q", v)
+		}
+	}
+	switch v := strings.ToLower(os.Getenv("LOG_FORMAT")); v {
+	case "":
+	case FormatJSON, FormatText:
+		cfg.Format = v
+	default:
+		return cfg, fmt.Errorf("invalid LOG_FORMAT %q", v)
+	}
+	return cfg, nil
+}
+
+// NewLogger creates a logger that writes to w. Records logged with a context
+// are tagged with its request ID and trace.
+func NewLogger(w io.Writer, cfg Config) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.Level}
+	var handler slog.Handler
+	if cfg.Format == FormatText {
+		handler = slog.NewTextHandler(w, opts)
+	} else {
+		handler = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(contextHandler{handler})
+}
+
+// Setup makes the logger configured by the environment the default, for slog
+// and for the log package, whose lines are logged at the level their prefix
+// names, such as "Warning: ". An invalid configuration is reported and the
+// defaults are used.
+func Setup() *slog.Logger {
+	cfg, err := ConfigFromEnv()
+	logger := NewLogger(os.Stderr, cfg)
+	slog.SetDefault(logger)
+	log.SetFlags(0)
+	log.SetOutput(logWriter{logger})
+	if err != nil {
+		logger.Warn("Invalid logging configuration, using defaults", "error", err)
+	}
+	return logger
+}
+
+// contextHandler adds the request ID and trace of a record's context
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestIDFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := tracing.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID.String()), slog.String("span_id", sc.SpanID.String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// logWriter logs the lines of the log package through a slog logger
+type logWriter struct {
+	logger *slog.Logger
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+	level := slog.LevelInfo
+	switch {
+	case strings.HasPrefix(msg, "Warning: "):
+		level = slog.LevelWarn
+		msg = strings.TrimPrefix(msg, "Warning: ")
+	case strings.HasPrefix(msg, "Error"), strings.HasPrefix(msg, "Failed"), strings.HasPrefix(msg, "Panic"):
+		level = slog.LevelError
+	}
+	w.logger.Log(context.Background(), level, msg)
+	return len(p), nil
+}
@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	cfg, err := ConfigFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultConfig(), cfg)
+
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("LOG_FORMAT", "TEXT")
+	cfg, err = ConfigFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, Config{Level: slog.LevelDebug, Format: FormatText}, cfg)
+
+	t.Setenv("LOG_LEVEL", "loud")
+	_, err = ConfigFromEnv()
+	assert.Error(t, err)
+
+	t.Setenv("LOG_LEVEL", "")
+	t.Setenv("LOG_FORMAT", "xml")
+	_, err = ConfigFromEnv()
+	assert.Error(t, err)
+}
+
+func TestLoggerContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, Config{Level: slog.LevelInfo, Format: FormatJSON})
+
+	sc, err := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	ctx := ContextWithRequestID(tracing.ContextWithSpanContext(context.Background(), sc), "req-1")
+	logger.With("component", "test").InfoContext(ctx, "hello", "n", 1)
+	logger.DebugContext(ctx, "not logged")
+
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "hello", line["msg"])
+	assert.Equal(t, "INFO", line["level"])
+	assert.Equal(t, "test", line["component"])
+	assert.Equal(t, float64(1), line["n"])
+	assert.Equal(t, "req-1", line["request_id"])
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", line["trace_id"])
+	assert.Equal(t, "00f067aa0ba902b7", line["span_id"])
+}
+
+func TestLogWriterLevels(t *testing.T) {
+	var buf bytes.Buffer
+	w := logWriter{NewLogger(&buf, DefaultConfig())}
+
+	for _, tc := range []struct {
+		line, level, msg string
+	}{
+		{"Starting HTTP server on port 8080...\n", "INFO", "Starting HTTP server on port 8080..."},
+		{"Warning: API_KEY not set\n", "WARN", "API_KEY not set"},
+		{"Error closing database connection: closed\n", "ERROR", "Error closing database connection: closed"},
+	} {
+		buf.Reset()
+		n, err := w.Write([]byte(tc.line))
+		assert.NoError(t, err)
+		assert.Equal(t, len(tc.line), n)
+
+		var line map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+		assert.Equal(t, tc.level, line["level"])
+		assert.Equal(t, tc.msg, line["msg"])
+	}
+}
@@ -0,0 +1,25 @@
+package logging
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	id := NewRequestID()
+	assert.Len(t, id, 32)
+	assert.NotEqual(t, id, NewRequestID())
+
+	assert.Equal(t, "abc-123", RequestID("abc-123"))
+	for _, sent := range []string{"", "has space", "new\nline", "café", strings.Repeat("a", 129)} {
+		assert.False(t, ValidRequestID(sent), sent)
+		assert.Len(t, RequestID(sent), 32, sent)
+	}
+
+	ctx := ContextWithRequestID(context.Background(), "abc-123")
+	assert.Equal(t, "abc-123", RequestIDFromContext(ctx))
+	assert.Empty(t, RequestIDFromContext(context.Background()))
+}
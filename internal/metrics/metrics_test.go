@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteText(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounterVec("test_requests_total", "Requests served.", "method", "code")
+	latency := registry.NewHistogramVec("test_latency_seconds", "Request latency.", []float64{0.1, 1}, "method")
+	registry.NewGaugeFunc("test_temperature", "Current temperature.", func() float64 { return 21.5 })
+
+	requests.Inc("GET", "200")
+	requests.Inc("GET", "200")
+	requests.Add(3, "POST", "500")
+	latency.Observe(0.05, "GET")
+	latency.Observe(0.1, "GET")
+	latency.Observe(2, "GET")
+
+	var b strings.Builder
+	require.NoError(t, registry.WriteText(&b))
+	assert.Equal(t, `# HELP test_latency_seconds Request latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{method="GET",le="0.1"} 2
+test_latency_seconds_bucket{method="GET",le="1"} 2
+test_latency_seconds_bucket{method="GET",le="+Inf"} 3
+test_latency_seconds_sum{method="GET"} 2.15
+test_latency_seconds_count{method="GET"} 3
+# HELP test_requests_total Requests served.
+# TYPE test_requests_total counter
+test_requests_total{method="GET",code="200"} 2
+test_requests_total{method="POST",code="500"} 3
+# HELP test_temperature Current temperature.
+# TYPE test_temperature gauge
+test_temperature 21.5
+`, b.String())
+}
+
+func TestWriteTextEscaping(t *testing.T) {
+	registry := NewRegistry()
+	events := registry.NewCounterVec("test_events_total", "Events\nby \\ type.", "type")
+	events.Inc("say \"hi\"\n")
+	// Families without samples are left out
+	registry.NewCounterVec("test_unused_total", "Never incremented.")
+
+	var b strings.Builder
+	require.NoError(t, registry.WriteText(&b))
+	assert.Equal(t, `# HELP test_events_total Events\nby \\ type.
+# TYPE test_events_total counter
+test_events_total{type="say \"hi\"\n"} 1
+`, b.String())
+}
+
+func TestRegistryPanics(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounterVec("test_total", "Test.", "a")
+
+	assert.Panics(t, func() { registry.NewCounterVec("test_total", "Test.") })
+	assert.Panics(t, func() { counter.Inc() })
+}
+
+func TestGlobalRegistryRuntime(t *testing.T) {
+	var b strings.Builder
+	require.NoError(t, GetGlobalRegistry().WriteText(&b))
+	assert.Contains(t, b.String(), "# TYPE go_goroutines gauge\n")
+	assert.Contains(t, b.String(), "go_info{version=\"go")
+	assert.Contains(t, b.String(), "process_start_time_seconds ")
+}
@@ -0,0 +1,301 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrLimited is matched by every LimitError
+var ErrLimited = errors.New("limit exceeded")
+
+// Limits configures the request rate and concurrency allowed for a function,
+// an API key or a route. Zero values mean no limit.
+type Limits struct {
+	// Requests is the number of requests allowed per interval
+	Requests int `json:"requests,omitempty"`
+	// IntervalSecs is the length of the rate interval, one minute by default
+	IntervalSecs int `json:"interval_secs,omitempty"`
+	// Concurrency is the number of requests allowed to run at once
+	Concurrency int `json:"concurrency,omitempty"`
+	// QueueTimeoutMs is how long an excess request waits for capacity before
+	// it is rejected. Without it, excess requests are rejected immediately.
+	QueueTimeoutMs int `json:"queue_timeout_ms,omitempty"`
+}
+
+// IsZero reports whether the limits don't restrict anything
+func (l Limits) IsZero() bool {
+	return l.Requests == 0 && l.Concurrency == 0
+}
+
+// Validate checks that no limit is negative
+func (l Limits) Validate() error {
+	if l.Requests < 0 || l.IntervalSecs < 0 || l.Concurrency < 0 || l.QueueTimeoutMs < 0 {
+		return errors.New("limits must not be negative")
+	}
+	return nil
+}
+
+func (l Limits) interval() time.Duration {
+	if l.IntervalSecs == 0 {
+		return time.Minute
+	}
+	return time.Duration(l.IntervalSecs) * time.Second
+}
+
+func (l Limits) queueTimeout() time.Duration {
+	return time.Duration(l.QueueTimeoutMs) * time.Millisecond
+}
+
+// LimitError is returned when a request exceeds a limit
+type LimitError struct {
+	// Name identifies the limit, such as "function:resize"
+	Name string
+	// RetryAfter is when the client should try again
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Name, ErrLimited)
+}
+
+// Is makes errors.Is(err, ErrLimited) match a LimitError
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimited
+}
+
+// RetryAfterSecs returns RetryAfter rounded up to whole seconds, as sent in
+// a Retry-After header
+func (e *LimitError) RetryAfterSecs() int {
+	return max(1, int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// Stats describes the current state of a limit
+type Stats struct {
+	Limits   Limits `json:"limits"`
+	InFlight int    `json:"in_flight"`
+	Queued   int    `json:"queued"`
+	Allowed  int64  `json:"allowed"`
+	Rejected int64  `json:"rejected"`
+}
+
+// limitState tracks the requests admitted under one set of limits
+type limitState struct {
+	limits Limits
+	slots  chan struct{}
+
+	mu       sync.Mutex
+	requests []time.Time
+	queued   int
+	allowed  int64
+	rejected int64
+}
+
+func newLimitState(limits Limits) *limitState {
+	state := &limitState{limits: limits}
+	if limits.Concurrency > 0 {
+		state.slots = make(chan struct{}, limits.Concurrency)
+	}
+	return state
+}
+
+// reserve records a request if it fits in the rate interval, or returns how
+// long until it would
+func (st *limitState) reserve(now time.Time) (bool, time.Duration) {
+	if st.limits.Requests == 0 {
+		return true, 0
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	cutoff := now.Add(-st.limits.interval())
+	valid := st.requests[:0]
+	for _, t := range st.requests {
+		if t.After(cutoff) {
+			valid = append(valid, t)
+		}
+	}
+	st.requests = valid
+
+	if len(st.requests) >= st.limits.Requests {
+		return false, st.requests[0].Sub(cutoff)
+	}
+	st.requests = append(st.requests, now)
+	return true, 0
+}
+
+// unreserve gives back a request recorded at t that wasn't admitted after
+// all, so it doesn't use up the rate budget
+func (st *limitState) unreserve(t time.Time) {
+	if st.limits.Requests == 0 {
+		return
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if i := slices.Index(st.requests, t); i >= 0 {
+		st.requests = slices.Delete(st.requests, i, i+1)
+	}
+}
+
+func (st *limitState) count(queued int, allowed, rejected int64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.queued += queued
+	st.allowed += allowed
+	st.rejected += rejected
+}
+
+// Manager enforces named rate and concurrency limits, such as those of a
+// function, an API key or a route
+type Manager struct {
+	mu     sync.Mutex
+	states map[string]*limitState
+}
+
+// NewManager creates a limit manager
+func NewManager() *Manager {
+	return &Manager{states: make(map[string]*limitState)}
+}
+
+var (
+	globalManager *Manager
+	managerOnce   sync.Once
+)
+
+// GetGlobalManager returns the global limit manager shared by the registry
+// and the HTTP and gRPC servers
+func GetGlobalManager() *Manager {
+	managerOnce.Do(func() {
+		globalManager = NewManager()
+	})
+	return globalManager
+}
+
+// state returns the state of a named limit, starting afresh when its limits
+// have changed. Requests admitted under the old limits release their slots
+// on the old state.
+func (m *Manager) state(name string, limits Limits) *limitState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.states[name]
+	if !ok || state.limits != limits {
+		state = newLimitState(limits)
+		m.states[name] = state
+	}
+	return state
+}
+
+// Acquire admits a request under the named limits. If there is no capacity,
+// it waits up to the queue timeout for some, then returns a *LimitError.
+// Requests that are turned away don't count toward the rate limit. The
+// returned release function must be called once the request has finished.
+func (m *Manager) Acquire(ctx context.Context, name string, limits Limits) (func(), error) {
+	if limits.IsZero() {
+		return func() {}, nil
+	}
+
+	st := m.state(name, limits)
+	deadline := time.Now().Add(limits.queueTimeout())
+	reject := func(retryAfter time.Duration) error {
+		st.count(0, 0, 1)
+		kind, _, _ := strings.Cut(name, ":")
+		rejectionsTotal.Inc(kind)
+		return &LimitError{Name: name, RetryAfter: retryAfter}
+	}
+
+	var reserved time.Time
+	for {
+		reserved = time.Now()
+		ok, retryAfter := st.reserve(reserved)
+		if ok {
+			break
+		}
+		if time.Now().Add(retryAfter).After(deadline) {
+			return nil, reject(retryAfter)
+		}
+		if err := st.wait(ctx, time.After(retryAfter)); err != nil {
+			return nil, err
+		}
+	}
+
+	if st.slots != nil {
+		select {
+		case st.slots <- struct{}{}:
+		default:
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				st.unreserve(reserved)
+				return nil, reject(time.Second)
+			}
+
+			st.count(1, 0, 0)
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case st.slots <- struct{}{}:
+				st.count(-1, 0, 0)
+			case <-timer.C:
+				st.count(-1, 0, 0)
+				st.unreserve(reserved)
+				return nil, reject(time.Second)
+			case <-ctx.Done():
+				st.count(-1, 0, 0)
+				st.unreserve(reserved)
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	st.count(0, 1, 0)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if st.slots != nil {
+				<-st.slots
+			}
+		})
+	}, nil
+}
+
+// wait blocks until ready fires, counting the request as queued meanwhile
+func (st *limitState) wait(ctx context.Context, ready <-chan time.Time) error {
+	st.count(1, 0, 0)
+	defer st.count(-1, 0, 0)
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns the state of every limit that has been used
+func (m *Manager) Stats() map[string]Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := make(map[string]Stats, len(m.states))
+	for name, st := range m.states {
+		st.mu.Lock()
+		stats[name] = Stats{
+			Limits:   st.limits,
+			InFlight: len(st.slots),
+			Queued:   st.queued,
+			Allowed:  st.allowed,
+			Rejected: st.rejected,
+		}
+		st.mu.Unlock()
+	}
+	return stats
+}
@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquireRate(t *testing.T) {
+	manager := NewManager()
+	limits := Limits{Requests: 2, IntervalSecs: 60}
+
+	for range 2 {
+		release, err := manager.Acquire(context.Background(), "key:a", limits)
+		assert.NoError(t, err)
+		release()
+	}
+
+	_, err := manager.Acquire(context.Background(), "key:a", limits)
+	var limitErr *LimitError
+	assert.ErrorAs(t, err, &limitErr)
+	assert.ErrorIs(t, err, ErrLimited)
+	assert.Equal(t, "key:a", limitErr.Name)
+	assert.InDelta(t, 60, limitErr.RetryAfterSecs(), 1)
+
+	// Limits are tracked per name
+	_, err = manager.Acquire(context.Background(), "key:b", limits)
+	assert.NoError(t, err)
+
+	stats := manager.Stats()["key:a"]
+	assert.Equal(t, int64(2), stats.Allowed)
+	assert.Equal(t, int64(1), stats.Rejected)
+
+	// Changing the limits starts afresh
+	_, err = manager.Acquire(context.Background(), "key:a", Limits{Requests: 3})
+	assert.NoError(t, err)
+}
+
+func TestAcquireConcurrency(t *testing.T) {
+	manager := NewManager()
+	limits := Limits{Concurrency: 1}
+
+	release, err := manager.Acquire(context.Background(), "function:f", limits)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, manager.Stats()["function:f"].InFlight)
+
+	_, err = manager.Acquire(context.Background(), "function:f", limits)
+	assert.ErrorIs(t, err, ErrLimited)
+
+	// Releasing twice frees the slot only once
+	release()
+	release()
+	assert.Equal(t, 0, manager.Stats()["function:f"].InFlight)
+
+	release, err = manager.Acquire(context.Background(), "function:f", limits)
+	assert.NoError(t, err)
+	release()
+}
+
+func TestAcquireQueue(t *testing.T) {
+	manager := NewManager()
+	limits := Limits{Concurrency: 1, QueueTimeoutMs: 1000}
+
+	release, err := manager.Acquire(context.Background(), "route:/a", limits)
+	assert.NoError(t, err)
+
+	// A queued request gets the slot once it is released
+	acquired := make(chan error)
+	go func() {
+		release, err := manager.Acquire(context.Background(), "route:/a", limits)
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+	assert.Eventually(t, func() bool {
+		return manager.Stats()["route:/a"].Queued == 1
+	}, time.Second, time.Millisecond)
+	release()
+	assert.NoError(t, <-acquired)
+
+	// A queued request gives up after the queue timeout
+	limits.QueueTimeoutMs = 20
+	release, err = manager.Acquire(context.Background(), "route:/a", limits)
+	assert.NoError(t, err)
+	defer release()
+	_, err = manager.Acquire(context.Background(), "route:/a", limits)
+	assert.ErrorIs(t, err, ErrLimited)
+
+	// Rate limited requests wait for the interval if it ends in time
+	rate := Limits{Requests: 1, IntervalSecs: 1, QueueTimeoutMs: 2000}
+	_, err = manager.Acquire(context.Background(), "route:/b", rate)
+	assert.NoError(t, err)
+	start := time.Now()
+	_, err = manager.Acquire(context.Background(), "route:/b", rate)
+	assert.NoError(t, err)
+	assert.Greater(t, time.Since(start), 500*time.Millisecond)
+
+	// Cancelling the context stops the wait
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = manager.Acquire(ctx, "route:/b", rate)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestAcquireRejectedKeepsRate(t *testing.T) {
+	manager := NewManager()
+	limits := Limits{Requests: 2, IntervalSecs: 60, Concurrency: 1, QueueTimeoutMs: 20}
+
+	release, err := manager.Acquire(context.Background(), "key:a", limits)
+	assert.NoError(t, err)
+
+	// Requests turned away for concurrency, or given up while queued, don't
+	// use up the rate budget
+	_, err = manager.Acquire(context.Background(), "key:a", limits)
+	assert.ErrorIs(t, err, ErrLimited)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(5*time.Millisecond, cancel)
+	_, err = manager.Acquire(ctx, "key:a", limits)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	release()
+	release, err = manager.Acquire(context.Background(), "key:a", limits)
+	assert.NoError(t, err)
+	release()
+
+	_, err = manager.Acquire(context.Background(), "key:a", limits)
+	var limitErr *LimitError
+	assert.ErrorAs(t, err, &limitErr)
+	assert.InDelta(t, 60, limitErr.RetryAfterSecs(), 1)
+}
+
+func TestLimitsValidate(t *testing.T) {
+	assert.NoError(t, Limits{}.Validate())
+	assert.True(t, Limits{QueueTimeoutMs: 10}.IsZero())
+	assert.Error(t, Limits{Requests: -1}.Validate())
+}
@@ -0,0 +1,146 @@
+package ratelimit
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/db"
+	"github.com/mstgnz/self-hosted-serverless/internal/metrics"
+)
+
+// rejectionsTotal counts rejected requests by the kind of limit that
+// rejected them: ip, function, key or route
+var rejectionsTotal = metrics.GetGlobalRegistry().NewCounterVec("serverless_rate_limit_rejections_total",
+	"Requests rejected by a rate or concurrency limit.", "limit")
+
+// DefaultLimitPerMinute is the request limit used when RATE_LIMIT_PER_MIN is not set
+const DefaultLimitPerMinute = 100
+
+// RateLimiter admits requests from clients identified by an arbitrary key
+// such as their IP address
+type RateLimiter interface {
+	// Check reports whether a request from the given client is within the
+	// limit and, if so, records it. A rejected request also gets how long
+	// until the client may retry.
+	Check(key string) (bool, time.Duration)
+}
+
+// NewRateLimiterFromEnv creates the per-client limiter configured by
+// RATE_LIMIT_PER_MIN and RATE_LIMIT_BACKEND. With the "redis" backend the
+// limit is shared by every instance using the same Redis server; prefix keeps
+// the counts of separate limiters apart. The default "memory" backend limits
+// each instance on its own.
+func NewRateLimiterFromEnv(prefix string) RateLimiter {
+	limit := LimitPerMinuteFromEnv()
+
+	switch backend := os.Getenv("RATE_LIMIT_BACKEND"); backend {
+	case "", "memory":
+	case "redis":
+		client, err := db.GetRedisClient()
+		if err != nil {
+			// The limiter falls back to local limiting until Redis is reachable
+			log.Printf("Warning: Failed to connect to Redis for rate limiting: %v", err)
+		}
+		return NewRedisLimiter(client, "ratelimit:"+prefix, limit, time.Minute)
+	default:
+		log.Printf("Warning: Unknown RATE_LIMIT_BACKEND %q, using memory", backend)
+	}
+	return NewLimiter(limit, time.Minute)
+}
+
+// clientState tracks request timestamps for a single client within the rate-limit window.
+type clientState struct {
+	requests []time.Time
+	lastSeen time.Time
+}
+
+// Limiter is an in-memory per-client sliding-window rate limiter. Clients are
+// identified by an arbitrary key such as their IP address.
+type Limiter struct {
+	mu      sync.Mutex
+	clients map[string]*clientState
+	limit   int
+	window  time.Duration
+}
+
+// NewLimiter creates a limiter allowing limit requests per window for each client
+func NewLimiter(limit int, window time.Duration) *Limiter {
+	l := &Limiter{
+		clients: make(map[string]*clientState),
+		limit:   limit,
+		window:  window,
+	}
+	go l.cleanupLoop()
+	return l
+}
+
+// LimitPerMinuteFromEnv returns the per-client request limit configured via
+// RATE_LIMIT_PER_MIN, or DefaultLimitPerMinute
+func LimitPerMinuteFromEnv() int {
+	if v := os.Getenv("RATE_LIMIT_PER_MIN"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return DefaultLimitPerMinute
+}
+
+// Allow reports whether a request from the given client is within the limit
+// and, if so, records it.
+func (l *Limiter) Allow(key string) bool {
+	ok, _ := l.Check(key)
+	return ok
+}
+
+// Check is like Allow but also returns, for a rejected request, how long
+// until the client may retry
+func (l *Limiter) Check(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-l.window)
+
+	state, ok := l.clients[key]
+	if !ok {
+		state = &clientState{}
+		l.clients[key] = state
+	}
+
+	// Drop requests outside the current window.
+	valid := state.requests[:0]
+	for _, t := range state.requests {
+		if t.After(cutoff) {
+			valid = append(valid, t)
+		}
+	}
+	state.requests = valid
+	state.lastSeen = now
+
+	if len(state.requests) >= l.limit {
+		rejectionsTotal.Inc("ip")
+		return false, state.requests[0].Sub(cutoff)
+	}
+
+	state.requests = append(state.requests, now)
+	return true, 0
+}
+
+// cleanupLoop evicts clients that haven't been seen in two windows to prevent unbounded growth.
+func (l *Limiter) cleanupLoop() {
+	ticker := time.NewTicker(l.window * 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		l.mu.Lock()
+		cutoff := time.Now().Add(-2 * l.window)
+		for key, state := range l.clients {
+			if state.lastSeen.Before(cutoff) {
+				delete(l.clients, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
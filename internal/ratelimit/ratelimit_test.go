@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllow(t *testing.T) {
+	limiter := NewLimiter(2, time.Minute)
+
+	assert.True(t, limiter.Allow("1.2.3.4"))
+	assert.True(t, limiter.Allow("1.2.3.4"))
+	assert.False(t, limiter.Allow("1.2.3.4"))
+
+	// Clients are limited independently
+	assert.True(t, limiter.Allow("5.6.7.8"))
+}
+
+func TestAllowWindow(t *testing.T) {
+	limiter := NewLimiter(1, 50*time.Millisecond)
+
+	assert.True(t, limiter.Allow("client"))
+	assert.False(t, limiter.Allow("client"))
+
+	// Requests outside the window no longer count
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, limiter.Allow("client"))
+}
+
+func TestLimitPerMinuteFromEnv(t *testing.T) {
+	t.Setenv("RATE_LIMIT_PER_MIN", "")
+	assert.Equal(t, DefaultLimitPerMinute, LimitPerMinuteFromEnv())
+
+	t.Setenv("RATE_LIMIT_PER_MIN", "10")
+	assert.Equal(t, 10, LimitPerMinuteFromEnv())
+
+	t.Setenv("RATE_LIMIT_PER_MIN", "invalid")
+	assert.Equal(t, DefaultLimitPerMinute, LimitPerMinuteFromEnv())
+}
+
+func TestCheckRetryAfter(t *testing.T) {
+	limiter := NewLimiter(1, time.Minute)
+
+	ok, _ := limiter.Check("client")
+	assert.True(t, ok)
+	ok, retryAfter := limiter.Check("client")
+	assert.False(t, ok)
+	assert.InDelta(t, time.Minute.Seconds(), retryAfter.Seconds(), 1)
+}
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisRetryInterval is how long the Redis limiter falls back to local
+// limiting after Redis fails, before trying Redis again
+const redisRetryInterval = 10 * time.Second
+
+// slidingWindowScript atomically drops requests older than the window,
+// counts the rest and records the new request if it is within the limit.
+// It uses the Redis clock so instances with skewed clocks agree. It returns
+// whether the request is allowed and, if not, the milliseconds until it
+// would be.
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local member = ARGV[3]
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+if redis.call('ZCARD', key) < limit then
+	redis.call('ZADD', key, now, member)
+	redis.call('PEXPIRE', key, window)
+	return {1, 0}
+end
+
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+return {0, tonumber(oldest[2]) + window - now}
+`)
+
+// RedisLimiter is a sliding-window rate limiter whose state is kept in Redis,
+// so every instance behind a load balancer shares the same limit. While Redis
+// is unavailable it falls back to limiting each instance locally.
+type RedisLimiter struct {
+	client redis.Scripter
+	prefix string
+	limit  int
+	window time.Duration
+	local  *Limiter
+
+	// instance and seq make the members recorded for each request unique
+	instance string
+	seq      atomic.Uint64
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+// NewRedisLimiter creates a limiter allowing limit requests per window for
+// each client. Keys are stored under prefix, so separate limiters sharing a
+// Redis server don't count each other's requests.
+func NewRedisLimiter(client redis.Scripter, prefix string, limit int, window time.Duration) *RedisLimiter {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	return &RedisLimiter{
+		client:   client,
+		prefix:   prefix,
+		limit:    limit,
+		window:   window,
+		local:    NewLimiter(limit, window),
+		instance: hex.EncodeToString(id),
+	}
+}
+
+// Check reports whether a request from the given client is within the limit
+// and, if so, records it. A rejected request also gets how long until the
+// client may retry.
+func (l *RedisLimiter) Check(key string) (bool, time.Duration) {
+	if !l.available() {
+		return l.local.Check(key)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	member := fmt.Sprintf("%s-%d", l.instance, l.seq.Add(1))
+	result, err := slidingWindowScript.Run(ctx, l.client, []string{l.prefix + ":" + key},
+		l.window.Milliseconds(), l.limit, member).Int64Slice()
+	if err == nil && len(result) != 2 {
+		err = fmt.Errorf("unexpected script result %v", result)
+	}
+	if err != nil {
+		l.fail(err)
+		return l.local.Check(key)
+	}
+
+	if result[0] == 1 {
+		return true, 0
+	}
+	rejectionsTotal.Inc("ip")
+	return false, time.Duration(result[1]) * time.Millisecond
+}
+
+// available reports whether Redis should be used, or whether it failed
+// recently
+func (l *RedisLimiter) available() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Now().After(l.downUntil)
+}
+
+func (l *RedisLimiter) fail(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	log.Printf("Warning: Redis rate limiter unavailable, limiting locally for %v: %v", redisRetryInterval, err)
+	l.downUntil = time.Now().Add(redisRetryInterval)
+}
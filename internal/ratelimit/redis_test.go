@@ -0,0 +1,150 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeScripter emulates the sliding-window script in memory, standing in for
+// a Redis server shared by several limiters
+type fakeScripter struct {
+	mu       sync.Mutex
+	requests map[string][]time.Time
+	members  map[string]bool
+	keys     []string
+	err      error
+}
+
+func newFakeScripter() *fakeScripter {
+	return &fakeScripter{requests: make(map[string][]time.Time), members: make(map[string]bool)}
+}
+
+func (f *fakeScripter) run(ctx context.Context, keys []string, args ...interface{}) *redis.Cmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	cmd := redis.NewCmd(ctx)
+	if f.err != nil {
+		cmd.SetErr(f.err)
+		return cmd
+	}
+
+	key := keys[0]
+	window := time.Duration(args[0].(int64)) * time.Millisecond
+	limit := args[1].(int)
+	member := args[2].(string)
+	f.keys = append(f.keys, key)
+	if f.members[member] {
+		panic("duplicate member " + member)
+	}
+
+	now := time.Now()
+	var valid []time.Time
+	for _, t := range f.requests[key] {
+		if now.Sub(t) < window {
+			valid = append(valid, t)
+		}
+	}
+	f.requests[key] = valid
+
+	if len(valid) < limit {
+		f.requests[key] = append(valid, now)
+		f.members[member] = true
+		cmd.SetVal([]interface{}{int64(1), int64(0)})
+	} else {
+		cmd.SetVal([]interface{}{int64(0), valid[0].Add(window).Sub(now).Milliseconds()})
+	}
+	return cmd
+}
+
+func (f *fakeScripter) Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
+	return f.run(ctx, keys, args...)
+}
+
+func (f *fakeScripter) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd {
+	return f.run(ctx, keys, args...)
+}
+
+func (f *fakeScripter) EvalRO(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
+	return f.run(ctx, keys, args...)
+}
+
+func (f *fakeScripter) EvalShaRO(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd {
+	return f.run(ctx, keys, args...)
+}
+
+func (f *fakeScripter) ScriptExists(ctx context.Context, hashes ...string) *redis.BoolSliceCmd {
+	return redis.NewBoolSliceCmd(ctx)
+}
+
+func (f *fakeScripter) ScriptLoad(ctx context.Context, script string) *redis.StringCmd {
+	return redis.NewStringCmd(ctx)
+}
+
+func TestRedisLimiterShared(t *testing.T) {
+	server := newFakeScripter()
+
+	// Two instances share one limit
+	a := NewRedisLimiter(server, "ratelimit:http", 2, time.Minute)
+	b := NewRedisLimiter(server, "ratelimit:http", 2, time.Minute)
+
+	ok, _ := a.Check("1.2.3.4")
+	assert.True(t, ok)
+	ok, _ = b.Check("1.2.3.4")
+	assert.True(t, ok)
+	ok, retryAfter := a.Check("1.2.3.4")
+	assert.False(t, ok)
+	assert.InDelta(t, time.Minute.Seconds(), retryAfter.Seconds(), 1)
+
+	// Clients and prefixes are counted separately
+	ok, _ = b.Check("5.6.7.8")
+	assert.True(t, ok)
+	grpc := NewRedisLimiter(server, "ratelimit:grpc", 2, time.Minute)
+	ok, _ = grpc.Check("1.2.3.4")
+	assert.True(t, ok)
+	assert.Contains(t, server.keys, "ratelimit:grpc:1.2.3.4")
+}
+
+func TestRedisLimiterFallback(t *testing.T) {
+	server := newFakeScripter()
+	server.err = errors.New("connection refused")
+	limiter := NewRedisLimiter(server, "ratelimit:http", 1, time.Minute)
+
+	// Requests are limited locally while Redis is down
+	ok, _ := limiter.Check("1.2.3.4")
+	assert.True(t, ok)
+	ok, _ = limiter.Check("1.2.3.4")
+	assert.False(t, ok)
+
+	// Redis is not tried again until the retry interval has passed
+	server.err = nil
+	ok, _ = limiter.Check("5.6.7.8")
+	assert.True(t, ok)
+	assert.Empty(t, server.keys)
+
+	limiter.downUntil = time.Now().Add(-time.Second)
+	ok, _ = limiter.Check("5.6.7.8")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"ratelimit:http:5.6.7.8"}, server.keys)
+}
+
+func TestNewRateLimiterFromEnv(t *testing.T) {
+	t.Setenv("RATE_LIMIT_BACKEND", "")
+	assert.IsType(t, &Limiter{}, NewRateLimiterFromEnv("http"))
+
+	// An unreachable Redis still gives a Redis limiter, which limits locally
+	// until Redis comes up
+	t.Setenv("RATE_LIMIT_BACKEND", "redis")
+	t.Setenv("REDIS_HOST", "127.0.0.1")
+	t.Setenv("REDIS_PORT", "1")
+	limiter := NewRateLimiterFromEnv("http")
+	assert.IsType(t, &RedisLimiter{}, limiter)
+	ok, _ := limiter.Check("1.2.3.4")
+	assert.True(t, ok)
+}
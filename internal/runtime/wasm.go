@@ -12,6 +12,9 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/mstgnz/self-hosted-serverless/internal/common"
+	"github.com/mstgnz/self-hosted-serverless/internal/tracing"
+	"github.com/mstgnz/self-hosted-serverless/internal/usage"
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
 	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
@@ -32,6 +35,9 @@ type WasmRuntime struct {
 
 var instanceCounter atomic.Int64
 
+// wasmPageSize is the size of a page of WebAssembly linear memory
+const wasmPageSize = 65536
+
 // NewWasmRuntime creates a new WebAssembly runtime
 func NewWasmRuntime() (*WasmRuntime, error) {
 	ctx := context.Background()
@@ -48,11 +54,12 @@ func NewWasmRuntime() (*WasmRuntime, error) {
 	}, nil
 }
 
-// getCompiledModule returns a cached compiled module, or compiles and caches it.
-func (r *WasmRuntime) getCompiledModule(ctx context.Context, wasmFile string) (wazero.CompiledModule, error) {
+// getCompiledModule returns a cached compiled module, or compiles and caches
+// it, and reports whether it had to be compiled.
+func (r *WasmRuntime) getCompiledModule(ctx context.Context, wasmFile string) (_ wazero.CompiledModule, compiled bool, _ error) {
 	info, err := os.Stat(wasmFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read WebAssembly file: %w", err)
+		return nil, false, fmt.Errorf("failed to read WebAssembly file: %w", err)
 	}
 	modTime := info.ModTime()
 
@@ -61,40 +68,112 @@ func (r *WasmRuntime) getCompiledModule(ctx context.Context, wasmFile string) (w
 	r.mu.RUnlock()
 
 	if ok && cached.modTime.Equal(modTime) {
-		return cached.module, nil
+		return cached.module, false, nil
 	}
 
 	wasmBytes, err := os.ReadFile(wasmFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read WebAssembly file: %w", err)
+		return nil, true, fmt.Errorf("failed to read WebAssembly file: %w", err)
 	}
 
-	module, err := r.runtime.CompileModule(ctx, wasmBytes)
+	module, err := r.compile(ctx, wasmFile, wasmBytes)
 	if err != nil {
-		return nil, fmt.Errorf("failed to compile WebAssembly module: %w", err)
+		return nil, true, err
 	}
 
 	r.mu.Lock()
 	r.cache[wasmFile] = cachedModule{module: module, modTime: modTime}
 	r.mu.Unlock()
 
+	return module, true, nil
+}
+
+// compile compiles a module in a span of its own
+func (r *WasmRuntime) compile(ctx context.Context, wasmFile string, wasmBytes []byte) (wazero.CompiledModule, error) {
+	ctx, span := tracing.Start(ctx, "wasm.compile", tracing.SpanKindInternal)
+	defer span.End()
+	span.SetAttribute("wasm.module", filepath.Base(wasmFile))
+	span.SetAttribute("wasm.size_bytes", len(wasmBytes))
+
+	module, err := r.runtime.CompileModule(ctx, wasmBytes)
+	if err != nil {
+		err = fmt.Errorf("failed to compile WebAssembly module: %w", err)
+		span.SetError(err)
+		return nil, err
+	}
 	return module, nil
 }
 
+// Validate checks that code is a valid WebAssembly module
+func (r *WasmRuntime) Validate(code []byte) error {
+	ctx := context.Background()
+	module, err := r.runtime.CompileModule(ctx, code)
+	if err != nil {
+		return fmt.Errorf("failed to compile WebAssembly module: %w", err)
+	}
+	return module.Close(ctx)
+}
+
+// Compile compiles a module file and caches it, replacing any cached version
+// even if the file's modification time has not changed.
+func (r *WasmRuntime) Compile(wasmFile string) error {
+	info, err := os.Stat(wasmFile)
+	if err != nil {
+		return fmt.Errorf("failed to read WebAssembly file: %w", err)
+	}
+
+	wasmBytes, err := os.ReadFile(wasmFile)
+	if err != nil {
+		return fmt.Errorf("failed to read WebAssembly file: %w", err)
+	}
+
+	module, err := r.compile(context.Background(), wasmFile, wasmBytes)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.cache[wasmFile] = cachedModule{module: module, modTime: info.ModTime()}
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Evict removes a module from the compilation cache
+func (r *WasmRuntime) Evict(wasmFile string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.cache, wasmFile)
+}
+
 // ExecuteWASI runs a WASI command module using JSON-over-stdio for I/O.
 // The module reads its input as a JSON object from stdin and must write
 // its result as a JSON value to stdout before exiting with code 0.
 func (r *WasmRuntime) ExecuteWASI(wasmFile string, input map[string]any) (any, error) {
-	ctx := context.Background()
+	result, _, err := r.ExecuteWASIContext(context.Background(), wasmFile, input)
+	return result, err
+}
+
+// ExecuteWASIContext is ExecuteWASI with compilation and instantiation traced
+// as part of the trace of ctx. It also reports how the module was started:
+// whether it had to be compiled, and how long compiling and instantiating
+// took before _start ran. Every call creates an instance of its own. The
+// size its memory grew to is recorded on the usage meter of ctx, if any.
+func (r *WasmRuntime) ExecuteWASIContext(ctx context.Context, wasmFile string, input map[string]any) (any, common.StartReport, error) {
+	start := common.StartReport{InstanceCreated: true}
 
-	module, err := r.getCompiledModule(ctx, wasmFile)
+	inputJSON, err := json.Marshal(input)
 	if err != nil {
-		return nil, err
+		return nil, start, fmt.Errorf("failed to marshal input: %w", err)
 	}
 
-	inputJSON, err := json.Marshal(input)
+	initStart := time.Now()
+	module, compiled, err := r.getCompiledModule(ctx, wasmFile)
+	start.Compiled = compiled
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal input: %w", err)
+		start.InitDuration = time.Since(initStart)
+		return nil, start, err
 	}
 
 	var stdout bytes.Buffer
@@ -104,29 +183,48 @@ func (r *WasmRuntime) ExecuteWASI(wasmFile string, input map[string]any) (any, e
 		WithStdout(&stdout).
 		WithStderr(os.Stderr).
 		WithStdin(bytes.NewReader(inputJSON)).
-		WithName(instanceName)
+		WithName(instanceName).
+		// _start is called below, so instantiation can be timed on its own
+		WithStartFunctions()
 
-	// InstantiateModule runs _start automatically for WASI command modules.
-	// When the module calls proc_exit(0), wazero returns a *sys.ExitError with code 0.
-	_, err = r.runtime.InstantiateModule(ctx, module, config)
+	spanCtx, span := tracing.Start(ctx, "wasm.instantiate", tracing.SpanKindInternal)
+	span.SetAttribute("wasm.module", filepath.Base(wasmFile))
+	instance, err := r.runtime.InstantiateModule(spanCtx, module, config)
+	span.SetError(err)
+	span.End()
+	start.InitDuration = time.Since(initStart)
 	if err != nil {
-		var exitErr *sys.ExitError
-		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 0 {
-			return nil, fmt.Errorf("failed to execute WebAssembly module: %w", err)
+		return nil, start, fmt.Errorf("failed to instantiate WebAssembly module: %w", err)
+	}
+	defer instance.Close(ctx)
+
+	// Memory only grows, so its size once the module is done is its peak
+	if mem := instance.Memory(); mem != nil {
+		defer func() { usage.FromContext(ctx).ObserveMemoryPages(int64(mem.Size() / wasmPageSize)) }()
+	}
+
+	// WASI command modules do their work in _start. When the module calls
+	// proc_exit(0), wazero returns a *sys.ExitError with code 0.
+	if fn := instance.ExportedFunction("_start"); fn != nil {
+		if _, err := fn.Call(ctx); err != nil {
+			var exitErr *sys.ExitError
+			if !errors.As(err, &exitErr) || exitErr.ExitCode() != 0 {
+				return nil, start, fmt.Errorf("failed to execute WebAssembly module: %w", err)
+			}
+			// exit code 0 = normal completion
 		}
-		// exit code 0 = normal completion
 	}
 
 	if stdout.Len() == 0 {
-		return nil, nil
+		return nil, start, nil
 	}
 
 	var result any
 	if jsonErr := json.Unmarshal(stdout.Bytes(), &result); jsonErr != nil {
 		// Return raw string output if it isn't valid JSON.
-		return stdout.String(), nil
+		return stdout.String(), start, nil
 	}
-	return result, nil
+	return result, start, nil
 }
 
 // ExecuteFunction calls a named export directly with primitive numeric arguments.
@@ -134,7 +232,7 @@ func (r *WasmRuntime) ExecuteWASI(wasmFile string, input map[string]any) (any, e
 func (r *WasmRuntime) ExecuteFunction(wasmFile string, functionName string, args ...any) (any, error) {
 	ctx := context.Background()
 
-	module, err := r.getCompiledModule(ctx, wasmFile)
+	module, _, err := r.getCompiledModule(ctx, wasmFile)
 	if err != nil {
 		return nil, err
 	}
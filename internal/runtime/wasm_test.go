@@ -1,10 +1,12 @@
 package runtime
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/mstgnz/self-hosted-serverless/internal/usage"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -47,3 +49,117 @@ func TestExecuteFunction_InvalidWasm(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to compile WebAssembly module")
 }
+
+// TestValidate tests validating WebAssembly modules before they are deployed
+func TestValidate(t *testing.T) {
+	runtime, err := NewWasmRuntime()
+	assert.NoError(t, err)
+
+	// The smallest valid module: magic number and version
+	assert.NoError(t, runtime.Validate([]byte("\x00asm\x01\x00\x00\x00")))
+
+	err = runtime.Validate([]byte("invalid wasm content"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to compile WebAssembly module")
+}
+
+// wasiModule returns a WASI command module whose _start writes output to
+// stdout
+func wasiModule(output string) []byte {
+	uleb := func(n int) []byte {
+		var b []byte
+		for {
+			c := byte(n & 0x7f)
+			n >>= 7
+			if n != 0 {
+				c |= 0x80
+			}
+			b = append(b, c)
+			if n == 0 {
+				return b
+			}
+		}
+	}
+	name := func(s string) []byte { return append(uleb(len(s)), s...) }
+	section := func(id byte, content ...[]byte) []byte {
+		var body []byte
+		for _, c := range content {
+			body = append(body, c...)
+		}
+		return append(append([]byte{id}, uleb(len(body))...), body...)
+	}
+
+	// Memory starts with an iovec pointing at the output at offset 16, and
+	// fd_write stores the number of bytes written at offset 8
+	data := []byte{16, 0, 0, 0, byte(len(output)), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	data = append(data, output...)
+	code := []byte{
+		0x00,       // no locals
+		0x41, 0x01, // fd 1
+		0x41, 0x00, // iovec
+		0x41, 0x01, // one iovec
+		0x41, 0x08, // nwritten
+		0x10, 0x00, // call fd_write
+		0x1a, // drop
+		0x0b, // end
+	}
+
+	var module []byte
+	module = append(module, "\x00asm\x01\x00\x00\x00"...)
+	module = append(module, section(1, []byte{0x02, 0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x01, 0x7f, 0x60, 0x00, 0x00})...)
+	module = append(module, section(2, []byte{0x01}, name("wasi_snapshot_preview1"), name("fd_write"), []byte{0x00, 0x00})...)
+	module = append(module, section(3, []byte{0x01, 0x01})...)
+	module = append(module, section(5, []byte{0x01, 0x00, 0x01})...)
+	module = append(module, section(7, []byte{0x02}, name("memory"), []byte{0x02, 0x00}, name("_start"), []byte{0x00, 0x01})...)
+	module = append(module, section(10, []byte{0x01}, uleb(len(code)), code)...)
+	module = append(module, section(11, []byte{0x01, 0x00, 0x41, 0x00, 0x0b}, uleb(len(data)), data)...)
+	return module
+}
+
+// TestExecuteWASIStartReport tests that executions report whether the module
+// had to be compiled
+func TestExecuteWASIStartReport(t *testing.T) {
+	runtime, err := NewWasmRuntime()
+	assert.NoError(t, err)
+	defer runtime.Close()
+
+	wasmFile := filepath.Join(t.TempDir(), "hello.wasm")
+	assert.NoError(t, os.WriteFile(wasmFile, wasiModule(`{"ok":true}`), 0644))
+
+	result, start, err := runtime.ExecuteWASIContext(context.Background(), wasmFile, map[string]any{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{"ok": true}, result)
+	assert.True(t, start.Compiled)
+	assert.True(t, start.InstanceCreated)
+	assert.Positive(t, start.InitDuration)
+
+	// The compiled module is cached
+	result, start, err = runtime.ExecuteWASIContext(context.Background(), wasmFile, map[string]any{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{"ok": true}, result)
+	assert.False(t, start.Compiled)
+	assert.True(t, start.InstanceCreated)
+
+	// Modules compiled ahead of time, as on deploy, start warm
+	runtime.Evict(wasmFile)
+	assert.NoError(t, runtime.Compile(wasmFile))
+	_, start, err = runtime.ExecuteWASIContext(context.Background(), wasmFile, map[string]any{})
+	assert.NoError(t, err)
+	assert.False(t, start.Compiled)
+}
+
+// TestExecuteWASIMemoryUsage tests that executions report the memory of the
+// instance to the meter of their context
+func TestExecuteWASIMemoryUsage(t *testing.T) {
+	runtime, err := NewWasmRuntime()
+	assert.NoError(t, err)
+	defer runtime.Close()
+
+	wasmFile := filepath.Join(t.TempDir(), "hello.wasm")
+	assert.NoError(t, os.WriteFile(wasmFile, wasiModule(`{"ok":true}`), 0644))
+
+	meter := &usage.Meter{}
+	_, _, err = runtime.ExecuteWASIContext(usage.NewContext(context.Background(), meter), wasmFile, map[string]any{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), meter.Usage().MemoryPages)
+}
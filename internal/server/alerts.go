@@ -0,0 +1,41 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/alert"
+)
+
+// SetAlerts makes the server list the alerts of manager under /alerts
+func (s *Server) SetAlerts(manager *alert.Manager) {
+	s.alerts = manager
+}
+
+// handleAlerts lists the active and recently resolved alerts, and the rules
+// they come from
+func (s *Server) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.alerts == nil {
+		http.Error(w, "Alerting not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", alert.StatePending, alert.StateFiring, alert.StateResolved:
+	default:
+		http.Error(w, "Invalid state: must be pending, firing or resolved", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"alerts": s.alerts.Alerts(state),
+		"rules":  s.alerts.Rules(),
+	})
+}
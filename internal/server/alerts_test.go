@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/alert"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlertsEndpoint(t *testing.T) {
+	server := setupTestServer()
+
+	// Without alert rules the endpoint is disabled
+	req := httptest.NewRequest(http.MethodGet, "/alerts", nil)
+	w := httptest.NewRecorder()
+	server.handleAlerts(w, req)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+
+	manager, err := alert.NewManager(server.registry, nil, alert.Config{Rules: []alert.Rule{{
+		Name: "busy", Metric: "invocations", Op: ">=", Threshold: 1, WindowSecs: 60, Severity: alert.SeverityInfo,
+	}}})
+	require.NoError(t, err)
+	defer manager.Close()
+	server.SetAlerts(manager)
+
+	req = httptest.NewRequest(http.MethodPost, "/run/test-function", strings.NewReader(`{}`))
+	server.handleRunFunction(httptest.NewRecorder(), req)
+	manager.Evaluate(time.Now())
+
+	query := func(rawQuery string) (int, []alert.Alert, []alert.Rule) {
+		req := httptest.NewRequest(http.MethodGet, "/alerts?"+rawQuery, nil)
+		w := httptest.NewRecorder()
+		server.handleAlerts(w, req)
+		var body struct {
+			Alerts []alert.Alert `json:"alerts"`
+			Rules  []alert.Rule  `json:"rules"`
+		}
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		}
+		return w.Code, body.Alerts, body.Rules
+	}
+
+	code, alerts, rules := query("")
+	assert.Equal(t, http.StatusOK, code)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "test-function", alerts[0].Function)
+	assert.Equal(t, alert.StateFiring, alerts[0].State)
+	assert.Equal(t, float64(1), alerts[0].Value)
+	require.Len(t, rules, 1)
+	assert.Equal(t, "busy", rules[0].Name)
+
+	_, alerts, _ = query("state=resolved")
+	assert.Empty(t, alerts)
+
+	code, _, _ = query("state=silenced")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/logging"
+	"github.com/mstgnz/self-hosted-serverless/internal/tracing"
+)
+
+type clientIPKey struct{}
+
+// realIP returns the IP address of the client, resolved once per request by
+// accessLog so rate limiting, logs and functions all see the same address
+func (s *Server) realIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return s.clientIP.FromRequest(r)
+}
+
+// accessLog resolves the client IP, assigns every request an ID, which is
+// taken from the client's X-Request-ID header if it sent a valid one and
+// returned in the response, traces it as a continuation of the caller's
+// trace, if any, and writes an access log line and records request metrics
+// for it once it has been served
+func (s *Server) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ip := s.clientIP.FromRequest(r)
+		requestID := logging.RequestID(r.Header.Get(logging.RequestIDHeader))
+		w.Header().Set(logging.RequestIDHeader, requestID)
+
+		entry := &accessEntry{}
+		ctx := context.WithValue(r.Context(), clientIPKey{}, ip)
+		ctx = context.WithValue(ctx, accessEntryKey{}, entry)
+		ctx = logging.ContextWithRequestID(ctx, requestID)
+		ctx, span := tracing.Start(tracing.ExtractHeader(ctx, r.Header), "HTTP "+r.Method, tracing.SpanKindServer)
+		defer span.End()
+		span.SetAttribute("http.request.id", requestID)
+		r = r.WithContext(ctx)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		duration := time.Since(start)
+		observeRequest(r, rec.status, duration)
+		traceRequest(span, r, ip, rec.status)
+		logRequest(ctx, r, entry, ip, rec, duration)
+	})
+}
+
+// statusRecorder records the status code and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, which
+// streaming responses need to flush
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/clientip"
+	"github.com/mstgnz/self-hosted-serverless/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIPRateLimit(t *testing.T) {
+	server := setupTestServer()
+	server.limiter = ratelimit.NewLimiter(1, time.Minute)
+	handler := server.accessLog(server.public(server.handleListFunctions))
+
+	request := func(forwardedFor string) int {
+		req := httptest.NewRequest("GET", "/functions", nil)
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Without trusted proxies, a forged header doesn't dodge the limit
+	assert.Equal(t, http.StatusOK, request("1.1.1.1"))
+	assert.Equal(t, http.StatusTooManyRequests, request("2.2.2.2"))
+
+	// Behind a trusted proxy, each client gets its own limit
+	var err error
+	server.clientIP, err = clientip.NewResolver([]string{"192.0.2.0/24"}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, request("3.3.3.3"))
+	assert.Equal(t, http.StatusOK, request("4.4.4.4"))
+	assert.Equal(t, http.StatusTooManyRequests, request("4.4.4.4"))
+}
+
+func TestClientIPInput(t *testing.T) {
+	server := setupTestServer()
+	server.clientIP, _ = clientip.NewResolver([]string{"192.0.2.0/24"}, clientip.HeaderForwarded)
+	handler := server.accessLog(http.HandlerFunc(server.handleRunFunction))
+
+	req := httptest.NewRequest("POST", "/run/test-function", bytes.NewBufferString(`{"client_ip": "forged"}`))
+	req.Header.Set("Forwarded", "for=198.51.100.7")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	input := response["input"].(map[string]interface{})
+	assert.Equal(t, "198.51.100.7", input["client_ip"])
+}
+
+func TestAccessLogStatus(t *testing.T) {
+	server := setupTestServer()
+	handler := server.accessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "192.0.2.1", server.realIP(r))
+		w.WriteHeader(http.StatusTeapot)
+		// The recorder stays flushable for streaming responses
+		assert.NoError(t, http.NewResponseController(w).Flush())
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusTeapot, w.Code)
+}
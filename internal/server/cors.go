@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/cors"
+)
+
+// corsMiddleware adds CORS headers according to the policy for the request
+// and answers preflight requests
+func (s *Server) corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.corsPolicy(r).Handle(w, r) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// corsPolicy picks the policy for a request: the policy of a matching custom
+// route, then the policy in the manifest of the function it invokes, then the
+// server-wide policy
+func (s *Server) corsPolicy(r *http.Request) *cors.Policy {
+	if name, ok := strings.CutPrefix(r.URL.Path, "/run/"); ok {
+		name, _, _ = strings.Cut(name, "/")
+		return s.functionCORS(name)
+	}
+
+	// Preflight requests name the method of the request that will follow
+	method := r.Method
+	if requested := r.Header.Get("Access-Control-Request-Method"); method == http.MethodOptions && requested != "" {
+		method = requested
+	}
+	route, _, err := s.router.Match(method, r.URL.Path)
+	if err != nil {
+		return s.cors
+	}
+	if route.CORS != nil {
+		return route.CORS
+	}
+	return s.functionCORS(route.Function)
+}
+
+func (s *Server) functionCORS(name string) *cors.Policy {
+	if info, ok := s.registry.GetFunctionInfo(name); ok && info.CORS != nil {
+		return info.CORS
+	}
+	return s.cors
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/common"
+	"github.com/mstgnz/self-hosted-serverless/internal/cors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCORSPolicyOverrides(t *testing.T) {
+	server := setupTestServer()
+	server.cors = &cors.Policy{AllowedOrigins: []string{"https://admin.example.com"}}
+
+	info := common.FunctionInfo{
+		Name:    "widget",
+		Runtime: "go",
+		CORS:    &cors.Policy{AllowedOrigins: []string{"https://*.widgets.example.com"}},
+	}
+	server.registry.Register(info.Name, &MockFunctionHandler{}, info)
+	require.NoError(t, server.router.Add(Route{Method: "GET", Path: "/api/widgets", Function: "widget", Public: true}))
+	require.NoError(t, server.router.Add(Route{
+		Method:   "POST",
+		Path:     "/api/orders",
+		Function: "test-function",
+		Public:   true,
+		CORS:     &cors.Policy{AllowedOrigins: []string{"https://shop.example.com"}, AllowCredentials: true},
+	}))
+	// A route can't allow credentials for any origin
+	require.Error(t, server.router.Add(Route{
+		Method:   "POST",
+		Path:     "/api/carts",
+		Function: "test-function",
+		CORS:     &cors.Policy{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+	}))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		origin string
+		want   string
+	}{
+		{"server policy", http.MethodGet, "/db", "https://admin.example.com", "https://admin.example.com"},
+		{"server policy rejects", http.MethodGet, "/db", "https://shop.example.com", ""},
+		{"function policy", http.MethodPost, "/run/widget", "https://a.widgets.example.com", "https://a.widgets.example.com"},
+		{"function policy rejects", http.MethodPost, "/run/widget", "https://admin.example.com", ""},
+		{"function without policy", http.MethodPost, "/run/test-function", "https://admin.example.com", "https://admin.example.com"},
+		{"route inherits function policy", http.MethodGet, "/api/widgets", "https://a.widgets.example.com", "https://a.widgets.example.com"},
+		{"route policy", http.MethodPost, "/api/orders", "https://shop.example.com", "https://shop.example.com"},
+		{"route policy rejects", http.MethodPost, "/api/orders", "https://admin.example.com", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			w := httptest.NewRecorder()
+			server.corsPolicy(req).Handle(w, req)
+			assert.Equal(t, tt.want, w.Header().Get("Access-Control-Allow-Origin"))
+		})
+	}
+}
+
+func TestCORSMiddlewarePreflight(t *testing.T) {
+	server := setupTestServer()
+	server.cors = &cors.Policy{AllowedOrigins: []string{"*"}}
+	require.NoError(t, server.router.Add(Route{
+		Method:   "POST",
+		Path:     "/api/orders",
+		Function: "test-function",
+		CORS:     &cors.Policy{AllowedOrigins: []string{"https://shop.example.com"}, AllowCredentials: true, MaxAgeSecs: 60},
+	}))
+
+	called := false
+	handler := server.corsMiddleware(func(w http.ResponseWriter, r *http.Request) { called = true })
+
+	// The preflight is matched against the route of the method it asks for
+	req := httptest.NewRequest(http.MethodOptions, "/api/orders", nil)
+	req.Header.Set("Origin", "https://shop.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	assert.False(t, called)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://shop.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "60", w.Header().Get("Access-Control-Max-Age"))
+
+	req = httptest.NewRequest(http.MethodPost, "/api/orders", nil)
+	req.Header.Set("Origin", "https://shop.example.com")
+	w = httptest.NewRecorder()
+	handler(w, req)
+	assert.True(t, called)
+}
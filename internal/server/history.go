@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/mstgnz/self-hosted-serverless/internal/function"
+	"github.com/mstgnz/self-hosted-serverless/internal/history"
+)
+
+// execOptions describes a request's execution for the invocation history and
+// tracing. Requests to custom routes, which have path parameters, are
+// triggered by the route rather than by /run.
+func (s *Server) execOptions(r *http.Request, params map[string]string) []function.ExecOption {
+	trigger := function.TriggerHTTP
+	if params != nil {
+		trigger = function.TriggerRoute
+	}
+	opts := []function.ExecOption{
+		function.WithContext(r.Context()),
+		function.WithTrigger(trigger),
+		function.WithCaller(s.caller(r)),
+	}
+	if id, ok := auth.FromContext(r.Context()); ok && id.KeyID != "" {
+		opts = append(opts, function.WithKeyID(id.KeyID))
+	}
+	return opts
+}
+
+// caller names the client of a request: the subject of its identity, or its
+// IP when it is anonymous
+func (s *Server) caller(r *http.Request) string {
+	if id, ok := auth.FromContext(r.Context()); ok && id.Subject != "" {
+		return id.Subject
+	}
+	return s.realIP(r)
+}
+
+// handleInvocations lists recorded executions, newest first
+func (s *Server) handleInvocations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	store := s.registry.History()
+	if store == nil {
+		http.Error(w, "Invocation history not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := history.Filter{
+		Function: query.Get("function"),
+		Outcome:  query.Get("status"),
+		Trigger:  query.Get("trigger"),
+		Caller:   query.Get("caller"),
+		KeyID:    query.Get("key_id"),
+	}
+	switch filter.Outcome {
+	case "", history.OutcomeSuccess, history.OutcomeError, history.OutcomeTimeout:
+	default:
+		http.Error(w, "Invalid status: must be success, error or timeout", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	var err error
+	if filter.Since, err = parseTime(query.Get("since"), now); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid since: %v", err), http.StatusBadRequest)
+		return
+	}
+	if filter.Until, err = parseTime(query.Get("until"), now); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid until: %v", err), http.StatusBadRequest)
+		return
+	}
+	if value := query.Get("limit"); value != "" {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil || filter.Limit <= 0 || filter.Limit > history.MaxQueryLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit: must be between 1 and %d", history.MaxQueryLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	records, err := store.Query(filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error querying invocation history: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if records == nil {
+		records = []history.Record{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"invocations": records})
+}
+
+// parseTime parses an RFC 3339 time, or a duration such as 1h meaning that
+// long before now. An empty value is the zero time.
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("duration %s must not be negative", value)
+		}
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("must be an RFC 3339 time or a duration such as 1h")
+	}
+	return t, nil
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/mstgnz/self-hosted-serverless/internal/history"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvocationsEndpoint(t *testing.T) {
+	server := setupTestServer()
+
+	// Without a store the endpoint is disabled
+	req := httptest.NewRequest(http.MethodGet, "/invocations", nil)
+	w := httptest.NewRecorder()
+	server.handleInvocations(w, req)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	store, err := history.NewStore(db, history.DefaultConfig())
+	require.NoError(t, err)
+	server.registry.SetHistory(store)
+
+	// A call through /run by an authenticated client
+	req = httptest.NewRequest(http.MethodPost, "/run/test-function", strings.NewReader(`{"a":1}`))
+	req = req.WithContext(auth.NewContext(req.Context(), auth.Identity{Subject: "billing", Scopes: []string{auth.ScopeInvoke}}))
+	server.handleRunFunction(httptest.NewRecorder(), req)
+
+	// A call through a custom route by an anonymous client
+	require.NoError(t, server.router.Add(Route{Method: "POST", Path: "/hooks/{id}", Function: "test-function", Public: true}))
+	req = httptest.NewRequest(http.MethodPost, "/hooks/42", strings.NewReader(`{}`))
+	req.RemoteAddr = "203.0.113.7:1234"
+	server.handleRoute(httptest.NewRecorder(), req)
+
+	// Wait for the records to be written
+	require.NoError(t, store.Close())
+
+	query := func(rawQuery string) (int, []history.Record) {
+		req := httptest.NewRequest(http.MethodGet, "/invocations?"+rawQuery, nil)
+		w := httptest.NewRecorder()
+		server.handleInvocations(w, req)
+		var body struct {
+			Invocations []history.Record `json:"invocations"`
+		}
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		}
+		return w.Code, body.Invocations
+	}
+
+	code, records := query("function=test-function")
+	assert.Equal(t, http.StatusOK, code)
+	require.Len(t, records, 2)
+	assert.Equal(t, "route", records[0].Trigger)
+	assert.Equal(t, "203.0.113.7", records[0].Caller)
+	assert.Equal(t, "http", records[1].Trigger)
+	assert.Equal(t, "billing", records[1].Caller)
+	assert.Equal(t, history.OutcomeSuccess, records[1].Outcome)
+	assert.Positive(t, records[1].InputBytes)
+	assert.Positive(t, records[1].OutputBytes)
+
+	_, records = query("caller=billing&since=1h&limit=10")
+	assert.Len(t, records, 1)
+
+	_, records = query("status=error")
+	assert.Empty(t, records)
+
+	_, records = query("until=" + time.Now().Add(-time.Hour).Format(time.RFC3339))
+	assert.Empty(t, records)
+
+	for _, bad := range []string{"status=failed", "since=yesterday", "until=-1h", "limit=0", "limit=5000"} {
+		code, _ = query(bad)
+		assert.Equal(t, http.StatusBadRequest, code, bad)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	parsed, err := parseTime("", now)
+	assert.NoError(t, err)
+	assert.True(t, parsed.IsZero())
+
+	parsed, err = parseTime("90m", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(-90*time.Minute), parsed)
+
+	parsed, err = parseTime("2024-05-01T10:00:00Z", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(-2*time.Hour), parsed)
+
+	_, err = parseTime("tomorrow", now)
+	assert.Error(t, err)
+}
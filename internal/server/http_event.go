@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/mstgnz/self-hosted-serverless/internal/common"
+	"github.com/mstgnz/self-hosted-serverless/internal/event"
+)
+
+// maxRequestBody is the maximum accepted request body size
+const maxRequestBody = 1 << 20 // 1 MB
+
+// runHTTPEventFunction executes a function in HTTP event mode: the function
+// receives the full request as an envelope and its response envelope is
+// written back to the client as-is.
+func (s *Server) runHTTPEventFunction(w http.ResponseWriter, r *http.Request, name string, params map[string]string, maxBody int64) {
+	req, err := newHTTPRequestEnvelope(w, r, maxBody)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.PathParams = params
+	req.ClientIP = s.realIP(r)
+
+	input, err := req.ToMap()
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.registry.Execute(name, input, s.execOptions(r, params)...)
+	if err != nil {
+		writeExecutionError(w, name, err)
+		return
+	}
+
+	resp, err := common.ParseHTTPResponse(result)
+	if err != nil {
+		log.Printf("Function %s returned an invalid response: %v", name, err)
+		http.Error(w, "Invalid function response", http.StatusBadGateway)
+		return
+	}
+
+	s.eventBus.Publish(r.Context(), event.Event{
+		Type: "function.executed",
+		Payload: map[string]any{
+			"function": name,
+			"input":    input,
+			"result":   result,
+		},
+	})
+
+	if err := writeHTTPResponseEnvelope(w, resp); err != nil {
+		log.Printf("Function %s returned an invalid response: %v", name, err)
+		http.Error(w, "Invalid function response", http.StatusBadGateway)
+	}
+}
+
+// credentialHeaders are withheld from functions, which get the verified
+// identity instead of the caller's secrets
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "X-API-Key"}
+
+// newHTTPRequestEnvelope builds the request envelope passed to a function.
+// Bodies that are not valid UTF-8 are base64 encoded.
+func newHTTPRequestEnvelope(w http.ResponseWriter, r *http.Request, maxBody int64) (common.HTTPRequest, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err != nil {
+		return common.HTTPRequest{}, err
+	}
+
+	req := common.HTTPRequest{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.Query(),
+		Headers: r.Header.Clone(),
+	}
+	if req.Headers == nil {
+		req.Headers = make(map[string][]string)
+	}
+	for _, key := range credentialHeaders {
+		http.Header(req.Headers).Del(key)
+	}
+	if r.Host != "" {
+		req.Headers["Host"] = []string{r.Host}
+	}
+	if id, ok := auth.FromContext(r.Context()); ok {
+		req.Identity = id.Map()
+	}
+
+	if utf8.Valid(body) {
+		req.Body = string(body)
+	} else {
+		req.Body = base64.StdEncoding.EncodeToString(body)
+		req.IsBase64Encoded = true
+	}
+
+	return req, nil
+}
+
+// writeHTTPResponseEnvelope writes a function's response envelope to the client.
+// It returns an error, without writing anything, if the envelope is invalid.
+func writeHTTPResponseEnvelope(w http.ResponseWriter, resp common.HTTPResponse) error {
+	body, err := resp.DecodedBody()
+	if err != nil {
+		return fmt.Errorf("failed to decode body: %w", err)
+	}
+
+	status := resp.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	// Informational statuses aren't final responses
+	if status < 200 || status > 599 {
+		return fmt.Errorf("invalid status code %d", status)
+	}
+
+	for key, values := range resp.Headers {
+		w.Header().Del(key)
+		for _, v := range values {
+			w.Header().Add(key, v)
+		}
+	}
+
+	w.WriteHeader(status)
+	w.Write(body)
+	return nil
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/mstgnz/self-hosted-serverless/internal/logging"
+	"github.com/mstgnz/self-hosted-serverless/internal/ratelimit"
+)
+
+// keyInfo is the public view of an API key; the hash is never returned
+type keyInfo struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Scopes    []string          `json:"scopes"`
+	Functions []string          `json:"functions,omitempty"`
+	Limits    *ratelimit.Limits `json:"limits,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt *time.Time        `json:"expires_at,omitempty"`
+}
+
+func newKeyInfo(k auth.Key) keyInfo {
+	return keyInfo{
+		ID:        k.ID,
+		Name:      k.Name,
+		Scopes:    k.Scopes,
+		Functions: k.Functions,
+		Limits:    k.Limits,
+		CreatedAt: k.CreatedAt,
+		ExpiresAt: k.ExpiresAt,
+	}
+}
+
+// handleKeys lists (GET) and creates (POST) API keys
+func (s *Server) handleKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := s.auth.ListKeys()
+		if err != nil {
+			writeKeyError(w, err)
+			return
+		}
+
+		infos := make([]keyInfo, 0, len(keys))
+		for _, k := range keys {
+			infos = append(infos, newKeyInfo(k))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": infos,
+		})
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+		var req keyInfo
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		key, secret, err := s.auth.CreateKey(auth.Key{
+			Name:      req.Name,
+			Scopes:    req.Scopes,
+			Functions: req.Functions,
+			Limits:    req.Limits,
+			ExpiresAt: req.ExpiresAt,
+		})
+		s.auditResult(r, logging.ActionKeyCreate, key.ID, err)
+		if err != nil {
+			writeKeyError(w, err)
+			return
+		}
+		writeKeySecret(w, http.StatusCreated, key, secret)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleKey revokes a key (DELETE /keys/{id}) or rotates its secret
+// (POST /keys/{id}/rotate)
+func (s *Server) handleKey(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/keys/"), "/")
+	if id == "" {
+		http.Error(w, "Key ID is required", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodDelete:
+		err := s.auth.RevokeKey(id)
+		s.auditResult(r, logging.ActionKeyRevoke, id, err)
+		if err != nil {
+			writeKeyError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "revoked"})
+	case action == "rotate" && r.Method == http.MethodPost:
+		key, secret, err := s.auth.RotateKey(id)
+		s.auditResult(r, logging.ActionKeyRotate, id, err)
+		if err != nil {
+			writeKeyError(w, err)
+			return
+		}
+		writeKeySecret(w, http.StatusOK, key, secret)
+	case action == "" || action == "rotate":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeKeySecret returns a key with its secret, which is shown only once
+func writeKeySecret(w http.ResponseWriter, status int, key auth.Key, secret string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"key":    newKeyInfo(key),
+		"secret": secret,
+	})
+}
+
+func writeKeyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidKey):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, auth.ErrKeyNotFound):
+		http.Error(w, "Key not found", http.StatusNotFound)
+	case errors.Is(err, auth.ErrNoKeyStore):
+		http.Error(w, "Key store not configured", http.StatusNotImplemented)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleKeys(t *testing.T) {
+	server := setupTestServer()
+
+	// Keys can't be managed without a store
+	req := httptest.NewRequest("GET", "/keys", nil)
+	w := httptest.NewRecorder()
+	server.handleKeys(w, req)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+
+	store, err := auth.NewFileKeyStore(filepath.Join(t.TempDir(), "keys.json"))
+	assert.NoError(t, err)
+	server.auth = auth.NewAuthenticator("", store)
+
+	req = httptest.NewRequest("POST", "/keys", bytes.NewBufferString(`{"name": "ci", "scopes": ["root"]}`))
+	w = httptest.NewRecorder()
+	server.handleKeys(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req = httptest.NewRequest("POST", "/keys", bytes.NewBufferString(`{"name": "ci", "scopes": ["invoke"], "functions": ["test-function"]}`))
+	w = httptest.NewRecorder()
+	server.handleKeys(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var created struct {
+		Key    keyInfo `json:"key"`
+		Secret string  `json:"secret"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "ci", created.Key.Name)
+	assert.NotEmpty(t, created.Secret)
+
+	// Listing never returns secrets or hashes
+	req = httptest.NewRequest("GET", "/keys", nil)
+	w = httptest.NewRecorder()
+	server.handleKeys(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), created.Secret)
+	assert.NotContains(t, w.Body.String(), "hash")
+
+	// Rotation returns a new secret
+	req = httptest.NewRequest("POST", "/keys/"+created.Key.ID+"/rotate", nil)
+	w = httptest.NewRecorder()
+	server.handleKey(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), created.Secret)
+
+	req = httptest.NewRequest("DELETE", "/keys/"+created.Key.ID, nil)
+	w = httptest.NewRecorder()
+	server.handleKey(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	server.handleKey(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestKeyScopes(t *testing.T) {
+	server := setupTestServer()
+	store, err := auth.NewFileKeyStore(filepath.Join(t.TempDir(), "keys.json"))
+	assert.NoError(t, err)
+	server.auth = auth.NewAuthenticator("", store)
+
+	_, secret, err := server.auth.CreateKey(auth.Key{
+		Name:      "ci",
+		Scopes:    []string{auth.ScopeInvoke},
+		Functions: []string{"test-function"},
+	})
+	assert.NoError(t, err)
+
+	run := server.protected(auth.ScopeInvoke, server.handleRunFunction)
+
+	// The key may invoke the functions it lists, and its identity is passed in
+	req := httptest.NewRequest("POST", "/run/test-function", bytes.NewBufferString(`{}`))
+	req.Header.Set("X-API-Key", secret)
+	w := httptest.NewRecorder()
+	run(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	identity := response["input"].(map[string]interface{})["identity"].(map[string]interface{})
+	assert.Equal(t, "ci", identity["subject"])
+
+	// Other functions are forbidden
+	req = httptest.NewRequest("POST", "/run/other-function", bytes.NewBufferString(`{}`))
+	req.Header.Set("X-API-Key", secret)
+	w = httptest.NewRecorder()
+	run(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// So are endpoints requiring other scopes
+	req = httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("X-API-Key", secret)
+	w = httptest.NewRecorder()
+	server.protected(auth.ScopeMetricsRead, server.handleGetMetrics)(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
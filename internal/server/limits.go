@@ -0,0 +1,51 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/ratelimit"
+)
+
+// writeTooManyRequests rejects a request that exceeded a limit, telling the
+// client when to retry
+func writeTooManyRequests(w http.ResponseWriter, err *ratelimit.LimitError) {
+	w.Header().Set("Retry-After", strconv.Itoa(err.RetryAfterSecs()))
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+}
+
+// writeExecutionError writes the error of a failed function execution. A
+// function over its limits gets 429, any other failure 500.
+func writeExecutionError(w http.ResponseWriter, name string, err error) {
+	var limitErr *ratelimit.LimitError
+	if errors.As(err, &limitErr) {
+		writeTooManyRequests(w, limitErr)
+		return
+	}
+	log.Printf("Error executing function %s: %v", name, err)
+	http.Error(w, fmt.Sprintf("Error executing function: %v", err), http.StatusInternalServerError)
+}
+
+// limitMiddleware admits a request under the named limits, queueing or
+// rejecting it when they are exceeded
+func (s *Server) limitMiddleware(name string, limits *ratelimit.Limits, next http.HandlerFunc) http.HandlerFunc {
+	if limits == nil || limits.IsZero() {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		release, err := s.limits.Acquire(r.Context(), name, *limits)
+		if err != nil {
+			var limitErr *ratelimit.LimitError
+			if errors.As(err, &limitErr) {
+				writeTooManyRequests(w, limitErr)
+			}
+			// Otherwise the client went away while queued
+			return
+		}
+		defer release()
+		next(w, r)
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/mstgnz/self-hosted-serverless/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteLimits(t *testing.T) {
+	server := setupTestServer()
+	server.limits = ratelimit.NewManager()
+
+	limits := &ratelimit.Limits{Requests: 1, IntervalSecs: 30}
+	assert.NoError(t, server.router.Add(Route{Method: "POST", Path: "/api/limited", Function: "test-function", Public: true, Limits: limits}))
+	assert.ErrorIs(t, server.router.Add(Route{Path: "/api/bad", Function: "f", Limits: &ratelimit.Limits{Concurrency: -1}}), errInvalidRoute)
+
+	req := httptest.NewRequest("POST", "/api/limited", bytes.NewBufferString(`{}`))
+	w := httptest.NewRecorder()
+	server.handleRoute(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req = httptest.NewRequest("POST", "/api/limited", bytes.NewBufferString(`{}`))
+	w = httptest.NewRecorder()
+	server.handleRoute(w, req)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+
+	stats := server.limits.Stats()["route:POST /api/limited"]
+	assert.Equal(t, int64(1), stats.Allowed)
+	assert.Equal(t, int64(1), stats.Rejected)
+}
+
+func TestKeyLimits(t *testing.T) {
+	server := setupTestServer()
+	server.limits = ratelimit.NewManager()
+
+	store, err := auth.NewFileKeyStore(filepath.Join(t.TempDir(), "keys.json"))
+	assert.NoError(t, err)
+	server.auth = auth.NewAuthenticator("", store)
+	key, secret, err := server.auth.CreateKey(auth.Key{
+		Name:   "limited",
+		Scopes: []string{auth.ScopeInvoke},
+		Limits: &ratelimit.Limits{Requests: 1},
+	})
+	assert.NoError(t, err)
+
+	handler := server.protected(auth.ScopeInvoke, server.handleRunFunction)
+	run := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/run/test-function", bytes.NewBufferString(`{}`))
+		req.Header.Set("X-API-Key", secret)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, run().Code)
+	w := run()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Equal(t, int64(1), server.limits.Stats()["key:"+key.ID].Rejected)
+}
+
+func TestFunctionLimits(t *testing.T) {
+	server := setupTestServer()
+	assert.NoError(t, server.registry.SetLimits("test-function", ratelimit.Limits{Requests: 1, IntervalSecs: 5}))
+
+	run := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/run/test-function", bytes.NewBufferString(`{}`))
+		w := httptest.NewRecorder()
+		server.handleRunFunction(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusOK, run().Code)
+	w := run()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+
+	// Limits are reported alongside the metrics
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	w = httptest.NewRecorder()
+	server.handleGetMetrics(w, req)
+	var response struct {
+		Limits map[string]ratelimit.Stats `json:"limits"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, ratelimit.Limits{Requests: 1, IntervalSecs: 5}, response.Limits["function:test-function"].Limits)
+	assert.Equal(t, int64(1), response.Limits["function:test-function"].Rejected)
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	server := setupTestServer()
+	server.limiter = ratelimit.NewLimiter(1, time.Minute)
+
+	handler := server.public(server.handleListFunctions)
+	req := httptest.NewRequest("GET", "/functions", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/mstgnz/self-hosted-serverless/internal/logging"
+)
+
+type accessEntryKey struct{}
+
+// accessEntry collects what the access log line of a request reports but
+// only inner handlers learn, such as who the client is
+type accessEntry struct {
+	identity      auth.Identity
+	authenticated bool
+}
+
+// setAccessIdentity records the client identity for the access log
+func setAccessIdentity(r *http.Request, id auth.Identity) {
+	if entry, ok := r.Context().Value(accessEntryKey{}).(*accessEntry); ok {
+		entry.identity = id
+		entry.authenticated = true
+	}
+}
+
+// logRequest writes the access log line of a served request
+func logRequest(ctx context.Context, r *http.Request, entry *accessEntry, ip string, rec *statusRecorder, duration time.Duration) {
+	attrs := []slog.Attr{
+		slog.String("protocol", "http"),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", rec.status),
+		slog.Int64("bytes", rec.bytes),
+		slog.Float64("duration_ms", float64(duration.Microseconds())/1000),
+		slog.String("client_ip", ip),
+	}
+	if r.Pattern != "" {
+		attrs = append(attrs, slog.String("route", r.Pattern))
+	}
+	if ua := r.UserAgent(); ua != "" {
+		attrs = append(attrs, slog.String("user_agent", ua))
+	}
+	if entry.authenticated {
+		attrs = append(attrs, slog.String("subject", entry.identity.Subject))
+		if entry.identity.KeyID != "" {
+			attrs = append(attrs, slog.String("key_id", entry.identity.KeyID))
+		}
+	}
+
+	level := slog.LevelInfo
+	if rec.status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	slog.LogAttrs(ctx, level, "request", attrs...)
+}
+
+// audit records an event in the audit log, attributed to the client of r
+func (s *Server) audit(r *http.Request, event logging.AuditEvent) {
+	if id, ok := auth.FromContext(r.Context()); ok {
+		event.Actor = id.Subject
+		event.KeyID = id.KeyID
+	}
+	event.ClientIP = s.realIP(r)
+	event.Protocol = "http"
+	logging.Audit(r.Context(), event)
+}
+
+// auditDenied records a request rejected by authentication or authorization
+func (s *Server) auditDenied(r *http.Request, reason string) {
+	s.audit(r, logging.AuditEvent{
+		Action:  logging.ActionAuthFailure,
+		Outcome: logging.OutcomeDenied,
+		Target:  r.Method + " " + r.URL.Path,
+		Reason:  reason,
+	})
+}
+
+// auditQuery records a /db query. Its arguments are left out, as they may
+// hold personal data; only their number is recorded.
+func (s *Server) auditQuery(r *http.Request, query string, args int, outcome, reason string) {
+	s.audit(r, logging.AuditEvent{
+		Action:  logging.ActionDBQuery,
+		Outcome: outcome,
+		Reason:  reason,
+		Details: map[string]any{"query": query, "args": args},
+	})
+}
+
+// auditResult records an admin action that succeeded, or failed with err
+func (s *Server) auditResult(r *http.Request, action, target string, err error) {
+	event := logging.AuditEvent{Action: action, Outcome: logging.OutcomeSuccess, Target: target}
+	if err != nil {
+		event.Outcome = logging.OutcomeFailure
+		event.Reason = err.Error()
+	}
+	s.audit(r, event)
+}
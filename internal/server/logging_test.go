@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/mstgnz/self-hosted-serverless/internal/db"
+	"github.com/mstgnz/self-hosted-serverless/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	// Keep the tests from writing an audit log next to the package
+	logging.SetGlobalAuditLogger(nil)
+	os.Exit(m.Run())
+}
+
+// captureLogs makes the default logger write JSON to the returned buffer
+// until the test ends
+func captureLogs(t *testing.T) *bytes.Buffer {
+	prev, prevWriter, prevFlags := slog.Default(), log.Writer(), log.Flags()
+	t.Cleanup(func() {
+		slog.SetDefault(prev)
+		log.SetOutput(prevWriter)
+		log.SetFlags(prevFlags)
+	})
+
+	var buf bytes.Buffer
+	slog.SetDefault(logging.NewLogger(&buf, logging.DefaultConfig()))
+	return &buf
+}
+
+// captureAudit makes the global audit log write to the returned buffer until
+// the test ends
+func captureAudit(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	logging.SetGlobalAuditLogger(logging.NewAuditLogger(&buf))
+	t.Cleanup(func() { logging.SetGlobalAuditLogger(nil) })
+	return &buf
+}
+
+// decodeLines decodes each line of JSON in r
+func decodeLines[T any](t *testing.T, r io.Reader) []T {
+	var values []T
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var v T
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &v))
+		values = append(values, v)
+	}
+	return values
+}
+
+func TestAccessLog(t *testing.T) {
+	logs := captureLogs(t)
+	server := setupTestServer()
+	server.auth = auth.NewAuthenticator("secret", nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/functions", server.protected("", server.handleListFunctions))
+	handler := server.accessLog(mux)
+
+	// A request ID is generated for requests without one
+	req := httptest.NewRequest(http.MethodGet, "/functions", nil)
+	req.Header.Set("X-API-Key", "secret")
+	req.Header.Set("User-Agent", "test-agent")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	generated := w.Header().Get("X-Request-ID")
+	assert.Len(t, generated, 32)
+
+	// A valid request ID sent by the client is kept
+	req = httptest.NewRequest(http.MethodGet, "/functions", nil)
+	req.Header.Set("X-Request-ID", "client-id-1")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "client-id-1", w.Header().Get("X-Request-ID"))
+
+	var lines []map[string]any
+	for _, line := range decodeLines[map[string]any](t, logs) {
+		if line["msg"] == "request" {
+			lines = append(lines, line)
+		}
+	}
+	require.Len(t, lines, 2)
+	assert.Equal(t, generated, lines[0]["request_id"])
+	assert.Equal(t, "http", lines[0]["protocol"])
+	assert.Equal(t, "GET", lines[0]["method"])
+	assert.Equal(t, "/functions", lines[0]["path"])
+	assert.Equal(t, "/functions", lines[0]["route"])
+	assert.Equal(t, float64(http.StatusOK), lines[0]["status"])
+	assert.Equal(t, float64(w.Body.Len()), lines[1]["bytes"])
+	assert.Equal(t, "192.0.2.1", lines[0]["client_ip"])
+	assert.Equal(t, "test-agent", lines[0]["user_agent"])
+	assert.Equal(t, "default", lines[0]["subject"])
+	assert.Contains(t, lines[0], "duration_ms")
+	assert.Equal(t, "client-id-1", lines[1]["request_id"])
+	assert.NotContains(t, lines[1], "subject")
+}
+
+func TestAuditLog(t *testing.T) {
+	audit := captureAudit(t)
+	server := setupTestServer()
+	server.auth = auth.NewAuthenticator("secret", nil)
+	server.dbService = &db.Service{}
+	handler := server.accessLog(http.HandlerFunc(server.protected(auth.ScopeDBRead, server.handleDatabaseQuery)))
+
+	// An auth failure
+	req := httptest.NewRequest(http.MethodPost, "/db", strings.NewReader(`{"query": "SELECT 1"}`))
+	req.Header.Set("X-API-Key", "wrong")
+	req.Header.Set("X-Request-ID", "req-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// A rejected query, with its arguments left out
+	req = httptest.NewRequest(http.MethodPost, "/db", strings.NewReader(`{"query": "DELETE FROM users WHERE email = $1", "args": ["a@example.com"]}`))
+	req.Header.Set("X-API-Key", "secret")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.NotContains(t, audit.String(), "a@example.com")
+
+	events := decodeLines[logging.AuditEvent](t, audit)
+	require.Len(t, events, 2)
+	assert.Equal(t, logging.ActionAuthFailure, events[0].Action)
+	assert.Equal(t, logging.OutcomeDenied, events[0].Outcome)
+	assert.Equal(t, "POST /db", events[0].Target)
+	assert.Equal(t, "req-1", events[0].RequestID)
+	assert.Equal(t, "192.0.2.1", events[0].ClientIP)
+	assert.Equal(t, "http", events[0].Protocol)
+	assert.Empty(t, events[0].Actor)
+
+	assert.Equal(t, logging.ActionDBQuery, events[1].Action)
+	assert.Equal(t, logging.OutcomeDenied, events[1].Outcome)
+	assert.Equal(t, "default", events[1].Actor)
+	assert.Equal(t, "DELETE FROM users WHERE email = $1", events[1].Details["query"])
+	assert.Equal(t, float64(1), events[1].Details["args"])
+}
+
+func TestAuditAdminActions(t *testing.T) {
+	audit := captureAudit(t)
+	server := setupTestServer()
+
+	req := httptest.NewRequest(http.MethodPost, "/routes", strings.NewReader(`{"method": "GET", "path": "/hello", "function": "test-function"}`))
+	w := httptest.NewRecorder()
+	server.handleRoutes(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	req = httptest.NewRequest(http.MethodDelete, "/routes?method=GET&path=/missing", nil)
+	w = httptest.NewRecorder()
+	server.handleRoutes(w, req)
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	req = httptest.NewRequest(http.MethodDelete, "/metrics", nil)
+	w = httptest.NewRecorder()
+	server.handleGetMetrics(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	events := decodeLines[logging.AuditEvent](t, audit)
+	require.Len(t, events, 3)
+	assert.Equal(t, logging.ActionRouteAdd, events[0].Action)
+	assert.Equal(t, logging.OutcomeSuccess, events[0].Outcome)
+	assert.Equal(t, "GET /hello", events[0].Target)
+	assert.Equal(t, logging.ActionRouteRemove, events[1].Action)
+	assert.Equal(t, logging.OutcomeFailure, events[1].Outcome)
+	assert.NotEmpty(t, events[1].Reason)
+	assert.Equal(t, logging.ActionMetricsReset, events[2].Action)
+	assert.Equal(t, "*", events[2].Target)
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/mstgnz/self-hosted-serverless/internal/function"
+	"github.com/mstgnz/self-hosted-serverless/internal/logging"
+	"github.com/mstgnz/self-hosted-serverless/internal/metrics"
+)
+
+var (
+	httpRequestsTotal = metrics.GetGlobalRegistry().NewCounterVec("serverless_http_requests_total",
+		"HTTP requests by handler, method and status code.", "handler", "method", "code")
+	httpRequestDuration = metrics.GetGlobalRegistry().NewHistogramVec("serverless_http_request_duration_seconds",
+		"HTTP request latency in seconds.", nil, "handler", "method")
+)
+
+// observeRequest records a served request. Requests are labeled with the mux
+// pattern that matched them rather than their path, which would give every
+// function, route parameter and probe a series of its own.
+func observeRequest(r *http.Request, status int, duration time.Duration) {
+	handler := r.Pattern
+	if handler == "" {
+		handler = "none"
+	}
+	method := r.Method
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+	default:
+		method = "other"
+	}
+
+	httpRequestsTotal.Inc(handler, method, strconv.Itoa(status))
+	httpRequestDuration.Observe(duration.Seconds(), handler, method)
+}
+
+// wantsPrometheus reports whether the client asked for the text exposition
+// format rather than JSON, as Prometheus does when it scrapes /metrics
+func wantsPrometheus(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return !strings.Contains(accept, "application/json") &&
+		(strings.Contains(accept, "text/plain") || strings.Contains(accept, "application/openmetrics-text"))
+}
+
+// handlePrometheusMetrics serves every metric in the Prometheus text format
+func (s *Server) handlePrometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	w.WriteHeader(http.StatusOK)
+	metrics.GetGlobalRegistry().WriteText(w)
+}
+
+// parseWindow reads the window query parameter, such as 5m or 24h. It
+// returns zero when the parameter is absent, and writes a 400 response and
+// returns false when it is invalid.
+func parseWindow(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	value := r.URL.Query().Get("window")
+	if value == "" {
+		return 0, true
+	}
+
+	window, err := time.ParseDuration(value)
+	if err != nil || window <= 0 || window > function.MaxMetricsWindow {
+		http.Error(w, fmt.Sprintf("Invalid window: must be a duration up to %v, such as 1m, 5m, 1h or 24h", function.MaxMetricsWindow), http.StatusBadRequest)
+		return 0, false
+	}
+	return window, true
+}
+
+// handleResetMetrics clears the metrics of a function, or of every function
+// when name is empty. It needs the admin scope, since the metrics are shared
+// by every client. Prometheus counters are not reset, as they must only grow.
+func (s *Server) handleResetMetrics(w http.ResponseWriter, r *http.Request, name string) {
+	target := name
+	if target == "" {
+		target = "*"
+	}
+	if !hasScope(r, auth.ScopeAdmin) {
+		s.auditDenied(r, "missing scope "+auth.ScopeAdmin)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if !s.registry.ResetMetrics(name) {
+		s.auditResult(r, logging.ActionMetricsReset, target, function.ErrFunctionNotFound)
+		http.Error(w, fmt.Sprintf("Function %s not found", name), http.StatusNotFound)
+		return
+	}
+	s.auditResult(r, logging.ActionMetricsReset, target, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/mstgnz/self-hosted-serverless/internal/function"
+	"github.com/mstgnz/self-hosted-serverless/internal/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	server := setupTestServer()
+
+	// Run a function through the access log so both the function and the
+	// request are recorded
+	mux := http.NewServeMux()
+	mux.HandleFunc("/run/", server.handleRunFunction)
+	req := httptest.NewRequest(http.MethodPost, "/run/test-function", strings.NewReader(`{}`))
+	server.accessLog(mux).ServeHTTP(httptest.NewRecorder(), req)
+
+	for _, accept := range []string{"", "text/plain;version=0.0.4;q=0.5,*/*;q=0.1"} {
+		req = httptest.NewRequest(http.MethodGet, "/metrics/prometheus", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		if accept == "" {
+			server.handlePrometheusMetrics(w, req)
+		} else {
+			// Prometheus scrapes /metrics and is served the text format
+			server.handleGetMetrics(w, req)
+		}
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, metrics.ContentType, w.Header().Get("Content-Type"))
+		body := w.Body.String()
+		assert.Contains(t, body, `serverless_function_invocations_total{function="test-function",runtime="go",outcome="success"}`)
+		assert.Contains(t, body, `serverless_function_duration_seconds_bucket{function="test-function",runtime="go",outcome="success",le="+Inf"}`)
+		assert.Contains(t, body, `serverless_http_requests_total{handler="/run/",method="POST",code="200"}`)
+		assert.Contains(t, body, "# TYPE go_goroutines gauge")
+	}
+
+	// Other clients still get JSON
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/json, text/plain, */*")
+	w := httptest.NewRecorder()
+	server.handleGetMetrics(w, req)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+}
+
+func TestWindowedMetricsEndpoint(t *testing.T) {
+	server := setupTestServer()
+	server.registry.Execute("test-function", map[string]any{})
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics/test-function?window=5m", nil)
+	w := httptest.NewRecorder()
+	server.handleGetFunctionMetrics(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var metrics function.WindowMetrics
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &metrics))
+	assert.Equal(t, int64(300), metrics.WindowSecs)
+	assert.Equal(t, int64(1), metrics.Invocations)
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics?window=1h", nil)
+	w = httptest.NewRecorder()
+	server.handleGetMetrics(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"window_secs":3600`)
+
+	for _, window := range []string{"soon", "-1m", "48h"} {
+		req = httptest.NewRequest(http.MethodGet, "/metrics/test-function?window="+window, nil)
+		w = httptest.NewRecorder()
+		server.handleGetFunctionMetrics(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, window)
+	}
+}
+
+func TestResetMetrics(t *testing.T) {
+	server := setupTestServer()
+	server.registry.Execute("test-function", map[string]any{})
+
+	// Resetting needs the admin scope
+	reader := auth.Identity{KeyID: "reader", Scopes: []string{auth.ScopeMetricsRead}}
+	req := httptest.NewRequest(http.MethodDelete, "/metrics/test-function", nil)
+	req = req.WithContext(auth.NewContext(req.Context(), reader))
+	w := httptest.NewRecorder()
+	server.handleGetFunctionMetrics(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	req = httptest.NewRequest(http.MethodDelete, "/metrics/test-function", nil)
+	w = httptest.NewRecorder()
+	server.handleGetFunctionMetrics(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	_, exists := server.registry.GetFunctionMetrics("test-function")
+	assert.False(t, exists)
+
+	req = httptest.NewRequest(http.MethodDelete, "/metrics/test-function", nil)
+	w = httptest.NewRecorder()
+	server.handleGetFunctionMetrics(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	server.registry.Execute("test-function", map[string]any{})
+	req = httptest.NewRequest(http.MethodDelete, "/metrics", nil)
+	w = httptest.NewRecorder()
+	server.handleGetMetrics(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, server.registry.GetMetrics())
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/cors"
+	"github.com/mstgnz/self-hosted-serverless/internal/function"
+	"github.com/mstgnz/self-hosted-serverless/internal/ratelimit"
+)
+
+// Route binds an HTTP method and path pattern to a function.
+// Path patterns may contain parameters such as /api/users/{id}, and the last
+// segment may be a catch-all parameter such as /files/{path...}.
+type Route struct {
+	Method       string `json:"method"`
+	Path         string `json:"path"`
+	Function     string `json:"function"`
+	Public       bool   `json:"public"`
+	MaxBodyBytes int64  `json:"max_body_bytes,omitempty"`
+	// Limits caps the request rate and concurrency of the route
+	Limits *ratelimit.Limits `json:"limits,omitempty"`
+	// CORS overrides the CORS policy of the function and the server
+	CORS *cors.Policy `json:"cors,omitempty"`
+}
+
+// routeManifest is the on-disk format of the route table
+type routeManifest struct {
+	Routes []Route `json:"routes"`
+}
+
+var (
+	errInvalidRoute     = errors.New("invalid route")
+	errRouteNotFound    = errors.New("route not found")
+	errMethodNotAllowed = errors.New("method not allowed")
+)
+
+// reservedPaths are served by built-in handlers and cannot be used by routes
+var reservedPaths = []string{"/health", "/run", "/functions", "/events", "/db", "/metrics", "/invocations", "/alerts", "/usage", "/routes", "/keys", "/ui"}
+
+type compiledRoute struct {
+	Route
+	segments []string
+}
+
+// Router matches requests against a table of custom routes
+type Router struct {
+	mu     sync.RWMutex
+	routes []compiledRoute
+	file   string
+}
+
+// NewRouter creates a router. When file is not empty, routes are loaded from
+// it if it exists and every change made through the router is written back.
+func NewRouter(file string) (*Router, error) {
+	rt := &Router{file: file}
+	if file == "" {
+		return rt, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return rt, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routes file: %w", err)
+	}
+
+	var manifest routeManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse routes file: %w", err)
+	}
+
+	for _, route := range manifest.Routes {
+		if err := rt.add(route); err != nil {
+			return nil, fmt.Errorf("invalid route in routes file: %w", err)
+		}
+	}
+
+	return rt, nil
+}
+
+// Routes returns the current route table in match order
+func (rt *Router) Routes() []Route {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	routes := make([]Route, 0, len(rt.routes))
+	for _, r := range rt.routes {
+		routes = append(routes, r.Route)
+	}
+	return routes
+}
+
+// Add appends a route to the table
+func (rt *Router) Add(route Route) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if err := rt.add(route); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidRoute, err)
+	}
+	if err := rt.save(); err != nil {
+		rt.routes = rt.routes[:len(rt.routes)-1]
+		return err
+	}
+	return nil
+}
+
+// Remove deletes the route with the given method and path
+func (rt *Router) Remove(method, path string) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	method = normalizeMethod(method)
+	for i, r := range rt.routes {
+		if r.Method == method && r.Path == path {
+			routes := append(append([]compiledRoute{}, rt.routes[:i]...), rt.routes[i+1:]...)
+			previous := rt.routes
+			rt.routes = routes
+			if err := rt.save(); err != nil {
+				rt.routes = previous
+				return err
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s %s", errRouteNotFound, method, path)
+}
+
+// Match finds the first route matching the request method and path and
+// returns it along with the extracted path parameters. It returns
+// errMethodNotAllowed if a route matches the path but not the method.
+func (rt *Router) Match(method, path string) (Route, map[string]string, error) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	segments := splitPath(path)
+	err := errRouteNotFound
+	for _, r := range rt.routes {
+		params, ok := matchSegments(r.segments, segments)
+		if !ok {
+			continue
+		}
+		if r.Method != "*" && r.Method != method {
+			err = errMethodNotAllowed
+			continue
+		}
+		return r.Route, params, nil
+	}
+	return Route{}, nil, err
+}
+
+// add validates and appends a route. The caller must hold the lock.
+func (rt *Router) add(route Route) error {
+	route.Method = normalizeMethod(route.Method)
+
+	if !strings.HasPrefix(route.Path, "/") {
+		return fmt.Errorf("route path %q must start with /", route.Path)
+	}
+	if isReservedPath(route.Path) {
+		return fmt.Errorf("route path %q conflicts with a built-in endpoint", route.Path)
+	}
+	if !function.IsValidName(route.Function) {
+		return fmt.Errorf("invalid function name %q", route.Function)
+	}
+	if route.MaxBodyBytes < 0 {
+		return errors.New("max_body_bytes must not be negative")
+	}
+	if route.Limits != nil {
+		if err := route.Limits.Validate(); err != nil {
+			return err
+		}
+	}
+	if route.CORS != nil {
+		if err := route.CORS.Validate(); err != nil {
+			return err
+		}
+	}
+
+	segments := splitPath(route.Path)
+	seen := make(map[string]bool)
+	for i, seg := range segments {
+		name, isParam, isCatchAll := parseParam(seg)
+		if !isParam {
+			continue
+		}
+		if name == "" {
+			return fmt.Errorf("route path %q has an empty parameter name", route.Path)
+		}
+		if isCatchAll && i != len(segments)-1 {
+			return fmt.Errorf("route path %q: catch-all parameter must be the last segment", route.Path)
+		}
+		if seen[name] {
+			return fmt.Errorf("route path %q has duplicate parameter %q", route.Path, name)
+		}
+		seen[name] = true
+	}
+
+	for _, r := range rt.routes {
+		if r.Method == route.Method && r.Path == route.Path {
+			return fmt.Errorf("route %s %s already exists", route.Method, route.Path)
+		}
+	}
+
+	rt.routes = append(rt.routes, compiledRoute{Route: route, segments: segments})
+	return nil
+}
+
+// save writes the route table to the routes file. The caller must hold the lock.
+func (rt *Router) save() error {
+	if rt.file == "" {
+		return nil
+	}
+
+	manifest := routeManifest{Routes: make([]Route, 0, len(rt.routes))}
+	for _, r := range rt.routes {
+		manifest.Routes = append(manifest.Routes, r.Route)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode routes: %w", err)
+	}
+	if err := os.WriteFile(rt.file, data, 0644); err != nil {
+		return fmt.Errorf("failed to write routes file: %w", err)
+	}
+	return nil
+}
+
+// normalizeMethod upper-cases a method; an empty method or "*" matches any method
+func normalizeMethod(method string) string {
+	if method == "" {
+		return "*"
+	}
+	return strings.ToUpper(method)
+}
+
+func isReservedPath(path string) bool {
+	for _, p := range reservedPaths {
+		if path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// parseParam reports whether a pattern segment is a {param} or {param...}
+func parseParam(seg string) (name string, isParam bool, isCatchAll bool) {
+	if !strings.HasPrefix(seg, "{") || !strings.HasSuffix(seg, "}") {
+		return "", false, false
+	}
+	name = seg[1 : len(seg)-1]
+	if strings.HasSuffix(name, "...") {
+		return strings.TrimSuffix(name, "..."), true, true
+	}
+	return name, true, false
+}
+
+func matchSegments(pattern, path []string) (map[string]string, bool) {
+	params := make(map[string]string)
+	for i, seg := range pattern {
+		name, isParam, isCatchAll := parseParam(seg)
+		if isCatchAll {
+			params[name] = strings.Join(path[i:], "/")
+			return params, true
+		}
+		if i >= len(path) {
+			return nil, false
+		}
+		if isParam {
+			params[name] = path[i]
+			continue
+		}
+		if seg != path[i] {
+			return nil, false
+		}
+	}
+	if len(pattern) != len(path) {
+		return nil, false
+	}
+	return params, true
+}
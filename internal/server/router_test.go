@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouterMatch(t *testing.T) {
+	router, err := NewRouter("")
+	assert.NoError(t, err)
+
+	assert.NoError(t, router.Add(Route{Method: "get", Path: "/api/users/{id}", Function: "get-user"}))
+	assert.NoError(t, router.Add(Route{Method: "POST", Path: "/webhooks/stripe", Function: "stripe"}))
+	assert.NoError(t, router.Add(Route{Path: "/files/{path...}", Function: "files"}))
+
+	// Path parameters are extracted
+	route, params, err := router.Match("GET", "/api/users/42")
+	assert.NoError(t, err)
+	assert.Equal(t, "get-user", route.Function)
+	assert.Equal(t, map[string]string{"id": "42"}, params)
+
+	// Method mismatch is reported separately from a missing route
+	_, _, err = router.Match("DELETE", "/api/users/42")
+	assert.ErrorIs(t, err, errMethodNotAllowed)
+	_, _, err = router.Match("GET", "/api/orders/42")
+	assert.ErrorIs(t, err, errRouteNotFound)
+
+	// A route without a method matches any method, and catch-all parameters
+	// capture the rest of the path
+	route, params, err = router.Match("PUT", "/files/a/b/c.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "files", route.Function)
+	assert.Equal(t, "a/b/c.txt", params["path"])
+}
+
+func TestRouterAddValidation(t *testing.T) {
+	router, err := NewRouter("")
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, router.Add(Route{Path: "api", Function: "f"}), errInvalidRoute)
+	assert.ErrorIs(t, router.Add(Route{Path: "/run/f", Function: "f"}), errInvalidRoute)
+	assert.ErrorIs(t, router.Add(Route{Path: "/api", Function: "../f"}), errInvalidRoute)
+	assert.ErrorIs(t, router.Add(Route{Path: "/api/{rest...}/x", Function: "f"}), errInvalidRoute)
+	assert.ErrorIs(t, router.Add(Route{Path: "/api/{id}/{id}", Function: "f"}), errInvalidRoute)
+
+	assert.NoError(t, router.Add(Route{Method: "GET", Path: "/api", Function: "f"}))
+	assert.ErrorIs(t, router.Add(Route{Method: "GET", Path: "/api", Function: "g"}), errInvalidRoute)
+}
+
+func TestRouterPersistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "routes.json")
+
+	router, err := NewRouter(file)
+	assert.NoError(t, err)
+	assert.NoError(t, router.Add(Route{Method: "GET", Path: "/a", Function: "a"}))
+	assert.NoError(t, router.Add(Route{Method: "GET", Path: "/b", Function: "b", Public: true}))
+	assert.NoError(t, router.Remove("GET", "/a"))
+	assert.ErrorIs(t, router.Remove("GET", "/a"), errRouteNotFound)
+
+	// Reloading the file restores the route table
+	reloaded, err := NewRouter(file)
+	assert.NoError(t, err)
+	assert.Equal(t, []Route{{Method: "GET", Path: "/b", Function: "b", Public: true}}, reloaded.Routes())
+}
+
+func TestHandleRoute(t *testing.T) {
+	server := setupTestServer()
+	server.auth = auth.NewAuthenticator("secret", nil)
+
+	assert.NoError(t, server.router.Add(Route{Method: "GET", Path: "/api/items/{id}", Function: "test-function", Public: true}))
+	assert.NoError(t, server.router.Add(Route{Method: "POST", Path: "/api/items", Function: "test-function", MaxBodyBytes: 16}))
+
+	// Public route: no key required, path parameters are passed in the input
+	req := httptest.NewRequest("GET", "/api/items/7", nil)
+	w := httptest.NewRecorder()
+	server.handleRoute(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	input := response["input"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"id": "7"}, input["params"])
+
+	// Parameters in the body can't pass for path parameters
+	assert.NoError(t, server.router.Add(Route{Method: "POST", Path: "/api/public", Function: "test-function", Public: true}))
+	req = httptest.NewRequest("POST", "/api/public", bytes.NewBufferString(`{"params": {"id": "forged"}}`))
+	w = httptest.NewRecorder()
+	server.handleRoute(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, map[string]interface{}{}, response["input"].(map[string]interface{})["params"])
+
+	// Nor can an identity in the body pass for a verified one
+	req = httptest.NewRequest("POST", "/api/public", bytes.NewBufferString(`{"identity": {"subject": "admin", "scopes": ["admin"]}}`))
+	w = httptest.NewRecorder()
+	server.handleRoute(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	response = nil
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.NotContains(t, response["input"].(map[string]interface{}), "identity")
+
+	// Protected route: key required
+	req = httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{}`))
+	w = httptest.NewRecorder()
+	server.handleRoute(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req = httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{}`))
+	req.Header.Set("X-API-Key", "secret")
+	w = httptest.NewRecorder()
+	server.handleRoute(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Per-route body limit
+	req = httptest.NewRequest("POST", "/api/items", bytes.NewBufferString(`{"key": "a long value"}`))
+	req.Header.Set("X-API-Key", "secret")
+	w = httptest.NewRecorder()
+	server.handleRoute(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Wrong method and unknown path
+	req = httptest.NewRequest("DELETE", "/api/items/7", nil)
+	w = httptest.NewRecorder()
+	server.handleRoute(w, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	req = httptest.NewRequest("GET", "/unknown", nil)
+	w = httptest.NewRecorder()
+	server.handleRoute(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleRoutes(t *testing.T) {
+	server := setupTestServer()
+
+	body := `{"method": "GET", "path": "/api/hello", "function": "test-function"}`
+	req := httptest.NewRequest("POST", "/routes", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	server.handleRoutes(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	req = httptest.NewRequest("POST", "/routes", bytes.NewBufferString(`{"path": "/db", "function": "f"}`))
+	w = httptest.NewRecorder()
+	server.handleRoutes(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req = httptest.NewRequest("GET", "/routes", nil)
+	w = httptest.NewRecorder()
+	server.handleRoutes(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Routes []Route `json:"routes"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 1, len(response.Routes))
+
+	req = httptest.NewRequest("DELETE", "/routes?method=GET&path=/api/hello", nil)
+	w = httptest.NewRecorder()
+	server.handleRoutes(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req = httptest.NewRequest("DELETE", "/routes?method=GET&path=/api/hello", nil)
+	w = httptest.NewRecorder()
+	server.handleRoutes(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -3,171 +3,172 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"net"
+	"maps"
 	"net/http"
 	"os"
-	"regexp"
-	"strconv"
+	"slices"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/mstgnz/self-hosted-serverless/internal/alert"
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/mstgnz/self-hosted-serverless/internal/clientip"
+	"github.com/mstgnz/self-hosted-serverless/internal/common"
+	"github.com/mstgnz/self-hosted-serverless/internal/cors"
 	"github.com/mstgnz/self-hosted-serverless/internal/db"
 	"github.com/mstgnz/self-hosted-serverless/internal/event"
 	"github.com/mstgnz/self-hosted-serverless/internal/function"
+	"github.com/mstgnz/self-hosted-serverless/internal/health"
+	"github.com/mstgnz/self-hosted-serverless/internal/logging"
+	"github.com/mstgnz/self-hosted-serverless/internal/ratelimit"
+	"github.com/mstgnz/self-hosted-serverless/internal/tlsconfig"
+	"github.com/mstgnz/self-hosted-serverless/internal/webhook"
 )
 
-var validFunctionName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
-
-// clientState tracks request timestamps for a single IP within the rate-limit window.
-type clientState struct {
-	requests []time.Time
-	lastSeen time.Time
-}
-
-// rateLimiter is a per-IP sliding-window rate limiter.
-type rateLimiter struct {
-	mu      sync.Mutex
-	clients map[string]*clientState
-	limit   int
-	window  time.Duration
-}
-
-func newRateLimiter(limit int, window time.Duration) *rateLimiter {
-	rl := &rateLimiter{
-		clients: make(map[string]*clientState),
-		limit:   limit,
-		window:  window,
-	}
-	go rl.cleanupLoop()
-	return rl
-}
-
-func (rl *rateLimiter) allow(ip string) bool {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	now := time.Now()
-	cutoff := now.Add(-rl.window)
-
-	state, ok := rl.clients[ip]
-	if !ok {
-		state = &clientState{}
-		rl.clients[ip] = state
-	}
-
-	// Drop requests outside the current window.
-	valid := state.requests[:0]
-	for _, t := range state.requests {
-		if t.After(cutoff) {
-			valid = append(valid, t)
-		}
-	}
-	state.requests = valid
-	state.lastSeen = now
-
-	if len(state.requests) >= rl.limit {
-		return false
-	}
-
-	state.requests = append(state.requests, now)
-	return true
-}
-
-// cleanupLoop evicts IPs that haven't been seen in two windows to prevent unbounded growth.
-func (rl *rateLimiter) cleanupLoop() {
-	ticker := time.NewTicker(rl.window * 2)
-	defer ticker.Stop()
-	for range ticker.C {
-		rl.mu.Lock()
-		cutoff := time.Now().Add(-2 * rl.window)
-		for ip, state := range rl.clients {
-			if state.lastSeen.Before(cutoff) {
-				delete(rl.clients, ip)
-			}
-		}
-		rl.mu.Unlock()
-	}
-}
-
-// realIP extracts the client IP from the request, honouring common proxy headers.
-func realIP(r *http.Request) string {
-	if ip := r.Header.Get("X-Forwarded-For"); ip != "" {
-		return strings.SplitN(ip, ",", 2)[0]
-	}
-	if ip := r.Header.Get("X-Real-IP"); ip != "" {
-		return ip
-	}
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
-	}
-	return host
-}
-
 // Server represents the serverless HTTP server
 type Server struct {
 	port      int
-	apiKey    string
+	auth      *auth.Authenticator
 	server    *http.Server
 	registry  *function.Registry
 	eventBus  *event.Bus
 	dbService *db.Service
-	limiter   *rateLimiter
+	limiter   ratelimit.RateLimiter
+	router    *Router
+	health    *health.Checker
+	limits    *ratelimit.Manager
+	clientIP  *clientip.Resolver
+	cors      *cors.Policy
+	webhooks  map[string]*webhook.Verifier
+	alerts    *alert.Manager
 }
 
 // NewServer creates a new serverless server
 func NewServer(port int, registry *function.Registry) *Server {
-	apiKey := os.Getenv("API_KEY")
-	if apiKey == "" {
+	authenticator, err := auth.NewAuthenticatorFromEnv()
+	if err != nil {
+		log.Printf("Warning: Failed to load API keys: %v\n", err)
+		authenticator = auth.NewAuthenticator(os.Getenv("API_KEY"), nil)
+	}
+	if !authenticator.Enabled() {
 		log.Println("Warning: API_KEY not set — all endpoints are unauthenticated")
 	}
 
-	rateLimit := 100
-	if v := os.Getenv("RATE_LIMIT_PER_MIN"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil && n > 0 {
-			rateLimit = n
-		}
+	dbService, dbErr := db.NewService(db.PostgreSQL)
+	if dbErr != nil {
+		log.Printf("Warning: Failed to initialize database service: %v\n", dbErr)
 	}
 
-	dbService, err := db.NewService(db.PostgreSQL)
+	resolver, err := clientip.ResolverFromEnv()
 	if err != nil {
-		log.Printf("Warning: Failed to initialize database service: %v\n", err)
+		log.Printf("Warning: Failed to parse TRUSTED_PROXIES or TRUSTED_PROXY_HEADER, ignoring forwarding headers: %v\n", err)
+		resolver, _ = clientip.NewResolver(nil, "")
 	}
 
-	return &Server{
+	corsPolicy, err := cors.PolicyFromEnv()
+	if err != nil {
+		log.Printf("Warning: Failed to load CORS policy, allowing any origin: %v\n", err)
+		corsPolicy = &cors.Policy{AllowedOrigins: []string{"*"}}
+	}
+
+	webhooks, err := webhook.LoadFile(os.Getenv("WEBHOOKS_FILE"))
+	if err != nil {
+		log.Printf("Warning: Failed to load webhooks, their functions require an API key: %v\n", err)
+	}
+
+	router, err := NewRouter(os.Getenv("ROUTES_FILE"))
+	if err != nil {
+		log.Printf("Warning: Failed to load routes: %v\n", err)
+		router, _ = NewRouter("")
+	}
+
+	s := &Server{
 		port:      port,
-		apiKey:    apiKey,
+		auth:      authenticator,
 		registry:  registry,
 		eventBus:  event.GetGlobalBus(),
 		dbService: dbService,
-		limiter:   newRateLimiter(rateLimit, time.Minute),
-	}
+		limiter:   ratelimit.NewRateLimiterFromEnv("http"),
+		router:    router,
+		health:    health.GetGlobalChecker(),
+		limits:    ratelimit.GetGlobalManager(),
+		clientIP:  resolver,
+		cors:      corsPolicy,
+		webhooks:  webhooks,
+	}
+
+	// The database is only needed by /db, so losing it degrades the server
+	// without taking it out of rotation.
+	s.health.Register("database", func(ctx context.Context) error {
+		if s.dbService == nil {
+			return fmt.Errorf("database service not initialized: %w", dbErr)
+		}
+		return s.dbService.Ping(ctx)
+	}, false)
+	s.health.Register("events", func(ctx context.Context) error {
+		if s.eventBus == nil {
+			return errors.New("event bus not initialized")
+		}
+		return nil
+	}, true)
+
+	return s
 }
 
 // Start starts the HTTP server
 func (s *Server) Start() error {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/health", s.public(s.handleHealth))
-	mux.HandleFunc("/run/", s.protected(s.handleRunFunction))
-	mux.HandleFunc("/functions", s.protected(s.handleListFunctions))
-	mux.HandleFunc("/events", s.protected(s.handlePublishEvent))
-	mux.HandleFunc("/db", s.protected(s.handleDatabaseQuery))
-	mux.HandleFunc("/metrics", s.protected(s.handleGetMetrics))
-	mux.HandleFunc("/metrics/", s.protected(s.handleGetFunctionMetrics))
+	// Health probes skip the rate limit so a busy server isn't taken out of
+	// rotation by its own load balancer
+	mux.HandleFunc("/health", s.corsMiddleware(s.handleHealth))
+	mux.HandleFunc("/health/live", s.corsMiddleware(s.handleHealth))
+	mux.HandleFunc("/health/ready", s.corsMiddleware(s.handleHealthReady))
+	mux.HandleFunc("/run/", s.public(s.runAuthMiddleware(s.handleRunFunction)))
+	mux.HandleFunc("/functions", s.protected("", s.handleListFunctions))
+	mux.HandleFunc("/events", s.protected(auth.ScopeEventsPublish, s.handlePublishEvent))
+	mux.HandleFunc("/db", s.protected(auth.ScopeDBRead, s.handleDatabaseQuery))
+	mux.HandleFunc("/metrics", s.protected(auth.ScopeMetricsRead, s.handleGetMetrics))
+	mux.HandleFunc("/metrics/", s.protected(auth.ScopeMetricsRead, s.handleGetFunctionMetrics))
+	mux.HandleFunc("/metrics/prometheus", s.protected(auth.ScopeMetricsRead, s.handlePrometheusMetrics))
+	mux.HandleFunc("/invocations", s.protected(auth.ScopeMetricsRead, s.handleInvocations))
+	mux.HandleFunc("/alerts", s.protected(auth.ScopeMetricsRead, s.handleAlerts))
+	mux.HandleFunc("/usage", s.protected(auth.ScopeMetricsRead, s.handleUsage))
+	mux.HandleFunc("/routes", s.protected(auth.ScopeAdmin, s.handleRoutes))
+	mux.HandleFunc("/keys", s.protected(auth.ScopeAdmin, s.handleKeys))
+	mux.HandleFunc("/keys/", s.protected(auth.ScopeAdmin, s.handleKey))
+	mux.Handle("/ui/", s.uiHandler(mux))
+
+	// Everything else is dispatched through the custom route table, which
+	// decides per route whether authentication is required.
+	mux.HandleFunc("/", s.public(s.handleRoute))
 
 	s.server = &http.Server{
 		Addr:         fmt.Sprintf(":%d", s.port),
-		Handler:      mux,
+		Handler:      s.accessLog(mux),
 		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		WriteTimeout: writeTimeout,
 		IdleTimeout:  120 * time.Second,
 	}
 
-	return s.server.ListenAndServe()
+	tlsCfg, err := tlsconfig.ConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	if !tlsCfg.Enabled() {
+		return s.server.ListenAndServe()
+	}
+
+	reloader, err := tlsconfig.NewReloader(tlsCfg)
+	if err != nil {
+		return err
+	}
+	s.server.TLSConfig = reloader.TLSConfig()
+	return s.server.ListenAndServeTLS("", "")
 }
 
 // Stop gracefully stops the server
@@ -186,97 +187,174 @@ func (s *Server) Stop() error {
 
 // public wraps a handler with CORS and rate limiting (no auth)
 func (s *Server) public(h http.HandlerFunc) http.HandlerFunc {
-	return corsMiddleware(s.rateLimitMiddleware(h))
+	return s.corsMiddleware(s.rateLimitMiddleware(h))
 }
 
 // protected wraps a handler with CORS, rate limiting, and API key auth
-func (s *Server) protected(h http.HandlerFunc) http.HandlerFunc {
-	return corsMiddleware(s.rateLimitMiddleware(s.authMiddleware(h)))
-}
-
-// corsMiddleware sets permissive CORS headers and handles preflight requests
-func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-API-Key, Authorization")
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-		next(w, r)
-	}
+// requiring the given scope. An empty scope admits any authenticated client.
+func (s *Server) protected(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return s.corsMiddleware(s.rateLimitMiddleware(s.authMiddleware(scope, h)))
 }
 
 // rateLimitMiddleware rejects requests that exceed the per-IP rate limit.
 func (s *Server) rateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !s.limiter.allow(realIP(r)) {
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		if ok, retryAfter := s.limiter.Check(s.realIP(r)); !ok {
+			writeTooManyRequests(w, &ratelimit.LimitError{Name: "ip", RetryAfter: retryAfter})
 			return
 		}
 		next(w, r)
 	}
 }
 
-// authMiddleware enforces API key authentication when API_KEY env var is set.
-// Clients must send the key via the X-API-Key header or as a Bearer token.
-func (s *Server) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// authMiddleware enforces API key authentication when API_KEY or a key store
+// is configured, and checks that the client was granted scope. Clients must
+// send the key via the X-API-Key header or as a Bearer token, or connect over
+// mutual TLS with a mapped client certificate. The client identity is stored in the
+// request context, and the limits of the client's key are applied.
+func (s *Server) authMiddleware(scope string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if s.apiKey == "" {
-			next(w, r)
-			return
-		}
+		// A verified client certificate authenticates the request on its own
+		id, ok := s.auth.AuthenticateCert(r.TLS)
+		if !ok {
+			if !s.auth.Enabled() {
+				next(w, r)
+				return
+			}
 
-		key := r.Header.Get("X-API-Key")
-		if key == "" {
-			if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
-				key = strings.TrimPrefix(auth, "Bearer ")
+			key := auth.KeyFromHeaders(r.Header.Get("X-API-Key"), r.Header.Get("Authorization"))
+			var err error
+			if id, err = s.auth.Authenticate(key); err != nil {
+				s.auditDenied(r, err.Error())
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
 			}
 		}
 
-		if key != s.apiKey {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		setAccessIdentity(r, id)
+		if !id.HasScope(scope) {
+			s.auditDenied(r.WithContext(auth.NewContext(r.Context(), id)), "missing scope "+scope)
+			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
 
-		next(w, r)
+		handler := s.limitMiddleware("key:"+id.KeyID, id.Limits, next)
+		handler(w, r.WithContext(auth.NewContext(r.Context(), id)))
 	}
 }
 
+// canInvoke reports whether the client may invoke the named function. Requests
+// without an identity, when authentication is disabled or the route is public,
+// may invoke any function.
+func canInvoke(r *http.Request, name string) bool {
+	id, ok := auth.FromContext(r.Context())
+	return !ok || id.CanInvoke(name)
+}
+
+// hasScope reports whether the client was granted scope. Requests without an
+// identity, when authentication is disabled, have every scope.
+func hasScope(r *http.Request, scope string) bool {
+	id, ok := auth.FromContext(r.Context())
+	return !ok || id.HasScope(scope)
+}
+
+// handleHealth reports that the process is alive. It is also served as
+// /health/live and does not depend on any component.
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
-func (s *Server) handleRunFunction(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// handleHealthReady reports the status of each component. It returns 503 when
+// a critical component is down so load balancers stop sending traffic. The
+// endpoint is public, so check errors are logged rather than returned.
+func (s *Server) handleHealthReady(w http.ResponseWriter, r *http.Request) {
+	report := s.health.Check(r.Context())
+	for _, name := range slices.Sorted(maps.Keys(report.Components)) {
+		if err := report.Components[name].Error; err != "" {
+			log.Printf("Warning: health check %s failed: %s", name, err)
+		}
 	}
 
-	name := strings.TrimPrefix(r.URL.Path, "/run/")
+	status := http.StatusOK
+	if !report.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report.Redacted())
+}
+
+func (s *Server) handleRunFunction(w http.ResponseWriter, r *http.Request) {
+	name, _, hasSubPath := strings.Cut(strings.TrimPrefix(r.URL.Path, "/run/"), "/")
 	if name == "" {
 		http.Error(w, "Function name is required", http.StatusBadRequest)
 		return
 	}
-	if !validFunctionName.MatchString(name) {
+	if !function.IsValidName(name) {
+		http.Error(w, "Invalid function name", http.StatusBadRequest)
+		return
+	}
+	if !canInvoke(r, name) {
+		s.auditDenied(r, "not allowed to invoke function "+name)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Functions in HTTP event mode accept any method and sub-path.
+	if info, ok := s.registry.GetFunctionInfo(name); ok && info.HTTPMode == common.HTTPModeEvent {
+		s.runHTTPEventFunction(w, r, name, nil, maxRequestBody)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if hasSubPath {
 		http.Error(w, "Invalid function name", http.StatusBadRequest)
 		return
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1 MB limit
+	s.runJSONFunction(w, r, name, nil, maxRequestBody)
+}
+
+// runJSONFunction executes a function with the JSON request body as input and
+// writes its result as JSON, or streams it if the client asked for a stream.
+// Path parameters are added to the input under the "params" key, replacing
+// any the client sent, the client identity, if known, under the "identity"
+// key and the client IP under the "client_ip" key.
+func (s *Server) runJSONFunction(w http.ResponseWriter, r *http.Request, name string, params map[string]string, maxBody int64) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBody)
 	var input map[string]any
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && !errors.Is(err, io.EOF) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if input == nil {
+		input = make(map[string]any)
+	}
+	if params != nil {
+		input["params"] = params
+	} else {
+		input["params"] = map[string]string{}
+	}
+	delete(input, "identity")
+	if id, ok := auth.FromContext(r.Context()); ok {
+		input["identity"] = id.Map()
+	}
+	input["client_ip"] = s.realIP(r)
 
-	result, err := s.registry.Execute(name, input)
+	if format := streamFormat(r); format != "" {
+		s.streamFunction(w, r, name, input, format, s.execOptions(r, params))
+		return
+	}
+
+	result, err := s.registry.Execute(name, input, s.execOptions(r, params)...)
 	if err != nil {
-		log.Printf("Error executing function %s: %v", name, err)
-		http.Error(w, fmt.Sprintf("Error executing function: %v", err), http.StatusInternalServerError)
+		writeExecutionError(w, name, err)
 		return
 	}
 
@@ -295,6 +373,102 @@ func (s *Server) handleRunFunction(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(result)
 }
 
+// handleRoute dispatches a request to the function bound to the matching custom route
+func (s *Server) handleRoute(w http.ResponseWriter, r *http.Request) {
+	route, params, err := s.router.Match(r.Method, r.URL.Path)
+	if errors.Is(err, errMethodNotAllowed) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	maxBody := route.MaxBodyBytes
+	if maxBody == 0 {
+		maxBody = maxRequestBody
+	}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if !canInvoke(r, route.Function) {
+			s.auditDenied(r, "not allowed to invoke function "+route.Function)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if info, ok := s.registry.GetFunctionInfo(route.Function); ok && info.HTTPMode == common.HTTPModeEvent {
+			s.runHTTPEventFunction(w, r, route.Function, params, maxBody)
+			return
+		}
+		s.runJSONFunction(w, r, route.Function, params, maxBody)
+	}
+	if verifier, ok := s.webhooks[route.Function]; ok {
+		handler = s.webhookMiddleware(verifier, maxBody, handler)
+	} else if !route.Public {
+		handler = s.authMiddleware(auth.ScopeInvoke, handler)
+	}
+	handler = s.limitMiddleware("route:"+route.Method+" "+route.Path, route.Limits, handler)
+	handler(w, r)
+}
+
+// handleRoutes lists (GET), adds (POST) and removes (DELETE) custom routes
+func (s *Server) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]any{
+			"routes": s.router.Routes(),
+		})
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+		var route Route
+		if err := json.NewDecoder(r.Body).Decode(&route); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		err := s.router.Add(route)
+		s.auditResult(r, logging.ActionRouteAdd, route.Method+" "+route.Path, err)
+		if err != nil {
+			if errors.Is(err, errInvalidRoute) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Printf("Error adding route: %v", err)
+			http.Error(w, fmt.Sprintf("Error adding route: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{
+			"status": "created",
+		})
+	case http.MethodDelete:
+		query := r.URL.Query()
+		err := s.router.Remove(query.Get("method"), query.Get("path"))
+		s.auditResult(r, logging.ActionRouteRemove, query.Get("method")+" "+query.Get("path"), err)
+		if err != nil {
+			if errors.Is(err, errRouteNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			log.Printf("Error removing route: %v", err)
+			http.Error(w, fmt.Sprintf("Error removing route: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]any{
+			"status": "deleted",
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 func (s *Server) handleListFunctions(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -358,17 +532,20 @@ func (s *Server) handleDatabaseQuery(w http.ResponseWriter, r *http.Request) {
 	// Only allow read queries to limit blast radius if credentials are compromised.
 	trimmed := strings.TrimSpace(strings.ToUpper(request.Query))
 	if !strings.HasPrefix(trimmed, "SELECT") && !strings.HasPrefix(trimmed, "WITH") {
+		s.auditQuery(r, request.Query, len(request.Args), logging.OutcomeDenied, "only SELECT queries are allowed")
 		http.Error(w, "Only SELECT queries are allowed via the HTTP API", http.StatusForbidden)
 		return
 	}
 
-	rows, err := s.dbService.Query(request.Query, request.Args...)
+	rows, err := s.dbService.QueryContext(r.Context(), request.Query, request.Args...)
 	if err != nil {
+		s.auditQuery(r, request.Query, len(request.Args), logging.OutcomeFailure, err.Error())
 		log.Printf("Error executing database query: %v", err)
 		http.Error(w, fmt.Sprintf("Error executing database query: %v", err), http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
+	s.auditQuery(r, request.Query, len(request.Args), logging.OutcomeSuccess, "")
 
 	columns, err := rows.Columns()
 	if err != nil {
@@ -418,22 +595,47 @@ func (s *Server) handleDatabaseQuery(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleGetMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		s.handleResetMetrics(w, r, "")
+		return
+	}
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if wantsPrometheus(r) {
+		s.handlePrometheusMetrics(w, r)
+		return
+	}
+
+	window, ok := parseWindow(w, r)
+	if !ok {
+		return
+	}
+	if window > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]any{"metrics": s.registry.GetWindowMetrics(window)})
+		return
+	}
 
 	metrics := s.registry.GetMetrics()
+	var events map[string]event.TypeStats
+	if s.eventBus != nil {
+		events = s.eventBus.Stats()
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]any{
 		"metrics": metrics,
+		"limits":  s.limits.Stats(),
+		"events":  events,
 	})
 }
 
 func (s *Server) handleGetFunctionMetrics(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
@@ -443,6 +645,26 @@ func (s *Server) handleGetFunctionMetrics(w http.ResponseWriter, r *http.Request
 		http.Error(w, "Function name is required", http.StatusBadRequest)
 		return
 	}
+	if r.Method == http.MethodDelete {
+		s.handleResetMetrics(w, r, name)
+		return
+	}
+
+	window, ok := parseWindow(w, r)
+	if !ok {
+		return
+	}
+	if window > 0 {
+		metrics, exists := s.registry.GetFunctionWindowMetrics(name, window)
+		if !exists {
+			http.Error(w, fmt.Sprintf("Function %s not found", name), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(metrics)
+		return
+	}
 
 	metrics, exists := s.registry.GetFunctionMetrics(name)
 	if !exists {
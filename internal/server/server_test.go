@@ -2,14 +2,25 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
 	"github.com/mstgnz/self-hosted-serverless/internal/common"
 	"github.com/mstgnz/self-hosted-serverless/internal/function"
+	"github.com/mstgnz/self-hosted-serverless/internal/health"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // MockFunctionHandler is a mock implementation of FunctionHandler for testing
@@ -65,6 +76,80 @@ func TestHandleHealth(t *testing.T) {
 	assert.Equal(t, "ok", response["status"])
 }
 
+func TestHandleHealthReady(t *testing.T) {
+	server := setupTestServer()
+	server.health = health.NewChecker()
+	server.health.Register("runtime", func(ctx context.Context) error { return nil }, true)
+	server.health.Register("database", func(ctx context.Context) error { return errors.New("connection refused") }, false)
+
+	// A non-critical failure is reported but the server stays ready
+	req := httptest.NewRequest("GET", "/health/ready", nil)
+	w := httptest.NewRecorder()
+	server.handleHealthReady(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var report health.Report
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, health.StatusDegraded, report.Status)
+	assert.Equal(t, health.StatusDown, report.Components["database"].Status)
+	assert.NotContains(t, w.Body.String(), "connection refused")
+
+	// A critical failure makes the server not ready
+	server.health.Register("runtime", func(ctx context.Context) error { return errors.New("not initialized") }, true)
+	w = httptest.NewRecorder()
+	server.handleHealthReady(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestAuthMiddlewareClientCert(t *testing.T) {
+	server := setupTestServer()
+	server.auth = auth.NewAuthenticator("secret", nil).WithClientCerts([]auth.CertIdentity{
+		{CommonName: "billing-service", Scopes: []string{auth.ScopeInvoke}, Functions: []string{"charge"}},
+	})
+
+	var identity auth.Identity
+	handler := server.authMiddleware(auth.ScopeInvoke, func(w http.ResponseWriter, r *http.Request) {
+		identity, _ = auth.FromContext(r.Context())
+	})
+
+	// Without a key or a client certificate the request is rejected
+	req := httptest.NewRequest("GET", "/functions", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// A verified client certificate with a mapping replaces the key
+	req.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "billing-service"}}},
+	}
+	w = httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, auth.Identity{
+		Subject:   "billing-service",
+		Method:    auth.MethodClientCert,
+		Scopes:    []string{auth.ScopeInvoke},
+		Functions: []string{"charge"},
+	}, identity)
+
+	// Its scopes are those of the mapping
+	admin := server.authMiddleware(auth.ScopeAdmin, func(w http.ResponseWriter, r *http.Request) {})
+	w = httptest.NewRecorder()
+	admin(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// A certificate without a mapping still needs a key
+	req.TLS.PeerCertificates[0].Subject.CommonName = "unknown"
+	w = httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	req.Header.Set("X-API-Key", "secret")
+	w = httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, auth.MethodAPIKey, identity.Method)
+}
+
 func TestHandleRunFunction(t *testing.T) {
 	server := setupTestServer()
 
@@ -94,6 +179,30 @@ func TestHandleRunFunction(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 }
 
+func TestStreamOutlastsWriteTimeout(t *testing.T) {
+	server := setupTestServer()
+	server.registry.Register("slow-stream", &MockStreamingHandler{
+		Chunks: []interface{}{1, 2, 3, 4},
+		Delay:  100 * time.Millisecond,
+	}, common.FunctionInfo{Name: "slow-stream", Runtime: "go"})
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(server.handleRunFunction))
+	ts.Config.WriteTimeout = 150 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	req, err := http.NewRequest("POST", ts.URL+"/run/slow-stream", strings.NewReader(`{}`))
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/x-ndjson")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "1\n2\n3\n4\n", string(body))
+}
+
 func TestHandleListFunctions(t *testing.T) {
 	server := setupTestServer()
 
@@ -153,3 +262,148 @@ func TestHandlePublishEvent(t *testing.T) {
 	server.handlePublishEvent(w, req)
 	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
 }
+
+func TestHandleRunFunctionHTTPEvent(t *testing.T) {
+	server := setupTestServer()
+
+	var received common.HTTPRequest
+	mockHandler := &MockFunctionHandler{
+		ExecuteFunc: func(input map[string]interface{}) (interface{}, error) {
+			req, err := common.ParseHTTPRequest(input)
+			if err != nil {
+				return nil, err
+			}
+			received = req
+			return common.HTTPResponse{
+				StatusCode: http.StatusCreated,
+				Headers: map[string]common.HeaderValues{
+					"Content-Type": {"text/plain"},
+					"X-Custom":     {"a", "b"},
+				},
+				Body: "created",
+			}, nil
+		},
+	}
+	info := common.FunctionInfo{
+		Name:     "http-function",
+		Runtime:  "go",
+		HTTPMode: common.HTTPModeEvent,
+	}
+	server.registry.Register(info.Name, mockHandler, info)
+
+	// Send a request with a sub-path, query string, custom header and binary body
+	req := httptest.NewRequest("PUT", "/run/http-function/items/1?tag=x&tag=y", bytes.NewReader([]byte{0xff, 0xfe}))
+	req.Header.Set("X-Request", "value")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-API-Key", "secret")
+	w := httptest.NewRecorder()
+	server.handleRunFunction(w, req)
+
+	// Check the request envelope the function received
+	assert.Equal(t, "PUT", received.Method)
+	assert.Equal(t, "/run/http-function/items/1", received.Path)
+	assert.Equal(t, []string{"x", "y"}, received.Query["tag"])
+	assert.Equal(t, []string{"value"}, received.Headers["X-Request"])
+	// Credentials are withheld from the function
+	assert.NotContains(t, received.Headers, "Authorization")
+	assert.NotContains(t, received.Headers, "X-Api-Key")
+	assert.True(t, received.IsBase64Encoded)
+	body, err := received.DecodedBody()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 0xfe}, body)
+
+	// Check the response was written faithfully
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+	assert.Equal(t, []string{"a", "b"}, w.Header().Values("X-Custom"))
+	assert.Equal(t, "created", w.Body.String())
+
+	// An invalid response envelope is reported as a bad gateway
+	mockHandler.ExecuteFunc = func(input map[string]interface{}) (interface{}, error) {
+		return "not an envelope", nil
+	}
+	req = httptest.NewRequest("GET", "/run/http-function", nil)
+	w = httptest.NewRecorder()
+	server.handleRunFunction(w, req)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+
+	// So are statuses that aren't final responses
+	for _, status := range []int{101, 103, 600} {
+		mockHandler.ExecuteFunc = func(input map[string]interface{}) (interface{}, error) {
+			return common.HTTPResponse{StatusCode: status}, nil
+		}
+		req = httptest.NewRequest("GET", "/run/http-function", nil)
+		w = httptest.NewRecorder()
+		server.handleRunFunction(w, req)
+		assert.Equal(t, http.StatusBadGateway, w.Code, "status %d", status)
+	}
+
+	// Sub-paths are rejected for functions in JSON mode
+	req = httptest.NewRequest("POST", "/run/test-function/extra", bytes.NewBufferString("{}"))
+	w = httptest.NewRecorder()
+	server.handleRunFunction(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// MockStreamingHandler is a mock implementation of StreamingFunctionHandler for testing
+type MockStreamingHandler struct {
+	MockFunctionHandler
+	Chunks []interface{}
+	Err    error
+	// Delay is how long to wait before each chunk
+	Delay time.Duration
+}
+
+// ExecuteStream emits the configured chunks followed by the configured error
+func (m *MockStreamingHandler) ExecuteStream(input map[string]interface{}, emit func(chunk interface{}) error) error {
+	for _, chunk := range m.Chunks {
+		time.Sleep(m.Delay)
+		if err := emit(chunk); err != nil {
+			return err
+		}
+	}
+	return m.Err
+}
+
+func TestHandleRunFunctionStream(t *testing.T) {
+	server := setupTestServer()
+
+	handler := &MockStreamingHandler{Chunks: []interface{}{
+		map[string]interface{}{"progress": 50},
+		map[string]interface{}{"progress": 100},
+	}}
+	server.registry.Register("stream-function", handler, common.FunctionInfo{Name: "stream-function", Runtime: "go"})
+
+	// Server-sent events
+	req := httptest.NewRequest("POST", "/run/stream-function", bytes.NewBufferString(`{}`))
+	req.Header.Set("Accept", "text/event-stream")
+	w := httptest.NewRecorder()
+	server.handleRunFunction(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "data: {\"progress\":50}\n\ndata: {\"progress\":100}\n\nevent: done\ndata: {}\n\n", w.Body.String())
+
+	// Newline-delimited JSON
+	req = httptest.NewRequest("POST", "/run/stream-function", bytes.NewBufferString(`{}`))
+	req.Header.Set("Accept", "application/x-ndjson")
+	w = httptest.NewRecorder()
+	server.handleRunFunction(w, req)
+	assert.Equal(t, "{\"progress\":50}\n{\"progress\":100}\n", w.Body.String())
+
+	// Errors after the stream started are reported in-band
+	handler.Err = errors.New("boom")
+	req = httptest.NewRequest("POST", "/run/stream-function", bytes.NewBufferString(`{}`))
+	req.Header.Set("Accept", "text/event-stream")
+	w = httptest.NewRecorder()
+	server.handleRunFunction(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "event: error\ndata: {\"error\":\"boom\"}\n\n")
+
+	// Errors before the first chunk are reported with a status code
+	handler.Chunks = nil
+	req = httptest.NewRequest("POST", "/run/stream-function", bytes.NewBufferString(`{}`))
+	req.Header.Set("Accept", "text/event-stream")
+	w = httptest.NewRecorder()
+	server.handleRunFunction(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
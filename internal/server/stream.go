@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/event"
+	"github.com/mstgnz/self-hosted-serverless/internal/function"
+)
+
+// Streaming response formats a client can request via the Accept header
+const (
+	streamFormatSSE    = "text/event-stream"
+	streamFormatNDJSON = "application/x-ndjson"
+)
+
+// writeTimeout is how long the server may take to write a response. Streamed
+// responses get it for each chunk instead, so they can outlast it.
+const writeTimeout = 30 * time.Second
+
+// streamFormat returns the streaming format requested by the client, or an
+// empty string for a regular JSON response
+func streamFormat(r *http.Request) string {
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, streamFormatSSE):
+		return streamFormatSSE
+	case strings.Contains(accept, streamFormatNDJSON):
+		return streamFormatNDJSON
+	}
+	return ""
+}
+
+// streamFunction executes a function and writes each chunk it emits to the
+// client as soon as it is produced, either as server-sent events or as
+// newline-delimited JSON over a chunked response.
+func (s *Server) streamFunction(w http.ResponseWriter, r *http.Request, name string, input map[string]any, format string, opts []function.ExecOption) {
+	rc := http.NewResponseController(w)
+	// Lift the server's write deadline, which would cut the stream off
+	// while the function is still running
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("Warning: Failed to lift write deadline for stream of %s: %v", name, err)
+	}
+	started := false
+	chunks := 0
+
+	start := func() {
+		if started {
+			return
+		}
+		w.Header().Set("Content-Type", format)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		started = true
+	}
+
+	write := func(eventName string, data []byte) error {
+		// A client that stops reading still can't hold the stream forever
+		rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+		defer rc.SetWriteDeadline(time.Time{})
+
+		var err error
+		if format == streamFormatSSE {
+			if eventName != "" {
+				_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventName, data)
+			} else {
+				_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+			}
+		} else {
+			_, err = fmt.Fprintf(w, "%s\n", data)
+		}
+		if err != nil {
+			return err
+		}
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		return nil
+	}
+
+	emit := func(chunk any) error {
+		data, err := json.Marshal(chunk)
+		if err != nil {
+			return fmt.Errorf("failed to encode chunk: %w", err)
+		}
+		start()
+		chunks++
+		return write("", data)
+	}
+
+	err := s.registry.ExecuteStream(name, input, emit, opts...)
+	if err != nil {
+		if !started {
+			writeExecutionError(w, name, err)
+			return
+		}
+		log.Printf("Error executing function %s: %v", name, err)
+		data, _ := json.Marshal(map[string]string{"error": err.Error()})
+		write("error", data)
+		return
+	}
+
+	start()
+	if format == streamFormatSSE {
+		write("done", []byte("{}"))
+	}
+
+	s.eventBus.Publish(r.Context(), event.Event{
+		Type: "function.executed",
+		Payload: map[string]any{
+			"function": name,
+			"input":    input,
+			"streamed": true,
+			"chunks":   chunks,
+		},
+	})
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/tracing"
+)
+
+// traceRequest names and describes the span of a served request. Like the
+// request metrics, the name uses the mux pattern that matched the request
+// rather than its path.
+func traceRequest(span *tracing.Span, r *http.Request, ip string, status int) {
+	if r.Pattern != "" {
+		span.SetName(r.Method + " " + r.Pattern)
+		span.SetAttribute("http.route", r.Pattern)
+	}
+	span.SetAttribute("http.request.method", r.Method)
+	span.SetAttribute("url.path", r.URL.Path)
+	span.SetAttribute("client.address", ip)
+	span.SetAttribute("http.response.status_code", status)
+	if status >= http.StatusInternalServerError {
+		span.SetFailed()
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryExporter keeps exported spans in memory
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (e *memoryExporter) Export(_ context.Context, spans []tracing.SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memoryExporter) Close() error {
+	return nil
+}
+
+func TestRequestTracing(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := tracing.NewTracer(exporter, tracing.Config{SampleRatio: 1})
+	tracing.SetGlobalTracer(tracer)
+	defer tracing.SetGlobalTracer(nil)
+
+	server := setupTestServer()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/run/", server.handleRunFunction)
+
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodPost, "/run/test-function", strings.NewReader(`{"a":1}`))
+	req.Header.Set("traceparent", parent)
+	w := httptest.NewRecorder()
+	server.accessLog(mux).ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, tracer.Close())
+
+	spans := make(map[string]tracing.SpanData)
+	for _, span := range exporter.spans {
+		spans[span.Name] = span
+	}
+	httpSpan, ok := spans["POST /run/"]
+	require.True(t, ok, "spans: %v", exporter.spans)
+	execSpan, ok := spans["execute test-function"]
+	require.True(t, ok)
+	publishSpan, ok := spans["publish function.executed"]
+	require.True(t, ok)
+
+	// The request continues the caller's trace, and the execution and the
+	// event it causes are part of it
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", httpSpan.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", httpSpan.ParentSpanID.String())
+	assert.Equal(t, tracing.SpanKindServer, httpSpan.Kind)
+	assert.Equal(t, 200, httpSpan.Attributes["http.response.status_code"])
+	assert.Equal(t, httpSpan.SpanID, execSpan.ParentSpanID)
+	assert.Equal(t, "http", execSpan.Attributes["function.trigger"])
+	assert.Equal(t, "success", execSpan.Attributes["function.outcome"])
+	assert.Equal(t, httpSpan.SpanID, publishSpan.ParentSpanID)
+
+	// The function is passed the trace context of its execution
+	var body struct {
+		Input map[string]any `json:"input"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	sc := tracing.SpanContextFromContext(tracing.ExtractInput(context.Background(), body.Input))
+	require.True(t, sc.IsValid())
+	assert.Equal(t, execSpan.SpanID, sc.SpanID)
+}
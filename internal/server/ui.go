@@ -0,0 +1,106 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+// uiRealm is the realm of the Basic auth challenge sent to browsers
+const uiRealm = "Serverless Dashboard"
+
+// uiHandler serves the dashboard under /ui/. Its files need the same
+// authentication as the API, and its data comes from the API itself, served
+// again under /ui/api/ so browsers send the dashboard's credentials with it.
+// Every API endpoint keeps its own scope checks.
+//
+// Browsers can't attach an X-API-Key header when loading a page, so under
+// /ui/ the key may also be sent as the password of HTTP Basic auth, and
+// unauthenticated requests are challenged for it. Since browsers send such
+// credentials with cross-site requests too, API requests must come from the
+// dashboard's own origin.
+func (s *Server) uiHandler(api http.Handler) http.Handler {
+	static, _ := fs.Sub(uiFiles, "ui")
+	files := s.protected("", http.StripPrefix("/ui/", http.FileServer(http.FS(static))).ServeHTTP)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withBasicKey(r)
+		w = &challengeWriter{ResponseWriter: w}
+
+		rest, isAPI := strings.CutPrefix(r.URL.Path, "/ui/api/")
+		if !isAPI {
+			w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			files(w, r)
+			return
+		}
+
+		if !sameOrigin(r) {
+			s.auditDenied(r, "cross-origin dashboard request")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if rest == "ui" || strings.HasPrefix(rest, "ui/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		r2 := r.Clone(r.Context())
+		r2.URL = &url.URL{Path: "/" + rest, RawQuery: r.URL.RawQuery}
+		r2.RequestURI = r2.URL.RequestURI()
+		api.ServeHTTP(w, r2)
+	})
+}
+
+// withBasicKey returns r with the password of its Basic auth credentials, if
+// any, as its X-API-Key header
+func withBasicKey(r *http.Request) *http.Request {
+	if r.Header.Get("X-API-Key") != "" {
+		return r
+	}
+	_, key, ok := r.BasicAuth()
+	if !ok || key == "" {
+		return r
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set("X-API-Key", key)
+	return r
+}
+
+// sameOrigin reports whether a request was made by a page of the server's own
+// origin, going by the Sec-Fetch-Site header browsers send or, failing that,
+// the Origin header. Requests with neither don't come from a browser page.
+func sameOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin" || site == "none"
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// challengeWriter asks browsers for credentials when a request is
+// unauthorized
+type challengeWriter struct {
+	http.ResponseWriter
+}
+
+func (w *challengeWriter) WriteHeader(status int) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+uiRealm+`", charset="UTF-8"`)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *challengeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,344 @@
+// Serverless dashboard. Everything it shows comes from the server's API,
+// reached under /ui/api with the dashboard's credentials.
+'use strict';
+
+const API = '/ui/api';
+// Samples of the windowed metrics kept per function for the latency chart
+const MAX_SAMPLES = 60;
+
+const state = {
+  functions: [],
+  selected: null,
+  samples: {},
+  events: null,
+  eventsAt: 0,
+  timer: null,
+};
+
+const $ = (id) => document.getElementById(id);
+
+// ApiError is thrown for responses other than 2xx
+class ApiError extends Error {
+  constructor(status, message) {
+    super(message);
+    this.status = status;
+  }
+}
+
+async function api(path, options = {}) {
+  const res = await fetch(API + path, { credentials: 'same-origin', ...options });
+  const text = await res.text();
+  if (!res.ok) {
+    throw new ApiError(res.status, text.trim() || res.statusText);
+  }
+  return text ? JSON.parse(text) : null;
+}
+
+// describeError explains why a panel could not be loaded
+function describeError(err) {
+  if (err instanceof ApiError) {
+    switch (err.status) {
+      case 403: return 'Your key lacks the scope needed to see this.';
+      case 501: return err.message;
+    }
+    return `Error ${err.status}: ${err.message}`;
+  }
+  return err.message;
+}
+
+// Durations are reported in nanoseconds
+function formatDuration(ns) {
+  if (!ns) return '0';
+  const ms = ns / 1e6;
+  if (ms < 1) return `${(ns / 1e3).toFixed(0)}µs`;
+  if (ms < 1000) return `${ms.toFixed(ms < 10 ? 2 : 1)}ms`;
+  return `${(ms / 1000).toFixed(2)}s`;
+}
+
+function formatTime(value) {
+  const date = new Date(value);
+  return isNaN(date) ? '' : date.toLocaleTimeString();
+}
+
+// el creates an element with the given class and text
+function el(tag, text, className) {
+  const node = document.createElement(tag);
+  if (text !== undefined && text !== null) node.textContent = String(text);
+  if (className) node.className = className;
+  return node;
+}
+
+function row(cells) {
+  const tr = document.createElement('tr');
+  for (const cell of cells) {
+    tr.appendChild(cell instanceof Node ? cell : el('td', cell));
+  }
+  return tr;
+}
+
+function num(value) {
+  return el('td', value, 'num');
+}
+
+function setMessage(id, text) {
+  $(id).textContent = text || '';
+}
+
+async function loadFunctions() {
+  try {
+    const [list, all] = await Promise.all([api('/functions'), api('/metrics')]);
+    state.functions = (list.functions || []).sort((a, b) => a.name.localeCompare(b.name));
+    renderFunctions(all.metrics || {});
+    renderEvents(all.events || {});
+    setMessage('functions-message', state.functions.length ? '' : 'No functions are registered.');
+    setMessage('events-message', '');
+  } catch (err) {
+    setMessage('functions-message', describeError(err));
+    setMessage('events-message', describeError(err));
+    throw err;
+  }
+}
+
+function renderFunctions(metrics) {
+  const body = $('functions');
+  body.replaceChildren();
+  for (const fn of state.functions) {
+    const m = metrics[fn.name] || {};
+    const latency = m.latency || {};
+    const tr = row([
+      el('td', fn.name),
+      el('td', fn.runtime + (fn.http_mode ? ` (${fn.http_mode})` : '')),
+      el('td', fn.version || ''),
+      el('td', fn.description || '', 'description'),
+      num(m.execution_count || 0),
+      num(m.error_count || 0),
+      num(m.cold_start_count || 0),
+      num(formatDuration(m.average_duration)),
+      num(formatDuration(latency.p50)),
+      num(formatDuration(latency.p95)),
+      num(formatDuration(latency.p99)),
+    ]);
+    if (fn.name === state.selected) tr.classList.add('selected');
+    tr.addEventListener('click', () => selectFunction(fn.name));
+    body.appendChild(tr);
+  }
+  renderInvokeOptions();
+}
+
+function renderInvokeOptions() {
+  const select = $('invoke-function');
+  const current = select.value || state.selected;
+  const names = state.functions.map((fn) => fn.name);
+  if (select.options.length === names.length && names.every((name, i) => select.options[i].value === name)) {
+    return;
+  }
+  select.replaceChildren(...names.map((name) => {
+    const option = el('option', name);
+    option.value = name;
+    return option;
+  }));
+  if (names.includes(current)) select.value = current;
+}
+
+function selectFunction(name) {
+  state.selected = name;
+  $('invoke-function').value = name;
+  for (const tr of $('functions').children) {
+    tr.classList.toggle('selected', tr.firstChild.textContent === name);
+  }
+  renderChart();
+}
+
+async function loadWindow() {
+  const data = await api('/metrics?window=1m');
+  const now = Date.now();
+  for (const [name, m] of Object.entries(data.metrics || {})) {
+    const samples = state.samples[name] || (state.samples[name] = []);
+    samples.push({ at: now, rate: m.invocation_rate, ...m.latency });
+    if (samples.length > MAX_SAMPLES) samples.shift();
+  }
+  renderChart();
+}
+
+function svg(tag, attrs) {
+  const node = document.createElementNS('http://www.w3.org/2000/svg', tag);
+  for (const [key, value] of Object.entries(attrs)) node.setAttribute(key, value);
+  return node;
+}
+
+function renderChart() {
+  const chart = $('chart');
+  chart.replaceChildren();
+  $('chart-title').textContent = state.selected ? `— ${state.selected}` : '';
+  $('chart-rate').textContent = '';
+
+  const samples = state.selected ? state.samples[state.selected] || [] : [];
+  if (!state.selected) {
+    setMessage('chart-message', 'Select a function to chart its latency over the last minute.');
+    return;
+  }
+  if (samples.length < 2) {
+    setMessage('chart-message', 'Collecting samples…');
+    return;
+  }
+  setMessage('chart-message', '');
+
+  const width = 600, height = 220, left = 56, bottom = 20, top = 10;
+  const max = Math.max(1, ...samples.map((s) => s.p99 || 0));
+  const x = (i) => left + (i / (MAX_SAMPLES - 1)) * (width - left - 4);
+  const y = (v) => top + (1 - v / max) * (height - top - bottom);
+
+  for (const fraction of [0, 0.5, 1]) {
+    const v = max * fraction;
+    chart.appendChild(svg('line', { class: 'grid', x1: left, x2: width, y1: y(v), y2: y(v) }));
+    const label = svg('text', { class: 'axis', x: 4, y: y(v) + 4 });
+    label.textContent = formatDuration(v);
+    chart.appendChild(label);
+  }
+
+  const offset = MAX_SAMPLES - samples.length;
+  for (const key of ['p50', 'p95', 'p99']) {
+    const points = samples.map((s, i) => `${x(i + offset).toFixed(1)},${y(s[key] || 0).toFixed(1)}`).join(' ');
+    chart.appendChild(svg('polyline', { class: key, points }));
+  }
+
+  const last = samples[samples.length - 1];
+  $('chart-rate').textContent = `${(last.rate || 0).toFixed(2)} invocations/s over the last minute`;
+}
+
+function renderEvents(events) {
+  const now = Date.now();
+  const previous = state.events;
+  const elapsed = (now - state.eventsAt) / 1000;
+  state.events = events;
+  state.eventsAt = now;
+
+  const body = $('events');
+  body.replaceChildren();
+  const types = Object.keys(events).sort();
+  for (const type of types) {
+    const e = events[type];
+    let rate = '';
+    if (previous && elapsed > 0) {
+      const before = previous[type] ? previous[type].published : 0;
+      rate = ((e.published - before) / elapsed).toFixed(2);
+    }
+    body.appendChild(row([el('td', type), num(e.subscribers), num(e.published), num(e.delivered), num(e.failed), num(rate)]));
+  }
+  if (!types.length) setMessage('events-message', 'No events have been published.');
+}
+
+async function loadInvocations() {
+  try {
+    const data = await api('/invocations?limit=20');
+    const body = $('invocations');
+    body.replaceChildren();
+    for (const inv of data.invocations || []) {
+      body.appendChild(row([
+        el('td', formatTime(inv.started_at)),
+        el('td', inv.function),
+        el('td', inv.trigger),
+        el('td', inv.caller || ''),
+        el('td', inv.outcome, `outcome-${inv.outcome}`),
+        num(formatDuration(inv.duration)),
+      ]));
+    }
+    setMessage('invocations-message', data.invocations && data.invocations.length ? '' : 'No invocations recorded yet.');
+  } catch (err) {
+    setMessage('invocations-message', describeError(err));
+  }
+
+  try {
+    const [errors, timeouts] = await Promise.all([
+      api('/invocations?status=error&limit=10'),
+      api('/invocations?status=timeout&limit=10'),
+    ]);
+    const failures = [...(errors.invocations || []), ...(timeouts.invocations || [])]
+      .sort((a, b) => new Date(b.started_at) - new Date(a.started_at))
+      .slice(0, 10);
+    const list = $('errors');
+    list.replaceChildren();
+    for (const inv of failures) {
+      const item = el('li');
+      item.appendChild(el('div', `${formatTime(inv.started_at)} · ${inv.function} · ${inv.trigger} · ${formatDuration(inv.duration)}`, 'meta'));
+      item.appendChild(el('div', inv.error || inv.outcome, 'text'));
+      list.appendChild(item);
+    }
+    setMessage('errors-message', failures.length ? '' : 'No failed invocations recorded.');
+  } catch (err) {
+    setMessage('errors-message', describeError(err));
+  }
+}
+
+async function invoke(event) {
+  event.preventDefault();
+  const name = $('invoke-function').value;
+  const output = $('invoke-output');
+  const status = $('invoke-status');
+  output.className = '';
+  if (!name) return;
+
+  let body;
+  try {
+    body = JSON.stringify(JSON.parse($('invoke-input').value || '{}'));
+  } catch (err) {
+    output.className = 'error';
+    output.textContent = `Invalid JSON: ${err.message}`;
+    return;
+  }
+
+  const button = event.submitter || $('invoke-form').querySelector('button');
+  button.disabled = true;
+  status.textContent = `Invoking ${name}…`;
+  const started = performance.now();
+  try {
+    const res = await fetch(`${API}/run/${encodeURIComponent(name)}`, {
+      method: 'POST',
+      credentials: 'same-origin',
+      headers: { 'Content-Type': 'application/json' },
+      body,
+    });
+    const text = await res.text();
+    const elapsed = performance.now() - started;
+    status.textContent = `${res.status} ${res.statusText} in ${elapsed.toFixed(0)}ms · request ${res.headers.get('X-Request-ID') || ''}`;
+    if (!res.ok) output.className = 'error';
+    try {
+      output.textContent = JSON.stringify(JSON.parse(text), null, 2);
+    } catch {
+      output.textContent = text;
+    }
+  } catch (err) {
+    output.className = 'error';
+    output.textContent = err.message;
+    status.textContent = '';
+  } finally {
+    button.disabled = false;
+  }
+  refresh();
+}
+
+async function refresh() {
+  const status = $('status');
+  try {
+    await loadFunctions();
+    await Promise.all([loadWindow(), loadInvocations()]);
+    status.className = 'status';
+    status.textContent = `Updated ${new Date().toLocaleTimeString()}`;
+  } catch (err) {
+    status.className = 'status error';
+    status.textContent = describeError(err);
+  }
+}
+
+function schedule() {
+  clearInterval(state.timer);
+  const interval = Number($('refresh').value);
+  if (interval > 0) state.timer = setInterval(refresh, interval);
+}
+
+document.addEventListener('DOMContentLoaded', () => {
+  $('invoke-form').addEventListener('submit', invoke);
+  $('refresh').addEventListener('change', schedule);
+  refresh();
+  schedule();
+});
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/mstgnz/self-hosted-serverless/internal/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupUIServer() http.Handler {
+	server := setupTestServer()
+	server.auth = auth.NewAuthenticator("secret", nil)
+	server.eventBus = event.NewBus()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/functions", server.protected("", server.handleListFunctions))
+	mux.HandleFunc("/metrics", server.protected(auth.ScopeMetricsRead, server.handleGetMetrics))
+	mux.Handle("/ui/", server.uiHandler(mux))
+	return mux
+}
+
+func TestDashboardAssets(t *testing.T) {
+	handler := setupUIServer()
+
+	// Unauthenticated browsers are asked for the key
+	req := httptest.NewRequest(http.MethodGet, "/ui/", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), `Basic realm="Serverless Dashboard"`)
+
+	// The key is accepted as the Basic auth password
+	req = httptest.NewRequest(http.MethodGet, "/ui/", nil)
+	req.SetBasicAuth("admin", "secret")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<script src="app.js" defer></script>`)
+	assert.Contains(t, w.Header().Get("Content-Security-Policy"), "default-src 'self'")
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+
+	// And so is the API key header
+	for _, file := range []string{"app.js", "style.css"} {
+		req = httptest.NewRequest(http.MethodGet, "/ui/"+file, nil)
+		req.Header.Set("X-API-Key", "secret")
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, file)
+	}
+
+	// A wrong password is rejected
+	req = httptest.NewRequest(http.MethodGet, "/ui/", nil)
+	req.SetBasicAuth("admin", "wrong")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestDashboardAPI(t *testing.T) {
+	handler := setupUIServer()
+
+	get := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.SetBasicAuth("", "secret")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// API endpoints are served under /ui/api
+	w := get("/ui/api/functions", map[string]string{"Sec-Fetch-Site": "same-origin"})
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Functions []struct {
+			Name string `json:"name"`
+		} `json:"functions"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Functions, 1)
+	assert.Equal(t, "test-function", list.Functions[0].Name)
+
+	// Metrics report event bus throughput
+	w = get("/ui/api/metrics", map[string]string{"Origin": "http://example.com"})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"events":{}`)
+
+	// Requests from other sites are refused
+	w = get("/ui/api/functions", map[string]string{"Sec-Fetch-Site": "cross-site"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = get("/ui/api/functions", map[string]string{"Origin": "http://evil.example"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// The dashboard isn't served again under its own API
+	w = get("/ui/api/ui/", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Unknown endpoints aren't found
+	w = get("/ui/api/unknown", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
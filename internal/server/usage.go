@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/history"
+)
+
+// handleUsage reports what executions consumed between from and to, grouped
+// by function or API key, as JSON or CSV
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	store := s.registry.History()
+	if store == nil {
+		http.Error(w, "Invocation history not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := history.UsageFilter{GroupBy: query.Get("group_by")}
+	switch filter.GroupBy {
+	case "":
+		filter.GroupBy = history.GroupByFunction
+	case history.GroupByFunction, history.GroupByKey:
+	default:
+		http.Error(w, "Invalid group_by: must be function or key", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	var err error
+	if filter.Since, err = parseTime(query.Get("from"), now); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid from: %v", err), http.StatusBadRequest)
+		return
+	}
+	if filter.Until, err = parseTime(query.Get("to"), now); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid to: %v", err), http.StatusBadRequest)
+		return
+	}
+	if filter.Until.IsZero() {
+		filter.Until = now
+	}
+
+	usage, err := store.Usage(filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error querying usage: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if query.Get("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		writeUsageCSV(w, filter.GroupBy, usage)
+		return
+	}
+
+	report := map[string]any{
+		"group_by": filter.GroupBy,
+		"to":       filter.Until.UTC(),
+		"usage":    usage,
+	}
+	if !filter.Since.IsZero() {
+		report["from"] = filter.Since.UTC()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// writeUsageCSV writes a usage report as CSV with a header row
+func writeUsageCSV(w http.ResponseWriter, groupBy string, usage []history.Usage) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	header := []string{"function"}
+	if groupBy == history.GroupByKey {
+		header = []string{"key_id", "caller"}
+	}
+	header = append(header, "invocations", "errors", "wall_time_secs", "input_bytes", "output_bytes",
+		"db_queries", "peak_memory_pages", "memory_page_secs")
+
+	out := csv.NewWriter(w)
+	out.Write(header)
+	for _, u := range usage {
+		row := []string{u.Function}
+		if groupBy == history.GroupByKey {
+			row = []string{u.KeyID, u.Caller}
+		}
+		row = append(row,
+			strconv.FormatInt(u.Invocations, 10),
+			strconv.FormatInt(u.Errors, 10),
+			strconv.FormatFloat(u.WallTimeSecs, 'f', 3, 64),
+			strconv.FormatInt(u.InputBytes, 10),
+			strconv.FormatInt(u.OutputBytes, 10),
+			strconv.FormatInt(u.DBQueries, 10),
+			strconv.FormatInt(u.PeakMemoryPages, 10),
+			strconv.FormatFloat(u.MemoryPageSecs, 'f', 3, 64),
+		)
+		out.Write(row)
+	}
+	out.Flush()
+}
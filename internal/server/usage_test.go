@@ -0,0 +1,86 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/mstgnz/self-hosted-serverless/internal/history"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageEndpoint(t *testing.T) {
+	server := setupTestServer()
+
+	// Without a store the endpoint is disabled
+	req := httptest.NewRequest(http.MethodGet, "/usage", nil)
+	w := httptest.NewRecorder()
+	server.handleUsage(w, req)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	store, err := history.NewStore(db, history.DefaultConfig())
+	require.NoError(t, err)
+	server.registry.SetHistory(store)
+
+	// Two calls with an API key and one with a bearer token
+	for _, identity := range []auth.Identity{
+		{Subject: "billing", KeyID: "k1", Scopes: []string{auth.ScopeInvoke}},
+		{Subject: "billing", KeyID: "k1", Scopes: []string{auth.ScopeInvoke}},
+		{Subject: "web", Scopes: []string{auth.ScopeInvoke}},
+	} {
+		req = httptest.NewRequest(http.MethodPost, "/run/test-function", strings.NewReader(`{"a":1}`))
+		req = req.WithContext(auth.NewContext(req.Context(), identity))
+		server.handleRunFunction(httptest.NewRecorder(), req)
+	}
+	require.NoError(t, store.Close())
+
+	get := func(rawQuery string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/usage?"+rawQuery, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		server.handleUsage(w, req)
+		return w
+	}
+
+	w = get("from=1h", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var report struct {
+		GroupBy string          `json:"group_by"`
+		Usage   []history.Usage `json:"usage"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, history.GroupByFunction, report.GroupBy)
+	require.Len(t, report.Usage, 1)
+	assert.Equal(t, "test-function", report.Usage[0].Function)
+	assert.Equal(t, int64(3), report.Usage[0].Invocations)
+
+	w = get("group_by=key&format=csv", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/csv")
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	assert.Equal(t, []string{"key_id", "caller", "invocations"}, rows[0][:3])
+	assert.Equal(t, []string{"", "web", "1"}, rows[1][:3])
+	assert.Equal(t, []string{"k1", "billing", "2"}, rows[2][:3])
+
+	// The Accept header asks for CSV too
+	w = get("", http.Header{"Accept": {"text/csv"}})
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/csv")
+
+	assert.Equal(t, http.StatusBadRequest, get("group_by=region", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, get("from=yesterday", nil).Code)
+}
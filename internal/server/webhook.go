@@ -0,0 +1,48 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/mstgnz/self-hosted-serverless/internal/webhook"
+)
+
+// runAuthMiddleware authenticates calls to /run/{name}. Functions with a
+// webhook secret are called by third parties that can't send an API key, so
+// their requests must be signed instead.
+func (s *Server) runAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/run/"), "/")
+		if verifier, ok := s.webhooks[name]; ok {
+			s.webhookMiddleware(verifier, maxRequestBody, next)(w, r)
+			return
+		}
+		s.authMiddleware(auth.ScopeInvoke, next)(w, r)
+	}
+}
+
+// webhookMiddleware verifies the signature of a webhook against the raw
+// request body before the body is decoded, and rejects unsigned or forged
+// requests with 401
+func (s *Server) webhookMiddleware(verifier *webhook.Verifier, maxBody int64, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := verifier.Verify(r.Header, body, time.Now()); err != nil {
+			s.auditDenied(r, "webhook signature: "+err.Error())
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	}
+}
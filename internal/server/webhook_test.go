@@ -0,0 +1,77 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mstgnz/self-hosted-serverless/internal/auth"
+	"github.com/mstgnz/self-hosted-serverless/internal/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func signWebhook(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookSignature(t *testing.T) {
+	server := setupTestServer()
+	server.auth = auth.NewAuthenticator("admin-key", nil)
+	verifier, err := webhook.NewVerifier(webhook.Config{Secret: "s3cret", Header: "X-Hub-Signature-256", Prefix: "sha256="})
+	require.NoError(t, err)
+	server.webhooks = map[string]*webhook.Verifier{"test-function": verifier}
+	require.NoError(t, server.router.Add(Route{Method: "POST", Path: "/hooks/github", Function: "test-function", Public: true}))
+
+	body := `{"action":"opened"}`
+	tests := []struct {
+		name      string
+		path      string
+		signature string
+		apiKey    string
+		want      int
+	}{
+		{"signed", "/run/test-function", signWebhook("s3cret", body), "", http.StatusOK},
+		{"wrong secret", "/run/test-function", signWebhook("other", body), "", http.StatusUnauthorized},
+		{"unsigned", "/run/test-function", "", "", http.StatusUnauthorized},
+		{"API key is not a signature", "/run/test-function", "", "admin-key", http.StatusUnauthorized},
+		{"signed route", "/hooks/github", signWebhook("s3cret", body), "", http.StatusOK},
+		{"unsigned public route", "/hooks/github", "", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(body))
+			if tt.signature != "" {
+				req.Header.Set("X-Hub-Signature-256", tt.signature)
+			}
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+			if strings.HasPrefix(tt.path, "/run/") {
+				server.runAuthMiddleware(server.handleRunFunction)(w, req)
+			} else {
+				server.handleRoute(w, req)
+			}
+			assert.Equal(t, tt.want, w.Code)
+			if tt.want == http.StatusOK {
+				// The function still receives the decoded body
+				assert.Contains(t, w.Body.String(), `"action":"opened"`)
+			}
+		})
+	}
+
+	// Functions without a webhook still require the API key
+	delete(server.webhooks, "test-function")
+	req := httptest.NewRequest(http.MethodPost, "/run/test-function", strings.NewReader(body))
+	req.Header.Set("X-Hub-Signature-256", signWebhook("s3cret", body))
+	w := httptest.NewRecorder()
+	server.runAuthMiddleware(server.handleRunFunction)(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
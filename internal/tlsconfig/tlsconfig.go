@@ -0,0 +1,226 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Client certificate modes accepted in TLS_CLIENT_AUTH
+const (
+	ClientAuthRequire  = "require"
+	ClientAuthOptional = "optional"
+)
+
+// Config describes the certificate files used to serve TLS
+type Config struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	// ClientAuth is ClientAuthRequire or ClientAuthOptional when ClientCAFile
+	// is set, and empty otherwise
+	ClientAuth string
+}
+
+// ConfigFromEnv reads TLS_CERT_FILE, TLS_KEY_FILE, TLS_CLIENT_CA_FILE and
+// TLS_CLIENT_AUTH. TLS is disabled when no certificate is configured.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		CertFile:     os.Getenv("TLS_CERT_FILE"),
+		KeyFile:      os.Getenv("TLS_KEY_FILE"),
+		ClientCAFile: os.Getenv("TLS_CLIENT_CA_FILE"),
+		ClientAuth:   os.Getenv("TLS_CLIENT_AUTH"),
+	}
+	return cfg, cfg.validate()
+}
+
+// Enabled reports whether TLS is configured
+func (c Config) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+func (c *Config) validate() error {
+	if !c.Enabled() {
+		if c.ClientCAFile != "" || c.ClientAuth != "" {
+			return errors.New("TLS_CLIENT_CA_FILE and TLS_CLIENT_AUTH require TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return errors.New("both TLS_CERT_FILE and TLS_KEY_FILE must be set")
+	}
+
+	if c.ClientCAFile == "" {
+		if c.ClientAuth != "" {
+			return errors.New("TLS_CLIENT_AUTH requires TLS_CLIENT_CA_FILE")
+		}
+		return nil
+	}
+	switch c.ClientAuth {
+	case "":
+		c.ClientAuth = ClientAuthRequire
+	case ClientAuthRequire, ClientAuthOptional:
+	default:
+		return fmt.Errorf("invalid TLS_CLIENT_AUTH %q: must be %q or %q", c.ClientAuth, ClientAuthRequire, ClientAuthOptional)
+	}
+	return nil
+}
+
+// Reloader serves the configured certificates and picks up changes to the
+// files without a restart. The files are checked on every handshake and
+// reloaded when their modification time changes; if a reload fails the
+// previous certificates stay in use.
+type Reloader struct {
+	cfg      Config
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	modTimes []time.Time
+}
+
+// NewReloader loads the configured certificates
+func NewReloader(cfg Config) (*Reloader, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if !cfg.Enabled() {
+		return nil, errors.New("TLS is not configured")
+	}
+
+	r := &Reloader{cfg: cfg}
+	modTimes, err := r.fileModTimes()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTimes); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns a server TLS configuration backed by the reloader. When
+// a client CA is configured, client certificates are verified against the
+// current CA pool.
+func (r *Reloader) TLSConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.getCertificate,
+	}
+
+	// Client certificates are verified in VerifyConnection rather than by
+	// setting ClientCAs so that a reloaded CA takes effect immediately.
+	switch r.cfg.ClientAuth {
+	case ClientAuthRequire:
+		cfg.ClientAuth = tls.RequireAnyClientCert
+		cfg.VerifyConnection = r.verifyConnection
+	case ClientAuthOptional:
+		cfg.ClientAuth = tls.RequestClientCert
+		cfg.VerifyConnection = r.verifyConnection
+	}
+	return cfg
+}
+
+func (r *Reloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.reloadIfChanged()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+func (r *Reloader) verifyConnection(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		if r.cfg.ClientAuth == ClientAuthRequire {
+			return errors.New("client certificate required")
+		}
+		return nil
+	}
+
+	r.mu.RLock()
+	pool := r.pool
+	r.mu.RUnlock()
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return fmt.Errorf("invalid client certificate: %w", err)
+	}
+	return nil
+}
+
+func (r *Reloader) reloadIfChanged() {
+	modTimes, err := r.fileModTimes()
+	if err != nil {
+		log.Printf("Warning: Failed to check TLS certificate files: %v", err)
+		return
+	}
+
+	r.mu.RLock()
+	changed := !slices.EqualFunc(modTimes, r.modTimes, time.Time.Equal)
+	r.mu.RUnlock()
+	if !changed {
+		return
+	}
+
+	if err := r.load(modTimes); err != nil {
+		log.Printf("Warning: Failed to reload TLS certificates, keeping the previous ones: %v", err)
+		return
+	}
+	log.Println("Reloaded TLS certificates")
+}
+
+// load reads the certificate files and records modTimes as the version loaded
+func (r *Reloader) load(modTimes []time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		data, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return errors.New("client CA file contains no certificates")
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.pool = pool
+	r.modTimes = modTimes
+	return nil
+}
+
+// fileModTimes returns the modification time of each configured file
+func (r *Reloader) fileModTimes() ([]time.Time, error) {
+	var modTimes []time.Time
+	for _, file := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	return modTimes, nil
+}
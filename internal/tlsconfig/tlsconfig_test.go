@@ -0,0 +1,212 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCert is a certificate and its key, signed by parent or self-signed
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		tmpl.ExtKeyUsage = nil
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string, modTime time.Time) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	if keyFile != "" {
+		require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+		require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+	}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// serve accepts TLS connections and completes their handshakes
+func serve(t *testing.T, cfg *tls.Config) string {
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { lis.Close() })
+
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return lis.Addr().String()
+}
+
+// dial connects to addr and returns the server certificate's common name
+func dial(addr string, roots *x509.CertPool, clientCert *testCert) (string, error) {
+	cfg := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+	if clientCert != nil {
+		cfg.Certificates = []tls.Certificate{clientCert.tlsCertificate()}
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", addr, cfg)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	// With TLS 1.3 a rejected client certificate surfaces on the first read
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	cfg, err := ConfigFromEnv()
+	assert.NoError(t, err)
+	assert.False(t, cfg.Enabled())
+
+	t.Setenv("TLS_CERT_FILE", "cert.pem")
+	_, err = ConfigFromEnv()
+	assert.Error(t, err)
+
+	t.Setenv("TLS_KEY_FILE", "key.pem")
+	t.Setenv("TLS_CLIENT_CA_FILE", "ca.pem")
+	cfg, err = ConfigFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, ClientAuthRequire, cfg.ClientAuth)
+
+	t.Setenv("TLS_CLIENT_AUTH", "sometimes")
+	_, err = ConfigFromEnv()
+	assert.Error(t, err)
+}
+
+func TestReloaderMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", nil, 0)
+	server := newTestCert(t, "server-1", ca, x509.ExtKeyUsageServerAuth)
+	client := newTestCert(t, "client", ca, x509.ExtKeyUsageClientAuth)
+	stranger := newTestCert(t, "stranger", newTestCert(t, "other-ca", nil, 0), x509.ExtKeyUsageClientAuth)
+
+	cfg := Config{
+		CertFile:     filepath.Join(dir, "cert.pem"),
+		KeyFile:      filepath.Join(dir, "key.pem"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+		ClientAuth:   ClientAuthRequire,
+	}
+	modTime := time.Now().Add(-time.Minute)
+	server.write(t, cfg.CertFile, cfg.KeyFile, modTime)
+	ca.write(t, cfg.ClientCAFile, "", modTime)
+
+	reloader, err := NewReloader(cfg)
+	require.NoError(t, err)
+	addr := serve(t, reloader.TLSConfig())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	// Only clients with a certificate signed by the client CA are accepted
+	name, err := dial(addr, roots, client)
+	assert.NoError(t, err)
+	assert.Equal(t, "server-1", name)
+	_, err = dial(addr, roots, nil)
+	assert.Error(t, err)
+	_, err = dial(addr, roots, stranger)
+	assert.Error(t, err)
+
+	// Replaced certificate files are picked up without a restart
+	newTestCert(t, "server-2", ca, x509.ExtKeyUsageServerAuth).write(t, cfg.CertFile, cfg.KeyFile, time.Now())
+	name, err = dial(addr, roots, client)
+	assert.NoError(t, err)
+	assert.Equal(t, "server-2", name)
+
+	// A broken certificate file keeps the previous certificate in use
+	require.NoError(t, os.WriteFile(cfg.CertFile, []byte("garbage"), 0600))
+	require.NoError(t, os.Chtimes(cfg.CertFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute)))
+	name, err = dial(addr, roots, client)
+	assert.NoError(t, err)
+	assert.Equal(t, "server-2", name)
+}
+
+func TestReloaderOptionalClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", nil, 0)
+	server := newTestCert(t, "server", ca, x509.ExtKeyUsageServerAuth)
+
+	cfg := Config{
+		CertFile:     filepath.Join(dir, "cert.pem"),
+		KeyFile:      filepath.Join(dir, "key.pem"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+		ClientAuth:   ClientAuthOptional,
+	}
+	server.write(t, cfg.CertFile, cfg.KeyFile, time.Now())
+	ca.write(t, cfg.ClientCAFile, "", time.Now())
+
+	reloader, err := NewReloader(cfg)
+	require.NoError(t, err)
+	addr := serve(t, reloader.TLSConfig())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	// Clients may connect without a certificate
+	_, err = dial(addr, roots, nil)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,127 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSpans() []SpanData {
+	start := time.Unix(1700000000, 0)
+	root := SpanData{
+		TraceID: newTraceID(), SpanID: newSpanID(), Name: "POST /run/", Kind: SpanKindServer,
+		Start: start, End: start.Add(time.Second),
+		Attributes: map[string]any{"http.response.status_code": 500, "http.request.method": "POST"},
+		Failed:     true,
+	}
+	child := SpanData{
+		TraceID: root.TraceID, SpanID: newSpanID(), ParentSpanID: root.SpanID, Name: "execute resize",
+		Kind: SpanKindInternal, Start: start, End: start.Add(time.Second),
+		Attributes: map[string]any{"function.cold_start": true, "ratio": 0.5},
+		Error:      "boom", Failed: true,
+	}
+	return []SpanData{root, child}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body map[string]any
+	var headers http.Header
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	spans := testSpans()
+	exporter := NewOTLPExporter(collector.URL+"/v1/traces", map[string]string{"X-Token": "abc"}, "edge")
+	require.NoError(t, exporter.Export(context.Background(), spans))
+	assert.Equal(t, "application/json", headers.Get("Content-Type"))
+	assert.Equal(t, "abc", headers.Get("X-Token"))
+
+	resource := body["resourceSpans"].([]any)[0].(map[string]any)
+	assert.Equal(t, []any{map[string]any{"key": "service.name", "value": map[string]any{"stringValue": "edge"}}},
+		resource["resource"].(map[string]any)["attributes"])
+
+	encoded := resource["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)
+	require.Len(t, encoded, 2)
+	root := encoded[0].(map[string]any)
+	assert.Equal(t, spans[0].TraceID.String(), root["traceId"])
+	assert.NotContains(t, root, "parentSpanId")
+	assert.Equal(t, float64(2), root["kind"])
+	assert.Equal(t, "1700000000000000000", root["startTimeUnixNano"])
+	assert.Equal(t, map[string]any{"code": float64(2)}, root["status"])
+	assert.Equal(t, []any{
+		map[string]any{"key": "http.request.method", "value": map[string]any{"stringValue": "POST"}},
+		map[string]any{"key": "http.response.status_code", "value": map[string]any{"intValue": "500"}},
+	}, root["attributes"])
+
+	child := encoded[1].(map[string]any)
+	assert.Equal(t, spans[0].SpanID.String(), child["parentSpanId"])
+	assert.Equal(t, map[string]any{"code": float64(2), "message": "boom"}, child["status"])
+	assert.Equal(t, []any{
+		map[string]any{"key": "function.cold_start", "value": map[string]any{"boolValue": true}},
+		map[string]any{"key": "ratio", "value": map[string]any{"doubleValue": 0.5}},
+	}, child["attributes"])
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	exporter = NewOTLPExporter(failing.URL, nil, "edge")
+	assert.Error(t, exporter.Export(context.Background(), spans))
+}
+
+func TestWriterExporter(t *testing.T) {
+	var buf bytes.Buffer
+	exporter := NewWriterExporter(&buf)
+	spans := testSpans()
+	require.NoError(t, exporter.Export(context.Background(), spans))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	var span map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &span))
+	assert.Equal(t, spans[1].TraceID.String(), span["trace_id"])
+	assert.Equal(t, spans[0].SpanID.String(), span["parent_span_id"])
+	assert.Equal(t, "internal", span["kind"])
+	assert.Equal(t, "boom", span["error"])
+	assert.NotContains(t, lines[0], "parent_span_id")
+
+	path := filepath.Join(t.TempDir(), "traces", "spans.jsonl")
+	file, err := NewFileExporter(path)
+	require.NoError(t, err)
+	require.NoError(t, file.Export(context.Background(), spans))
+	require.NoError(t, file.Close())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, buf.String(), string(data))
+}
+
+// failingExporter fails every export
+type failingExporter struct{ memoryExporter }
+
+func (e *failingExporter) Export(context.Context, []SpanData) error {
+	return errors.New("collector down")
+}
+
+func TestTracerExportFailure(t *testing.T) {
+	tracer := NewTracer(&failingExporter{}, Config{SampleRatio: 1, QueueSize: 1})
+	for i := 0; i < 10; i++ {
+		_, span := tracer.Start(context.Background(), "work", SpanKindInternal)
+		span.End()
+	}
+	// Failed exports and dropped spans are logged, never returned
+	assert.NoError(t, tracer.Close())
+}
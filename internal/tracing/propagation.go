@@ -0,0 +1,126 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+)
+
+// Trace context headers, as defined by W3C Trace Context. The same keys are
+// used in gRPC metadata, event attributes and function input.
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// InputKey is the reserved key of a function's input that carries its trace
+// context, as an object with traceparent and tracestate fields. It is
+// namespaced so it doesn't collide with the caller's fields.
+const InputKey = "_trace"
+
+// ErrInvalidTraceparent is returned for a malformed traceparent
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// ParseTraceparent parses a traceparent value such as
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceparent(value string) (SpanContext, error) {
+	// Later versions may append fields, which are ignored
+	if len(value) < 55 || (len(value) > 55 && value[55] != '-') {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	if value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+
+	var version, flags [1]byte
+	var sc SpanContext
+	if !decodeHex(version[:], value[0:2]) || version[0] == 0xff || (version[0] == 0 && len(value) != 55) ||
+		!decodeHex(sc.TraceID[:], value[3:35]) ||
+		!decodeHex(sc.SpanID[:], value[36:52]) ||
+		!decodeHex(flags[:], value[53:55]) {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+// decodeHex decodes lowercase hex into dst, which it must fill exactly
+func decodeHex(dst []byte, src string) bool {
+	for _, c := range src {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	n, err := hex.Decode(dst, []byte(src))
+	return err == nil && n == len(dst)
+}
+
+// Traceparent formats the span context as a traceparent value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// Inject passes the current span of ctx, if any, to set as traceparent and
+// tracestate values
+func Inject(ctx context.Context, set func(key, value string)) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	set(TraceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		set(TracestateHeader, sc.TraceState)
+	}
+}
+
+// Extract returns a copy of ctx whose current span is the one described by
+// the traceparent and tracestate values that get returns. A missing or
+// malformed traceparent leaves ctx unchanged.
+func Extract(ctx context.Context, get func(key string) string) context.Context {
+	sc, err := ParseTraceparent(get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+	sc.TraceState = get(TracestateHeader)
+	return ContextWithSpanContext(ctx, sc)
+}
+
+// InjectHeader sets the trace context headers of an outgoing HTTP request
+func InjectHeader(ctx context.Context, h http.Header) {
+	Inject(ctx, h.Set)
+}
+
+// ExtractHeader reads the trace context headers of an incoming HTTP request
+func ExtractHeader(ctx context.Context, h http.Header) context.Context {
+	return Extract(ctx, h.Get)
+}
+
+// InjectInput adds the current span of ctx to a function's input under
+// InputKey, so spans the function starts, and events it publishes, join the
+// trace. Any value the caller sent under InputKey is replaced.
+func InjectInput(ctx context.Context, input map[string]any) {
+	delete(input, InputKey)
+	trace := map[string]any{}
+	Inject(ctx, func(key, value string) { trace[key] = value })
+	if len(trace) > 0 {
+		input[InputKey] = trace
+	}
+}
+
+// ExtractInput returns a copy of ctx that continues the trace of a function's
+// input. Functions use it as the context of the events they publish.
+func ExtractInput(ctx context.Context, input map[string]any) context.Context {
+	trace, _ := input[InputKey].(map[string]any)
+	return Extract(ctx, func(key string) string {
+		value, _ := trace[key].(string)
+		return value
+	})
+}
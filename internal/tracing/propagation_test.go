@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	sc, err = ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	require.NoError(t, err)
+	assert.False(t, sc.Sampled)
+
+	// Later versions may append fields
+	_, err = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+	assert.NoError(t, err)
+
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		_, err := ParseTraceparent(value)
+		assert.ErrorIs(t, err, ErrInvalidTraceparent, value)
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	h := http.Header{}
+	h.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.Set(TracestateHeader, "vendor=1")
+	ctx := ExtractHeader(context.Background(), h)
+
+	sc := SpanContextFromContext(ctx)
+	assert.True(t, sc.IsValid())
+	assert.Equal(t, "vendor=1", sc.TraceState)
+
+	out := http.Header{}
+	InjectHeader(ctx, out)
+	assert.Equal(t, h, out)
+
+	// The caller's fields are left alone, apart from the reserved key
+	input := map[string]any{TraceparentHeader: "mine", InputKey: "forged"}
+	InjectInput(ctx, input)
+	assert.Equal(t, "mine", input[TraceparentHeader])
+	assert.Equal(t, map[string]any{TraceparentHeader: sc.Traceparent(), TracestateHeader: "vendor=1"}, input[InputKey])
+	assert.Equal(t, sc, SpanContextFromContext(ExtractInput(context.Background(), input)))
+
+	// The trace survives a round trip through JSON, as WebAssembly input does
+	data, err := json.Marshal(input)
+	assert.NoError(t, err)
+	var decoded map[string]any
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, sc, SpanContextFromContext(ExtractInput(context.Background(), decoded)))
+
+	// Nothing is injected without a span, and bad values are ignored
+	empty := http.Header{}
+	InjectHeader(context.Background(), empty)
+	assert.Empty(t, empty)
+	h.Set(TraceparentHeader, "garbage")
+	assert.False(t, SpanContextFromContext(ExtractHeader(context.Background(), h)).IsValid())
+}
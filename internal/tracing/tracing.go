@@ -0,0 +1,522 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SpanKind says how a span relates to the spans around it. The values are
+// those of OTLP.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+	SpanKindProducer SpanKind = 4
+	SpanKindConsumer SpanKind = 5
+)
+
+var spanKindNames = map[SpanKind]string{
+	SpanKindInternal: "internal",
+	SpanKindServer:   "server",
+	SpanKindClient:   "client",
+	SpanKindProducer: "producer",
+	SpanKindConsumer: "consumer",
+}
+
+func (k SpanKind) String() string {
+	if name, ok := spanKindNames[k]; ok {
+		return name
+	}
+	return "unspecified"
+}
+
+// MarshalText encodes the kind by name
+func (k SpanKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether the ID is not all zeros
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// MarshalText encodes the ID in hex
+func (id TraceID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether the ID is not all zeros
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// MarshalText encodes the ID in hex, or as an empty string if it is not valid
+func (id SpanID) MarshalText() ([]byte, error) {
+	if !id.IsValid() {
+		return []byte{}, nil
+	}
+	return []byte(id.String()), nil
+}
+
+// SpanContext is the part of a span that is propagated to other services
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+	// TraceState is the vendor data received in the tracestate header, passed
+	// on unchanged
+	TraceState string
+}
+
+// IsValid reports whether the span context identifies a span
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a copy of ctx in which sc is the current span
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the current span of ctx, which is invalid
+// if there is none
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc
+}
+
+// SpanData is a finished span, as handed to exporters
+type SpanData struct {
+	TraceID      TraceID        `json:"trace_id"`
+	SpanID       SpanID         `json:"span_id"`
+	ParentSpanID SpanID         `json:"parent_span_id,omitzero"`
+	Name         string         `json:"name"`
+	Kind         SpanKind       `json:"kind"`
+	Start        time.Time      `json:"start"`
+	End          time.Time      `json:"end"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	// Error is the message of the error the span failed with, if any
+	Error string `json:"error,omitempty"`
+	// Failed is set when the span failed without an error message, such as
+	// an HTTP request answered with a 5xx status
+	Failed bool `json:"failed,omitempty"`
+}
+
+// Span is a unit of work in a trace. A nil span, as returned when tracing is
+// disabled, ignores every call.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+
+	mu   sync.Mutex
+	data SpanData
+	done bool
+}
+
+// SpanContext returns the identity of the span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetName renames the span, for when its name is only known once the work
+// has started
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Name = name
+}
+
+// SetAttribute sets an attribute of the span. Values should be strings,
+// booleans, integers or floats.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]any)
+	}
+	s.data.Attributes[key] = value
+}
+
+// SetError marks the span as failed if err is not nil
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+	s.data.Failed = true
+}
+
+// SetFailed marks the span as failed without an error message
+func (s *Span) SetFailed() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Failed = true
+}
+
+// End finishes the span and queues it for export if it was sampled. Calls
+// after the first are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return
+	}
+	s.done = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if s.sc.Sampled {
+		s.tracer.enqueue(data)
+	}
+}
+
+// Config controls how spans are sampled and exported
+type Config struct {
+	// ServiceName names this process in the exported traces
+	ServiceName string
+	// SampleRatio is the fraction of new traces that are recorded. Traces
+	// started elsewhere follow the sampling decision of their caller.
+	SampleRatio float64
+	// BatchSize is the number of spans exported in one request
+	BatchSize int
+	// FlushInterval is the longest a span waits before being exported
+	FlushInterval time.Duration
+	// QueueSize is the number of spans buffered for export. Spans are dropped
+	// when the queue is full rather than slowing requests down.
+	QueueSize int
+}
+
+// DefaultServiceName is the service name used when OTEL_SERVICE_NAME is not set
+const DefaultServiceName = "self-hosted-serverless"
+
+// DefaultConfig returns the configuration used when nothing is set
+func DefaultConfig() Config {
+	return Config{
+		ServiceName:   DefaultServiceName,
+		SampleRatio:   1,
+		BatchSize:     512,
+		FlushInterval: 5 * time.Second,
+		QueueSize:     2048,
+	}
+}
+
+// Tracer starts spans and exports them in batches in the background. A nil
+// tracer, as used when tracing is disabled, starts no spans but leaves
+// propagated trace context in place.
+type Tracer struct {
+	exporter Exporter
+	cfg      Config
+
+	mu      sync.RWMutex
+	closed  bool
+	queue   chan SpanData
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+// NewTracer creates a tracer that exports spans through exporter and starts
+// exporting in the background. Close must be called to export the spans
+// still queued.
+func NewTracer(exporter Exporter, cfg Config) *Tracer {
+	defaults := DefaultConfig()
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = defaults.ServiceName
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaults.BatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaults.FlushInterval
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaults.QueueSize
+	}
+
+	t := &Tracer{
+		exporter: exporter,
+		cfg:      cfg,
+		queue:    make(chan SpanData, cfg.QueueSize),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// NewTracerFromEnv creates the tracer selected by TRACING_EXPORTER: "otlp",
+// "stdout", "file", or "none" (the default) to disable tracing, in which case
+// it returns nil. OTEL_SERVICE_NAME and TRACING_SAMPLE_RATIO override the
+// defaults.
+func NewTracerFromEnv() (*Tracer, error) {
+	cfg := DefaultConfig()
+	if v := os.Getenv("OTEL_SERVICE_NAME"); v != "" {
+		cfg.ServiceName = v
+	}
+	if v := os.Getenv("TRACING_SAMPLE_RATIO"); v != "" {
+		ratio, err := strconv.ParseFloat(v, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("invalid TRACING_SAMPLE_RATIO %q: must be between 0 and 1", v)
+		}
+		cfg.SampleRatio = ratio
+	}
+
+	var exporter Exporter
+	switch kind := os.Getenv("TRACING_EXPORTER"); kind {
+	case "", "none":
+		return nil, nil
+	case "otlp":
+		endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+		if endpoint == "" {
+			base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+			if base == "" {
+				base = DefaultOTLPEndpoint
+			}
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+		headers, err := parseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+		if err != nil {
+			return nil, err
+		}
+		exporter = NewOTLPExporter(endpoint, headers, cfg.ServiceName)
+	case "stdout":
+		exporter = NewWriterExporter(os.Stdout)
+	case "file":
+		path := os.Getenv("TRACING_FILE")
+		if path == "" {
+			path = DefaultTraceFile
+		}
+		var err error
+		if exporter, err = NewFileExporter(path); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown TRACING_EXPORTER %q: must be otlp, stdout, file or none", kind)
+	}
+	return NewTracer(exporter, cfg), nil
+}
+
+// parseHeaders parses OTLP headers given as comma-separated key=value pairs
+func parseHeaders(value string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS entry %q: must be key=value", pair)
+		}
+		headers[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return headers, nil
+}
+
+// Start starts a span as a child of the current span of ctx, or as the root
+// of a new trace if there is none, and returns a context in which it is the
+// current span. The span must be ended.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	parent := SpanContextFromContext(ctx)
+	sc := SpanContext{SpanID: newSpanID()}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+		sc.TraceState = parent.TraceState
+	} else {
+		sc.TraceID = newTraceID()
+		sc.Sampled = t.sample(sc.TraceID)
+	}
+
+	span := &Span{
+		tracer: t,
+		sc:     sc,
+		data: SpanData{
+			TraceID:      sc.TraceID,
+			SpanID:       sc.SpanID,
+			ParentSpanID: parent.SpanID,
+			Name:         name,
+			Kind:         kind,
+			Start:        time.Now(),
+		},
+	}
+	return ContextWithSpanContext(ctx, sc), span
+}
+
+// sample decides whether a new trace is recorded. The decision depends only
+// on the trace ID, so it is the same wherever the trace is started.
+func (t *Tracer) sample(id TraceID) bool {
+	switch {
+	case t.cfg.SampleRatio >= 1:
+		return true
+	case t.cfg.SampleRatio <= 0:
+		return false
+	}
+	bound := uint64(t.cfg.SampleRatio * math.MaxUint64)
+	return binary.BigEndian.Uint64(id[8:]) < bound
+}
+
+// enqueue queues a finished span for export. It never blocks; when the
+// queue is full the span is dropped and counted.
+func (t *Tracer) enqueue(data SpanData) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- data:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// Close exports the queued spans and stops the background exporter
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	close(t.queue)
+	t.mu.Unlock()
+
+	<-t.done
+	return t.exporter.Close()
+}
+
+// run exports queued spans in batches
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(t.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, t.cfg.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+			if err := t.exporter.Export(ctx, batch); err != nil {
+				log.Printf("Warning: Failed to export %d spans: %v", len(batch), err)
+			}
+			cancel()
+			batch = make([]SpanData, 0, t.cfg.BatchSize)
+		}
+		if dropped := t.dropped.Swap(0); dropped > 0 {
+			log.Printf("Warning: Span queue full, dropped %d spans", dropped)
+		}
+	}
+
+	for {
+		select {
+		case data, ok := <-t.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, data)
+			if len(batch) >= t.cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+var (
+	globalTracer atomic.Pointer[Tracer]
+	globalOnce   sync.Once
+)
+
+// GetGlobalTracer returns the tracer configured by the environment, or nil if
+// tracing is disabled
+func GetGlobalTracer() *Tracer {
+	globalOnce.Do(func() {
+		t, err := NewTracerFromEnv()
+		if err != nil {
+			log.Printf("Warning: Tracing disabled: %v", err)
+		}
+		globalTracer.Store(t)
+	})
+	return globalTracer.Load()
+}
+
+// SetGlobalTracer replaces the global tracer. It is meant for tests.
+func SetGlobalTracer(t *Tracer) {
+	globalOnce.Do(func() {})
+	globalTracer.Store(t)
+}
+
+// Start starts a span with the global tracer
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	return GetGlobalTracer().Start(ctx, name, kind)
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
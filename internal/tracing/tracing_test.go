@@ -0,0 +1,149 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryExporter keeps exported spans in memory
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (e *memoryExporter) Export(_ context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memoryExporter) Close() error {
+	return nil
+}
+
+func TestTracerStart(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer(exporter, Config{SampleRatio: 1})
+
+	ctx, root := tracer.Start(context.Background(), "GET /run/", SpanKindServer)
+	root.SetAttribute("http.request.method", "GET")
+	_, child := tracer.Start(ctx, "execute resize", SpanKindInternal)
+	child.SetError(errors.New("boom"))
+	child.End()
+	root.SetName("POST /run/")
+	root.End()
+	root.End()
+
+	assert.Equal(t, root.SpanContext(), SpanContextFromContext(ctx))
+	assert.Equal(t, root.SpanContext().TraceID, child.SpanContext().TraceID)
+	assert.True(t, child.SpanContext().Sampled)
+
+	require.NoError(t, tracer.Close())
+	require.Len(t, exporter.spans, 2)
+
+	spans := exporter.spans
+	assert.Equal(t, "execute resize", spans[0].Name)
+	assert.Equal(t, root.SpanContext().SpanID, spans[0].ParentSpanID)
+	assert.Equal(t, "boom", spans[0].Error)
+	assert.True(t, spans[0].Failed)
+
+	assert.Equal(t, "POST /run/", spans[1].Name)
+	assert.Equal(t, SpanKindServer, spans[1].Kind)
+	assert.False(t, spans[1].ParentSpanID.IsValid())
+	assert.Equal(t, "GET", spans[1].Attributes["http.request.method"])
+	assert.False(t, spans[1].End.Before(spans[1].Start))
+}
+
+func TestTracerSampling(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer(exporter, Config{SampleRatio: 0})
+
+	// New traces are not sampled, but still propagate
+	ctx, span := tracer.Start(context.Background(), "unsampled", SpanKindServer)
+	assert.True(t, span.SpanContext().IsValid())
+	assert.False(t, span.SpanContext().Sampled)
+	span.End()
+
+	// Traces started elsewhere follow the caller's decision
+	remote := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true, TraceState: "vendor=1"}
+	ctx = ContextWithSpanContext(ctx, remote)
+	_, span = tracer.Start(ctx, "sampled", SpanKindServer)
+	assert.True(t, span.SpanContext().Sampled)
+	assert.Equal(t, "vendor=1", span.SpanContext().TraceState)
+	span.End()
+
+	require.NoError(t, tracer.Close())
+	require.Len(t, exporter.spans, 1)
+	assert.Equal(t, "sampled", exporter.spans[0].Name)
+	assert.Equal(t, remote.SpanID, exporter.spans[0].ParentSpanID)
+
+	half := &Tracer{cfg: Config{SampleRatio: 0.5}}
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		if half.sample(newTraceID()) {
+			sampled++
+		}
+	}
+	assert.InDelta(t, 500, sampled, 100)
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	remote := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true}
+	ctx := ContextWithSpanContext(context.Background(), remote)
+
+	next, span := tracer.Start(ctx, "ignored", SpanKindInternal)
+	assert.Nil(t, span)
+	assert.Equal(t, remote, SpanContextFromContext(next))
+
+	// A nil span ignores every call
+	span.SetAttribute("key", "value")
+	span.SetError(errors.New("boom"))
+	span.End()
+	assert.NoError(t, tracer.Close())
+}
+
+func TestNewTracerFromEnv(t *testing.T) {
+	t.Setenv("TRACING_EXPORTER", "")
+	tracer, err := NewTracerFromEnv()
+	assert.NoError(t, err)
+	assert.Nil(t, tracer)
+
+	t.Setenv("TRACING_EXPORTER", "file")
+	t.Setenv("TRACING_FILE", t.TempDir()+"/traces/spans.jsonl")
+	t.Setenv("TRACING_SAMPLE_RATIO", "0.25")
+	t.Setenv("OTEL_SERVICE_NAME", "edge")
+	tracer, err = NewTracerFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, 0.25, tracer.cfg.SampleRatio)
+	assert.Equal(t, "edge", tracer.cfg.ServiceName)
+	assert.NoError(t, tracer.Close())
+
+	t.Setenv("TRACING_EXPORTER", "otlp")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318/")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "x-token=abc, x-team = core")
+	tracer, err = NewTracerFromEnv()
+	require.NoError(t, err)
+	exporter := tracer.exporter.(*OTLPExporter)
+	assert.Equal(t, "http://collector:4318/v1/traces", exporter.url)
+	assert.Equal(t, map[string]string{"x-token": "abc", "x-team": "core"}, exporter.headers)
+	assert.NoError(t, tracer.Close())
+
+	for key, value := range map[string]string{
+		"TRACING_EXPORTER":           "jaeger",
+		"TRACING_SAMPLE_RATIO":       "2",
+		"OTEL_EXPORTER_OTLP_HEADERS": "novalue",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			_, err := NewTracerFromEnv()
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package usage
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Usage is what one execution consumed beyond its wall time and the size of
+// its input and output
+type Usage struct {
+	// MemoryPages is the most 64 KiB pages of linear memory a WebAssembly
+	// instance of the execution had
+	MemoryPages int64
+	// DBQueries is the number of SQL statements the execution issued
+	DBQueries int64
+}
+
+// Meter counts what an execution consumes. Runtimes and services find it in
+// the context they are given. A nil Meter counts nothing.
+type Meter struct {
+	memoryPages atomic.Int64
+	dbQueries   atomic.Int64
+}
+
+type meterKey struct{}
+
+// NewContext returns a copy of ctx carrying m
+func NewContext(ctx context.Context, m *Meter) context.Context {
+	return context.WithValue(ctx, meterKey{}, m)
+}
+
+// FromContext returns the meter of ctx, or nil if it has none
+func FromContext(ctx context.Context) *Meter {
+	m, _ := ctx.Value(meterKey{}).(*Meter)
+	return m
+}
+
+// ObserveMemoryPages records the size of an instance's memory, keeping the
+// largest seen
+func (m *Meter) ObserveMemoryPages(pages int64) {
+	if m == nil {
+		return
+	}
+	for {
+		current := m.memoryPages.Load()
+		if pages <= current || m.memoryPages.CompareAndSwap(current, pages) {
+			return
+		}
+	}
+}
+
+// CountDBQuery records a SQL statement
+func (m *Meter) CountDBQuery() {
+	if m == nil {
+		return
+	}
+	m.dbQueries.Add(1)
+}
+
+// Usage returns what has been counted so far
+func (m *Meter) Usage() Usage {
+	if m == nil {
+		return Usage{}
+	}
+	return Usage{
+		MemoryPages: m.memoryPages.Load(),
+		DBQueries:   m.dbQueries.Load(),
+	}
+}
@@ -0,0 +1,39 @@
+package usage
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMeter(t *testing.T) {
+	m := &Meter{}
+	ctx := NewContext(context.Background(), m)
+	assert.Same(t, m, FromContext(ctx))
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 10; i++ {
+		wg.Add(1)
+		go func(pages int64) {
+			defer wg.Done()
+			FromContext(ctx).CountDBQuery()
+			FromContext(ctx).ObserveMemoryPages(pages)
+		}(int64(i))
+	}
+	wg.Wait()
+	m.ObserveMemoryPages(3)
+
+	assert.Equal(t, Usage{MemoryPages: 10, DBQueries: 10}, m.Usage())
+}
+
+func TestNilMeter(t *testing.T) {
+	m := FromContext(context.Background())
+	assert.Nil(t, m)
+
+	// A nil meter counts nothing
+	m.CountDBQuery()
+	m.ObserveMemoryPages(4)
+	assert.Equal(t, Usage{}, m.Usage())
+}
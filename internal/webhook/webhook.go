@@ -0,0 +1,207 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidSignature is returned when a request is unsigned or its
+	// signature doesn't match the body
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrStaleTimestamp is returned when a signed timestamp is missing or
+	// outside the tolerance, which stops old requests from being replayed
+	ErrStaleTimestamp = errors.New("webhook timestamp missing or outside tolerance")
+)
+
+// Signature formats
+const (
+	// FormatDigest sends the digest alone in the signature header, optionally
+	// after a prefix such as "sha256=" (GitHub style)
+	FormatDigest = "digest"
+	// FormatStripe sends "t=<timestamp>,v1=<digest>" in the signature header
+	// and signs "<timestamp>.<body>" (Stripe style)
+	FormatStripe = "stripe"
+)
+
+// DefaultToleranceSecs is how far a signed timestamp may be from the current
+// time when the config doesn't say
+const DefaultToleranceSecs = 300
+
+// Config describes how a provider signs the webhooks it sends to a function.
+// When TimestampHeader is set, or the format is FormatStripe, the signed
+// content is "<timestamp>.<body>" and the timestamp, in Unix seconds, must be
+// within ToleranceSecs of the current time.
+type Config struct {
+	// Secret is the shared secret. SecretEnv names an environment variable
+	// holding it instead, which keeps it out of the config file.
+	Secret          string `json:"secret,omitempty"`
+	SecretEnv       string `json:"secret_env,omitempty"`
+	Header          string `json:"header"`
+	Algorithm       string `json:"algorithm,omitempty"`
+	Format          string `json:"format,omitempty"`
+	Prefix          string `json:"prefix,omitempty"`
+	Encoding        string `json:"encoding,omitempty"`
+	TimestampHeader string `json:"timestamp_header,omitempty"`
+	ToleranceSecs   int    `json:"tolerance_secs,omitempty"`
+}
+
+// Verifier checks webhook signatures for one function
+type Verifier struct {
+	cfg       Config
+	secret    []byte
+	hash      func() hash.Hash
+	tolerance time.Duration
+}
+
+// NewVerifier validates cfg and resolves its secret
+func NewVerifier(cfg Config) (*Verifier, error) {
+	secret := cfg.Secret
+	if cfg.SecretEnv != "" {
+		secret = os.Getenv(cfg.SecretEnv)
+	}
+	if secret == "" {
+		return nil, errors.New("webhook secret is empty")
+	}
+
+	v := &Verifier{cfg: cfg, secret: []byte(secret)}
+	switch strings.ToLower(cfg.Algorithm) {
+	case "", "sha256", "hmac-sha256":
+		v.hash = sha256.New
+	case "sha1", "hmac-sha1":
+		v.hash = sha1.New
+	default:
+		return nil, fmt.Errorf("unsupported webhook algorithm %q", cfg.Algorithm)
+	}
+
+	switch cfg.Format {
+	case "", FormatDigest:
+		if v.cfg.Header == "" {
+			return nil, errors.New("webhook signature header is required")
+		}
+	case FormatStripe:
+		if v.cfg.Header == "" {
+			v.cfg.Header = "Stripe-Signature"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported webhook format %q", cfg.Format)
+	}
+
+	switch cfg.Encoding {
+	case "", "hex", "base64":
+	default:
+		return nil, fmt.Errorf("unsupported webhook encoding %q", cfg.Encoding)
+	}
+
+	if cfg.ToleranceSecs < 0 {
+		return nil, errors.New("webhook tolerance must not be negative")
+	}
+	v.tolerance = time.Duration(cfg.ToleranceSecs) * time.Second
+	if v.tolerance == 0 {
+		v.tolerance = DefaultToleranceSecs * time.Second
+	}
+	return v, nil
+}
+
+// LoadFile reads a JSON file mapping function names to their webhook configs
+func LoadFile(file string) (map[string]*Verifier, error) {
+	if file == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhooks file: %w", err)
+	}
+	var configs map[string]Config
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse webhooks file: %w", err)
+	}
+
+	verifiers := make(map[string]*Verifier, len(configs))
+	for name, cfg := range configs {
+		v, err := NewVerifier(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("webhook for function %s: %w", name, err)
+		}
+		verifiers[name] = v
+	}
+	return verifiers, nil
+}
+
+// Verify checks the signature in header against the raw request body
+func (v *Verifier) Verify(header http.Header, body []byte, now time.Time) error {
+	value := header.Get(v.cfg.Header)
+	if value == "" {
+		return ErrInvalidSignature
+	}
+
+	var timestamp string
+	var signatures []string
+	if v.cfg.Format == FormatStripe {
+		for _, field := range strings.Split(value, ",") {
+			k, val, _ := strings.Cut(strings.TrimSpace(field), "=")
+			switch k {
+			case "t":
+				timestamp = val
+			case "v1":
+				// Providers send several signatures while rotating secrets
+				signatures = append(signatures, val)
+			}
+		}
+		if timestamp == "" {
+			return ErrStaleTimestamp
+		}
+	} else {
+		sig, ok := strings.CutPrefix(value, v.cfg.Prefix)
+		if !ok {
+			return ErrInvalidSignature
+		}
+		signatures = []string{sig}
+		if v.cfg.TimestampHeader != "" {
+			if timestamp = header.Get(v.cfg.TimestampHeader); timestamp == "" {
+				return ErrStaleTimestamp
+			}
+		}
+	}
+
+	mac := hmac.New(v.hash, v.secret)
+	if timestamp != "" {
+		secs, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return ErrStaleTimestamp
+		}
+		if age := now.Sub(time.Unix(secs, 0)); age > v.tolerance || age < -v.tolerance {
+			return ErrStaleTimestamp
+		}
+		mac.Write([]byte(timestamp + "."))
+	}
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	for _, sig := range signatures {
+		if got, err := v.decode(sig); err == nil && hmac.Equal(got, expected) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func (v *Verifier) decode(sig string) ([]byte, error) {
+	if v.cfg.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(sig)
+	}
+	return hex.DecodeString(strings.ToLower(sig))
+}
@@ -0,0 +1,136 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sign(h func() hash.Hash, secret, payload string) []byte {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func TestVerifyDigest(t *testing.T) {
+	v, err := NewVerifier(Config{Secret: "s3cret", Header: "X-Hub-Signature-256", Prefix: "sha256="})
+	require.NoError(t, err)
+
+	body := []byte(`{"action":"opened"}`)
+	header := http.Header{}
+	header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(sign(sha256.New, "s3cret", string(body))))
+	assert.NoError(t, v.Verify(header, body, time.Now()))
+
+	// Tampered body, wrong secret, missing prefix and missing header
+	assert.ErrorIs(t, v.Verify(header, []byte(`{"action":"closed"}`), time.Now()), ErrInvalidSignature)
+	header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(sign(sha256.New, "other", string(body))))
+	assert.ErrorIs(t, v.Verify(header, body, time.Now()), ErrInvalidSignature)
+	header.Set("X-Hub-Signature-256", hex.EncodeToString(sign(sha256.New, "s3cret", string(body))))
+	assert.ErrorIs(t, v.Verify(header, body, time.Now()), ErrInvalidSignature)
+	assert.ErrorIs(t, v.Verify(http.Header{}, body, time.Now()), ErrInvalidSignature)
+}
+
+func TestVerifySHA1Base64(t *testing.T) {
+	v, err := NewVerifier(Config{Secret: "s3cret", Header: "X-Signature", Algorithm: "sha1", Encoding: "base64"})
+	require.NoError(t, err)
+
+	body := []byte("payload")
+	header := http.Header{}
+	header.Set("X-Signature", base64.StdEncoding.EncodeToString(sign(sha1.New, "s3cret", "payload")))
+	assert.NoError(t, v.Verify(header, body, time.Now()))
+
+	header.Set("X-Signature", base64.StdEncoding.EncodeToString(sign(sha256.New, "s3cret", "payload")))
+	assert.ErrorIs(t, v.Verify(header, body, time.Now()), ErrInvalidSignature)
+}
+
+func TestVerifyTimestampHeader(t *testing.T) {
+	v, err := NewVerifier(Config{Secret: "s3cret", Header: "X-Signature", TimestampHeader: "X-Timestamp", ToleranceSecs: 60})
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	body := []byte("payload")
+	header := http.Header{}
+	header.Set("X-Timestamp", "1700000000")
+	header.Set("X-Signature", hex.EncodeToString(sign(sha256.New, "s3cret", "1700000000.payload")))
+	assert.NoError(t, v.Verify(header, body, now))
+	assert.NoError(t, v.Verify(header, body, now.Add(59*time.Second)))
+
+	// Replayed later, or sent with a forged timestamp
+	assert.ErrorIs(t, v.Verify(header, body, now.Add(2*time.Minute)), ErrStaleTimestamp)
+	header.Set("X-Timestamp", "1700000030")
+	assert.ErrorIs(t, v.Verify(header, body, now), ErrInvalidSignature)
+	header.Del("X-Timestamp")
+	assert.ErrorIs(t, v.Verify(header, body, now), ErrStaleTimestamp)
+}
+
+func TestVerifyStripe(t *testing.T) {
+	v, err := NewVerifier(Config{Secret: "whsec", Format: FormatStripe})
+	require.NoError(t, err)
+
+	now := time.Now()
+	ts := strconv.FormatInt(now.Unix(), 10)
+	body := []byte(`{"type":"charge.succeeded"}`)
+	sig := hex.EncodeToString(sign(sha256.New, "whsec", ts+"."+string(body)))
+	old := hex.EncodeToString(sign(sha256.New, "old-secret", ts+"."+string(body)))
+
+	header := http.Header{}
+	header.Set("Stripe-Signature", "t="+ts+",v1="+old+",v1="+sig)
+	assert.NoError(t, v.Verify(header, body, now))
+
+	assert.ErrorIs(t, v.Verify(header, body, now.Add(DefaultToleranceSecs*time.Second+time.Second)), ErrStaleTimestamp)
+
+	header.Set("Stripe-Signature", "v1="+sig)
+	assert.ErrorIs(t, v.Verify(header, body, now), ErrStaleTimestamp)
+	header.Set("Stripe-Signature", "t="+ts+",v1="+old)
+	assert.ErrorIs(t, v.Verify(header, body, now), ErrInvalidSignature)
+}
+
+func TestNewVerifierErrors(t *testing.T) {
+	_, err := NewVerifier(Config{Header: "X-Signature"})
+	assert.Error(t, err)
+	_, err = NewVerifier(Config{Secret: "s", Header: "X-Signature", Algorithm: "md5"})
+	assert.Error(t, err)
+	_, err = NewVerifier(Config{Secret: "s"})
+	assert.Error(t, err)
+	_, err = NewVerifier(Config{Secret: "s", Header: "X-Signature", Format: "svix"})
+	assert.Error(t, err)
+	_, err = NewVerifier(Config{Secret: "s", Header: "X-Signature", ToleranceSecs: -1})
+	assert.Error(t, err)
+
+	t.Setenv("GITHUB_WEBHOOK_SECRET", "from-env")
+	v, err := NewVerifier(Config{SecretEnv: "GITHUB_WEBHOOK_SECRET", Header: "X-Signature"})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("from-env"), v.secret)
+}
+
+func TestLoadFile(t *testing.T) {
+	verifiers, err := LoadFile("")
+	assert.NoError(t, err)
+	assert.Empty(t, verifiers)
+
+	file := filepath.Join(t.TempDir(), "webhooks.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{
+		"github": {"secret": "s3cret", "header": "X-Hub-Signature-256", "prefix": "sha256="},
+		"stripe": {"secret": "whsec", "format": "stripe"}
+	}`), 0o600))
+	verifiers, err = LoadFile(file)
+	require.NoError(t, err)
+	assert.Len(t, verifiers, 2)
+	assert.Equal(t, "Stripe-Signature", verifiers["stripe"].cfg.Header)
+
+	require.NoError(t, os.WriteFile(file, []byte(`{"github": {"header": "X-Hub-Signature-256"}}`), 0o600))
+	_, err = LoadFile(file)
+	assert.ErrorContains(t, err, "github")
+}